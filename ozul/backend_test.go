@@ -0,0 +1,687 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// backend is a code generator under test. generate returns the code it
+// emits as text, and run executes a program compiled by it, returning what
+// the program printed and the error it stopped with. run skips the test when
+// a tool the backend needs is not installed.
+type backend struct {
+	name     string
+	generate func(program *Program) string
+	run      func(t *testing.T, program *Program, input string) (string, error)
+}
+
+// backends lists every code generator, each compared with the interpreter
+var backends = []backend{
+	{"C", generateC, runC},
+	{"LLVM", generateLLVM, runLLVM},
+	{"WASM", func(program *Program) string { return generateWasm(program).GetWAT() }, runWasm},
+	{"WASMNode", func(program *Program) string { return generateWasm(program).GetWAT() }, runWasmInNode},
+	{"JS", generateJS, runJS},
+	{"Go", generateGo, runGo},
+}
+
+// findBackend returns the backend with the given name
+func findBackend(t *testing.T, name string) backend {
+	t.Helper()
+	for _, b := range backends {
+		if b.name == name {
+			return b
+		}
+	}
+	t.Fatalf("no backend named %s", name)
+	return backend{}
+}
+
+func generateC(program *Program) string {
+	cg := NewCodeGen()
+	cg.GenerateProgram(program)
+	return cg.GetCode()
+}
+
+func generateLLVM(program *Program) string {
+	lg := NewLLVMGen()
+	lg.GenerateProgram(program)
+	return lg.GetIR()
+}
+
+func generateWasm(program *Program) *WasmGen {
+	wasmgen := NewWasmGen()
+	wasmgen.GenerateProgram(program)
+	return wasmgen
+}
+
+func generateJS(program *Program) string {
+	jsgen := NewJSGen()
+	jsgen.GenerateProgram(program)
+	return jsgen.GetCode()
+}
+
+func generateGo(program *Program) string {
+	gogen := NewGoGen()
+	gogen.GenerateProgram(program)
+	return gogen.GetCode()
+}
+
+// lookTool finds a tool on the PATH, skipping the test when it is missing
+func lookTool(t *testing.T, name string) string {
+	t.Helper()
+	path, err := exec.LookPath(name)
+	if err != nil {
+		t.Skipf("%s not found", name)
+	}
+	return path
+}
+
+// runTool runs a compiled program with input on stdin. A program that stops
+// with exit code 1 returns the error it printed on stderr; anything else
+// that goes wrong fails the test.
+func runTool(t *testing.T, input, name string, args ...string) (string, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdin = strings.NewReader(input)
+	var stdout, stderr strings.Builder
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return stdout.String(), errors.New(strings.TrimSpace(stderr.String()))
+	}
+	if err != nil {
+		t.Fatalf("%s failed: %v\n%s", filepath.Base(name), err, stderr.String())
+	}
+	if stderr.Len() > 0 {
+		t.Errorf("Expected nothing on stderr, got: %s", stderr.String())
+	}
+	return stdout.String(), nil
+}
+
+// writeTemp writes generated code to a file in the test's temporary
+// directory and returns its path
+func writeTemp(t *testing.T, name string, code []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, code, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// runC compiles the C with the compiler in $CC (or cc) and runs it
+func runC(t *testing.T, program *Program, input string) (string, error) {
+	cc := os.Getenv("CC")
+	if cc == "" {
+		cc = "cc"
+	}
+	return runTool(t, input, compileC(t, lookTool(t, cc), program))
+}
+
+// runLLVM runs the IR with lli
+func runLLVM(t *testing.T, program *Program, input string) (string, error) {
+	lli := lookTool(t, "lli")
+	var args []string
+	if llvmMajorVersion(lli) < 15 {
+		args = append(args, "-opaque-pointers")
+	}
+	return runTool(t, input, lli, append(args, writeTemp(t, "program.ll", []byte(generateLLVM(program))))...)
+}
+
+// runWasm compiles a program to a binary module and runs it on wasmMachine
+func runWasm(t *testing.T, program *Program, input string) (string, error) {
+	t.Helper()
+	var m *wasmMachine
+	var out strings.Builder
+	m, err := decodeWasm(generateWasm(program).GetWasm(), wasmHost(&m, &out, bufio.NewReader(strings.NewReader(input))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.invoke("run")
+	return out.String(), err
+}
+
+// runWasmInNode runs a binary module with ozul_host.js in Node.js
+func runWasmInNode(t *testing.T, program *Program, input string) (string, error) {
+	node := lookTool(t, "node")
+	script := fmt.Sprintf(`const fs = require("fs");
+const { runOzul } = require(%q);
+const lines = fs.readFileSync(0, "utf8").split("\n");
+runOzul(fs.readFileSync(process.argv[1]), {
+  write: (text) => process.stdout.write(text),
+  read: () => (lines.length ? lines.shift() : null),
+}).catch((e) => { process.stderr.write(e.message + "\n"); process.exit(1); });`, mustAbs(t, "ozul_host.js"))
+	return runTool(t, input, node, "-e", script, writeTemp(t, "program.wasm", generateWasm(program).GetWasm()))
+}
+
+// runJS runs the script with Node.js
+func runJS(t *testing.T, program *Program, input string) (string, error) {
+	return runTool(t, input, lookTool(t, "node"), writeTemp(t, "program.js", []byte(generateJS(program))))
+}
+
+// runGo builds the program with the go tool and runs it
+func runGo(t *testing.T, program *Program, input string) (string, error) {
+	if testing.Short() {
+		t.Skip("builds a program for each case")
+	}
+	gotool := lookTool(t, "go")
+	source := writeTemp(t, "main.go", []byte(generateGo(program)))
+	binary := filepath.Join(filepath.Dir(source), "program")
+	if out, err := exec.Command(gotool, "build", "-o", binary, source).CombinedOutput(); err != nil {
+		t.Fatalf("go build failed: %v\n%s", err, out)
+	}
+	return runTool(t, input, binary)
+}
+
+// backendPrograms are run by the interpreter and by every backend, which
+// must behave alike
+var backendPrograms = []struct {
+	name, source, input string
+}{
+	{"Numbers", `Pikachu hp is 10
+Psyduck speed is 2.5
+release hp * 2 + 1
+release speed / 2
+release 1000000.0
+release 123456.5
+release 0.00001
+release hp / 3
+Pikachu neg is 0 - 2
+release 7 / neg
+Pikachu min is 0 - 9223372036854775807 - 1
+release min
+Pikachu minusOne is neg + 1
+release min / minusOne
+release 2 / 4.0`, ""},
+	{"Strings", `Pikachu hp is 10
+Psyduck speed is 2.5
+Eevee name is "Ash"
+release name + " has " + hp + " hp and " + speed + " speed " + true
+Pikachu neg is 0 - 120
+release "" + neg + " " + 0 + " " + 0.1
+release "ab" < "abc" and "b" > "abc" and "x" == "x" and "x" != "y"
+release "Ash" >= "Ask"`, ""},
+	{"Logic", `Pikachu hp is 30
+release hp > 0 and not hp > 100
+release hp < 0 or hp == 30
+release false or 1 / 0 == 1 and true
+release true == true
+release 3 < 2.5`, ""},
+	{"Control", `Pikachu total is 0
+train while total < 10
+total evolves to total + 3
+if total == 6 then
+release "six"
+else if total > 6 then
+release "big"
+else
+release total
+end
+end
+repeat total / 4 times
+release "rep"
+end
+Pikachu i is 0
+repeat 0 - 1 times
+release "never"
+end`, ""},
+	{"Moves", `move fact(Pikachu n) gives Pikachu
+if n <= 1 then
+return 1
+end
+return n * fact(n - 1)
+end
+move greet(Eevee name, Pikachu count)
+repeat count times
+release "Hi " + name
+end
+return
+release "unreachable"
+end
+move scale(Psyduck x) gives Psyduck
+return x * 2
+end
+release fact(20)
+greet("Misty", 2)
+release scale(3)
+Pikachu fact is 5
+release fact`, ""},
+	{"Scopes", `Pikachu hp is 10
+if hp > 0 then
+Pikachu hp is 99
+release hp
+end
+release hp
+catch hp from trainer
+release hp`, "42\n"},
+	{"Catch", `catch Pikachu age from trainer
+catch Eevee full from trainer
+catch Psyduck h from trainer
+catch fresh from trainer
+release age + 1
+release full
+release h * 2
+release fresh`, "41\nMisty Waterflower\n1.75\n  -3 \n"},
+	{"DivisionByZero", `Pikachu hp is 10
+release hp
+Pikachu zero is hp - 10
+release hp / zero
+release "after"`, ""},
+	{"FloatDivisionByZero", `release 1.5 / 0`, ""},
+	{"Operators", `Pikachu hp is 7
+Psyduck speed is 2.5
+release 10 - 3 - 2
+release 2 ** 3 ** 2
+release (2 ** 3) ** 2
+release -hp ** 2
+release (0 - hp) ** 3
+release 3 ** 41
+release 2 ** 64
+release hp % 3
+release -hp % 3
+release hp % -3
+release speed % 1
+release -7.5 % 2
+release 2 ** 0.5
+release speed ** 2
+release 2.0 ** -1
+release (hp + 1) * -(speed)
+release - -hp
+release -speed
+Pikachu min is 0 - 9223372036854775807 - 1
+Pikachu minusOne is 0 - 1
+release -min
+release min % minusOne
+release "{-hp}, {hp % 4 ** 2}"`, ""},
+	{"RemainderByZero", `Pikachu zero is 0
+release 7 % 1
+release 7 % zero`, ""},
+	{"FloatRemainderByZero", `Psyduck zero is 0.0
+release 7.5 % zero`, ""},
+	{"NegativeExponent", `Pikachu exp is 0 - 1
+release 2 ** 0
+release 2 ** exp
+release "after"`, ""},
+	{"BadInput", `release "before"
+catch Pikachu age from trainer`, "forty\n"},
+	// Names in other scripts, one with a combining mark (U+0308) and one
+	// with Devanagari vowel signs
+	{"Unicode", "move попадание(Pikachu урон) gives Pikachu\nreturn урон * 2\nend\n" +
+		"Eevee café is \"Zoë 🎉\"\nPikachu 名前 is попадание(21)\nPsyduck nai\u0308ve is 1.5\nPikachu नमस्ते is 3\n" +
+		"release café + \" \" + 名前\nrelease nai\u0308ve * नमस्ते\n" +
+		"catch Eevee trainer_ñame from trainer\nrelease trainer_ñame + \"!\"", "Ñandú 🐦\n"},
+	// Escapes, a raw string over several lines and interpolations, which
+	// join as text even when every part is a number
+	{"Interpolation", `move half(Pikachu n) gives Psyduck
+return n / 2.0
+end
+Pikachu hp is 7
+Pikachu level is 5
+release "tab\there \"quoted\" back\\slash \{braces} \u{1F525}"
+release """raw "text" with \n and {hp}
+over two lines"""
+release "HP: {hp}, half: {half(hp)}, alive: {hp > 0}"
+release "{hp}{level}"
+release "{"nested " + "{level}"}!"
+catch Eevee name from trainer
+release "Hi {name}, you have {hp * 10} XP"`, "Misty\n"},
+	// A declaration that shadows a variable reads the outer one
+	{"Shadowing", `Pikachu hp is 10
+if hp > 0 then
+release hp
+Pikachu hp is hp + 1
+release hp
+train while hp > 9
+hp evolves to hp - 1
+Psyduck hp is 0.5
+release hp
+hp evolves to 20
+end
+release hp
+hp evolves to 0
+end
+release hp`, ""},
+	// Pikachu values are 64 bits everywhere and wrap around on overflow
+	{"Overflow", `Pikachu big is 9223372036854775807
+release big + 1
+release big * 3
+release 0 - big - 2
+release big / 2 * 2.0
+release 3 ** 40 + 3 ** 41`, ""},
+	// Names JavaScript reserves, and its own string and number rules
+	{"JSReservedNames", `Pikachu class is 3
+Eevee new is "new"
+move delete(Pikachu this) gives Pikachu
+Pikachu class is 4
+return this * class
+end
+release new + delete(class)`, ""},
+	{"UnicodeStrings", `release "é" < "z"
+release "😀" > "ｚ"
+release "é" + 1`, ""},
+	{"FloatFormats", `release 100000.0 * 10
+release 0.1 + 0.2
+release 1.0 / 3
+release "" + 0.0078125 + " " + 1.0 / 3
+Psyduck tiny is 0.00001
+release tiny
+release 0.0 - 0.0`, ""},
+	// Go's own rules: names it reserves, unused variables and exact
+	// constants
+	{"GoReservedNames", `Pikachu func is 3
+Eevee string is "new"
+Voltorb nil is false
+move fmt(Pikachu main) gives Pikachu
+Pikachu fmt is 4
+return main * fmt
+end
+release string + fmt(func) + nil`, ""},
+	{"Unused", `Pikachu unused is 1
+catch Eevee ignored from trainer
+if true then
+Psyduck inner is 2
+end`, "x\n"},
+	{"Constants", `release 0.1 + 0.2 + 0.3
+release 1.0 / 3 * 3
+release 7 / 2 * 1.5
+release 2 / 4.0 + 0.1
+release 9223372036854775807 / 2
+release 0.1 * 3 == 0.3`, ""},
+	{"RepeatCount", `Pikachu n is 3
+repeat n times
+n evolves to n + 1
+release n
+end
+move twice(Pikachu n) gives Pikachu
+release "counted"
+return n * 2
+end
+repeat twice(1) times
+release "rep"
+end`, ""},
+}
+
+// TestBackends_MatchInterpreter runs backendPrograms with every backend and
+// expects the output and the failure, if any, that the interpreter gives
+func TestBackends_MatchInterpreter(t *testing.T) {
+	for _, b := range backends {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			for _, tc := range backendPrograms {
+				tc := tc
+				t.Run(tc.name, func(t *testing.T) {
+					program := parseChecked(t, tc.source)
+					expected, runErr := runInterpreterWithOutput(program, tc.input)
+
+					output, err := b.run(t, program, tc.input)
+					if output != expected {
+						t.Errorf("Expected:\n%s\nGot:\n%s", expected, output)
+					}
+					expectSameFailure(t, runErr, err)
+				})
+			}
+		})
+	}
+}
+
+// expectSameFailure checks that a compiled program failed where the
+// interpreter did, with the same message
+func expectSameFailure(t *testing.T, runErr error, err error) {
+	t.Helper()
+	if runErr == nil {
+		if err != nil {
+			t.Errorf("Expected no error, got: %v", err)
+		}
+		return
+	}
+	runtimeErr, ok := runErr.(*RuntimeError)
+	if !ok {
+		t.Fatalf("The interpreter failed without a runtime error: %v", runErr)
+	}
+	if err == nil || strings.TrimSpace(err.Error()) != runtimeErr.Error() {
+		t.Errorf("Expected %q, got: %v", runtimeErr.Error(), err)
+	}
+}
+
+// backendCode lists code that a backend is expected to emit for a program,
+// and code it must leave out
+var backendCode = []struct {
+	backend, name, source string
+	expected, unexpected  []string
+}{
+	{"LLVM", "Program", `Pikachu hp is 10
+Psyduck speed is 2.5
+Eevee name is "Ash"
+release name + " has " + hp
+release hp / 3
+move half(Psyduck x) gives Psyduck
+return x / 2
+end
+release half(speed)`, []string{
+		"define i32 @main()",
+		"%hp.1 = alloca i64",
+		"%speed.2 = alloca double",
+		"%name.3 = alloca ptr",
+		`c"Ash\00"`,
+		"@ozul_concat",
+		"@ozul_int_str",
+		"sdiv i64",
+		"define internal double @move.half(double %arg0)",
+		"call double @move.half",
+		"fdiv double",
+		"call ptr @ozul_format_float",
+		"ret i32 0",
+	}, nil},
+	{"LLVM", "ControlFlow", `Pikachu n is 0
+train while n < 5 and not false
+n evolves to n + 1
+end
+repeat 3 times
+if n == 5 then
+release "five"
+else
+release n
+end
+end`, []string{
+		"icmp slt i64",
+		"phi i1",
+		"xor i1",
+		"br i1",
+		"icmp eq i64",
+	}, nil},
+	{"WASM", "Module", `move half(Psyduck x) gives Psyduck
+return x / 2
+end
+Pikachu hp is 10
+release half(hp)
+release "hp: " + hp
+catch Eevee name from trainer`, []string{
+		`(import "ozul" "release_float" (func $release_float (param f64)))`,
+		`(import "ozul" "catch_string" (func $catch_string (param i32) (param i32) (result i32)))`,
+		`(memory (export "memory") 1)`,
+		`(func $ozul.alloc (export "alloc") (param $size i32) (result i32)`,
+		`(func $move.half (param $x f64) (result f64)`,
+		`call $ozul.div_f64`,
+		`(func $main (export "run")`,
+		`(local $hp.1 i64)`,
+		"i64.const 10\n    local.set $hp.1",
+		"local.get $hp.1\n    f64.convert_i64_s\n    call $move.half\n    call $release_float",
+		`call $ozul.int_to_string`,
+		`call $ozul.concat`,
+		`call $catch_string`,
+	}, nil},
+	{"WASM", "ControlFlow", `Pikachu n is 3
+train while n > 0
+if n == 2 then
+release "two"
+else
+release n
+end
+n evolves to n - 1
+end
+repeat n + 2 times
+release true and n < 1
+end`, []string{
+		"block $while.end.2\n      loop $while.cond.3",
+		"i32.eqz\n        br_if $while.end.2",
+		"br $while.cond.3",
+		"if\n",
+		"else\n",
+		"local.set $repeat.count.",
+		"i64.ge_s",
+		"if (result i32)",
+	}, nil},
+	{"JS", "Program", `move half(Psyduck x) gives Psyduck
+return x / 2
+end
+Pikachu hp is 10
+Psyduck speed is hp
+release hp / 3 + half(hp)
+release "hp: " + hp + " speed: " + speed + " " + true
+catch Eevee name from trainer
+catch hp from trainer
+if name < "M" or not hp == 1 then
+release 1.5
+else if hp > speed then
+release false
+end`, []string{
+		"function move$half(x) {\n      return $fdiv(x, 2, 2);\n    }",
+		"let hp = 10n;",
+		"let speed = Number(hp);",
+		"$io.write($format((Number($div(hp, 3n, 6)) + move$half(Number(hp)))) + \"\\n\");",
+		`$io.write(((((("hp: " + String(hp)) + " speed: ") + $fixed(speed)) + " ") + String(true)) + "\n");`,
+		`let name = $catchString($io, "name");`,
+		`hp = $catchInt($io, "hp", 9);`,
+		`if (($compare(name, "M") < 0) || !(hp === 1n)) {`,
+		"} else if (Number(hp) > speed) {",
+	}, nil},
+	{"JS", "Names", `Pikachu class is 1
+Pikachu hp is 2
+if hp > 1 then
+release hp
+Pikachu hp is hp + class
+repeat hp times
+Eevee hp is "inner"
+end
+end
+move hp(Pikachu new) gives Pikachu
+return new
+end
+release hp(hp)`, []string{
+		"let class$ = 1n;",
+		"let hp$2 = $int(hp + class$);",
+		"for (let $i1 = 0n, $n1 = hp$2; $i1 < $n1; $i1++) {",
+		`let hp$3 = "inner";`,
+		"function move$hp(new$) {\n      return new$;",
+		"move$hp(hp)",
+	}, nil},
+	{"Go", "Program", `move half(Psyduck x) gives Psyduck
+return x / 2
+end
+Pikachu hp is 10
+Psyduck speed is hp
+Psyduck whole is 3
+release hp / 3 + half(hp)
+release "hp: " + hp + " speed: " + speed + " " + true + " 100%"
+release 0.1 + 0.2
+catch Eevee name from trainer
+catch hp from trainer
+if name < "M" or not hp == 1 then
+release 1.5
+else if hp > speed / hp then
+release false
+end
+train while true
+release 1 / 0
+end`, []string{
+		"func half(x float64) float64 {\n\treturn x / 2\n}",
+		"\thp := 10\n",
+		"\tspeed := float64(hp)\n",
+		"\twhole := 3.0\n\t_ = whole\n",
+		"fmt.Println(float64(hp/3) + half(float64(hp)))",
+		`fmt.Printf("hp: %d speed: %f %t 100%%\n", hp, speed, true)`,
+		"fmt.Println(float64(0.1) + 0.2)",
+		`name := catchString("name")`,
+		`hp = catchInt("hp", 11)`,
+		`if name < "M" || !(hp == 1) {`,
+		"} else if float64(hp) > divide(speed, float64(hp), 14) {",
+		"\tfor {\n\t\tfmt.Println(divide(1, 0, 18))",
+		"import (\n\t\"bufio\"\n\t\"fmt\"\n\t\"os\"\n\t\"strconv\"\n\t\"strings\"\n)",
+		"func divide[T int | float64](a, b T, line int) T {",
+	}, []string{"catchFloat"}},
+	{"Go", "Names", `Pikachu type is 1
+Pikachu i is 2
+Pikachu fmt is 3
+repeat i times
+repeat 2 times
+release type + fmt
+end
+end
+repeat i times
+i evolves to i - 1
+end
+move len(Pikachu string) gives Pikachu
+return string
+end
+Pikachu len is len(i)
+release "done"`, []string{
+		"type_ := 1",
+		"fmt_ := 3",
+		"for j := 0; j < i; j++ {\n\t\tfor k := 0; k < 2; k++ {",
+		"fmt.Println(type_ + fmt_)",
+		"for j, n := 0, i; j < n; j++ {",
+		"func len_(string_ int) int {",
+		"len__ := len_(i)\n\t_ = len__",
+		`fmt.Println("done")`,
+	}, nil},
+}
+
+// TestBackends_GeneratedCode checks the code in backendCode
+func TestBackends_GeneratedCode(t *testing.T) {
+	for _, tc := range backendCode {
+		t.Run(tc.backend+"/"+tc.name, func(t *testing.T) {
+			code := findBackend(t, tc.backend).generate(parseChecked(t, tc.source))
+			for _, expected := range tc.expected {
+				if !strings.Contains(code, expected) {
+					t.Errorf("Expected code to contain %q, got:\n%s", expected, code)
+				}
+			}
+			for _, unexpected := range tc.unexpected {
+				if strings.Contains(code, unexpected) {
+					t.Errorf("Expected code without %q, got:\n%s", unexpected, code)
+				}
+			}
+		})
+	}
+}
+
+// TestBackends_TypeErrors checks that every backend refuses to generate code
+// for a program with a type error
+func TestBackends_TypeErrors(t *testing.T) {
+	program := NewParser(NewLexer(`Pikachu x is "hello"`).Tokenize()).Parse()
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			defer func() {
+				r := recover()
+				if r == nil || !strings.Contains(fmt.Sprint(r), "must be a Pikachu") {
+					t.Errorf("Expected a type error, got: %v", r)
+				}
+			}()
+
+			b.generate(program)
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Opcode is one bytecode instruction. Arithmetic, comparison and conversion
+// opcodes are typed: the compiler knows the type of every operand, so the VM
+// never has to look at a value's type to decide what to do.
+type Opcode uint8
+
+const (
+	OpConst Opcode = iota // push Constants[Arg]
+	OpPop                 // drop the top value
+	OpStep                // count one step against the step budget
+	OpHalt                // stop the program
+	OpFail                // raise Failures[Arg] as a runtime error
+	OpCatch               // pop a variable name, prompt for it and push the trainer's input as catchTypes[Arg]
+	OpPrint               // pop a value and release it
+
+	// Variables: Arg is a slot of the current frame, or a global's index
+	OpLoadLocal
+	OpStoreLocal
+	OpLoadGlobal
+	OpStoreGlobal
+	OpDefineGlobal // pop a value into a new global; Arg is always the next index
+
+	// Jumps: Arg is the target instruction
+	OpJump
+	OpJumpIfFalse // pop a bool and jump if it is false
+	OpJumpIfTrue  // pop a bool and jump if it is true
+
+	// Moves
+	OpCall       // call the move the compiler numbered Arg, whose arguments are on the stack
+	OpReturn     // return the top value to the caller
+	OpReturnVoid // return without a value
+
+	// Typed arithmetic
+	OpAddInt
+	OpSubInt
+	OpMulInt
+	OpDivInt
+	OpModInt
+	OpPowInt
+	OpNegInt
+	OpAddFloat
+	OpSubFloat
+	OpMulFloat
+	OpDivFloat
+	OpModFloat
+	OpPowFloat
+	OpNegFloat
+	OpConcat
+
+	// Typed comparisons: Arg is the operator, one of CmpEq to CmpGe
+	OpCompareInt
+	OpCompareFloat
+	OpCompareString
+	OpCompareBool
+	OpNot
+
+	// Conversions: Arg is how far below the top of the stack the value is
+	OpIntToFloat
+	OpStringToInt
+	OpStringToFloat
+	OpIntToString
+	OpFloatToString
+	OpBoolToString
+)
+
+var opcodeNames = [...]string{
+	OpConst:         "CONST",
+	OpPop:           "POP",
+	OpStep:          "STEP",
+	OpHalt:          "HALT",
+	OpFail:          "FAIL",
+	OpCatch:         "CATCH",
+	OpPrint:         "PRINT",
+	OpLoadLocal:     "LOAD_LOCAL",
+	OpStoreLocal:    "STORE_LOCAL",
+	OpLoadGlobal:    "LOAD_GLOBAL",
+	OpStoreGlobal:   "STORE_GLOBAL",
+	OpDefineGlobal:  "DEFINE_GLOBAL",
+	OpJump:          "JUMP",
+	OpJumpIfFalse:   "JUMP_IF_FALSE",
+	OpJumpIfTrue:    "JUMP_IF_TRUE",
+	OpCall:          "CALL",
+	OpReturn:        "RETURN",
+	OpReturnVoid:    "RETURN_VOID",
+	OpAddInt:        "ADD_INT",
+	OpSubInt:        "SUB_INT",
+	OpMulInt:        "MUL_INT",
+	OpDivInt:        "DIV_INT",
+	OpModInt:        "MOD_INT",
+	OpPowInt:        "POW_INT",
+	OpNegInt:        "NEG_INT",
+	OpAddFloat:      "ADD_FLOAT",
+	OpSubFloat:      "SUB_FLOAT",
+	OpMulFloat:      "MUL_FLOAT",
+	OpDivFloat:      "DIV_FLOAT",
+	OpModFloat:      "MOD_FLOAT",
+	OpPowFloat:      "POW_FLOAT",
+	OpNegFloat:      "NEG_FLOAT",
+	OpConcat:        "CONCAT",
+	OpCompareInt:    "COMPARE_INT",
+	OpCompareFloat:  "COMPARE_FLOAT",
+	OpCompareString: "COMPARE_STRING",
+	OpCompareBool:   "COMPARE_BOOL",
+	OpNot:           "NOT",
+	OpIntToFloat:    "INT_TO_FLOAT",
+	OpStringToInt:   "STRING_TO_INT",
+	OpStringToFloat: "STRING_TO_FLOAT",
+	OpIntToString:   "INT_TO_STRING",
+	OpFloatToString: "FLOAT_TO_STRING",
+	OpBoolToString:  "BOOL_TO_STRING",
+}
+
+func (op Opcode) String() string {
+	if int(op) < len(opcodeNames) && opcodeNames[op] != "" {
+		return opcodeNames[op]
+	}
+	return fmt.Sprintf("Opcode(%d)", op)
+}
+
+// Comparison operators, as the Arg of a compare instruction
+const (
+	CmpEq int32 = iota
+	CmpNe
+	CmpLt
+	CmpLe
+	CmpGt
+	CmpGe
+)
+
+var compareOps = [...]string{CmpEq: "==", CmpNe: "!=", CmpLt: "<", CmpLe: "<=", CmpGt: ">", CmpGe: ">="}
+
+// catchTypes lists the Pokemon types the Arg of OpCatch refers to
+var catchTypes = []string{"Pikachu", "Psyduck", "Eevee", "Voltorb"}
+
+// Instruction is an opcode and its single operand
+type Instruction struct {
+	Op  Opcode
+	Arg int32
+}
+
+// Function is the compiled code of a move, or of a program's top level.
+// Arguments arrive in the first slots; the remaining slots hold the
+// variables of the body's blocks.
+type Function struct {
+	Name       string
+	Params     int
+	Slots      int
+	ReturnType string // Pokemon type the move gives, "" for none
+
+	Code      []Instruction
+	Spans     []Span  // source of each instruction, for runtime errors
+	Constants []Value // constant pool
+	Failures  []*RuntimeError
+}
+
+// Disassemble writes a readable listing of the function's code
+func (fn *Function) Disassemble(w io.Writer) {
+	fmt.Fprintf(w, "== %s (params %d, slots %d) ==\n", fn.Name, fn.Params, fn.Slots)
+	for pc, ins := range fn.Code {
+		line := fmt.Sprintf("%04d %4d  %-15s", pc, fn.Spans[pc].Start.Line, ins.Op)
+		switch ins.Op {
+		case OpConst:
+			c := fn.Constants[ins.Arg]
+			if c.Type == "string" {
+				line += fmt.Sprintf(" %d (%q)", ins.Arg, c.Str)
+			} else {
+				line += fmt.Sprintf(" %d (%s)", ins.Arg, c)
+			}
+		case OpFail:
+			line += fmt.Sprintf(" %d (%s)", ins.Arg, fn.Failures[ins.Arg].Message)
+		case OpCatch:
+			line += " " + catchTypes[ins.Arg]
+		case OpCompareInt, OpCompareFloat, OpCompareString, OpCompareBool:
+			line += " " + compareOps[ins.Arg]
+		case OpLoadLocal, OpStoreLocal, OpLoadGlobal, OpStoreGlobal, OpDefineGlobal, OpJump, OpJumpIfFalse, OpJumpIfTrue, OpCall:
+			line += fmt.Sprintf(" %d", ins.Arg)
+		}
+		fmt.Fprintln(w, strings.TrimRight(line, " "))
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"fmt"
+)
+
+// unknownType marks an expression whose type could not be worked out
+// because of an earlier error, so one mistake is reported only once
+const unknownType = "?"
+
+// Checker is the semantic analysis pass that runs between parsing and
+// execution. It infers a Pokemon type for every expression and enforces the
+// declared types of variables, parameters and return values.
+type Checker struct {
+	// Declared Pokemon type of each variable, one scope per block
+	scope *Scope[string]
+
+	// Declared moves, by name
+	functions map[string]*FunctionDecl
+
+	// Move whose body is being checked (nil at the top level)
+	function *FunctionDecl
+
+	// Statement being checked, blamed for errors that have no better span
+	stmt Statement
+
+	// Inferred Pokemon type of every checked expression
+	types map[Expression]string
+
+	errors []PokemonError
+}
+
+// NewChecker creates a checker with an empty top-level scope
+func NewChecker() *Checker {
+	return &Checker{
+		scope:     NewScope[string](nil),
+		functions: make(map[string]*FunctionDecl),
+		types:     make(map[Expression]string),
+	}
+}
+
+// Check analyses a program and returns every type error found in it.
+// Declarations stay in the checker's scope, so a later Check call sees the
+// variables and moves of earlier ones.
+func (c *Checker) Check(program *Program) []PokemonError {
+	c.errors = nil
+
+	// Register moves first so calls may appear before the declaration
+	for _, stmt := range program.Statements {
+		if fn, ok := stmt.(*FunctionDecl); ok {
+			if _, exists := c.functions[fn.Name]; exists {
+				c.errorAt(fn.Pos(), "move %s is already declared", fn.Name)
+				continue
+			}
+			c.functions[fn.Name] = fn
+		}
+	}
+
+	c.checkStatements(program.Statements)
+	return c.errors
+}
+
+// TypeOf returns the Pokemon type inferred for an expression by the last
+// Check, or an empty string for expressions the checker has not seen
+func (c *Checker) TypeOf(expr Expression) string {
+	return c.types[expr]
+}
+
+// VariableType returns the declared Pokemon type of a top-level variable
+func (c *Checker) VariableType(name string) (string, bool) {
+	return c.scope.Lookup(name)
+}
+
+// errorAt records a type error covering a span of source
+func (c *Checker) errorAt(span Span, format string, args ...interface{}) {
+	c.errors = append(c.errors, NewPokemonError("type", span, fmt.Sprintf(format, args...)))
+}
+
+// addError records a type error about the statement being checked
+func (c *Checker) addError(format string, args ...interface{}) {
+	c.errorAt(c.stmt.Pos(), format, args...)
+}
+
+func (c *Checker) checkStatements(stmts []Statement) {
+	for _, stmt := range stmts {
+		c.checkStatement(stmt)
+	}
+}
+
+// checkBlock checks a nested block in its own scope
+func (c *Checker) checkBlock(stmts []Statement) {
+	outer := c.scope
+	c.scope = NewScope(outer)
+	c.checkStatements(stmts)
+	c.scope = outer
+}
+
+func (c *Checker) checkStatement(stmt Statement) {
+	c.stmt = stmt
+	switch s := stmt.(type) {
+	case *DeclarationStmt:
+		valueType := c.checkExpression(s.Value)
+		if _, ok := valueTypes[s.PokemonType]; !ok {
+			c.addError("unknown Pokemon type %s", s.PokemonType)
+			return
+		}
+		c.expectAssignable(s.Value, s.PokemonType, valueType, "variable "+s.Name)
+		if err := c.scope.Declare(s.Name, s.PokemonType); err != nil {
+			c.addError("variable %s is already declared in this scope", s.Name)
+		}
+	case *AssignmentStmt:
+		valueType := c.checkExpression(s.Value)
+		declared, ok := c.scope.Lookup(s.Name)
+		if !ok {
+			c.addError("variable %s is not declared", s.Name)
+			return
+		}
+		c.expectAssignable(s.Value, declared, valueType, "variable "+s.Name)
+	case *ReleaseStmt:
+		c.checkExpression(s.Value)
+	case *CatchStmt:
+		c.checkCatch(s)
+	case *IfStmt:
+		c.expectType(s.Condition, "Voltorb", c.checkExpression(s.Condition), "'if' condition")
+		c.checkBlock(s.Then)
+		if s.Else != nil {
+			c.checkBlock(s.Else)
+		}
+	case *WhileStmt:
+		c.expectType(s.Condition, "Voltorb", c.checkExpression(s.Condition), "'train while' condition")
+		c.checkBlock(s.Body)
+	case *RepeatStmt:
+		c.expectType(s.Count, "Pikachu", c.checkExpression(s.Count), "'repeat' count")
+		c.checkBlock(s.Body)
+	case *FunctionDecl:
+		c.checkFunction(s)
+	case *ReturnStmt:
+		c.checkReturn(s)
+	case *ExpressionStmt:
+		if call, ok := s.Expr.(*CallExpr); ok {
+			c.checkCall(call) // a move without a return type is fine here
+		} else {
+			c.checkExpression(s.Expr)
+		}
+	}
+}
+
+// checkCatch follows the catch rules shared by both backends: a typed catch
+// declares a new variable like a declaration does, while an untyped one
+// reads into a visible variable or else declares a new Pikachu.
+func (c *Checker) checkCatch(s *CatchStmt) {
+	declared, exists := c.scope.Lookup(s.Variable)
+	if s.PokemonType != "" || !exists {
+		declared = s.PokemonType
+		if declared == "" {
+			declared = "Pikachu"
+		}
+		if _, ok := valueTypes[declared]; !ok {
+			c.addError("unknown Pokemon type %s", declared)
+			return
+		}
+		if err := c.scope.Declare(s.Variable, declared); err != nil {
+			c.addError("variable %s is already declared in this scope", s.Variable)
+		}
+	}
+	if declared == "Voltorb" {
+		c.addError("cannot catch a Voltorb from the trainer")
+	}
+}
+
+func (c *Checker) checkFunction(fn *FunctionDecl) {
+	if fn.ReturnType != "" {
+		if _, ok := valueTypes[fn.ReturnType]; !ok {
+			c.addError("unknown Pokemon type %s", fn.ReturnType)
+		}
+	}
+
+	// Moves cannot see the variables around them; parameters and the
+	// body's top-level declarations share one scope
+	outerScope, outerFunction := c.scope, c.function
+	c.scope = NewScope[string](nil)
+	c.function = fn
+	for _, param := range fn.Params {
+		if _, ok := valueTypes[param.PokemonType]; !ok {
+			c.errorAt(param.Pos(), "unknown Pokemon type %s", param.PokemonType)
+		}
+		if err := c.scope.Declare(param.Name, param.PokemonType); err != nil {
+			c.errorAt(param.Pos(), "parameter %s is declared twice in move %s", param.Name, fn.Name)
+		}
+	}
+
+	c.checkStatements(fn.Body)
+	if fn.ReturnType != "" && !alwaysReturns(fn.Body) {
+		c.errorAt(fn.Pos(), "move %s may end without giving back a %s", fn.Name, fn.ReturnType)
+	}
+
+	c.scope, c.function = outerScope, outerFunction
+}
+
+func (c *Checker) checkReturn(s *ReturnStmt) {
+	if c.function == nil {
+		c.addError("'return' can only be used inside a move")
+		return
+	}
+	if s.Value == nil {
+		if c.function.ReturnType != "" {
+			c.addError("move %s must give back a %s", c.function.Name, c.function.ReturnType)
+		}
+		return
+	}
+	valueType := c.checkExpression(s.Value)
+	if c.function.ReturnType == "" {
+		c.addError("move %s does not declare a return type but returns a value", c.function.Name)
+		return
+	}
+	c.expectAssignable(s.Value, c.function.ReturnType, valueType, "return value of move "+c.function.Name)
+}
+
+// alwaysReturns reports whether every path through a block ends in a return
+func alwaysReturns(stmts []Statement) bool {
+	if len(stmts) == 0 {
+		return false
+	}
+	switch s := stmts[len(stmts)-1].(type) {
+	case *ReturnStmt:
+		return true
+	case *IfStmt:
+		return s.Else != nil && alwaysReturns(s.Then) && alwaysReturns(s.Else)
+	}
+	return false
+}
+
+// checkExpression infers and records the type of an expression
+func (c *Checker) checkExpression(expr Expression) string {
+	t := c.inferExpression(expr)
+	if expr != nil {
+		c.types[expr] = t
+	}
+	return t
+}
+
+func (c *Checker) inferExpression(expr Expression) string {
+	switch e := expr.(type) {
+	case *NumberLiteral:
+		return "Pikachu"
+	case *FloatLiteral:
+		return "Psyduck"
+	case *InterpolatedString:
+		return c.checkExpression(e.Value)
+	case *StringLiteral:
+		return "Eevee"
+	case *BooleanLiteral:
+		return "Voltorb"
+	case *Identifier:
+		t, ok := c.scope.Lookup(e.Name)
+		if !ok {
+			c.errorAt(e.Pos(), "undefined variable %s", e.Name)
+			return unknownType
+		}
+		return t
+	case *CallExpr:
+		t := c.checkCall(e)
+		if t == "" {
+			c.errorAt(e.Pos(), "move %s does not give a value", e.Name)
+			return unknownType
+		}
+		return t
+	case *UnaryExpr:
+		operand := c.checkExpression(e.Operand)
+		if e.Operator == "not" {
+			c.expectType(e.Operand, "Voltorb", operand, "operand of 'not'")
+			return "Voltorb"
+		}
+		if e.Operator == "-" {
+			if operand == unknownType {
+				return unknownType
+			}
+			if !isNumericType(operand) {
+				c.errorAt(e.Operand.Pos(), "operand of '-' must be a Pikachu or Psyduck, got %s", operand)
+				return unknownType
+			}
+			return operand
+		}
+		c.errorAt(e.Pos(), "unknown operator %s", e.Operator)
+		return unknownType
+	case *BinaryExpr:
+		return c.inferBinary(e)
+	case nil:
+		return unknownType // already reported by the parser
+	default:
+		c.errorAt(expr.Pos(), "unknown expression %s", expr.String())
+		return unknownType
+	}
+}
+
+// checkCall checks a move call and returns its return type ("" for moves
+// that give nothing back)
+func (c *Checker) checkCall(call *CallExpr) string {
+	argTypes := make([]string, len(call.Args))
+	for i, arg := range call.Args {
+		argTypes[i] = c.checkExpression(arg)
+	}
+
+	fn, ok := c.functions[call.Name]
+	if !ok {
+		c.errorAt(call.Pos(), "undefined move %s", call.Name)
+		return unknownType
+	}
+	c.types[call] = fn.ReturnType
+	if len(call.Args) != len(fn.Params) {
+		c.errorAt(call.Pos(), "move %s expects %d arguments, got %d", fn.Name, len(fn.Params), len(call.Args))
+		return fn.ReturnType
+	}
+	for i, param := range fn.Params {
+		c.expectAssignable(call.Args[i], param.PokemonType, argTypes[i], "argument "+param.Name+" of move "+fn.Name)
+	}
+	return fn.ReturnType
+}
+
+func (c *Checker) inferBinary(e *BinaryExpr) string {
+	left := c.checkExpression(e.Left)
+	right := c.checkExpression(e.Right)
+	if left == unknownType || right == unknownType {
+		return unknownType
+	}
+
+	switch e.Operator {
+	case "and", "or":
+		c.expectType(e.Left, "Voltorb", left, "left side of '"+e.Operator+"'")
+		c.expectType(e.Right, "Voltorb", right, "right side of '"+e.Operator+"'")
+		return "Voltorb"
+	case "==", "!=", "<", "<=", ">", ">=":
+		switch {
+		case isNumericType(left) && isNumericType(right):
+		case left == "Eevee" && right == "Eevee":
+		case left == "Voltorb" && right == "Voltorb" && (e.Operator == "==" || e.Operator == "!="):
+		default:
+			c.errorAt(e.Pos(), "cannot compare %s with %s using %s", left, right, e.Operator)
+		}
+		return "Voltorb"
+	case "+":
+		if left == "Eevee" || right == "Eevee" {
+			return "Eevee" // any value can be joined onto a string
+		}
+	}
+
+	if !isNumericType(left) || !isNumericType(right) {
+		c.errorAt(e.Pos(), "operator %s needs Pikachu or Psyduck values, got %s and %s", e.Operator, left, right)
+		return unknownType
+	}
+	if left == "Psyduck" || right == "Psyduck" {
+		return "Psyduck"
+	}
+	return "Pikachu"
+}
+
+func isNumericType(t string) bool {
+	return t == "Pikachu" || t == "Psyduck"
+}
+
+// expectType reports an error at expr unless got is exactly want
+func (c *Checker) expectType(expr Expression, want, got, context string) {
+	if got != want && got != unknownType {
+		c.errorAt(expr.Pos(), "%s must be a %s, got %s", context, want, got)
+	}
+}
+
+// expectAssignable reports an error unless a got value can be stored where a
+// want is declared. A Pikachu may be stored in a Psyduck, which widens it.
+func (c *Checker) expectAssignable(expr Expression, want, got, context string) {
+	if got == want || got == unknownType || (want == "Psyduck" && got == "Pikachu") {
+		return
+	}
+	c.errorAt(expr.Pos(), "%s must be a %s, got %s", context, want, got)
+}
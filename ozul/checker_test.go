@@ -0,0 +1,184 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func checkSource(source string) []PokemonError {
+	program := NewParser(NewLexer(source).Tokenize()).Parse()
+	return NewChecker().Check(program)
+}
+
+func TestChecker_ValidProgram(t *testing.T) {
+	source := `Pikachu health is 100
+Psyduck speed is 3
+Eevee name is "Ash"
+Voltorb ready is health > 50 and speed < 10.5
+speed evolves to speed * 2
+Eevee label is name + ": " + health + " HP"
+move attack(Pikachu power, Psyduck bonus) gives Psyduck
+if power > 10 then
+return power * bonus
+else
+return 0
+end
+end
+catch Eevee rival from trainer
+catch level from trainer
+level evolves to level + 1
+if ready then
+release attack(health, 1.5)
+end`
+	if errs := checkSource(source); len(errs) > 0 {
+		t.Errorf("Expected no type errors, got: %v", errs)
+	}
+}
+
+func TestChecker_Errors(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{`Pikachu x is "hello"`, "variable x must be a Pikachu, got Eevee"},
+		{"Pikachu x is 5\nx evolves to 2.5", "variable x must be a Pikachu, got Psyduck"},
+		{"Eevee s is \"a\"\ns evolves to 1", "variable s must be a Eevee, got Pikachu"},
+		{"release missingno", "undefined variable missingno"},
+		{"x evolves to 1", "variable x is not declared"},
+		{"if 1 then\nend", "'if' condition must be a Voltorb, got Pikachu"},
+		{"train while \"yes\"\nend", "'train while' condition must be a Voltorb, got Eevee"},
+		{"repeat 2.5 times\nend", "'repeat' count must be a Pikachu, got Psyduck"},
+		{"release true + 1", "operator + needs Pikachu or Psyduck values, got Voltorb and Pikachu"},
+		{"release \"a\" - 1", "operator - needs Pikachu or Psyduck values"},
+		{"release \"a\" < 1", "cannot compare Eevee with Pikachu"},
+		{"release true < false", "cannot compare Voltorb with Voltorb"},
+		{"release 1 and true", "left side of 'and' must be a Voltorb"},
+		{"release not 5", "operand of 'not' must be a Voltorb"},
+		{"release -\"a\"", "operand of '-' must be a Pikachu or Psyduck, got Eevee"},
+		{"release -true", "operand of '-' must be a Pikachu or Psyduck, got Voltorb"},
+		{"release \"a\" % 2", "operator % needs Pikachu or Psyduck values"},
+		{"release true ** 2", "operator ** needs Pikachu or Psyduck values, got Voltorb and Pikachu"},
+		{"Pikachu x is 1\nPikachu x is 2", "variable x is already declared in this scope"},
+		{"release attack(1)", "undefined move attack"},
+		{"move attack(Pikachu a)\nend\nattack(\"a\")", "argument a of move attack must be a Pikachu, got Eevee"},
+		{"move attack(Pikachu a)\nend\nattack()", "move attack expects 1 arguments, got 0"},
+		{"move cheer()\nend\nrelease cheer()", "move cheer does not give a value"},
+		{"move half(Psyduck a) gives Pikachu\nreturn a / 2\nend", "return value of move half must be a Pikachu, got Psyduck"},
+		{"move lazy(Pikachu a) gives Pikachu\nif a > 0 then\nreturn a\nend\nend", "move lazy may end without giving back a Pikachu"},
+		{"move cheer()\nreturn 5\nend", "does not declare a return type but returns a value"},
+		{"move a()\nend\nmove a()\nend", "move a is already declared"},
+		{"Pikachu hp is 1\nmove peek() gives Pikachu\nreturn hp\nend", "undefined variable hp"},
+		{"catch Voltorb ok from trainer", "cannot catch a Voltorb"},
+	}
+
+	for _, tt := range tests {
+		errs := checkSource(tt.source)
+		found := false
+		for _, err := range errs {
+			if strings.Contains(err.Message, tt.expected) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%q: expected error containing %q, got: %v", tt.source, tt.expected, errs)
+		}
+	}
+}
+
+func TestChecker_ReportsAllErrorsWithLines(t *testing.T) {
+	source := `Pikachu x is "hello"
+Eevee ok is "fine"
+x evolves to 2.5
+release y`
+	errs := checkSource(source)
+
+	expectedLines := []int{1, 3, 4}
+	if len(errs) != len(expectedLines) {
+		t.Fatalf("Expected %d errors, got %d: %v", len(expectedLines), len(errs), errs)
+	}
+	for i, line := range expectedLines {
+		if errs[i].Line != line {
+			t.Errorf("error %d: expected line %d, got %d (%s)", i, line, errs[i].Line, errs[i].Message)
+		}
+	}
+}
+
+func TestChecker_ErrorsPointAtExpressions(t *testing.T) {
+	tests := []struct {
+		source    string
+		column    int
+		endColumn int
+	}{
+		{`Pikachu x is "hello"`, 14, 21},
+		{`if 1 + 2 then release 1 end`, 4, 9},
+		{`release 1 + missingno`, 13, 22},
+		{`release true < false`, 9, 21},
+	}
+
+	for _, tt := range tests {
+		errs := checkSource(tt.source)
+		if len(errs) != 1 {
+			t.Errorf("%q: expected 1 error, got %v", tt.source, errs)
+			continue
+		}
+		if errs[0].Kind != "type" || errs[0].Column != tt.column || errs[0].EndColumn != tt.endColumn {
+			t.Errorf("%q: expected type error at columns %d-%d, got %s error at %d-%d",
+				tt.source, tt.column, tt.endColumn, errs[0].Kind, errs[0].Column, errs[0].EndColumn)
+		}
+	}
+}
+
+func TestChecker_NoCascadingErrors(t *testing.T) {
+	errs := checkSource(`release missingno + 1 * 2 - 3`)
+	if len(errs) != 1 {
+		t.Errorf("Expected exactly 1 error, got %d: %v", len(errs), errs)
+	}
+}
+
+func TestChecker_TypeOf(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{"release 1 + 2", "Pikachu"},
+		{"release 1 + 2.5", "Psyduck"},
+		{"release 10 / 4", "Pikachu"},
+		{"release \"HP: \" + 2.5", "Eevee"},
+		{"release 3 + \" HP\"", "Eevee"},
+		{"release 1 < 2", "Voltorb"},
+		{"release not true", "Voltorb"},
+		{"release -2", "Pikachu"},
+		{"release -2.5", "Psyduck"},
+		{"release 7 % 2", "Pikachu"},
+		{"release 7.5 % 2", "Psyduck"},
+		{"release 2 ** 10", "Pikachu"},
+		{"release 2 ** 0.5", "Psyduck"},
+		{"move f() gives Eevee\nreturn \"x\"\nend\nrelease f()", "Eevee"},
+	}
+
+	for _, tt := range tests {
+		program := NewParser(NewLexer(tt.source).Tokenize()).Parse()
+		checker := NewChecker()
+		if errs := checker.Check(program); len(errs) > 0 {
+			t.Errorf("%q: unexpected errors: %v", tt.source, errs)
+			continue
+		}
+		release := program.Statements[len(program.Statements)-1].(*ReleaseStmt)
+		if got := checker.TypeOf(release.Value); got != tt.expected {
+			t.Errorf("%q: expected type %s, got %s", tt.source, tt.expected, got)
+		}
+	}
+}
+
+func TestChecker_ScopesPersistAcrossChecks(t *testing.T) {
+	checker := NewChecker()
+	first := NewParser(NewLexer("Pikachu hp is 1").Tokenize()).Parse()
+	second := NewParser(NewLexer("hp evolves to hp + 1").Tokenize()).Parse()
+
+	if errs := checker.Check(first); len(errs) > 0 {
+		t.Fatalf("Unexpected errors: %v", errs)
+	}
+	if errs := checker.Check(second); len(errs) > 0 {
+		t.Errorf("Expected hp to stay declared for a later Check, got: %v", errs)
+	}
+}
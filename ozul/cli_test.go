@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/cli")
+
+// cliDir holds the end-to-end cases. A case NAME is a NAME.ozul source and,
+// optionally, NAME.args (the arguments, whitespace separated, instead of
+// just the source) and NAME.stdin. NAME.stdout, NAME.stderr and NAME.exit
+// are the expected results. A {out} argument is replaced with a temporary
+// file, whose contents are expected to match NAME.out
+var cliDir = filepath.Join("testdata", "cli")
+
+// cliCases lists the case names: every .ozul source and every .args file,
+// so a case can test arguments without a source of its own
+func cliCases(t *testing.T) []string {
+	t.Helper()
+	seen := map[string]bool{}
+	for _, pattern := range []string{"*.ozul", "*.args"} {
+		matches, err := filepath.Glob(filepath.Join(cliDir, pattern))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, match := range matches {
+			name := filepath.Base(match)
+			seen[strings.TrimSuffix(name, filepath.Ext(name))] = true
+		}
+	}
+	if len(seen) == 0 {
+		t.Fatal("no cases in testdata/cli")
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// readOptional reads a case file, or returns "" when it does not exist
+func readOptional(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ""
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// checkGolden compares got with the golden file, or rewrites it with -update
+func checkGolden(t *testing.T, path, got string) {
+	t.Helper()
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run go test -run CLI -update to create it)", err)
+	}
+	if string(expected) != got {
+		t.Errorf("%s differs.\nExpected:\n%s\nGot:\n%s", filepath.Base(path), expected, got)
+	}
+}
+
+// TestCLI_Golden runs each case in testdata/cli through the ozul command,
+// in-process and from inside testdata/cli so file names in diagnostics stay
+// short, and compares its output, exit status and output file
+func TestCLI_Golden(t *testing.T) {
+	names := cliCases(t)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(cliDir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	for _, name := range names {
+		name := name
+		t.Run(name, func(t *testing.T) {
+			args := []string{name + ".ozul"}
+			if raw := readOptional(t, name+".args"); raw != "" {
+				args = strings.Fields(raw)
+			}
+			out := filepath.Join(t.TempDir(), "out")
+			writesFile := false
+			for i, arg := range args {
+				if arg == "{out}" {
+					args[i] = out
+					writesFile = true
+				}
+			}
+
+			var stdout, stderr bytes.Buffer
+			code := run(args, strings.NewReader(readOptional(t, name+".stdin")), &stdout, &stderr)
+
+			// The temporary path changes on every run
+			checkGolden(t, name+".stdout", strings.ReplaceAll(stdout.String(), out, "{out}"))
+			checkGolden(t, name+".stderr", strings.ReplaceAll(stderr.String(), out, "{out}"))
+			checkGolden(t, name+".exit", strconv.Itoa(code)+"\n")
+			if writesFile {
+				written, err := os.ReadFile(out)
+				if err != nil {
+					t.Fatalf("Expected an output file: %v", err)
+				}
+				checkGolden(t, name+".out", string(written))
+			}
+		})
+	}
+}
+
+// TestCLI_FmtWrite checks that ozul fmt -w rewrites the file in place
+func TestCLI_FmtWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prog.ozul")
+	if err := os.WriteFile(path, []byte("Pikachu hp is 1+2\nrelease   hp\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"fmt", "-w", path}, strings.NewReader(""), &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected exit status 0, got %d: %s", code, stderr.String())
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("Expected nothing printed, got %q", stdout.String())
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "Pikachu hp is 1 + 2\nrelease hp\n"; string(got) != expected {
+		t.Errorf("Expected the file to become %q, got %q", expected, got)
+	}
+}
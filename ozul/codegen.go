@@ -7,36 +7,388 @@ import (
 
 // CodeGen represents the code generator for OZUL (simplified version)
 type CodeGen struct {
-	// Symbol table for variables
-	variables map[string]interface{}
+	// Symbol table for variables (C type by name), one scope per C block
+	variables *Scope[string]
 
 	// Generated code (simplified representation)
 	code []string
+
+	// Current indentation depth inside main()
+	indent int
+
+	// Counter used to give each repeat loop a unique index variable
+	loops int
+
+	// Counter used to name the temporaries that hold a shadowing
+	// declaration's value
+	temps int
+
+	// Whether the program has Eevee values, so it needs the string runtime
+	usesStrings bool
+
+	// Whether the program catches input, so it needs the input runtime
+	catchesInput bool
+
+	// Whether a Psyduck is released, so the program needs ozul_format_float
+	formatsFloats bool
+
+	// Whether a Pikachu is raised to a power, so the program needs ozul_ipow
+	raisesInts bool
+
+	// Whether a division or remainder needs a check for zero, so the
+	// program needs the division runtime
+	dividesChecked bool
+
+	// Type checker whose inferred types drive the C types and formats
+	checker *Checker
+}
+
+// cTypes maps each Pokemon type to the C type that represents it
+var cTypes = map[string]string{
+	"Pikachu": "int64_t",
+	"Psyduck": "double",
+	"Eevee":   "ozul_str",
+	"Voltorb": "bool",
 }
 
 // NewCodeGen creates a new code generator
 func NewCodeGen() *CodeGen {
 	return &CodeGen{
-		variables: make(map[string]interface{}),
+		variables: NewScope[string](nil),
 		code:      []string{},
+		checker:   NewChecker(),
 	}
 }
 
-// GenerateProgram generates code for the entire program
+// GenerateProgram generates code for the entire program. The program is
+// type checked first; type errors are reported as a panic.
 func (cg *CodeGen) GenerateProgram(program *Program) {
+	if errs := cg.checker.Check(program); len(errs) > 0 {
+		messages := make([]string, len(errs))
+		for i, err := range errs {
+			messages[i] = err.Error()
+		}
+		panic("[OZUL CodeGen Error] " + strings.Join(messages, "; "))
+	}
+
 	cg.code = append(cg.code, "#include <stdio.h>")
 	cg.code = append(cg.code, "#include <stdlib.h>")
 	cg.code = append(cg.code, "#include <string.h>")
+	cg.code = append(cg.code, "#include <stdbool.h>")
+	cg.code = append(cg.code, "#include <stdint.h>")
+	cg.code = append(cg.code, "#include <inttypes.h>")
+	cg.code = append(cg.code, "#include <math.h>")
 	cg.code = append(cg.code, "")
+	runtimeAt := len(cg.code)
+
+	// Moves become C functions; prototypes first so they can call each other
+	var functions []*FunctionDecl
+	for _, stmt := range program.Statements {
+		if fn, ok := stmt.(*FunctionDecl); ok {
+			functions = append(functions, fn)
+		}
+	}
+	for _, fn := range functions {
+		cg.code = append(cg.code, cg.functionSignature(fn)+";")
+	}
+	if len(functions) > 0 {
+		cg.code = append(cg.code, "")
+	}
+	for _, fn := range functions {
+		cg.generateFunction(fn)
+	}
+
 	cg.code = append(cg.code, "int main() {")
+	cg.indent = 1
 
 	// Generate code for each statement
 	for _, stmt := range program.Statements {
+		if _, ok := stmt.(*FunctionDecl); ok {
+			continue
+		}
 		cg.generateStatement(stmt)
 	}
 
-	cg.code = append(cg.code, "    return 0;")
+	if cg.usesStrings {
+		cg.emit("ozul_free_all();")
+	}
+	cg.emit("return 0;")
+	cg.indent = 0
 	cg.code = append(cg.code, "}")
+
+	// The runtime goes before the moves, with only the parts the program uses
+	var runtime []string
+	if cg.usesStrings {
+		runtime = append(runtime, strings.Split(strings.Trim(cStringRuntime, "\n"), "\n")...)
+		runtime = append(runtime, "")
+	}
+	if cg.catchesInput {
+		runtime = append(runtime, strings.Split(strings.Trim(cInputRuntime, "\n"), "\n")...)
+		runtime = append(runtime, "")
+	}
+	if cg.formatsFloats {
+		runtime = append(runtime, strings.Split(strings.Trim(cFormatFloat, "\n"), "\n")...)
+		runtime = append(runtime, "")
+	}
+	if cg.raisesInts {
+		runtime = append(runtime, strings.Split(strings.Trim(cIntPower, "\n"), "\n")...)
+		runtime = append(runtime, "")
+	}
+	if cg.dividesChecked {
+		runtime = append(runtime, strings.Split(strings.Trim(cDivision, "\n"), "\n")...)
+		runtime = append(runtime, "")
+	}
+	cg.code = append(cg.code[:runtimeAt], append(runtime, cg.code[runtimeAt:]...)...)
+}
+
+// cIntPower raises a Pikachu to a Pikachu power by squaring. It works on
+// unsigned numbers, so overflow wraps around instead of being undefined.
+const cIntPower = `
+static inline int64_t ozul_ipow(int64_t base, int64_t exp, int line) {
+    if (exp < 0) {
+        fflush(stdout);
+        fprintf(stderr, "[OZUL Error] ` + negativeExponent + ` (line %d)\n", line);
+        exit(1);
+    }
+    uint64_t result = 1, b = (uint64_t)base;
+    for (uint64_t e = (uint64_t)exp; e > 0; e >>= 1) {
+        if (e & 1) result *= b;
+        b *= b;
+    }
+    return (int64_t)result;
+}
+`
+
+// cDivision divides and takes remainders with a check for zero, which
+// would otherwise crash the program (or quietly give inf for a Psyduck).
+// Dividing the smallest Pikachu by -1 wraps around, as in the other backends.
+const cDivision = `
+static void ozul_division_by_zero(int line) {
+    fflush(stdout);
+    fprintf(stderr, "[OZUL Error] Division by zero. (line %d)\n", line);
+    exit(1);
+}
+
+static inline int64_t ozul_div(int64_t a, int64_t b, int line) {
+    if (b == 0) ozul_division_by_zero(line);
+    if (b == -1) return (int64_t)(0 - (uint64_t)a);
+    return a / b;
+}
+
+static inline int64_t ozul_mod(int64_t a, int64_t b, int line) {
+    if (b == 0) ozul_division_by_zero(line);
+    if (b == -1) return 0;
+    return a % b;
+}
+
+static inline double ozul_fdiv(double a, double b, int line) {
+    if (b == 0) ozul_division_by_zero(line);
+    return a / b;
+}
+
+static inline double ozul_fmod(double a, double b, int line) {
+    if (b == 0) ozul_division_by_zero(line);
+    return fmod(a, b);
+}
+`
+
+// cStringRuntime represents every Eevee as an ozul_str, which knows its
+// length, so joining strings never writes past a buffer. Strings the
+// program builds come from an arena that main frees when it ends. The
+// functions are inline so compilers do not warn about the ones a program
+// never calls.
+const cStringRuntime = `
+/* An Eevee: its bytes and their length. The bytes always end with a NUL
+   too, but the length is what counts. */
+typedef struct {
+    size_t len;
+    const char *data;
+} ozul_str;
+
+#define ozul_lit(s) ((ozul_str){sizeof(s) - 1, (s)})
+
+/* Strings the program builds are kept on one list, freed when it ends */
+typedef struct ozul_block {
+    struct ozul_block *next;
+} ozul_block;
+
+static ozul_block *ozul_arena = NULL;
+
+static inline char *ozul_alloc(size_t size) {
+    ozul_block *block = size <= SIZE_MAX - sizeof(ozul_block) ? malloc(sizeof(ozul_block) + size) : NULL;
+    if (block == NULL) {
+        fflush(stdout);
+        fputs("[OZUL Error] Out of memory.\n", stderr);
+        exit(1);
+    }
+    block->next = ozul_arena;
+    ozul_arena = block;
+    return (char *)(block + 1);
+}
+
+static inline void ozul_free_all(void) {
+    while (ozul_arena != NULL) {
+        ozul_block *next = ozul_arena->next;
+        free(ozul_arena);
+        ozul_arena = next;
+    }
+}
+
+static inline ozul_str ozul_concat(ozul_str a, ozul_str b) {
+    size_t len = a.len + b.len;
+    char *data = ozul_alloc(b.len < SIZE_MAX - a.len ? len + 1 : SIZE_MAX);
+    memcpy(data, a.data, a.len);
+    memcpy(data + a.len, b.data, b.len);
+    data[len] = '\0';
+    return (ozul_str){len, data};
+}
+
+/* Numbers join onto strings like the interpreter writes them: whole
+   numbers as they are, decimals with six places */
+static inline ozul_str ozul_int_str(int64_t v) {
+    int len = snprintf(NULL, 0, "%" PRId64, v);
+    char *data = ozul_alloc(len + 1);
+    snprintf(data, len + 1, "%" PRId64, v);
+    return (ozul_str){len, data};
+}
+
+static inline ozul_str ozul_float_str(double v) {
+    if (isnan(v)) return ozul_lit("NaN");
+    if (isinf(v)) return v > 0 ? ozul_lit("+Inf") : ozul_lit("-Inf");
+    int len = snprintf(NULL, 0, "%f", v);
+    char *data = ozul_alloc(len + 1);
+    snprintf(data, len + 1, "%f", v);
+    return (ozul_str){len, data};
+}
+
+static inline ozul_str ozul_bool_str(bool v) {
+    return v ? ozul_lit("true") : ozul_lit("false");
+}
+
+/* Orders strings byte by byte, like Go: negative, zero or positive */
+static inline int ozul_compare(ozul_str a, ozul_str b) {
+    int c = memcmp(a.data, b.data, a.len < b.len ? a.len : b.len);
+    if (c != 0) return c;
+    return (a.len > b.len) - (a.len < b.len);
+}
+
+static inline void ozul_puts(ozul_str s) {
+    fwrite(s.data, 1, s.len, stdout);
+    putchar('\n');
+}
+
+`
+
+// cInputRuntime reads catch input a line at a time, prompting and checking
+// it like the interpreter does. It builds on cStringRuntime.
+const cInputRuntime = `
+#include <errno.h>
+#include <limits.h>
+
+/* Reads one line from standard input, without its line ending */
+static inline ozul_str ozul_read_line(void) {
+    size_t len = 0, cap = 64;
+    char *buf = malloc(cap);
+    int c;
+    while (buf != NULL && (c = getchar()) != EOF && c != '\n') {
+        if (len + 1 == cap) {
+            char *grown = cap <= SIZE_MAX / 2 ? realloc(buf, cap * 2) : NULL;
+            if (grown == NULL) {
+                free(buf);
+                buf = NULL;
+                break;
+            }
+            buf = grown;
+            cap *= 2;
+        }
+        buf[len++] = (char)c;
+    }
+    if (buf == NULL) {
+        fflush(stdout);
+        fputs("[OZUL Error] Out of memory.\n", stderr);
+        exit(1);
+    }
+    while (len > 0 && buf[len - 1] == '\r') {
+        len--;
+    }
+    char *data = ozul_alloc(len + 1);
+    memcpy(data, buf, len);
+    data[len] = '\0';
+    free(buf);
+    return (ozul_str){len, data};
+}
+
+static inline ozul_str ozul_catch_str(const char *name) {
+    printf("Enter value for %s: ", name);
+    fflush(stdout);
+    return ozul_read_line();
+}
+
+static inline void ozul_bad_input(const char *expected, ozul_str input, int line) {
+    fflush(stdout);
+    fprintf(stderr, "[OZUL Error] Expected a %s from the trainer, got \"%s\" (line %d)\n", expected, input.data, line);
+    exit(1);
+}
+
+/* Finds the input without the spaces around it, which must be all of it
+   that a number reads */
+static inline const char *ozul_trim(ozul_str input, const char **end) {
+    const char *start = input.data;
+    *end = input.data + input.len;
+    while (start < *end && (*start == ' ' || (*start >= '\t' && *start <= '\r'))) start++;
+    while (*end > start && ((*end)[-1] == ' ' || ((*end)[-1] >= '\t' && (*end)[-1] <= '\r'))) (*end)--;
+    return start;
+}
+
+static inline int64_t ozul_catch_int(const char *name, int line) {
+    ozul_str input = ozul_catch_str(name);
+    const char *end, *start = ozul_trim(input, &end);
+    char *stop;
+    errno = 0;
+    long long v = strtoll(start, &stop, 10);
+    if (start == end || stop != end || errno == ERANGE || v < INT64_MIN || v > INT64_MAX) {
+        ozul_bad_input("Pikachu (whole number)", input, line);
+    }
+    return (int64_t)v;
+}
+
+static inline double ozul_catch_float(const char *name, int line) {
+    ozul_str input = ozul_catch_str(name);
+    const char *end, *start = ozul_trim(input, &end);
+    char *stop;
+    errno = 0;
+    double v = strtod(start, &stop);
+    if (start == end || stop != end || (errno == ERANGE && isinf(v))) {
+        ozul_bad_input("Psyduck (number)", input, line);
+    }
+    return v;
+}
+`
+
+// cFormatFloat prints a Psyduck the way the interpreter's release does
+const cFormatFloat = `
+/* Formats a Psyduck like Go's %v: the fewest digits that read back as the
+   same value, in exponent form when the exponent is below -4 or at least 6 */
+static const char *ozul_format_float(double v) {
+    static char buf[40];
+    if (isnan(v)) return "NaN";
+    if (isinf(v)) return v > 0 ? "+Inf" : "-Inf";
+    int digits = 0;
+    for (;; digits++) {
+        snprintf(buf, sizeof buf, "%.*e", digits, v);
+        if (digits >= 16 || strtod(buf, NULL) == v) break;
+    }
+    int exponent = atoi(strchr(buf, 'e') + 1);
+    if (exponent >= -4 && exponent < 6) {
+        snprintf(buf, sizeof buf, "%.*f", digits > exponent ? digits - exponent : 0, v);
+    }
+    return buf;
+}
+`
+
+// emit appends a line of code at the current indentation depth
+func (cg *CodeGen) emit(format string, args ...interface{}) {
+	cg.code = append(cg.code, strings.Repeat("    ", cg.indent)+fmt.Sprintf(format, args...))
 }
 
 // generateStatement generates code for a single statement
@@ -50,34 +402,119 @@ func (cg *CodeGen) generateStatement(stmt Statement) {
 		cg.generateRelease(s)
 	case *CatchStmt:
 		cg.generateCatch(s)
+	case *IfStmt:
+		cg.generateIf(s)
+	case *WhileStmt:
+		cg.generateWhile(s)
+	case *RepeatStmt:
+		cg.generateRepeat(s)
+	case *ReturnStmt:
+		cg.generateReturn(s)
+	case *ExpressionStmt:
+		cg.emit("%s;", cg.generateExpression(s.Expr))
+	}
+}
+
+// functionSignature builds the C signature for a move
+func (cg *CodeGen) functionSignature(fn *FunctionDecl) string {
+	returnType := "void"
+	if fn.ReturnType != "" {
+		returnType = cg.cType(fn.ReturnType)
+	}
+	params := make([]string, len(fn.Params))
+	for i, param := range fn.Params {
+		params[i] = fmt.Sprintf("%s %s", cg.cType(param.PokemonType), param.Name)
+	}
+	if len(params) == 0 {
+		params = []string{"void"}
+	}
+	return fmt.Sprintf("%s ozul_%s(%s)", returnType, fn.Name, strings.Join(params, ", "))
+}
+
+// generateFunction generates a C function for a move, with its own
+// variable table so it cannot see main's variables. As in C, the
+// parameters share a scope with the top level of the body.
+func (cg *CodeGen) generateFunction(fn *FunctionDecl) {
+	outer := cg.variables
+	cg.variables = NewScope[string](nil)
+	for _, param := range fn.Params {
+		cg.declare(param.Name, cg.cType(param.PokemonType))
 	}
+
+	cg.code = append(cg.code, cg.functionSignature(fn)+" {")
+	cg.indent++
+	for _, stmt := range fn.Body {
+		cg.generateStatement(stmt)
+	}
+	cg.indent--
+	cg.code = append(cg.code, "}")
+	cg.code = append(cg.code, "")
+
+	cg.variables = outer
+}
+
+// generateReturn generates code for returning from a move
+func (cg *CodeGen) generateReturn(stmt *ReturnStmt) {
+	if stmt.Value == nil {
+		cg.emit("return;")
+		return
+	}
+	cg.emit("return %s;", cg.generateExpression(stmt.Value))
 }
 
 // generateDeclaration generates code for variable declarations
 func (cg *CodeGen) generateDeclaration(stmt *DeclarationStmt) {
 	value := cg.generateExpression(stmt.Value)
 
-	switch stmt.PokemonType {
-	case "Pikachu":
-		cg.code = append(cg.code, fmt.Sprintf("    int %s = %s;", stmt.Name, value))
-		cg.variables[stmt.Name] = "int"
-	case "Psyduck":
-		cg.code = append(cg.code, fmt.Sprintf("    double %s = %s;", stmt.Name, value))
-		cg.variables[stmt.Name] = "double"
-	case "Eevee":
-		cg.code = append(cg.code, fmt.Sprintf("    char* %s = %s;", stmt.Name, value))
-		cg.variables[stmt.Name] = "char*"
-	default:
-		panic(fmt.Sprintf("[OZUL CodeGen Error] Unknown Pokemon type: %s", stmt.PokemonType))
+	cType := cg.cType(stmt.PokemonType)
+	if _, shadows := cg.variables.Lookup(stmt.Name); shadows && readsVariable(stmt.Value, stmt.Name) {
+		// In C the new variable is in scope in its own initializer, so
+		// "int hp = hp + 1;" would read itself. The value is worked out
+		// into a temporary while the outer variable is still visible.
+		cg.temps++
+		temp := fmt.Sprintf("ozul_t%d", cg.temps)
+		cg.emit("%s %s = %s;", cg.exprType(stmt.Value), temp, value)
+		value = temp
+	}
+	cg.declare(stmt.Name, cType)
+	cg.emit("%s %s = %s;", cType, stmt.Name, value)
+}
+
+// readsVariable reports whether an expression reads the variable name. Moves
+// cannot see the caller's variables, so only the expression itself counts.
+func readsVariable(expr Expression, name string) bool {
+	switch e := expr.(type) {
+	case *Identifier:
+		return e.Name == name
+	case *BinaryExpr:
+		return readsVariable(e.Left, name) || readsVariable(e.Right, name)
+	case *UnaryExpr:
+		return readsVariable(e.Operand, name)
+	case *InterpolatedString:
+		return readsVariable(e.Value, name)
+	case *CallExpr:
+		for _, arg := range e.Args {
+			if readsVariable(arg, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// declare adds a variable to the current scope, rejecting a second
+// declaration of the same name in the same C block
+func (cg *CodeGen) declare(name string, cType string) {
+	if err := cg.variables.Declare(name, cType); err != nil {
+		panic(fmt.Sprintf("[OZUL CodeGen Error] Variable %s already declared in this scope!", name))
 	}
 }
 
 // generateAssignment generates code for variable assignments
 func (cg *CodeGen) generateAssignment(stmt *AssignmentStmt) {
 	value := cg.generateExpression(stmt.Value)
-	if typ, exists := cg.variables[stmt.Name]; exists {
-		cg.code = append(cg.code, fmt.Sprintf("    %s = %s;", stmt.Name, value))
-		cg.variables[stmt.Name] = typ
+	if _, exists := cg.variables.Lookup(stmt.Name); exists {
+		cg.emit("%s = %s;", stmt.Name, value)
 	} else {
 		panic(fmt.Sprintf("[OZUL CodeGen Error] Variable %s not declared!", stmt.Name))
 	}
@@ -86,41 +523,106 @@ func (cg *CodeGen) generateAssignment(stmt *AssignmentStmt) {
 // generateRelease generates code for output statements
 func (cg *CodeGen) generateRelease(stmt *ReleaseStmt) {
 	value := cg.generateExpression(stmt.Value)
-	var varType string
-	if id, ok := stmt.Value.(*Identifier); ok {
-		t, exists := cg.variables[id.Name]
-		if !exists {
-			panic(fmt.Sprintf("[OZUL CodeGen Error] Variable %s not declared!", id.Name))
-		}
-		varType = t.(string)
-	} else if _, ok := stmt.Value.(*NumberLiteral); ok {
-		varType = "int"
-	} else if _, ok := stmt.Value.(*FloatLiteral); ok {
-		varType = "double"
-	} else if _, ok := stmt.Value.(*StringLiteral); ok {
-		varType = "char*"
-	} else {
-		// Try to infer type from expression
-		varType = "int"
-	}
+	varType := cg.exprType(stmt.Value)
 
 	switch varType {
-	case "int":
-		cg.code = append(cg.code, fmt.Sprintf("    printf(\"%%d\\n\", %s);", value))
+	case "int64_t":
+		cg.emit("printf(\"%%\" PRId64 \"\\n\", %s);", value)
 	case "double":
-		cg.code = append(cg.code, fmt.Sprintf("    printf(\"%%f\\n\", %s);", value))
-	case "char*":
-		cg.code = append(cg.code, fmt.Sprintf("    printf(\"%%s\\n\", %s);", value))
+		cg.formatsFloats = true
+		cg.emit("printf(\"%%s\\n\", ozul_format_float(%s));", value)
+	case "ozul_str":
+		cg.emit("ozul_puts(%s);", value)
+	case "bool":
+		cg.emit("printf(\"%%s\\n\", %s ? \"true\" : \"false\");", value)
 	default:
 		panic(fmt.Sprintf("[OZUL CodeGen Error] Unknown type for release: %s", varType))
 	}
 }
 
-// generateCatch generates code for input statements
+// generateCatch generates code for input statements. A typed catch declares
+// a new variable; an untyped one reads into a visible variable, or else
+// declares a new int. Like the interpreter, it prompts for the variable and
+// reads a whole line, stopping the program when a number does not parse.
 func (cg *CodeGen) generateCatch(stmt *CatchStmt) {
-	cg.code = append(cg.code, fmt.Sprintf("    int %s;", stmt.Variable))
-	cg.code = append(cg.code, fmt.Sprintf("    scanf(\"%%d\", &%s);", stmt.Variable))
-	cg.variables[stmt.Variable] = "int"
+	cType, exists := cg.variables.Lookup(stmt.Variable)
+	declare := stmt.PokemonType != "" || !exists
+	if declare {
+		cType = "int64_t"
+		if stmt.PokemonType != "" {
+			cType = cg.cType(stmt.PokemonType)
+		}
+	}
+
+	var value string
+	name, line := cQuote(stmt.Variable), stmt.Pos().Start.Line
+	switch cType {
+	case "int64_t":
+		value = fmt.Sprintf("ozul_catch_int(%s, %d)", name, line)
+	case "double":
+		value = fmt.Sprintf("ozul_catch_float(%s, %d)", name, line)
+	case "ozul_str":
+		value = fmt.Sprintf("ozul_catch_str(%s)", name)
+	default:
+		panic(fmt.Sprintf("[OZUL CodeGen Error] Cannot catch into %s variable %s", cType, stmt.Variable))
+	}
+	cg.usesStrings, cg.catchesInput = true, true
+
+	if declare {
+		cg.declare(stmt.Variable, cType)
+		cg.emit("%s %s = %s;", cType, stmt.Variable, value)
+	} else {
+		cg.emit("%s = %s;", stmt.Variable, value)
+	}
+}
+
+// generateIf generates code for conditional blocks
+func (cg *CodeGen) generateIf(stmt *IfStmt) {
+	cond := cg.generateExpression(stmt.Condition)
+	cg.emit("if (%s) {", cond)
+	cg.generateBlock(stmt.Then)
+	if stmt.Else != nil {
+		cg.emit("} else {")
+		cg.generateBlock(stmt.Else)
+	}
+	cg.emit("}")
+}
+
+// generateWhile generates code for "train while" loops
+func (cg *CodeGen) generateWhile(stmt *WhileStmt) {
+	cond := cg.generateExpression(stmt.Condition)
+	cg.emit("while (%s) {", cond)
+	cg.generateBlock(stmt.Body)
+	cg.emit("}")
+}
+
+// generateRepeat generates code for counted "repeat N times" loops. The
+// count is evaluated once, before the first iteration.
+func (cg *CodeGen) generateRepeat(stmt *RepeatStmt) {
+	count := cg.generateExpression(stmt.Count)
+	cg.loops++
+	index := fmt.Sprintf("ozul_i%d", cg.loops)
+	if _, ok := stmt.Count.(*NumberLiteral); ok {
+		cg.emit("for (int64_t %s = 0; %s < %s; %s++) {", index, index, count, index)
+	} else {
+		limit := fmt.Sprintf("ozul_n%d", cg.loops)
+		cg.emit("for (int64_t %s = 0, %s = %s; %s < %s; %s++) {", index, limit, count, index, limit, index)
+	}
+	cg.generateBlock(stmt.Body)
+	cg.emit("}")
+}
+
+// generateBlock generates the statements of a nested block one level deeper,
+// in a new scope that ends with the C block
+func (cg *CodeGen) generateBlock(stmts []Statement) {
+	outer := cg.variables
+	cg.variables = NewScope(outer)
+	cg.indent++
+	for _, stmt := range stmts {
+		cg.generateStatement(stmt)
+	}
+	cg.indent--
+	cg.variables = outer
 }
 
 // generateExpression generates code for expressions
@@ -130,29 +632,162 @@ func (cg *CodeGen) generateExpression(expr Expression) string {
 		return fmt.Sprintf("%d", e.Value)
 	case *FloatLiteral:
 		return fmt.Sprintf("%f", e.Value)
+	case *InterpolatedString:
+		return cg.generateExpression(e.Value)
 	case *StringLiteral:
-		return fmt.Sprintf("\"%s\"", e.Value)
+		cg.usesStrings = true
+		return fmt.Sprintf("ozul_lit(%s)", cQuote(e.Value))
+	case *BooleanLiteral:
+		return fmt.Sprintf("%t", e.Value)
 	case *Identifier:
-		if _, exists := cg.variables[e.Name]; exists {
-			return e.Name
+		return e.Name
+	case *CallExpr:
+		args := make([]string, len(e.Args))
+		for i, arg := range e.Args {
+			args[i] = cg.generateExpression(arg)
+		}
+		return fmt.Sprintf("ozul_%s(%s)", e.Name, strings.Join(args, ", "))
+	case *UnaryExpr:
+		operand := cg.generateExpression(e.Operand)
+		if e.Operator == "not" {
+			return fmt.Sprintf("(!%s)", operand)
 		}
-		panic(fmt.Sprintf("[OZUL CodeGen Error] Undefined variable: %s", e.Name))
+		if e.Operator == "-" {
+			return fmt.Sprintf("(-%s)", operand)
+		}
+		panic(fmt.Sprintf("[OZUL CodeGen Error] Unknown operator: %s", e.Operator))
 	case *BinaryExpr:
+		if e.Operator == "+" && cg.exprType(e) == "ozul_str" {
+			return cg.generateConcatenation(e)
+		}
 		left := cg.generateExpression(e.Left)
 		right := cg.generateExpression(e.Right)
-		if strings.HasPrefix(left, "\"") || strings.HasPrefix(right, "\"") {
-			// String concatenation - create a buffer
-			bufferName := fmt.Sprintf("str_buffer_%d", len(cg.variables))
-			cg.code = append(cg.code, fmt.Sprintf("    char %s[256];", bufferName))
-			cg.code = append(cg.code, fmt.Sprintf("    strcpy(%s, %s);", bufferName, left))
-			cg.code = append(cg.code, fmt.Sprintf("    strcat(%s, %s);", bufferName, right))
-			return bufferName
+		switch e.Operator {
+		case "and":
+			return fmt.Sprintf("(%s && %s)", left, right)
+		case "or":
+			return fmt.Sprintf("(%s || %s)", left, right)
+		case "==", "!=", "<", "<=", ">", ">=":
+			if cg.exprType(e.Left) == "ozul_str" && cg.exprType(e.Right) == "ozul_str" {
+				return fmt.Sprintf("(ozul_compare(%s, %s) %s 0)", left, right, e.Operator)
+			}
+			return fmt.Sprintf("(%s %s %s)", left, e.Operator, right)
+		case "/", "%":
+			double := cg.exprType(e) == "double"
+			if !nonZeroLiteral(e.Right) {
+				cg.dividesChecked = true
+				helper := "div"
+				if e.Operator == "%" {
+					helper = "mod"
+				}
+				if double {
+					helper = "f" + helper
+				}
+				return fmt.Sprintf("ozul_%s(%s, %s, %d)", helper, left, right, e.Pos().Start.Line)
+			}
+			if double && e.Operator == "%" {
+				return fmt.Sprintf("fmod(%s, %s)", left, right)
+			}
+		case "**":
+			if cg.exprType(e) == "double" {
+				return fmt.Sprintf("pow(%s, %s)", left, right)
+			}
+			cg.raisesInts = true
+			return fmt.Sprintf("ozul_ipow(%s, %s, %d)", left, right, e.Pos().Start.Line)
 		}
 		return fmt.Sprintf("(%s %s %s)", left, e.Operator, right)
 	default:
 		panic("[OZUL CodeGen Error] Unknown expression type.")
 	}
-	return "" // unreachable
+}
+
+// generateConcatenation joins a chain of + that makes an Eevee, converting
+// numbers and Voltorbs to text the way the interpreter's toString does.
+// Pikachu and Psyduck operands keep their own arithmetic, so 1 + 2 + "a" is
+// "3a".
+func (cg *CodeGen) generateConcatenation(e *BinaryExpr) string {
+	var parts []Expression
+	var flatten func(expr Expression)
+	flatten = func(expr Expression) {
+		if b, ok := expr.(*BinaryExpr); ok && b.Operator == "+" && cg.exprType(b) == "ozul_str" {
+			flatten(b.Left)
+			flatten(b.Right)
+			return
+		}
+		parts = append(parts, expr)
+	}
+	flatten(e)
+
+	joined := ""
+	for i, part := range parts {
+		value := cg.generateExpression(part)
+		switch partType := cg.exprType(part); partType {
+		case "int64_t":
+			value = fmt.Sprintf("ozul_int_str(%s)", value)
+		case "double":
+			value = fmt.Sprintf("ozul_float_str(%s)", value)
+		case "bool":
+			value = fmt.Sprintf("ozul_bool_str(%s)", value)
+		case "ozul_str":
+		default:
+			panic(fmt.Sprintf("[OZUL CodeGen Error] Cannot join a %s onto a string", partType))
+		}
+		if i == 0 {
+			joined = value
+		} else {
+			joined = fmt.Sprintf("ozul_concat(%s, %s)", joined, value)
+		}
+	}
+	return joined
+}
+
+// cQuote writes a C string literal. Bytes outside printable ASCII are
+// written as octal escapes, which never run into the next character.
+func cQuote(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c == '\n':
+			sb.WriteString(`\n`)
+		case c == '\t':
+			sb.WriteString(`\t`)
+		case c < ' ' || c >= 0x7f:
+			sb.WriteString(fmt.Sprintf(`\%03o`, c))
+		case c == '?':
+			sb.WriteString(`\?`) // no trigraphs
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// exprType returns the C type of an expression, as inferred by the checker
+// ("void" for calls to moves without a return type)
+func (cg *CodeGen) exprType(expr Expression) string {
+	pokemonType := cg.checker.TypeOf(expr)
+	if pokemonType == "" {
+		return "void"
+	}
+	return cg.cType(pokemonType)
+}
+
+// cType returns the C type for a Pokemon type, noting when the program
+// needs the string runtime
+func (cg *CodeGen) cType(pokemonType string) string {
+	cType, ok := cTypes[pokemonType]
+	if !ok {
+		panic(fmt.Sprintf("[OZUL CodeGen Error] Unknown Pokemon type: %s", pokemonType))
+	}
+	if cType == "ozul_str" {
+		cg.usesStrings = true
+	}
+	return cType
 }
 
 // GetCode returns the generated C code as a string
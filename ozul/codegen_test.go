@@ -23,8 +23,8 @@ func TestCodeGen_Declaration(t *testing.T) {
 	cg.GenerateProgram(program)
 	code := cg.GetCode()
 
-	if !strings.Contains(code, "int x = 42;") {
-		t.Errorf("Expected 'int x = 42;' in generated code, got: %s", code)
+	if !strings.Contains(code, "int64_t x = 42;") {
+		t.Errorf("Expected 'int64_t x = 42;' in generated code, got: %s", code)
 	}
 }
 
@@ -65,8 +65,8 @@ func TestCodeGen_EeveeDeclaration(t *testing.T) {
 	cg.GenerateProgram(program)
 	code := cg.GetCode()
 
-	if !strings.Contains(code, "char* msg = \"Hello, Pokemon!\";") {
-		t.Errorf("Expected 'char* msg = \"Hello, Pokemon!\";' in generated code, got: %s", code)
+	if !strings.Contains(code, "ozul_str msg = ozul_lit(\"Hello, Pokemon!\");") {
+		t.Errorf("Expected 'ozul_str msg = ozul_lit(\"Hello, Pokemon!\");' in generated code, got: %s", code)
 	}
 }
 
@@ -115,8 +115,8 @@ func TestCodeGen_ArithmeticOperations(t *testing.T) {
 	cg.GenerateProgram(program)
 	code := cg.GetCode()
 
-	if !strings.Contains(code, "int result = (10 + 5);") {
-		t.Errorf("Expected 'int result = (10 + 5);' in generated code, got: %s", code)
+	if !strings.Contains(code, "int64_t result = (10 + 5);") {
+		t.Errorf("Expected 'int64_t result = (10 + 5);' in generated code, got: %s", code)
 	}
 }
 
@@ -148,7 +148,7 @@ func TestCodeGen_ComplexArithmetic(t *testing.T) {
 	cg.GenerateProgram(program)
 	code := cg.GetCode()
 
-	expected := "int result = ((10 * 2) + (5 - 3));"
+	expected := "int64_t result = ((10 * 2) + (5 - 3));"
 	if !strings.Contains(code, expected) {
 		t.Errorf("Expected '%s' in generated code, got: %s", expected, code)
 	}
@@ -174,17 +174,14 @@ func TestCodeGen_StringConcatenation(t *testing.T) {
 	cg.GenerateProgram(program)
 	code := cg.GetCode()
 
-	if !strings.Contains(code, "char str_buffer_0[256];") {
-		t.Errorf("Expected buffer declaration for string concatenation, got: %s", code)
+	if !strings.Contains(code, "ozul_str greeting = ozul_concat(ozul_lit(\"Hello\"), ozul_lit(\"World\"));") {
+		t.Errorf("Expected ozul_concat for string concatenation, got: %s", code)
 	}
-	if !strings.Contains(code, "strcpy(str_buffer_0, \"Hello\");") {
-		t.Errorf("Expected strcpy for string concatenation, got: %s", code)
+	if !strings.Contains(code, "static inline ozul_str ozul_concat(ozul_str a, ozul_str b) {") {
+		t.Errorf("Expected the string runtime, got: %s", code)
 	}
-	if !strings.Contains(code, "strcat(str_buffer_0, \"World\");") {
-		t.Errorf("Expected strcat for string concatenation, got: %s", code)
-	}
-	if !strings.Contains(code, "char* greeting = str_buffer_0;") {
-		t.Errorf("Expected assignment of buffer to greeting, got: %s", code)
+	if !strings.Contains(code, "    ozul_free_all();\n    return 0;") {
+		t.Errorf("Expected main to free the strings it built, got: %s", code)
 	}
 }
 
@@ -202,8 +199,8 @@ func TestCodeGen_ReleaseInteger(t *testing.T) {
 	cg.GenerateProgram(program)
 	code := cg.GetCode()
 
-	if !strings.Contains(code, "printf(\"%d\\n\", 42);") {
-		t.Errorf("Expected 'printf(\"%%d\\n\", 42);' in generated code, got: %s", code)
+	if !strings.Contains(code, "printf(\"%\" PRId64 \"\\n\", 42);") {
+		t.Errorf("Expected 'printf(\"%%\" PRId64 \"\\n\", 42);' in generated code, got: %s", code)
 	}
 }
 
@@ -221,8 +218,11 @@ func TestCodeGen_ReleaseFloat(t *testing.T) {
 	cg.GenerateProgram(program)
 	code := cg.GetCode()
 
-	if !strings.Contains(code, "printf(\"%f\\n\", 3.140000);") {
-		t.Errorf("Expected 'printf(\"%%f\\n\", 3.140000);' in generated code, got: %s", code)
+	if !strings.Contains(code, "printf(\"%s\\n\", ozul_format_float(3.140000));") {
+		t.Errorf("Expected 'printf(\"%%s\\n\", ozul_format_float(3.140000));' in generated code, got: %s", code)
+	}
+	if !strings.Contains(code, "static const char *ozul_format_float(double v) {") {
+		t.Errorf("Expected the float formatting runtime, got: %s", code)
 	}
 }
 
@@ -240,8 +240,8 @@ func TestCodeGen_ReleaseString(t *testing.T) {
 	cg.GenerateProgram(program)
 	code := cg.GetCode()
 
-	if !strings.Contains(code, "printf(\"%s\\n\", \"Hello, Pokemon!\");") {
-		t.Errorf("Expected 'printf(\"%%s\\n\", \"Hello, Pokemon!\");' in generated code, got: %s", code)
+	if !strings.Contains(code, "ozul_puts(ozul_lit(\"Hello, Pokemon!\"));") {
+		t.Errorf("Expected 'ozul_puts(ozul_lit(\"Hello, Pokemon!\"));' in generated code, got: %s", code)
 	}
 }
 
@@ -264,8 +264,8 @@ func TestCodeGen_ReleaseVariable(t *testing.T) {
 	cg.GenerateProgram(program)
 	code := cg.GetCode()
 
-	if !strings.Contains(code, "printf(\"%d\\n\", x);") {
-		t.Errorf("Expected 'printf(\"%%d\\n\", x);' in generated code, got: %s", code)
+	if !strings.Contains(code, "printf(\"%\" PRId64 \"\\n\", x);") {
+		t.Errorf("Expected 'printf(\"%%\" PRId64 \"\\n\", x);' in generated code, got: %s", code)
 	}
 }
 
@@ -283,11 +283,11 @@ func TestCodeGen_Catch(t *testing.T) {
 	cg.GenerateProgram(program)
 	code := cg.GetCode()
 
-	if !strings.Contains(code, "int input;") {
-		t.Errorf("Expected 'int input;' in generated code, got: %s", code)
+	if !strings.Contains(code, "int64_t input = ozul_catch_int(\"input\", 0);") {
+		t.Errorf("Expected 'int64_t input = ozul_catch_int(\"input\", 0);' in generated code, got: %s", code)
 	}
-	if !strings.Contains(code, "scanf(\"%d\", &input);") {
-		t.Errorf("Expected 'scanf(\"%%d\", &input);' in generated code, got: %s", code)
+	if !strings.Contains(code, "printf(\"Enter value for %s: \", name);") {
+		t.Errorf("Expected catch to prompt like the interpreter, got: %s", code)
 	}
 }
 
@@ -340,13 +340,13 @@ func TestCodeGen_CompleteProgram(t *testing.T) {
 		"#include <stdlib.h>",
 		"#include <string.h>",
 		"int main() {",
-		"int x = 10;",
+		"int64_t x = 10;",
 		"double y = 3.140000;",
-		"char* msg = \"Hello\";",
+		"ozul_str msg = ozul_lit(\"Hello\");",
 		"x = (x * 2);",
-		"printf(\"%d\\n\", x);",
-		"printf(\"%f\\n\", y);",
-		"printf(\"%s\\n\", msg);",
+		"printf(\"%\" PRId64 \"\\n\", x);",
+		"printf(\"%s\\n\", ozul_format_float(y));",
+		"ozul_puts(msg);",
 		"return 0;",
 		"}",
 	}
@@ -415,3 +415,569 @@ func TestCodeGen_AssignmentToUndefinedVariable(t *testing.T) {
 
 	cg.GenerateProgram(program)
 }
+
+func TestCodeGen_IfElse(t *testing.T) {
+	// Test if/else blocks
+	program := &Program{
+		Statements: []Statement{
+			&DeclarationStmt{
+				Name:        "x",
+				PokemonType: "Pikachu",
+				Value:       &NumberLiteral{Value: 1},
+			},
+			&IfStmt{
+				Condition: &BinaryExpr{
+					Left:     &Identifier{Name: "x"},
+					Operator: ">",
+					Right:    &NumberLiteral{Value: 0},
+				},
+				Then: []Statement{
+					&ReleaseStmt{Value: &NumberLiteral{Value: 1}},
+				},
+				Else: []Statement{
+					&ReleaseStmt{Value: &NumberLiteral{Value: 2}},
+				},
+			},
+		},
+	}
+
+	cg := NewCodeGen()
+	cg.GenerateProgram(program)
+	code := cg.GetCode()
+
+	expectedElements := []string{
+		"    if ((x > 0)) {",
+		"        printf(\"%\" PRId64 \"\\n\", 1);",
+		"    } else {",
+		"        printf(\"%\" PRId64 \"\\n\", 2);",
+	}
+
+	for _, expected := range expectedElements {
+		if !strings.Contains(code, expected) {
+			t.Errorf("Expected '%s' in generated code, got: %s", expected, code)
+		}
+	}
+}
+
+func TestCodeGen_BoolAndLogic(t *testing.T) {
+	// Test Voltorb (bool) declarations, logical operators and string comparison
+	program := &Program{
+		Statements: []Statement{
+			&DeclarationStmt{
+				Name:        "name",
+				PokemonType: "Eevee",
+				Value:       &StringLiteral{Value: "Ash"},
+			},
+			&DeclarationStmt{
+				Name:        "ok",
+				PokemonType: "Voltorb",
+				Value: &BinaryExpr{
+					Left: &BinaryExpr{
+						Left:     &Identifier{Name: "name"},
+						Operator: "==",
+						Right:    &StringLiteral{Value: "Ash"},
+					},
+					Operator: "and",
+					Right: &UnaryExpr{
+						Operator: "not",
+						Operand:  &BooleanLiteral{Value: false},
+					},
+				},
+			},
+			&ReleaseStmt{
+				Value: &Identifier{Name: "ok"},
+			},
+		},
+	}
+
+	cg := NewCodeGen()
+	cg.GenerateProgram(program)
+	code := cg.GetCode()
+
+	expectedElements := []string{
+		"#include <stdbool.h>",
+		"bool ok = ((ozul_compare(name, ozul_lit(\"Ash\")) == 0) && (!false));",
+		"printf(\"%s\\n\", ok ? \"true\" : \"false\");",
+	}
+
+	for _, expected := range expectedElements {
+		if !strings.Contains(code, expected) {
+			t.Errorf("Expected '%s' in generated code, got: %s", expected, code)
+		}
+	}
+}
+
+func TestCodeGen_Loops(t *testing.T) {
+	// Test while and repeat loops
+	program := &Program{
+		Statements: []Statement{
+			&DeclarationStmt{
+				Name:        "hp",
+				PokemonType: "Pikachu",
+				Value:       &NumberLiteral{Value: 30},
+			},
+			&WhileStmt{
+				Condition: &BinaryExpr{
+					Left:     &Identifier{Name: "hp"},
+					Operator: ">",
+					Right:    &NumberLiteral{Value: 0},
+				},
+				Body: []Statement{
+					&AssignmentStmt{
+						Name: "hp",
+						Value: &BinaryExpr{
+							Left:     &Identifier{Name: "hp"},
+							Operator: "-",
+							Right:    &NumberLiteral{Value: 10},
+						},
+					},
+				},
+			},
+			&RepeatStmt{
+				Count: &NumberLiteral{Value: 3},
+				Body: []Statement{
+					&ReleaseStmt{Value: &Identifier{Name: "hp"}},
+				},
+			},
+		},
+	}
+
+	cg := NewCodeGen()
+	cg.GenerateProgram(program)
+	code := cg.GetCode()
+
+	expectedElements := []string{
+		"    while ((hp > 0)) {",
+		"        hp = (hp - 10);",
+		"    for (int64_t ozul_i1 = 0; ozul_i1 < 3; ozul_i1++) {",
+		"        printf(\"%\" PRId64 \"\\n\", hp);",
+	}
+
+	for _, expected := range expectedElements {
+		if !strings.Contains(code, expected) {
+			t.Errorf("Expected '%s' in generated code, got: %s", expected, code)
+		}
+	}
+}
+
+func TestCodeGen_Functions(t *testing.T) {
+	// Test moves compiled to separate C functions
+	program := &Program{
+		Statements: []Statement{
+			&FunctionDecl{
+				Name:       "attack",
+				Params:     []Param{{PokemonType: "Pikachu", Name: "power"}, {PokemonType: "Psyduck", Name: "bonus"}},
+				ReturnType: "Psyduck",
+				Body: []Statement{
+					&ReturnStmt{
+						Value: &BinaryExpr{
+							Left:     &Identifier{Name: "power"},
+							Operator: "*",
+							Right:    &Identifier{Name: "bonus"},
+						},
+					},
+				},
+			},
+			&FunctionDecl{
+				Name: "cheer",
+				Body: []Statement{
+					&ReleaseStmt{Value: &StringLiteral{Value: "Go!"}},
+				},
+			},
+			&ReleaseStmt{
+				Value: &CallExpr{
+					Name: "attack",
+					Args: []Expression{&NumberLiteral{Value: 10}, &FloatLiteral{Value: 1.5}},
+				},
+			},
+			&ExpressionStmt{
+				Expr: &CallExpr{Name: "cheer"},
+			},
+		},
+	}
+
+	cg := NewCodeGen()
+	cg.GenerateProgram(program)
+	code := cg.GetCode()
+
+	expectedElements := []string{
+		"double ozul_attack(int64_t power, double bonus);",
+		"void ozul_cheer(void);",
+		"double ozul_attack(int64_t power, double bonus) {",
+		"    return (power * bonus);",
+		"void ozul_cheer(void) {",
+		"printf(\"%s\\n\", ozul_format_float(ozul_attack(10, 1.500000)));",
+		"    ozul_cheer();",
+	}
+
+	for _, expected := range expectedElements {
+		if !strings.Contains(code, expected) {
+			t.Errorf("Expected '%s' in generated code, got: %s", expected, code)
+		}
+	}
+
+	// Prototypes must come before main so calls in any order compile
+	if strings.Index(code, "double ozul_attack(int64_t power, double bonus);") > strings.Index(code, "int main() {") {
+		t.Errorf("Expected prototypes before main, got: %s", code)
+	}
+}
+
+func TestCodeGen_UndefinedFunction(t *testing.T) {
+	// Test error handling for calls to undeclared moves
+	program := &Program{
+		Statements: []Statement{
+			&ExpressionStmt{
+				Expr: &CallExpr{Name: "splash"},
+			},
+		},
+	}
+
+	cg := NewCodeGen()
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("Expected panic for undefined move, but none occurred")
+		}
+	}()
+
+	cg.GenerateProgram(program)
+}
+
+func TestCodeGen_Shadowing(t *testing.T) {
+	// Test that a nested block may shadow an outer variable
+	program := &Program{
+		Statements: []Statement{
+			&DeclarationStmt{
+				Name:        "hp",
+				PokemonType: "Pikachu",
+				Value:       &NumberLiteral{Value: 10},
+			},
+			&IfStmt{
+				Condition: &BooleanLiteral{Value: true},
+				Then: []Statement{
+					&DeclarationStmt{
+						Name:        "hp",
+						PokemonType: "Psyduck",
+						Value:       &FloatLiteral{Value: 2.5},
+					},
+					&ReleaseStmt{Value: &Identifier{Name: "hp"}},
+				},
+			},
+			&ReleaseStmt{Value: &Identifier{Name: "hp"}},
+		},
+	}
+
+	cg := NewCodeGen()
+	cg.GenerateProgram(program)
+	code := cg.GetCode()
+
+	expectedElements := []string{
+		"    int64_t hp = 10;",
+		"        double hp = 2.500000;",
+		"        printf(\"%s\\n\", ozul_format_float(hp));",
+		"    printf(\"%\" PRId64 \"\\n\", hp);",
+	}
+
+	for _, expected := range expectedElements {
+		if !strings.Contains(code, expected) {
+			t.Errorf("Expected '%s' in generated code, got: %s", expected, code)
+		}
+	}
+}
+
+func TestCodeGen_ShadowingReadsOuterVariable(t *testing.T) {
+	// In C a variable is in scope in its own initializer, so the value of a
+	// shadowing declaration that reads the outer variable goes through a
+	// temporary first
+	program := NewParser(NewLexer(`Pikachu hp is 5
+Psyduck speed is 0.5
+if true then
+Pikachu hp is hp + 1
+Psyduck speed is 1.5
+release hp + speed
+end`).Tokenize()).Parse()
+
+	cg := NewCodeGen()
+	cg.GenerateProgram(program)
+	code := cg.GetCode()
+
+	for _, expected := range []string{
+		"        int64_t ozul_t1 = (hp + 1);\n        int64_t hp = ozul_t1;",
+		"        double speed = 1.500000;",
+	} {
+		if !strings.Contains(code, expected) {
+			t.Errorf("Expected %q in generated code, got: %s", expected, code)
+		}
+	}
+	if strings.Contains(code, "int64_t hp = (hp + 1);") {
+		t.Errorf("Shadowing declaration reads itself: %s", code)
+	}
+}
+
+func TestCodeGen_RedeclarationInSameScope(t *testing.T) {
+	// Test error handling for declaring the same name twice in one block
+	program := &Program{
+		Statements: []Statement{
+			&DeclarationStmt{
+				Name:        "hp",
+				PokemonType: "Pikachu",
+				Value:       &NumberLiteral{Value: 10},
+			},
+			&DeclarationStmt{
+				Name:        "hp",
+				PokemonType: "Pikachu",
+				Value:       &NumberLiteral{Value: 20},
+			},
+		},
+	}
+
+	cg := NewCodeGen()
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("Expected panic for redeclaration, but none occurred")
+		}
+	}()
+
+	cg.GenerateProgram(program)
+}
+
+func TestCodeGen_BlockVariableEndsWithBlock(t *testing.T) {
+	// Test that a variable declared in a block is not visible after it
+	program := &Program{
+		Statements: []Statement{
+			&RepeatStmt{
+				Count: &NumberLiteral{Value: 2},
+				Body: []Statement{
+					&DeclarationStmt{
+						Name:        "fresh",
+						PokemonType: "Pikachu",
+						Value:       &NumberLiteral{Value: 1},
+					},
+				},
+			},
+			&ReleaseStmt{Value: &Identifier{Name: "fresh"}},
+		},
+	}
+
+	cg := NewCodeGen()
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("Expected panic for use of a block variable after its block, but none occurred")
+		}
+	}()
+
+	cg.GenerateProgram(program)
+}
+
+func TestCodeGen_ReleaseUsesCheckedTypes(t *testing.T) {
+	// Test that release picks its format from the checker's inferred type
+	program := &Program{
+		Statements: []Statement{
+			&DeclarationStmt{
+				Name:        "health",
+				PokemonType: "Pikachu",
+				Value:       &NumberLiteral{Value: 100},
+			},
+			&ReleaseStmt{
+				Value: &BinaryExpr{
+					Left:     &Identifier{Name: "health"},
+					Operator: "-",
+					Right:    &FloatLiteral{Value: 25.5},
+				},
+			},
+		},
+	}
+
+	cg := NewCodeGen()
+	cg.GenerateProgram(program)
+	code := cg.GetCode()
+
+	if !strings.Contains(code, "printf(\"%s\\n\", ozul_format_float((health - 25.500000)));") {
+		t.Errorf("Expected float format for a mixed int/float expression, got: %s", code)
+	}
+}
+
+func TestCodeGen_TypeErrors(t *testing.T) {
+	// Test that type errors stop code generation
+	program := &Program{
+		Statements: []Statement{
+			&DeclarationStmt{
+				Name:        "x",
+				PokemonType: "Pikachu",
+				Value:       &StringLiteral{Value: "hello"},
+			},
+		},
+	}
+
+	cg := NewCodeGen()
+
+	defer func() {
+		r := recover()
+		if r == nil {
+			t.Errorf("Expected panic for a type error, but none occurred")
+		} else if !strings.Contains(r.(string), "must be a Pikachu") {
+			t.Errorf("Expected type error message, got: %v", r)
+		}
+	}()
+
+	cg.GenerateProgram(program)
+}
+
+func TestCodeGen_TypedCatch(t *testing.T) {
+	// Test catch with an explicit Pokemon type
+	program := &Program{
+		Statements: []Statement{
+			&CatchStmt{PokemonType: "Psyduck", Variable: "speed"},
+		},
+	}
+
+	cg := NewCodeGen()
+	cg.GenerateProgram(program)
+	code := cg.GetCode()
+
+	if !strings.Contains(code, "double speed = ozul_catch_float(\"speed\", 0);") {
+		t.Errorf("Expected a double read with ozul_catch_float, got: %s", code)
+	}
+}
+
+func TestCodeGen_ConcatenationOfAnyTypes(t *testing.T) {
+	// Test that concatenation follows the checked types rather than the
+	// operands' text, converting numbers like the interpreter does
+	program := NewParser(NewLexer(`Eevee first is "Ash"
+Eevee last is "Ketchum"
+Pikachu hp is 10
+Psyduck speed is 2.5
+Voltorb ok is true
+release first + last
+release first + " has " + hp + " hp, " + speed + " " + ok
+release 1 + 2 + first`).Tokenize()).Parse()
+
+	cg := NewCodeGen()
+	cg.GenerateProgram(program)
+	code := cg.GetCode()
+
+	expectedElements := []string{
+		"ozul_puts(ozul_concat(first, last));",
+		"ozul_puts(ozul_concat(ozul_concat(ozul_concat(ozul_concat(ozul_concat(ozul_concat(first, ozul_lit(\" has \")), ozul_int_str(hp)), ozul_lit(\" hp, \")), ozul_float_str(speed)), ozul_lit(\" \")), ozul_bool_str(ok)));",
+		"ozul_puts(ozul_concat(ozul_int_str((1 + 2)), first));",
+	}
+	for _, expected := range expectedElements {
+		if !strings.Contains(code, expected) {
+			t.Errorf("Expected '%s' in generated code, got: %s", expected, code)
+		}
+	}
+	if strings.Contains(code, "ozul_format_float") {
+		t.Errorf("Expected no float runtime without a released Psyduck, got: %s", code)
+	}
+}
+
+func TestCodeGen_EscapesAndInterpolation(t *testing.T) {
+	// Test that decoded escapes are escaped again for C, and that an
+	// interpolation is generated as the concatenation it stands for
+	program := NewParser(NewLexer(`Pikachu hp is 3
+release "say \"hi\"\t{hp}\u{e9}\\\n"
+release """two
+lines"""`).Tokenize()).Parse()
+
+	cg := NewCodeGen()
+	cg.GenerateProgram(program)
+	code := cg.GetCode()
+
+	expectedElements := []string{
+		`ozul_puts(ozul_concat(ozul_concat(ozul_lit("say \"hi\"\t"), ozul_int_str(hp)), ozul_lit("\303\251\\\n")));`,
+		`ozul_puts(ozul_lit("two\nlines"));`,
+	}
+	for _, expected := range expectedElements {
+		if !strings.Contains(code, expected) {
+			t.Errorf("Expected '%s' in generated code, got: %s", expected, code)
+		}
+	}
+}
+
+func TestCodeGen_StringsWithoutFixedBuffers(t *testing.T) {
+	// Test that Eevee input and literals need no fixed-size buffers
+	program := NewParser(NewLexer(`catch Eevee name from trainer
+catch name from trainer
+release "50% \\ ok?" + name`).Tokenize()).Parse()
+
+	cg := NewCodeGen()
+	cg.GenerateProgram(program)
+	code := cg.GetCode()
+
+	expectedElements := []string{
+		"ozul_str name = ozul_catch_str(\"name\");",
+		"name = ozul_catch_str(\"name\");",
+		`ozul_puts(ozul_concat(ozul_lit("50% \\ ok\?"), name));`,
+	}
+	for _, expected := range expectedElements {
+		if !strings.Contains(code, expected) {
+			t.Errorf("Expected '%s' in generated code, got: %s", expected, code)
+		}
+	}
+	for _, unsafe := range []string{"[256]", "strcpy", "strcat", "scanf("} {
+		if strings.Contains(code, unsafe) {
+			t.Errorf("Expected no %s in generated code, got: %s", unsafe, code)
+		}
+	}
+}
+
+func TestCodeGen_NoStringRuntimeWithoutStrings(t *testing.T) {
+	// Test that programs without Eevee values stay free of the runtime
+	program := NewParser(NewLexer(`Pikachu hp is 10
+release hp > 5`).Tokenize()).Parse()
+
+	cg := NewCodeGen()
+	cg.GenerateProgram(program)
+	code := cg.GetCode()
+
+	if strings.Contains(code, "ozul_str") || strings.Contains(code, "ozul_free_all") {
+		t.Errorf("Expected no string runtime, got: %s", code)
+	}
+}
+
+func TestCodeGen_RepeatCountEvaluatedOnce(t *testing.T) {
+	// Test that a loop changing its own count still runs the original count
+	program := NewParser(NewLexer(`Pikachu count is 3
+repeat count times
+count evolves to count + 1
+end`).Tokenize()).Parse()
+
+	cg := NewCodeGen()
+	cg.GenerateProgram(program)
+	code := cg.GetCode()
+
+	expected := "for (int64_t ozul_i1 = 0, ozul_n1 = count; ozul_i1 < ozul_n1; ozul_i1++) {"
+	if !strings.Contains(code, expected) {
+		t.Errorf("Expected '%s' in generated code, got: %s", expected, code)
+	}
+}
+
+func TestCodeGen_CheckedDivision(t *testing.T) {
+	// Test that dividing by anything but a non-zero literal checks for zero
+	program := NewParser(NewLexer(`Pikachu hp is 10
+Psyduck speed is 1.5
+release hp / 2
+release hp / hp
+release hp % hp
+release speed / 0
+release speed % speed`).Tokenize()).Parse()
+
+	cg := NewCodeGen()
+	cg.GenerateProgram(program)
+	code := cg.GetCode()
+
+	for _, expected := range []string{
+		"(hp / 2)",
+		"ozul_div(hp, hp, 4)",
+		"ozul_mod(hp, hp, 5)",
+		"ozul_fdiv(speed, 0, 6)",
+		"ozul_fmod(speed, speed, 7)",
+		"[OZUL Error] Division by zero. (line %d)",
+	} {
+		if !strings.Contains(code, expected) {
+			t.Errorf("Expected '%s' in generated code, got: %s", expected, code)
+		}
+	}
+}
@@ -0,0 +1,608 @@
+package main
+
+import "fmt"
+
+// Compiler turns programs into bytecode for the VM. Every name is resolved
+// to a slot and every operation to a typed opcode here, once, so the VM does
+// no lookups while it runs.
+//
+// The compiler follows the interpreter's rules exactly, including when they
+// fail: a mistake the interpreter only reports once it reaches it, like an
+// undefined variable, compiles to an OpFail at that spot. Like the
+// interpreter it remembers the top-level variables and moves of the
+// programs it has compiled, so one VM can run several programs in turn.
+type Compiler struct {
+	// Top-level variables in declaration order, and the scope naming them
+	globals []string
+	root    *Scope[vmVar]
+
+	// Every move compiled so far, by index, and the index of each name
+	functions []*Function
+	decls     []*FunctionDecl
+	moves     map[string]int
+
+	// Function being compiled
+	fn        *Function
+	decl      *FunctionDecl // nil at the top level
+	scope     *Scope[vmVar]
+	slots     int // next free slot
+	constants map[Value]int
+	at        Span // source of the statement or expression being compiled
+}
+
+// vmVar says where a variable lives and what type of value it holds
+type vmVar struct {
+	index     int
+	global    bool
+	valueType string // "int", "float", "string" or "bool"
+}
+
+// NewCompiler creates a compiler with no variables or moves
+func NewCompiler() *Compiler {
+	return &Compiler{
+		root:  NewScope[vmVar](nil),
+		moves: make(map[string]int),
+	}
+}
+
+// Compile compiles a program's moves and returns the code of its top level
+func (c *Compiler) Compile(program *Program) *Function {
+	// Register every move first so calls may appear before the declaration
+	var registered []*FunctionDecl
+	var redeclared *FunctionDecl
+	for _, stmt := range program.Statements {
+		if decl, ok := stmt.(*FunctionDecl); ok {
+			if _, exists := c.moves[decl.Name]; exists {
+				redeclared = decl
+				break
+			}
+			c.moves[decl.Name] = len(c.functions)
+			c.functions = append(c.functions, &Function{Name: decl.Name, Params: len(decl.Params), ReturnType: decl.ReturnType})
+			c.decls = append(c.decls, decl)
+			registered = append(registered, decl)
+		}
+	}
+	for _, decl := range registered {
+		c.compileMove(decl, c.functions[c.moves[decl.Name]])
+	}
+
+	main := &Function{Name: "<main>"}
+	c.begin(main, nil, c.root)
+	if redeclared != nil {
+		c.at = redeclared.Pos()
+		c.fail(ErrRedeclaredMove, "Move already declared: %s", redeclared.Name)
+		return main
+	}
+	for _, stmt := range program.Statements {
+		c.compileStatement(stmt)
+	}
+	c.emit(OpHalt, 0)
+	return main
+}
+
+// forgetGlobals drops the top-level variables after the first n. The VM
+// calls it after a run with the number of variables the run really
+// declared, since a failed run may not reach every declaration.
+func (c *Compiler) forgetGlobals(n int) {
+	if n == len(c.globals) {
+		return
+	}
+	old := c.root
+	c.root = NewScope[vmVar](nil)
+	for _, name := range c.globals[:n] {
+		v, _ := old.Lookup(name)
+		c.root.Declare(name, v)
+	}
+	c.globals = c.globals[:n]
+}
+
+// begin starts compiling code into fn
+func (c *Compiler) begin(fn *Function, decl *FunctionDecl, scope *Scope[vmVar]) {
+	c.fn = fn
+	c.decl = decl
+	c.scope = scope
+	c.slots = 0
+	c.constants = make(map[Value]int)
+	c.at = Span{}
+	if decl != nil {
+		c.at = decl.Pos()
+	}
+}
+
+// emit appends an instruction and returns its index
+func (c *Compiler) emit(op Opcode, arg int) int {
+	c.fn.Code = append(c.fn.Code, Instruction{op, int32(arg)})
+	c.fn.Spans = append(c.fn.Spans, c.at)
+	return len(c.fn.Code) - 1
+}
+
+// patch points the jump at index jump to the next instruction
+func (c *Compiler) patch(jump int) {
+	c.fn.Code[jump].Arg = int32(len(c.fn.Code))
+}
+
+// constant emits an instruction pushing value, adding it to the constant
+// pool unless it is already there
+func (c *Compiler) constant(value Value) {
+	index, ok := c.constants[value]
+	if !ok {
+		index = len(c.fn.Constants)
+		c.fn.Constants = append(c.fn.Constants, value)
+		c.constants[value] = index
+	}
+	c.emit(OpConst, index)
+}
+
+// fail emits an instruction raising a runtime error at the current position
+func (c *Compiler) fail(kind RuntimeErrorKind, format string, args ...interface{}) {
+	c.fn.Failures = append(c.fn.Failures, &RuntimeError{Kind: kind, Message: fmt.Sprintf(format, args...)})
+	c.emit(OpFail, len(c.fn.Failures)-1)
+}
+
+// newSlot reserves a slot in the current function's frame
+func (c *Compiler) newSlot() int {
+	slot := c.slots
+	c.slots++
+	if c.slots > c.fn.Slots {
+		c.fn.Slots = c.slots
+	}
+	return slot
+}
+
+// declare pops the value on top of the stack into a new variable. In the
+// top-level scope that is a global, anywhere else a slot of the frame.
+func (c *Compiler) declare(name, valueType string) {
+	v := vmVar{valueType: valueType, global: c.scope == c.root}
+	if v.global {
+		v.index = len(c.globals)
+	} else {
+		v.index = c.slots
+	}
+	if err := c.scope.Declare(name, v); err != nil {
+		c.fail(ErrRedeclaredVariable, "Variable already declared in this scope: %s", name)
+		return
+	}
+	if v.global {
+		c.globals = append(c.globals, name)
+		c.emit(OpDefineGlobal, v.index)
+	} else {
+		c.emit(OpStoreLocal, c.newSlot())
+	}
+}
+
+func (c *Compiler) load(v vmVar) {
+	if v.global {
+		c.emit(OpLoadGlobal, v.index)
+	} else {
+		c.emit(OpLoadLocal, v.index)
+	}
+}
+
+func (c *Compiler) store(v vmVar) {
+	if v.global {
+		c.emit(OpStoreGlobal, v.index)
+	} else {
+		c.emit(OpStoreLocal, v.index)
+	}
+}
+
+// compileMove compiles a move's body into fn. Parameters take the first
+// slots; like in the interpreter they share one scope with the body's
+// top-level declarations, and the caller's variables are out of reach.
+func (c *Compiler) compileMove(decl *FunctionDecl, fn *Function) {
+	c.begin(fn, decl, NewScope[vmVar](nil))
+	for _, param := range decl.Params {
+		// A duplicate parameter is reported by every call, before the body
+		// could run, so only the first one needs a name
+		c.scope.Declare(param.Name, vmVar{index: c.newSlot(), valueType: valueTypes[param.PokemonType]})
+	}
+	for _, stmt := range decl.Body {
+		c.compileStatement(stmt)
+	}
+	if decl.ReturnType != "" {
+		c.fail(ErrMissingReturn, "Move %s ended without giving back a %s", decl.Name, decl.ReturnType)
+	} else {
+		c.emit(OpReturnVoid, 0)
+	}
+}
+
+// compileBlock compiles a nested block in its own scope. Slots of the
+// block's variables are reused once it ends.
+func (c *Compiler) compileBlock(stmts []Statement) {
+	outer, slots := c.scope, c.slots
+	c.scope = NewScope(outer)
+	for _, stmt := range stmts {
+		c.compileStatement(stmt)
+	}
+	c.scope, c.slots = outer, slots
+}
+
+func (c *Compiler) compileStatement(stmt Statement) {
+	c.at = stmt.Pos()
+	c.emit(OpStep, 0)
+	switch s := stmt.(type) {
+	case *DeclarationStmt:
+		valueType := c.compileExpression(s.Value)
+		c.convert(valueType, s.PokemonType, "variable "+s.Name)
+		c.declare(s.Name, valueTypes[s.PokemonType])
+	case *AssignmentStmt:
+		valueType := c.compileExpression(s.Value)
+		v, ok := c.scope.Lookup(s.Name)
+		if !ok {
+			c.fail(ErrUndefinedVariable, "Variable not declared: %s", s.Name)
+			return
+		}
+		// A variable keeps the type it was declared with
+		c.convert(valueType, pokemonTypes[v.valueType], "variable "+s.Name)
+		c.store(v)
+	case *ReleaseStmt:
+		c.compileExpression(s.Value)
+		c.emit(OpPrint, 0)
+	case *IfStmt:
+		c.expectBool(c.compileExpression(s.Condition), "if")
+		toElse := c.emit(OpJumpIfFalse, 0)
+		c.compileBlock(s.Then)
+		toEnd := c.emit(OpJump, 0)
+		c.patch(toElse)
+		c.compileBlock(s.Else)
+		c.patch(toEnd)
+	case *WhileStmt:
+		start := len(c.fn.Code)
+		c.expectBool(c.compileExpression(s.Condition), "train while")
+		exit := c.emit(OpJumpIfFalse, 0)
+		c.compileBlock(s.Body)
+		c.at = s.Pos()
+		c.emit(OpStep, 0) // count iterations so empty bodies still hit the limit
+		c.emit(OpJump, start)
+		c.patch(exit)
+	case *RepeatStmt:
+		c.compileRepeat(s)
+	case *FunctionDecl:
+		// Compiled up front by Compile
+	case *ReturnStmt:
+		c.compileReturn(s)
+	case *ExpressionStmt:
+		var valueType string
+		if call, ok := s.Expr.(*CallExpr); ok {
+			valueType, _ = c.compileCall(call)
+		} else {
+			valueType = c.compileExpression(s.Expr)
+		}
+		if valueType != "" {
+			c.emit(OpPop, 0)
+		}
+	case *CatchStmt:
+		// A typed catch declares a new variable; an untyped one reads into a
+		// visible variable, or else declares a new Pikachu
+		old, exists := c.scope.Lookup(s.Variable)
+		pokemonType := s.PokemonType
+		if pokemonType == "" {
+			pokemonType = "Pikachu"
+			if exists {
+				pokemonType = pokemonTypes[old.valueType]
+			}
+		}
+		c.constant(Value{Type: "string", Str: s.Variable})
+		c.emit(OpCatch, catchType(pokemonType))
+		if s.PokemonType == "" && exists {
+			c.store(old)
+		} else {
+			c.declare(s.Variable, valueTypes[pokemonType])
+		}
+	}
+}
+
+// catchType returns the index of a Pokemon type in catchTypes
+func catchType(pokemonType string) int {
+	for i, t := range catchTypes {
+		if t == pokemonType {
+			return i
+		}
+	}
+	panic("[OZUL Compiler Error] Unknown type to catch: " + pokemonType)
+}
+
+// compileRepeat counts iterations in two hidden slots, so the count is
+// evaluated only once
+func (c *Compiler) compileRepeat(s *RepeatStmt) {
+	countType := c.compileExpression(s.Count)
+	if countType != "int" {
+		c.fail(ErrTypeMismatch, "'repeat' needs a Pikachu (int) count, got %s", countType)
+		return
+	}
+	slots := c.slots
+	limit, counter := c.newSlot(), c.newSlot()
+	c.emit(OpStoreLocal, limit)
+	c.constant(Value{Type: "int", Int: 0})
+	c.emit(OpStoreLocal, counter)
+
+	start := len(c.fn.Code)
+	c.emit(OpLoadLocal, counter)
+	c.emit(OpLoadLocal, limit)
+	c.emit(OpCompareInt, compareOp("<"))
+	exit := c.emit(OpJumpIfFalse, 0)
+	c.compileBlock(s.Body)
+	c.at = s.Pos()
+	c.emit(OpStep, 0)
+	c.emit(OpLoadLocal, counter)
+	c.constant(Value{Type: "int", Int: 1})
+	c.emit(OpAddInt, 0)
+	c.emit(OpStoreLocal, counter)
+	c.emit(OpJump, start)
+	c.patch(exit)
+	c.slots = slots
+}
+
+// compileReturn returns from the current move. At the top level, return
+// ends the program.
+func (c *Compiler) compileReturn(s *ReturnStmt) {
+	decl := c.decl
+	switch {
+	case decl == nil:
+		if s.Value != nil && c.compileExpression(s.Value) != "" {
+			c.emit(OpPop, 0)
+		}
+		c.emit(OpHalt, 0)
+	case s.Value == nil && decl.ReturnType != "":
+		c.fail(ErrMissingReturn, "Move %s ended without giving back a %s", decl.Name, decl.ReturnType)
+	case s.Value == nil:
+		c.emit(OpReturnVoid, 0)
+	case decl.ReturnType == "":
+		c.compileExpression(s.Value)
+		c.fail(ErrTypeMismatch, "Move %s does not declare a return type but returned a value", decl.Name)
+	default:
+		c.convert(c.compileExpression(s.Value), decl.ReturnType, "return value of move "+decl.Name)
+		c.emit(OpReturn, 0)
+	}
+}
+
+// compileExpression compiles expr, pointing the compiler's position at it.
+// It returns the type of the value it leaves on the stack, or "" when there
+// is none because the code fails first.
+func (c *Compiler) compileExpression(expr Expression) string {
+	outer := c.at
+	if expr != nil {
+		c.at = expr.Pos()
+	}
+	valueType := c.compileExpr(expr)
+	c.at = outer
+	return valueType
+}
+
+func (c *Compiler) compileExpr(expr Expression) string {
+	switch e := expr.(type) {
+	case *NumberLiteral:
+		c.constant(Value{Type: "int", Int: e.Value})
+		return "int"
+	case *FloatLiteral:
+		c.constant(Value{Type: "float", Float: e.Value})
+		return "float"
+	case *InterpolatedString:
+		return c.compileExpr(e.Value)
+	case *StringLiteral:
+		c.constant(Value{Type: "string", Str: e.Value})
+		return "string"
+	case *BooleanLiteral:
+		c.constant(Value{Type: "bool", Bool: e.Value})
+		return "bool"
+	case *Identifier:
+		v, ok := c.scope.Lookup(e.Name)
+		if !ok {
+			c.fail(ErrUndefinedVariable, "Undefined variable: %s", e.Name)
+			return ""
+		}
+		c.load(v)
+		return v.valueType
+	case *CallExpr:
+		valueType, called := c.compileCall(e)
+		if called && valueType == "" {
+			c.fail(ErrMissingReturn, "Move %s does not give a value", e.Name)
+		}
+		return valueType
+	case *UnaryExpr:
+		operandType := c.compileExpression(e.Operand)
+		if e.Operator == "not" {
+			c.expectBool(operandType, "not")
+			c.emit(OpNot, 0)
+			return "bool"
+		}
+		if e.Operator == "-" {
+			switch operandType {
+			case "int":
+				c.emit(OpNegInt, 0)
+			case "float":
+				c.emit(OpNegFloat, 0)
+			case "":
+			default:
+				c.fail(ErrTypeMismatch, "Cannot use %s (%s) values with operator -", pokemonTypes[operandType], operandType)
+			}
+			return operandType
+		}
+		c.fail(ErrUnknownOperator, "Unknown operator: %s", e.Operator)
+		return ""
+	case *BinaryExpr:
+		if e.Operator == "and" || e.Operator == "or" {
+			return c.compileLogical(e)
+		}
+		left := c.compileExpression(e.Left)
+		right := c.compileExpression(e.Right)
+		switch e.Operator {
+		case "==", "!=", "<", "<=", ">", ">=":
+			return c.compileComparison(e.Operator, left, right)
+		}
+		return c.compileArithmetic(e.Operator, left, right)
+	default:
+		c.fail(ErrUnknownExpression, "Unknown expression type.")
+		return ""
+	}
+}
+
+// compileCall compiles a call and returns the type of value the move gives.
+// called is false when the call fails before the move runs.
+func (c *Compiler) compileCall(call *CallExpr) (valueType string, called bool) {
+	index, ok := c.moves[call.Name]
+	if !ok {
+		c.fail(ErrUndefinedMove, "Undefined move: %s", call.Name)
+		return "", false
+	}
+	decl := c.decls[index]
+	if len(call.Args) != len(decl.Params) {
+		c.fail(ErrArgumentCount, "Move %s expects %d arguments, got %d", decl.Name, len(decl.Params), len(call.Args))
+		return "", false
+	}
+	for i, param := range decl.Params {
+		argType := c.compileExpression(call.Args[i])
+		c.convert(argType, param.PokemonType, "argument "+param.Name+" of move "+decl.Name)
+		for _, earlier := range decl.Params[:i] {
+			if earlier.Name == param.Name {
+				c.fail(ErrRedeclaredVariable, "Duplicate parameter %s in move %s", param.Name, decl.Name)
+				return "", false
+			}
+		}
+	}
+	c.emit(OpCall, index)
+	return valueTypes[decl.ReturnType], true
+}
+
+// compileLogical compiles "and"/"or", jumping over the right side when the
+// left side already decides the result
+func (c *Compiler) compileLogical(e *BinaryExpr) string {
+	c.expectBool(c.compileExpression(e.Left), e.Operator)
+	shortCircuit := OpJumpIfFalse
+	if e.Operator == "or" {
+		shortCircuit = OpJumpIfTrue
+	}
+	decided := c.emit(shortCircuit, 0)
+	c.expectBool(c.compileExpression(e.Right), e.Operator)
+	end := c.emit(OpJump, 0)
+	c.patch(decided)
+	c.constant(Value{Type: "bool", Bool: e.Operator == "or"})
+	c.patch(end)
+	return "bool"
+}
+
+func (c *Compiler) compileComparison(op string, left, right string) string {
+	switch {
+	case left == "string" && right == "string":
+		c.emit(OpCompareString, compareOp(op))
+	case left == "bool" && right == "bool":
+		if op != "==" && op != "!=" {
+			c.fail(ErrTypeMismatch, "Cannot order Voltorb (bool) values with operator %s", op)
+			return ""
+		}
+		c.emit(OpCompareBool, compareOp(op))
+	case left == "int" && right == "int":
+		c.emit(OpCompareInt, compareOp(op))
+	case isNumberType(left) && isNumberType(right):
+		c.toFloat(left, 1)
+		c.toFloat(right, 0)
+		c.emit(OpCompareFloat, compareOp(op))
+	default:
+		c.fail(ErrTypeMismatch, "Cannot compare %s with %s", left, right)
+		return ""
+	}
+	return "bool"
+}
+
+// compileArithmetic picks the typed opcode for + - * / % **. Like in the
+// interpreter, + joins strings, a float on either side makes the operation
+// a float one, and a string used as a number is parsed.
+func (c *Compiler) compileArithmetic(op string, left, right string) string {
+	if op == "+" && (left == "string" || right == "string") {
+		c.toString(left, 1)
+		c.toString(right, 0)
+		c.emit(OpConcat, 0)
+		return "string"
+	}
+	if left == "bool" || right == "bool" {
+		c.fail(ErrTypeMismatch, "Cannot use Voltorb (bool) values with operator %s", op)
+		return ""
+	}
+
+	ops, resultType := intOps, "int"
+	if left == "float" || right == "float" {
+		ops, resultType = floatOps, "float"
+		c.toFloat(left, 1)
+		c.toFloat(right, 0)
+	} else {
+		c.toInt(left, 1)
+		c.toInt(right, 0)
+	}
+	opcode, ok := ops[op]
+	if !ok {
+		c.fail(ErrUnknownOperator, "Unknown operator: %s", op)
+		return ""
+	}
+	c.emit(opcode, 0)
+	return resultType
+}
+
+var (
+	intOps   = map[string]Opcode{"+": OpAddInt, "-": OpSubInt, "*": OpMulInt, "/": OpDivInt, "%": OpModInt, "**": OpPowInt}
+	floatOps = map[string]Opcode{"+": OpAddFloat, "-": OpSubFloat, "*": OpMulFloat, "/": OpDivFloat, "%": OpModFloat, "**": OpPowFloat}
+)
+
+// convert checks the value on top of the stack against a declared Pokemon
+// type, promoting ints to floats where a Psyduck is expected
+func (c *Compiler) convert(valueType, pokemonType, context string) {
+	want := valueTypes[pokemonType]
+	if valueType == want || valueType == "" {
+		return
+	}
+	if want == "float" && valueType == "int" {
+		c.emit(OpIntToFloat, 0)
+		return
+	}
+	c.fail(ErrTypeMismatch, "%s must be a %s, got %s", context, pokemonType, valueType)
+}
+
+// expectBool fails unless a condition or logical operand is a real truth
+// value
+func (c *Compiler) expectBool(valueType, context string) {
+	if valueType != "bool" && valueType != "" {
+		c.fail(ErrTypeMismatch, "'%s' needs a Voltorb (bool) value, got %s", context, valueType)
+	}
+}
+
+// toFloat, toInt and toString convert the value depth places below the top
+// of the stack
+
+func (c *Compiler) toFloat(valueType string, depth int) {
+	switch valueType {
+	case "int":
+		c.emit(OpIntToFloat, depth)
+	case "string":
+		c.emit(OpStringToFloat, depth)
+	}
+}
+
+func (c *Compiler) toInt(valueType string, depth int) {
+	if valueType == "string" {
+		c.emit(OpStringToInt, depth)
+	}
+}
+
+func (c *Compiler) toString(valueType string, depth int) {
+	switch valueType {
+	case "int":
+		c.emit(OpIntToString, depth)
+	case "float":
+		c.emit(OpFloatToString, depth)
+	case "bool":
+		c.emit(OpBoolToString, depth)
+	}
+}
+
+func isNumberType(valueType string) bool {
+	return valueType == "int" || valueType == "float"
+}
+
+// compareOp returns the Arg of a compare instruction for an operator
+func compareOp(op string) int {
+	for i, o := range compareOps {
+		if o == op {
+			return i
+		}
+	}
+	panic("[OZUL Compiler Error] Unknown comparison: " + op)
+}
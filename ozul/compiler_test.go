@@ -0,0 +1,109 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// compileSource compiles a program and returns its top-level code
+func compileSource(source string) *Function {
+	return NewCompiler().Compile(NewParser(NewLexer(source).Tokenize()).Parse())
+}
+
+// opcodes lists the opcodes of a function, leaving out OpStep
+func opcodes(fn *Function) []string {
+	var ops []string
+	for _, ins := range fn.Code {
+		if ins.Op != OpStep {
+			ops = append(ops, ins.Op.String())
+		}
+	}
+	return ops
+}
+
+func TestCompiler_TypedOpcodes(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{"release 1 + 2", "CONST CONST ADD_INT PRINT HALT"},
+		{"release 1.5 * 2", "CONST CONST INT_TO_FLOAT MUL_FLOAT PRINT HALT"},
+		{"release 2 / 1.5", "CONST CONST INT_TO_FLOAT DIV_FLOAT PRINT HALT"},
+		{"release \"hp: \" + 3", "CONST CONST INT_TO_STRING CONCAT PRINT HALT"},
+		{"release 1 < 2.5", "CONST CONST INT_TO_FLOAT COMPARE_FLOAT PRINT HALT"},
+		{"release \"a\" == \"b\"", "CONST CONST COMPARE_STRING PRINT HALT"},
+		{"release true and false", "CONST JUMP_IF_FALSE CONST JUMP CONST PRINT HALT"},
+		{"Psyduck speed is 3", "CONST INT_TO_FLOAT DEFINE_GLOBAL HALT"},
+	}
+
+	for _, tt := range tests {
+		if got := strings.Join(opcodes(compileSource(tt.source)), " "); got != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.source, tt.expected, got)
+		}
+	}
+}
+
+func TestCompiler_ConversionsReachBelowTheTop(t *testing.T) {
+	fn := compileSource("release 1 + 2.5")
+	for _, ins := range fn.Code {
+		if ins.Op == OpIntToFloat && ins.Arg != 1 {
+			t.Errorf("Expected the left operand to be converted one below the top, got depth %d", ins.Arg)
+		}
+	}
+}
+
+func TestCompiler_ConstantPool(t *testing.T) {
+	fn := compileSource("release \"hi\"\nrelease \"hi\"\nrelease 7\nrelease 7.0")
+	if len(fn.Constants) != 3 {
+		t.Errorf("Expected 3 distinct constants, got %v", fn.Constants)
+	}
+}
+
+func TestCompiler_SlotsAreReusedAfterBlocks(t *testing.T) {
+	fn := compileSource(`if true then
+Pikachu a is 1
+Pikachu b is 2
+end
+if true then
+Pikachu c is 3
+end`)
+	if fn.Slots != 2 {
+		t.Errorf("Expected 2 slots, got %d", fn.Slots)
+	}
+
+	c := NewCompiler()
+	c.Compile(NewParser(NewLexer("move add(Pikachu a, Pikachu b) gives Pikachu\nPikachu sum is a + b\nreturn sum\nend").Tokenize()).Parse())
+	if add := c.functions[c.moves["add"]]; add.Params != 2 || add.Slots != 3 {
+		t.Errorf("Expected add to have 2 params in 3 slots, got %d in %d", add.Params, add.Slots)
+	}
+}
+
+func TestCompiler_MistakesFailWhereTheyAre(t *testing.T) {
+	fn := compileSource("release 1\nrelease missing\nrelease 2")
+	expected := "CONST PRINT FAIL PRINT CONST PRINT HALT"
+	if got := strings.Join(opcodes(fn), " "); got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+	if len(fn.Failures) != 1 || fn.Failures[0].Kind != ErrUndefinedVariable {
+		t.Errorf("Expected one undefined variable failure, got %v", fn.Failures)
+	}
+}
+
+func TestCompiler_Disassemble(t *testing.T) {
+	var out strings.Builder
+	compileSource("Pikachu hp is 10\nrelease hp > 5").Disassemble(&out)
+	expected := `== <main> (params 0, slots 0) ==
+0000    1  STEP
+0001    1  CONST           0 (10)
+0002    1  DEFINE_GLOBAL   0
+0003    2  STEP
+0004    2  LOAD_GLOBAL     0
+0005    2  CONST           1 (5)
+0006    2  COMPARE_INT     >
+0007    2  PRINT
+0008    2  HALT
+`
+	if out.String() != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, out.String())
+	}
+}
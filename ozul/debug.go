@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DebugSchemaVersion is bumped whenever the JSON dump changes in a way that
+// could break tools reading it. Adding a new node kind or field does not.
+const DebugSchemaVersion = 1
+
+// debugNode is a language-neutral description of one AST node. Both the text
+// and the JSON dumps are built from it, so they always show the same tree.
+type debugNode struct {
+	Kind   string
+	Span   *Span // nil for the Program, which has no position of its own
+	Fields []debugField
+}
+
+// debugField is one named part of a node. Value is a string, int, float64
+// or bool for plain data, a *debugNode for a child (nil when missing), or a
+// []*debugNode for a list of children.
+type debugField struct {
+	Name  string
+	Value interface{}
+}
+
+// describeProgram builds the debug tree of a whole program
+func describeProgram(program *Program) *debugNode {
+	return &debugNode{Kind: "Program", Fields: []debugField{
+		{"statements", describeStatements(program.Statements)},
+	}}
+}
+
+func describeStatements(stmts []Statement) []*debugNode {
+	nodes := make([]*debugNode, len(stmts))
+	for i, stmt := range stmts {
+		nodes[i] = describe(stmt)
+	}
+	return nodes
+}
+
+// describe builds the debug tree of a node. Nodes missing because of a parse
+// error become nil.
+func describe(node ASTNode) *debugNode {
+	var kind string
+	var fields []debugField
+	switch n := node.(type) {
+	case nil:
+		return nil
+	case *DeclarationStmt:
+		kind, fields = "DeclarationStmt", []debugField{{"type", n.PokemonType}, {"name", n.Name}, {"value", describe(n.Value)}}
+	case *AssignmentStmt:
+		kind, fields = "AssignmentStmt", []debugField{{"name", n.Name}, {"value", describe(n.Value)}}
+	case *ReleaseStmt:
+		kind, fields = "ReleaseStmt", []debugField{{"value", describe(n.Value)}}
+	case *CatchStmt:
+		kind, fields = "CatchStmt", []debugField{{"type", n.PokemonType}, {"name", n.Variable}}
+	case *IfStmt:
+		kind, fields = "IfStmt", []debugField{
+			{"condition", describe(n.Condition)},
+			{"then", describeStatements(n.Then)},
+			{"else", describeStatements(n.Else)},
+		}
+	case *WhileStmt:
+		kind, fields = "WhileStmt", []debugField{{"condition", describe(n.Condition)}, {"body", describeStatements(n.Body)}}
+	case *RepeatStmt:
+		kind, fields = "RepeatStmt", []debugField{{"count", describe(n.Count)}, {"body", describeStatements(n.Body)}}
+	case *FunctionDecl:
+		params := make([]*debugNode, len(n.Params))
+		for i, param := range n.Params {
+			span := param.Span
+			params[i] = &debugNode{Kind: "Param", Span: &span, Fields: []debugField{{"type", param.PokemonType}, {"name", param.Name}}}
+		}
+		kind, fields = "FunctionDecl", []debugField{
+			{"name", n.Name},
+			{"params", params},
+			{"returnType", n.ReturnType},
+			{"body", describeStatements(n.Body)},
+		}
+	case *ReturnStmt:
+		kind, fields = "ReturnStmt", []debugField{{"value", describe(n.Value)}}
+	case *ExpressionStmt:
+		kind, fields = "ExpressionStmt", []debugField{{"expr", describe(n.Expr)}}
+	case *BinaryExpr:
+		kind, fields = "BinaryExpr", []debugField{{"operator", n.Operator}, {"left", describe(n.Left)}, {"right", describe(n.Right)}}
+	case *UnaryExpr:
+		kind, fields = "UnaryExpr", []debugField{{"operator", n.Operator}, {"operand", describe(n.Operand)}}
+	case *NumberLiteral:
+		kind, fields = "NumberLiteral", []debugField{{"value", n.Value}}
+	case *FloatLiteral:
+		kind, fields = "FloatLiteral", []debugField{{"value", n.Value}}
+	case *StringLiteral:
+		kind, fields = "StringLiteral", []debugField{{"value", n.Value}}
+	case *InterpolatedString:
+		parts := make([]*debugNode, len(n.Parts))
+		for i, part := range n.Parts {
+			parts[i] = describe(part)
+		}
+		kind, fields = "InterpolatedString", []debugField{{"parts", parts}}
+	case *BooleanLiteral:
+		kind, fields = "BooleanLiteral", []debugField{{"value", n.Value}}
+	case *CallExpr:
+		args := make([]*debugNode, len(n.Args))
+		for i, arg := range n.Args {
+			args[i] = describe(arg)
+		}
+		kind, fields = "CallExpr", []debugField{{"name", n.Name}, {"args", args}}
+	case *Identifier:
+		kind, fields = "Identifier", []debugField{{"name", n.Name}}
+	default:
+		kind = fmt.Sprintf("%T", node)
+	}
+	span := node.Pos()
+	return &debugNode{Kind: kind, Span: &span, Fields: fields}
+}
+
+// DumpDebugText writes the token stream and the AST as an indented tree
+func DumpDebugText(w io.Writer, tokens []Token, program *Program) {
+	fmt.Fprintln(w, "=== TOKENS ===")
+	DumpTokensText(w, tokens)
+	fmt.Fprintln(w, "=== AST ===")
+	DumpASTText(w, program)
+}
+
+// DumpTokensText writes one token per line: its span, type and value. The
+// comments kept on a token come first, marked "comment".
+func DumpTokensText(w io.Writer, tokens []Token) {
+	for _, tok := range tokens {
+		for _, comment := range tok.Leading {
+			fmt.Fprintf(w, "%-12s %-11s %q\n", formatSpan(comment.Span), "comment", comment.Text)
+		}
+		fmt.Fprintf(w, "%-12s %-11s %q\n", formatSpan(tokenSpan(tok)), tok.Type, tok.Value)
+	}
+}
+
+// DumpASTText writes the AST as an indented tree
+func DumpASTText(w io.Writer, program *Program) {
+	writeDebugNode(w, describeProgram(program), "", "")
+}
+
+func formatSpan(span Span) string {
+	return fmt.Sprintf("%d:%d-%d:%d", span.Start.Line, span.Start.Column, span.End.Line, span.End.Column)
+}
+
+func tokenSpan(tok Token) Span {
+	return Span{Position{tok.Line, tok.Column}, Position{tok.EndLine, tok.EndColumn}}
+}
+
+// writeDebugNode prints a node's kind, span and plain fields on one line,
+// then its children indented beneath it
+func writeDebugNode(w io.Writer, node *debugNode, indent, label string) {
+	if node == nil {
+		fmt.Fprintf(w, "%s%s<missing>\n", indent, label)
+		return
+	}
+	var sb strings.Builder
+	sb.WriteString(indent + label + node.Kind)
+	if node.Span != nil {
+		sb.WriteString(" " + formatSpan(*node.Span))
+	}
+	for _, field := range node.Fields {
+		switch field.Value.(type) {
+		case *debugNode, []*debugNode:
+		case string:
+			sb.WriteString(fmt.Sprintf(" %s=%q", field.Name, field.Value))
+		default:
+			sb.WriteString(fmt.Sprintf(" %s=%v", field.Name, field.Value))
+		}
+	}
+	fmt.Fprintln(w, sb.String())
+
+	inner := indent + "  "
+	for _, field := range node.Fields {
+		switch value := field.Value.(type) {
+		case *debugNode:
+			writeDebugNode(w, value, inner, field.Name+": ")
+		case []*debugNode:
+			if len(value) == 0 {
+				continue
+			}
+			fmt.Fprintf(w, "%s%s:\n", inner, field.Name)
+			for _, child := range value {
+				writeDebugNode(w, child, inner+"  ", "")
+			}
+		}
+	}
+}
+
+// DumpDebugJSON writes the token stream and the AST as one JSON document:
+//
+//	{"version": 1, "tokens": [Token...], "program": Node}
+//
+// A Token is {"type", "value", "span"}; type is the TokenType name, like
+// "IDENTIFIER". A token with comments before it also has "leading", a list
+// of {"text", "doc", "span"}. A Node is {"kind", "span", ...fields}, where
+// kind is the AST type name, like "DeclarationStmt", and the fields are the
+// ones shown by the text dump. A span is {"start": {"line", "column"},
+// "end": {...}} with end just past the last character. A missing child is
+// null and an empty block is []. The Program node has no span. A nil tokens
+// or program leaves that part out of the document.
+func DumpDebugJSON(w io.Writer, tokens []Token, program *Program) error {
+	type jsonComment struct {
+		Text string `json:"text"`
+		Doc  bool   `json:"doc"`
+		Span Span   `json:"span"`
+	}
+	type jsonToken struct {
+		Type    string        `json:"type"`
+		Value   string        `json:"value"`
+		Span    Span          `json:"span"`
+		Leading []jsonComment `json:"leading,omitempty"`
+	}
+
+	doc := map[string]interface{}{"version": DebugSchemaVersion}
+	if tokens != nil {
+		jsonTokens := make([]jsonToken, len(tokens))
+		for i, tok := range tokens {
+			jsonTokens[i] = jsonToken{Type: tok.Type.String(), Value: tok.Value, Span: tokenSpan(tok)}
+			for _, comment := range tok.Leading {
+				jsonTokens[i].Leading = append(jsonTokens[i].Leading, jsonComment{comment.Text, comment.Doc, comment.Span})
+			}
+		}
+		doc["tokens"] = jsonTokens
+	}
+	if program != nil {
+		doc["program"] = debugJSON(describeProgram(program))
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
+
+// debugJSON turns a debug node into a value encoding/json can write. Maps
+// keep the output stable: their keys are always written in sorted order.
+func debugJSON(node *debugNode) interface{} {
+	if node == nil {
+		return nil
+	}
+	obj := map[string]interface{}{"kind": node.Kind}
+	if node.Span != nil {
+		obj["span"] = *node.Span
+	}
+	for _, field := range node.Fields {
+		switch value := field.Value.(type) {
+		case *debugNode:
+			obj[field.Name] = debugJSON(value)
+		case []*debugNode:
+			children := make([]interface{}, len(value))
+			for i, child := range value {
+				children[i] = debugJSON(child)
+			}
+			obj[field.Name] = children
+		default:
+			obj[field.Name] = value
+		}
+	}
+	return obj
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// allNodesSource uses every kind of AST node at least once
+const allNodesSource = `Pikachu hp is 10
+Psyduck speed is 2.5
+Eevee name is "Ash"
+Voltorb ready is not false
+hp evolves to hp - 1
+catch Pikachu level from trainer
+move heal(Pikachu amount) gives Pikachu
+return amount + 1
+end
+if ready and hp > 0 then
+train while hp < 20
+hp evolves to heal(hp)
+end
+else
+repeat 2 times
+release name
+end
+end
+heal(1)`
+
+func debugInput(source string) ([]Token, *Program) {
+	tokens := NewLexer(source).Tokenize()
+	return tokens, NewParser(tokens).Parse()
+}
+
+func TestDumpDebugText(t *testing.T) {
+	var out bytes.Buffer
+	tokens, program := debugInput("Pikachu x is 1 + 2\nrelease x")
+	DumpDebugText(&out, tokens, program)
+
+	expected := `=== TOKENS ===
+1:1-1:8      PIKACHU     "Pikachu"
+1:9-1:10     IDENTIFIER  "x"
+1:11-1:13    IS          "is"
+1:14-1:15    NUMBER      "1"
+1:16-1:17    PLUS        "+"
+1:18-1:19    NUMBER      "2"
+1:19-2:1     NEWLINE     "\n"
+2:1-2:8      RELEASE     "release"
+2:9-2:10     IDENTIFIER  "x"
+2:10-2:10    EOF         ""
+=== AST ===
+Program
+  statements:
+    DeclarationStmt 1:1-1:19 type="Pikachu" name="x"
+      value: BinaryExpr 1:14-1:19 operator="+"
+        left: NumberLiteral 1:14-1:15 value=1
+        right: NumberLiteral 1:18-1:19 value=2
+    ReleaseStmt 2:1-2:10
+      value: Identifier 2:9-2:10 name="x"
+`
+	if out.String() != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, out.String())
+	}
+}
+
+func TestDumpDebugText_CoversEveryNode(t *testing.T) {
+	var out bytes.Buffer
+	tokens, program := debugInput(allNodesSource)
+	DumpDebugText(&out, tokens, program)
+
+	for _, kind := range []string{
+		"DeclarationStmt", "AssignmentStmt", "ReleaseStmt", "CatchStmt", "IfStmt",
+		"WhileStmt", "RepeatStmt", "FunctionDecl", "Param", "ReturnStmt", "ExpressionStmt",
+		"BinaryExpr", "UnaryExpr", "NumberLiteral", "FloatLiteral", "StringLiteral",
+		"BooleanLiteral", "CallExpr", "Identifier",
+	} {
+		if !strings.Contains(out.String(), kind+" ") {
+			t.Errorf("Expected a %s node in the dump", kind)
+		}
+	}
+	if strings.Contains(out.String(), "*main.") {
+		t.Errorf("Expected every node to have a described kind, got:\n%s", out.String())
+	}
+}
+
+// TestDumpDebugJSON_Schema pins the JSON schema: the fields of every node
+// kind must not change without bumping DebugSchemaVersion
+func TestDumpDebugJSON_Schema(t *testing.T) {
+	var out bytes.Buffer
+	tokens, program := debugInput(allNodesSource)
+	if err := DumpDebugJSON(&out, tokens, program); err != nil {
+		t.Fatal(err)
+	}
+
+	var doc struct {
+		Version int                      `json:"version"`
+		Tokens  []map[string]interface{} `json:"tokens"`
+		Program map[string]interface{}   `json:"program"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &doc); err != nil {
+		t.Fatalf("Expected valid JSON, got %v:\n%s", err, out.String())
+	}
+	if doc.Version != 1 {
+		t.Errorf("Expected schema version 1, got %d", doc.Version)
+	}
+	if len(doc.Tokens) != len(tokens) || doc.Tokens[0]["type"] != "PIKACHU" || doc.Tokens[0]["value"] != "Pikachu" {
+		t.Errorf("Expected the token stream, got %v", doc.Tokens[:1])
+	}
+
+	expected := map[string]string{
+		"Program":         "kind statements",
+		"DeclarationStmt": "kind name span type value",
+		"AssignmentStmt":  "kind name span value",
+		"ReleaseStmt":     "kind span value",
+		"CatchStmt":       "kind name span type",
+		"IfStmt":          "condition else kind span then",
+		"WhileStmt":       "body condition kind span",
+		"RepeatStmt":      "body count kind span",
+		"FunctionDecl":    "body kind name params returnType span",
+		"Param":           "kind name span type",
+		"ReturnStmt":      "kind span value",
+		"ExpressionStmt":  "expr kind span",
+		"BinaryExpr":      "kind left operator right span",
+		"UnaryExpr":       "kind operand operator span",
+		"NumberLiteral":   "kind span value",
+		"FloatLiteral":    "kind span value",
+		"StringLiteral":   "kind span value",
+		"BooleanLiteral":  "kind span value",
+		"CallExpr":        "args kind name span",
+		"Identifier":      "kind name span",
+	}
+	seen := make(map[string]bool)
+	var walk func(value interface{})
+	walk = func(value interface{}) {
+		switch v := value.(type) {
+		case []interface{}:
+			for _, child := range v {
+				walk(child)
+			}
+		case map[string]interface{}:
+			kind, ok := v["kind"].(string)
+			if !ok {
+				return // a span
+			}
+			seen[kind] = true
+			keys := make([]string, 0, len(v))
+			for key, child := range v {
+				keys = append(keys, key)
+				walk(child)
+			}
+			sort.Strings(keys)
+			if got := strings.Join(keys, " "); got != expected[kind] {
+				t.Errorf("%s: expected fields %q, got %q", kind, expected[kind], got)
+			}
+		}
+	}
+	walk(doc.Program)
+
+	for kind := range expected {
+		if !seen[kind] {
+			t.Errorf("Expected a %s node in the JSON", kind)
+		}
+	}
+}
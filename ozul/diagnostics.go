@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Render formats a diagnostic the way compilers do: the location, the
+// message, then the offending source line with carets under the code it is
+// about. file is the name shown in the location; source is the program text.
+func (e PokemonError) Render(file string, source string) string {
+	return e.RenderIn(file, source, ColumnRunes)
+}
+
+// RenderIn is Render for a diagnostic whose columns were counted in unit.
+// The location shows them as they are; the carets still line up.
+func (e PokemonError) RenderIn(file string, source string, unit ColumnUnit) string {
+	var sb strings.Builder
+
+	kind := e.Kind
+	if kind == "" {
+		kind = "error"
+	} else {
+		kind += " error"
+	}
+
+	if e.Line <= 0 {
+		sb.WriteString(fmt.Sprintf("%s: %s: %s\n", file, kind, e.Message))
+		return sb.String()
+	}
+	sb.WriteString(fmt.Sprintf("%s:%d:%d: %s: %s\n", file, e.Line, e.Column, kind, e.Message))
+
+	lines := strings.Split(source, "\n")
+	if e.Line > len(lines) {
+		return sb.String()
+	}
+	text := strings.TrimRight(lines[e.Line-1], "\r")
+	if unit == ColumnUTF16 {
+		e.Column = runeColumn(text, e.Column)
+		if e.EndLine == e.Line {
+			e.EndColumn = runeColumn(text, e.EndColumn)
+		}
+	}
+
+	gutter := fmt.Sprintf("%d", e.Line)
+	pad := strings.Repeat(" ", len(gutter))
+	sb.WriteString(fmt.Sprintf(" %s | %s\n", gutter, text))
+	sb.WriteString(fmt.Sprintf(" %s | %s%s\n", pad, caretPadding(text, e.Column), strings.Repeat("^", e.caretWidth(text))))
+	return sb.String()
+}
+
+// sortErrors puts diagnostics in the order of the code they are about, so
+// the lexer's and the parser's read as one list
+func sortErrors(errs []PokemonError) {
+	sort.SliceStable(errs, func(i, j int) bool {
+		if errs[i].Line != errs[j].Line {
+			return errs[i].Line < errs[j].Line
+		}
+		return errs[i].Column < errs[j].Column
+	})
+}
+
+// caretWidth is how many carets to draw: the whole span when it ends on the
+// same line, otherwise up to the end of the line. Wide characters get two.
+func (e PokemonError) caretWidth(text string) int {
+	runes := []rune(text)
+	end := e.EndColumn
+	if e.EndLine != e.Line {
+		end = len(runes) + 1
+	}
+	width := 0
+	for i := e.Column - 1; i < end-1; i++ {
+		if i >= 0 && i < len(runes) {
+			width += displayWidth(runes[i])
+		} else {
+			width++
+		}
+	}
+	if width > 0 {
+		return width
+	}
+	return 1
+}
+
+// caretPadding lines the carets up under a column, counted in characters,
+// copying tabs from the source line so they line up however wide the
+// terminal draws a tab
+func caretPadding(text string, column int) string {
+	var sb strings.Builder
+	runes := []rune(text)
+	for i := 0; i < column-1; i++ {
+		switch {
+		case i >= len(runes):
+			sb.WriteByte(' ')
+		case runes[i] == '\t':
+			sb.WriteByte('\t')
+		default:
+			sb.WriteString(strings.Repeat(" ", displayWidth(runes[i])))
+		}
+	}
+	return sb.String()
+}
+
+// wideRanges are the main blocks of characters that terminals draw two
+// cells wide: Hangul, CJK, full-width forms and emoji
+var wideRanges = [][2]rune{
+	{0x1100, 0x115F}, {0x2E80, 0x303E}, {0x3041, 0x33FF}, {0x3400, 0x4DBF},
+	{0x4E00, 0x9FFF}, {0xA000, 0xA4CF}, {0xAC00, 0xD7A3}, {0xF900, 0xFAFF},
+	{0xFE30, 0xFE4F}, {0xFF00, 0xFF60}, {0xFFE0, 0xFFE6}, {0x1F300, 0x1F64F},
+	{0x1F900, 0x1F9FF}, {0x20000, 0x3FFFD},
+}
+
+// displayWidth is how many terminal cells a character takes up: none for a
+// combining mark, which sits on the character before it, two for a wide one
+func displayWidth(r rune) int {
+	if unicode.In(r, unicode.Mn, unicode.Me) {
+		return 0
+	}
+	for _, wide := range wideRanges {
+		if r >= wide[0] && r <= wide[1] {
+			return 2
+		}
+	}
+	return 1
+}
+
+// runeColumn turns a column on a line counted in UTF-16 units into one
+// counted in characters
+func runeColumn(text string, column int) int {
+	units := 1
+	for i, r := range []rune(text) {
+		if units >= column {
+			return i + 1
+		}
+		units++
+		if r > 0xFFFF {
+			units++
+		}
+	}
+	return column - units + len([]rune(text)) + 1
+}
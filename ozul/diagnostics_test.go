@@ -0,0 +1,66 @@
+package main
+
+import "testing"
+
+func TestRender(t *testing.T) {
+	source := "Pikachu x is 1\nrelease x + \"a\" * 2\n"
+	err := NewPokemonError("type", Span{Position{2, 13}, Position{2, 20}}, "operator * needs Pikachu or Psyduck values")
+
+	expected := "battle.ozul:2:13: type error: operator * needs Pikachu or Psyduck values\n" +
+		" 2 | release x + \"a\" * 2\n" +
+		"   |             ^^^^^^^\n"
+	if got := err.Render("battle.ozul", source); got != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, got)
+	}
+}
+
+func TestRender_KeepsTabsAligned(t *testing.T) {
+	source := "if true then\n\trelease missingno\nend"
+	err := NewPokemonError("type", Span{Position{2, 10}, Position{2, 19}}, "undefined variable missingno")
+
+	expected := "a.ozul:2:10: type error: undefined variable missingno\n" +
+		" 2 | \trelease missingno\n" +
+		"   | \t        ^^^^^^^^^\n"
+	if got := err.Render("a.ozul", source); got != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, got)
+	}
+}
+
+func TestRender_MultiLineSpan(t *testing.T) {
+	source := "move heal() gives Pikachu\nend"
+	err := NewPokemonError("type", Span{Position{1, 1}, Position{2, 4}}, "move heal may end without giving back a Pikachu")
+
+	expected := "a.ozul:1:1: type error: move heal may end without giving back a Pikachu\n" +
+		" 1 | move heal() gives Pikachu\n" +
+		"   | ^^^^^^^^^^^^^^^^^^^^^^^^^\n"
+	if got := err.Render("a.ozul", source); got != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, got)
+	}
+}
+
+func TestRender_WithoutPosition(t *testing.T) {
+	err := PokemonError{Kind: "runtime", Message: "Execution step limit of 10 exceeded"}
+	expected := "a.ozul: runtime error: Execution step limit of 10 exceeded\n"
+	if got := err.Render("a.ozul", ""); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestRender_WideCharacters(t *testing.T) {
+	source := "Eevee 名前 is \"🔥\" * 2\n"
+	err := NewPokemonError("type", Span{Position{1, 13}, Position{1, 20}}, "operator * needs Pikachu or Psyduck values")
+
+	// 名 and 前 take two cells each in a terminal, so the carets shift right
+	expected := "a.ozul:1:13: type error: operator * needs Pikachu or Psyduck values\n" +
+		" 1 | Eevee 名前 is \"🔥\" * 2\n" +
+		"   |               ^^^^^^^^\n"
+	if got := err.Render("a.ozul", source); got != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, got)
+	}
+
+	// The same span with columns counted in UTF-16 units
+	err = NewPokemonError("type", Span{Position{1, 13}, Position{1, 21}}, "operator * needs Pikachu or Psyduck values")
+	if got := err.RenderIn("a.ozul", source, ColumnUTF16); got != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, got)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// differentialInput is the stdin for programs without a .stdin file, so
+// samples that catch a number have one to read
+const differentialInput = "42\n"
+
+// differentialPrograms lists the sample programs next to the source and the
+// corpus in testdata/differential
+func differentialPrograms(t *testing.T) []string {
+	t.Helper()
+	samples, err := filepath.Glob("*.ozul")
+	if err != nil {
+		t.Fatal(err)
+	}
+	corpus, err := filepath.Glob(filepath.Join("testdata", "differential", "*.ozul"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(corpus) == 0 {
+		t.Fatal("no programs in testdata/differential")
+	}
+	return append(samples, corpus...)
+}
+
+// programInput reads the stdin for a program from the .stdin file next to
+// it, if there is one
+func programInput(t *testing.T, path string) string {
+	t.Helper()
+	input, err := os.ReadFile(strings.TrimSuffix(path, ".ozul") + ".stdin")
+	if os.IsNotExist(err) {
+		return differentialInput
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(input)
+}
+
+// parseChecked lexes, parses and type checks a program, failing the test on
+// any error, so a broken program can't pass by failing the same way twice
+func parseChecked(t *testing.T, source string) *Program {
+	t.Helper()
+	lexer := NewLexer(source)
+	parser := NewParser(lexer.Tokenize())
+	program := parser.Parse()
+	errs := append(lexer.Errors(), parser.Errors()...)
+	if len(errs) == 0 {
+		errs = NewChecker().Check(program)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("Expected a valid program, got: %v", errs)
+	}
+	return program
+}
+
+// compileC generates C for a program and compiles it with cc, returning the
+// path of the executable. -fwrapv makes Pikachu overflow wrap around, as it
+// does in the other backends.
+func compileC(t *testing.T, cc string, program *Program) string {
+	t.Helper()
+	cg := NewCodeGen()
+	cg.GenerateProgram(program)
+
+	dir := t.TempDir()
+	source, binary := filepath.Join(dir, "program.c"), filepath.Join(dir, "program")
+	if err := os.WriteFile(source, []byte(cg.GetCode()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command(cc, "-std=c99", "-fwrapv", "-o", binary, source, "-lm").CombinedOutput(); err != nil {
+		t.Fatalf("%s failed: %v\n%s\n%s", cc, err, out, cg.GetCode())
+	}
+	return binary
+}
+
+// TestDifferential_InterpreterMatchesC runs every sample and corpus program
+// through the interpreter and through the C backend, compiled with the C
+// compiler in $CC (or cc), and expects the same stdout from both. A program
+// that fails at runtime must fail in C too, with the same error on stderr and
+// exit code 1.
+func TestDifferential_InterpreterMatchesC(t *testing.T) {
+	for _, path := range differentialPrograms(t) {
+		path := path
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			source, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			input := programInput(t, path)
+			program := parseChecked(t, string(source))
+			expected, runErr := runInterpreterWithOutput(program, input)
+
+			output, err := runC(t, program, input)
+			if output != expected {
+				t.Errorf("Output differs.\nInterpreter:\n%s\nC:\n%s", expected, output)
+			}
+			expectSameFailure(t, runErr, err)
+		})
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// formatIndent is the indentation of one block level in formatted code
+const formatIndent = "    "
+
+// Format prints a program in the standard layout: one statement per line,
+// blocks indented by four spaces and single spaces around operators. A blank
+// line between two statements is kept, but runs of them become one. The
+// comments kept on the tokens are put back: on their own lines where they
+// were on their own lines, and at the end of a line where they ended one. The
+// program must have parsed from the tokens without errors.
+func Format(program *Program, tokens []Token) string {
+	f := &formatter{fresh: true}
+	for _, tok := range tokens {
+		f.comments = append(f.comments, tok.Leading...)
+	}
+	f.block(program.Statements, 0)
+	f.commentsBefore(0, math.MaxInt)
+	return f.sb.String()
+}
+
+type formatter struct {
+	sb       strings.Builder
+	comments []Comment
+	next     int  // the first comment not printed yet
+	last     int  // the source line that the last printed line ended on
+	fresh    bool // nothing has been printed in the current block yet
+}
+
+// gap prints a blank line when the source skipped lines before line
+func (f *formatter) gap(line int) {
+	if !f.fresh && line > f.last+1 {
+		f.sb.WriteString("\n")
+	}
+}
+
+// commentsBefore prints the comments that come before a source line, each
+// on a line of its own
+func (f *formatter) commentsBefore(depth, line int) {
+	for f.next < len(f.comments) && f.comments[f.next].Start.Line < line {
+		comment := f.comments[f.next]
+		f.gap(comment.Start.Line)
+		f.sb.WriteString(strings.Repeat(formatIndent, depth) + comment.Text + "\n")
+		f.last, f.fresh = comment.End.Line, false
+		f.next++
+	}
+}
+
+// line prints code that came from source lines start to end, after the
+// comments above it and followed by the comments that shared its lines
+func (f *formatter) line(depth int, text string, start, end int) {
+	f.commentsBefore(depth, start)
+	f.gap(start)
+	f.sb.WriteString(strings.Repeat(formatIndent, depth) + text)
+	f.last, f.fresh = end, false
+	for f.next < len(f.comments) && f.comments[f.next].Start.Line <= end {
+		comment := f.comments[f.next]
+		f.sb.WriteString(" " + comment.Text)
+		f.last = comment.End.Line
+		f.next++
+	}
+	f.sb.WriteString("\n")
+}
+
+// closer prints a line that closes a block, like "end" or "else", after
+// the comments at the end of the block. It never has a blank line before it.
+func (f *formatter) closer(depth int, text string, line int) {
+	f.commentsBefore(depth+1, line)
+	f.fresh = true
+	f.line(depth, text, line, line)
+}
+
+func (f *formatter) block(stmts []Statement, depth int) {
+	f.fresh = true
+	for _, stmt := range stmts {
+		f.statement(stmt, depth)
+	}
+}
+
+func (f *formatter) statement(stmt Statement, depth int) {
+	span := stmt.Pos()
+	start, end := span.Start.Line, span.End.Line
+	switch s := stmt.(type) {
+	case *DeclarationStmt:
+		f.line(depth, fmt.Sprintf("%s %s is %s", s.PokemonType, s.Name, formatExpr(s.Value)), start, end)
+	case *AssignmentStmt:
+		f.line(depth, fmt.Sprintf("%s evolves to %s", s.Name, formatExpr(s.Value)), start, end)
+	case *ReleaseStmt:
+		f.line(depth, "release "+formatExpr(s.Value), start, end)
+	case *CatchStmt:
+		f.line(depth, s.String(), start, end)
+	case *IfStmt:
+		f.line(depth, "if "+formatExpr(s.Condition)+" then", start, start)
+		f.ifRest(s, depth)
+	case *WhileStmt:
+		f.line(depth, "train while "+formatExpr(s.Condition), start, start)
+		f.block(s.Body, depth+1)
+		f.closer(depth, "end", end)
+	case *RepeatStmt:
+		f.line(depth, "repeat "+formatExpr(s.Count)+" times", start, start)
+		f.block(s.Body, depth+1)
+		f.closer(depth, "end", end)
+	case *FunctionDecl:
+		params := make([]string, len(s.Params))
+		for i, param := range s.Params {
+			params[i] = param.PokemonType + " " + param.Name
+		}
+		header := fmt.Sprintf("move %s(%s)", s.Name, strings.Join(params, ", "))
+		if s.ReturnType != "" {
+			header += " gives " + s.ReturnType
+		}
+		f.line(depth, header, start, start)
+		f.block(s.Body, depth+1)
+		f.closer(depth, "end", end)
+	case *ReturnStmt:
+		if s.Value == nil {
+			f.line(depth, "return", start, end)
+		} else {
+			f.line(depth, "return "+formatExpr(s.Value), start, end)
+		}
+	case *ExpressionStmt:
+		f.line(depth, formatExpr(s.Expr), start, end)
+	default:
+		panic(fmt.Sprintf("[OZUL Format Error] Cannot format %T", stmt))
+	}
+}
+
+// ifRest prints the branches of an if after its first line. An else holding
+// only another if is written as "else if", sharing the closing 'end'.
+func (f *formatter) ifRest(s *IfStmt, depth int) {
+	f.block(s.Then, depth+1)
+	if len(s.Else) == 1 {
+		if nested, ok := s.Else[0].(*IfStmt); ok {
+			f.commentsBefore(depth+1, s.ElseLine)
+			f.fresh = true
+			f.line(depth, "else if "+formatExpr(nested.Condition)+" then", s.ElseLine, nested.Pos().Start.Line)
+			f.ifRest(nested, depth)
+			// An if nested in a plain else had an 'end' of its own, which the
+			// chain no longer needs
+			f.last = s.Pos().End.Line
+			return
+		}
+	}
+	if s.Else != nil {
+		f.closer(depth, "else", s.ElseLine)
+		f.block(s.Else, depth+1)
+	}
+	f.closer(depth, "end", s.Pos().End.Line)
+}
+
+// formatExpr prints an expression as source, with parentheses where
+// the tree would not read back the same without them
+func formatExpr(expr Expression) string {
+	return formatOperand(expr, precLowest)
+}
+
+// formatOperand prints an expression that sits where only operators binding
+// tighter than precedence could go without parentheses
+func formatOperand(expr Expression, precedence int) string {
+	own := exprPrecedence(expr)
+	text := formatBare(expr)
+	if own <= precedence {
+		return "(" + text + ")"
+	}
+	return text
+}
+
+// exprPrecedence is how tightly an expression's outermost operator binds
+func exprPrecedence(expr Expression) int {
+	switch e := expr.(type) {
+	case *BinaryExpr:
+		for _, op := range infixOperators {
+			if op.text == e.Operator {
+				return op.precedence
+			}
+		}
+	case *UnaryExpr:
+		if e.Operator == "not" {
+			return precNot
+		}
+		return precPrefix
+	}
+	return precPower + 1 // literals, names and calls never need parentheses
+}
+
+func formatBare(expr Expression) string {
+	switch e := expr.(type) {
+	case *BinaryExpr:
+		// Operators group to the left, so an operand of the same level needs
+		// parentheses on the right; "**" groups to the right instead
+		own := exprPrecedence(e)
+		left, right := own, own
+		if e.Operator == "**" {
+			left++
+		} else {
+			right++
+		}
+		return formatOperand(e.Left, left-1) + " " + e.Operator + " " + formatOperand(e.Right, right-1)
+	case *UnaryExpr:
+		operand := formatOperand(e.Operand, exprPrecedence(e)-1)
+		if e.Operator == "-" {
+			if strings.HasPrefix(operand, "-") {
+				return "- " + operand // "--" would not be two minuses
+			}
+			return "-" + operand
+		}
+		return e.Operator + " " + operand
+	case *NumberLiteral:
+		return strconv.Itoa(e.Value)
+	case *FloatLiteral:
+		// Keep the decimal point, or the number would read back as a Pikachu
+		text := strconv.FormatFloat(e.Value, 'f', -1, 64)
+		if !strings.Contains(text, ".") {
+			text += ".0"
+		}
+		return text
+	case *StringLiteral:
+		if e.Raw {
+			return `"""` + e.Value + `"""`
+		}
+		return `"` + escapeString(e.Value) + `"`
+	case *InterpolatedString:
+		var sb strings.Builder
+		sb.WriteByte('"')
+		for _, part := range e.Parts {
+			if text, ok := part.(*StringLiteral); ok {
+				sb.WriteString(escapeString(text.Value))
+			} else {
+				sb.WriteString("{" + formatExpr(part) + "}")
+			}
+		}
+		sb.WriteByte('"')
+		return sb.String()
+	case *BooleanLiteral:
+		return strconv.FormatBool(e.Value)
+	case *CallExpr:
+		args := make([]string, len(e.Args))
+		for i, arg := range e.Args {
+			args[i] = formatExpr(arg)
+		}
+		return e.Name + "(" + strings.Join(args, ", ") + ")"
+	case *Identifier:
+		return e.Name
+	}
+	panic(fmt.Sprintf("[OZUL Format Error] Cannot format %T", expr))
+}
+
+// escapeString writes text as it goes between the quotes of a string, with
+// the escape sequences the lexer reads back
+func escapeString(text string) string {
+	var sb strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '"' || r == '\\' || r == '{':
+			sb.WriteRune('\\')
+			sb.WriteRune(r)
+		case r == '\n':
+			sb.WriteString(`\n`)
+		case r == '\t':
+			sb.WriteString(`\t`)
+		case r == '\r':
+			sb.WriteString(`\r`)
+		case unicode.IsControl(r):
+			fmt.Fprintf(&sb, `\u{%X}`, r)
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
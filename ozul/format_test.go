@@ -0,0 +1,195 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func formatSource(t *testing.T, source string) string {
+	t.Helper()
+	tokens := NewLexer(source).Tokenize()
+	parser := NewParser(tokens)
+	program := parser.Parse()
+	if len(parser.Errors()) > 0 {
+		t.Fatalf("Unexpected parse errors: %v", parser.Errors())
+	}
+	return Format(program, tokens)
+}
+
+func TestFormat_Layout(t *testing.T) {
+	got := formatSource(t, `move  greet(Eevee who,Pikachu times_)
+release "hi "+who
+return
+end
+
+
+Psyduck x is 2.
+if not x<1 or x>=2.50 then
+train while false
+greet("Ash",1)
+end
+else
+if x == 0.0 then
+release x*2
+end
+end
+repeat 2 times
+catch x from trainer
+end`)
+	expected := `move greet(Eevee who, Pikachu times_)
+    release "hi " + who
+    return
+end
+
+Psyduck x is 2.0
+if not x < 1 or x >= 2.5 then
+    train while false
+        greet("Ash", 1)
+    end
+else if x == 0.0 then
+    release x * 2
+end
+repeat 2 times
+    catch x from trainer
+end
+`
+	if got != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, got)
+	}
+}
+
+func TestFormat_Strings(t *testing.T) {
+	// Escapes are written back the way the lexer reads them; raw strings stay
+	// as they were
+	got := formatSource(t, `release "tab\t \"q\" \\ \{ \u{1F525} \u{7}"
+release "HP: {hp+1}, {"x"+"{y}"}"
+release """raw {hp} \n
+line"""`)
+	expected := `release "tab\t \"q\" \\ \{ 🔥 \u{7}"
+release "HP: {hp + 1}, {"x" + "{y}"}"
+release """raw {hp} \n
+line"""
+`
+	if got != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, got)
+	}
+}
+
+func TestFormat_Parentheses(t *testing.T) {
+	// Only the parentheses the tree needs are kept
+	got := formatSource(t, `release (a+b)*c
+release a-(b-c)
+release (a-b)-c
+release ((a))*(b)
+release 2**3**2
+release (2**3)**2
+release -x**2
+release (-x)**2
+release -(x*y)
+release - -x
+release a - -b
+release not (a and b)
+release (not a) == b
+release -(-(x))`)
+	expected := `release (a + b) * c
+release a - (b - c)
+release a - b - c
+release a * b
+release 2 ** 3 ** 2
+release (2 ** 3) ** 2
+release -x ** 2
+release (-x) ** 2
+release -(x * y)
+release - -x
+release a - -b
+release not (a and b)
+release (not a) == b
+release - -x
+`
+	if got != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, got)
+	}
+}
+
+// TestFormat_RoundTrip formats every sample and corpus program and expects
+// the result to parse into the same tree, and to format to itself again
+func TestFormat_RoundTrip(t *testing.T) {
+	corpus, err := filepath.Glob(filepath.Join("testdata", "*", "*.ozul"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range append(differentialPrograms(t), corpus...) {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lexer := NewLexer(string(source))
+		tokens := lexer.Tokenize()
+		parser := NewParser(tokens)
+		program := parser.Parse()
+		if len(lexer.Errors()) > 0 || len(parser.Errors()) > 0 {
+			continue // the CLI tests have programs with syntax errors on purpose
+		}
+		formatted := Format(program, tokens)
+		again := formatSource(t, formatted)
+		if again != formatted {
+			t.Errorf("%s: formatting is not stable.\nFirst:\n%s\nSecond:\n%s", path, formatted, again)
+		}
+		reparsed := NewParser(NewLexer(formatted).Tokenize()).Parse()
+		if len(reparsed.Statements) != len(program.Statements) {
+			t.Errorf("%s: expected %d statements after formatting, got %d", path, len(program.Statements), len(reparsed.Statements))
+			continue
+		}
+		for i, stmt := range program.Statements {
+			if stmt.String() != reparsed.Statements[i].String() {
+				t.Errorf("%s: statement %d changed from %s to %s", path, i+1, stmt, reparsed.Statements[i])
+			}
+		}
+	}
+}
+
+func TestFormat_Comments(t *testing.T) {
+	got := formatSource(t, `# Battle
+#[ two
+lines ]#
+
+
+## Base power.
+Pikachu power is 10   # trailing
+move attack(Pikachu p) gives Pikachu # header
+   # first
+   return p*2
+   # last
+
+end
+if power>5 then
+release "big"
+   # before else
+else   # else
+  release "small"
+end
+# the end`)
+	expected := `# Battle
+#[ two
+lines ]#
+
+## Base power.
+Pikachu power is 10 # trailing
+move attack(Pikachu p) gives Pikachu # header
+    # first
+    return p * 2
+    # last
+end
+if power > 5 then
+    release "big"
+    # before else
+else # else
+    release "small"
+end
+# the end
+`
+	if got != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, got)
+	}
+}
@@ -1,7 +1,3 @@
 module ozul
 
 go 1.21
- 
-require (
-	tinygo.org/x/go-llvm v0.0.0-20230519182509-4b3e117aa94c
-) 
\ No newline at end of file
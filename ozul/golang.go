@@ -0,0 +1,791 @@
+package main
+
+import (
+	"fmt"
+	"go/format"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// GoGen translates a program into Go source, so students can read their
+// OZUL program as the Go it stands for. Pikachu, Psyduck, Eevee and Voltorb
+// become int, float64, string and bool, variables are declared with :=,
+// moves become functions and catch reads lines with a bufio.Reader.
+//
+// The generated program behaves like the interpreter: integer division
+// truncates, overflow wraps, dividing by zero stops with OZUL's error and
+// floats print the same way. Like compiled C it has no step limit.
+type GoGen struct {
+	// Symbol table for variables, one scope per Go block
+	variables *Scope[*goVar]
+
+	// Variables declared so far, to find the ones that are never read
+	declared []*goVar
+
+	// Generated main and move functions, without the package clause
+	code []string
+
+	// Current indentation depth
+	indent int
+
+	// Go names of the moves
+	moves map[string]string
+
+	// Go names taken in the current function, so loop counters get names
+	// of their own
+	taken map[string]bool
+
+	// Packages and runtime helpers the generated code uses
+	imports map[string]bool
+	helpers map[string]bool
+
+	// Pokemon type the current move gives, "" for none
+	returnType string
+
+	// Type checker whose inferred types drive conversions and formatting
+	checker *Checker
+}
+
+// goVar is a variable's name in the generated code and its Pokemon type.
+// Go rejects variables that are never read, so reads are tracked.
+type goVar struct {
+	name        string
+	pokemonType string
+	line        int // index of the declaring line in code
+	used        bool
+}
+
+// goExpr is a generated expression with the precedence of its outermost
+// operator. An untyped expression is built only from literals, so Go
+// evaluates it as an exact constant.
+type goExpr struct {
+	code    string
+	prec    int
+	untyped bool
+}
+
+// Precedence of Go operators; operands bind tighter than any of them
+const (
+	goPrecOr = iota + 1
+	goPrecAnd
+	goPrecCompare
+	goPrecAdd
+	goPrecMul
+	goPrecUnary
+	goPrecOperand
+)
+
+var goPrecedence = map[string]int{
+	"or": goPrecOr, "and": goPrecAnd,
+	"==": goPrecCompare, "!=": goPrecCompare, "<": goPrecCompare, "<=": goPrecCompare, ">": goPrecCompare, ">=": goPrecCompare,
+	"+": goPrecAdd, "-": goPrecAdd,
+	"*": goPrecMul, "/": goPrecMul, "%": goPrecMul,
+}
+
+// goReserved are Go keywords, predeclared names and the names the generated
+// code uses itself, which OZUL names must not hide
+var goReserved = map[string]bool{
+	"break": true, "case": true, "chan": true, "const": true, "continue": true, "default": true, "defer": true,
+	"else": true, "fallthrough": true, "for": true, "func": true, "go": true, "goto": true, "if": true,
+	"import": true, "interface": true, "map": true, "package": true, "range": true, "return": true,
+	"select": true, "struct": true, "switch": true, "type": true, "var": true,
+	"any": true, "bool": true, "byte": true, "comparable": true, "complex64": true, "complex128": true,
+	"error": true, "float32": true, "float64": true, "int": true, "int8": true, "int16": true, "int32": true,
+	"int64": true, "rune": true, "string": true, "uint": true, "uint8": true, "uint16": true, "uint32": true,
+	"uint64": true, "uintptr": true, "true": true, "false": true, "iota": true, "nil": true,
+	"append": true, "cap": true, "clear": true, "close": true, "complex": true, "copy": true, "delete": true,
+	"imag": true, "len": true, "make": true, "max": true, "min": true, "new": true, "panic": true,
+	"print": true, "println": true, "real": true, "recover": true,
+	"main": true, "init": true, "bufio": true, "fmt": true, "os": true, "strconv": true, "strings": true,
+	"trainer": true, "fail": true, "divide": true, "catchInt": true, "catchFloat": true, "catchString": true,
+	"math": true, "remainder": true, "floatRemainder": true, "power": true,
+}
+
+// NewGoGen creates a new Go generator
+func NewGoGen() *GoGen {
+	return &GoGen{
+		variables: NewScope[*goVar](nil),
+		moves:     make(map[string]string),
+		imports:   make(map[string]bool),
+		helpers:   make(map[string]bool),
+		checker:   NewChecker(),
+	}
+}
+
+// GenerateProgram generates the Go source for the entire program. The
+// program is type checked first; type errors are reported as a panic.
+func (gg *GoGen) GenerateProgram(program *Program) {
+	if errs := gg.checker.Check(program); len(errs) > 0 {
+		messages := make([]string, len(errs))
+		for i, err := range errs {
+			messages[i] = err.Error()
+		}
+		panic("[OZUL Go Error] " + strings.Join(messages, "; "))
+	}
+
+	var moves []*FunctionDecl
+	var statements []Statement
+	for _, stmt := range program.Statements {
+		if fn, ok := stmt.(*FunctionDecl); ok {
+			moves = append(moves, fn)
+		} else {
+			statements = append(statements, stmt)
+		}
+	}
+	for _, fn := range moves {
+		name := goIdent(fn.Name)
+		for goReserved[name] {
+			name += "_"
+		}
+		gg.moves[fn.Name] = name
+	}
+
+	// The top level becomes main, followed by one function per move
+	gg.taken = gg.names(nil, statements)
+	gg.emit("func main() {")
+	gg.generateBlock(statements)
+	gg.emit("}")
+	for _, fn := range moves {
+		gg.generateFunction(fn)
+	}
+	gg.useDeclared()
+}
+
+// emit appends a line of code at the current indentation depth
+func (gg *GoGen) emit(format string, args ...interface{}) {
+	gg.code = append(gg.code, strings.Repeat("\t", gg.indent)+fmt.Sprintf(format, args...))
+}
+
+// goIdent spells an OZUL name as a Go identifier. Go names hold only
+// letters, digits and _, so the combining marks OZUL also allows, like the
+// U+0308 of a decomposed "ï", become _308_.
+func goIdent(name string) string {
+	var sb strings.Builder
+	for _, r := range name {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			sb.WriteRune(r)
+		} else {
+			fmt.Fprintf(&sb, "_%x_", r)
+		}
+	}
+	return sb.String()
+}
+
+// goName is the Go name of a variable, moved aside when it is reserved or
+// would hide a move
+func (gg *GoGen) goName(name string) string {
+	name = goIdent(name)
+	taken := func(name string) bool {
+		if goReserved[name] {
+			return true
+		}
+		for _, move := range gg.moves {
+			if move == name {
+				return true
+			}
+		}
+		return false
+	}
+	for taken(name) {
+		name += "_"
+	}
+	return name
+}
+
+// names collects the Go names of a function's parameters and of every
+// variable declared in its body
+func (gg *GoGen) names(params []Param, stmts []Statement) map[string]bool {
+	names := make(map[string]bool)
+	for _, param := range params {
+		names[gg.goName(param.Name)] = true
+	}
+	var walk func(stmts []Statement)
+	walk = func(stmts []Statement) {
+		for _, stmt := range stmts {
+			switch s := stmt.(type) {
+			case *DeclarationStmt:
+				names[gg.goName(s.Name)] = true
+			case *CatchStmt:
+				names[gg.goName(s.Variable)] = true
+			case *IfStmt:
+				walk(s.Then)
+				walk(s.Else)
+			case *WhileStmt:
+				walk(s.Body)
+			case *RepeatStmt:
+				walk(s.Body)
+			}
+		}
+	}
+	walk(stmts)
+	return names
+}
+
+// fresh returns the first of the candidates, or of base2, base3 and so on,
+// that is not yet taken in the current function, and takes it
+func (gg *GoGen) fresh(base string, candidates ...string) string {
+	for n := 2; ; n++ {
+		candidates = append(candidates, fmt.Sprintf("%s%d", base, n))
+		for _, name := range candidates {
+			if !gg.taken[name] && !goReserved[name] {
+				gg.taken[name] = true
+				return name
+			}
+		}
+	}
+}
+
+// declare gives a variable a Go name in the current scope, declared on the
+// line about to be emitted
+func (gg *GoGen) declare(name, pokemonType string) *goVar {
+	v := &goVar{name: gg.goName(name), pokemonType: pokemonType, line: len(gg.code)}
+	if err := gg.variables.Declare(name, v); err != nil {
+		panic(fmt.Sprintf("[OZUL Go Error] Variable %s already declared in this scope!", name))
+	}
+	gg.declared = append(gg.declared, v)
+	return v
+}
+
+func (gg *GoGen) lookup(name string) *goVar {
+	v, ok := gg.variables.Lookup(name)
+	if !ok {
+		panic(fmt.Sprintf("[OZUL Go Error] Variable %s not declared!", name))
+	}
+	return v
+}
+
+// useDeclared follows each variable that is never read with `_ = name`,
+// since Go does not compile programs with unused variables
+func (gg *GoGen) useDeclared() {
+	unused := make(map[int]*goVar)
+	for _, v := range gg.declared {
+		if !v.used {
+			unused[v.line] = v
+		}
+	}
+	code := make([]string, 0, len(gg.code)+len(unused))
+	for i, line := range gg.code {
+		code = append(code, line)
+		if v, ok := unused[i]; ok {
+			indent := line[:len(line)-len(strings.TrimLeft(line, "\t"))]
+			code = append(code, indent+"_ = "+v.name)
+		}
+	}
+	gg.code = code
+}
+
+// generateFunction generates a function for a move, with its own variables
+// so it cannot see main's
+func (gg *GoGen) generateFunction(fn *FunctionDecl) {
+	outer, outerTaken := gg.variables, gg.taken
+	gg.variables, gg.taken = NewScope[*goVar](nil), gg.names(fn.Params, fn.Body)
+	gg.returnType = fn.ReturnType
+
+	params := make([]string, len(fn.Params))
+	for i, param := range fn.Params {
+		// Parameters may go unused, so they need no tracking
+		v := &goVar{name: gg.goName(param.Name), pokemonType: param.PokemonType, used: true}
+		if err := gg.variables.Declare(param.Name, v); err != nil {
+			panic(fmt.Sprintf("[OZUL Go Error] Parameter %s already declared!", param.Name))
+		}
+		params[i] = fmt.Sprintf("%s %s", v.name, goTypes[param.PokemonType])
+	}
+	result := ""
+	if fn.ReturnType != "" {
+		result = " " + goTypes[fn.ReturnType]
+	}
+
+	gg.code = append(gg.code, "")
+	gg.emit("func %s(%s)%s {", gg.moves[fn.Name], strings.Join(params, ", "), result)
+	gg.generateBlock(fn.Body)
+	gg.emit("}")
+
+	gg.variables, gg.taken = outer, outerTaken
+	gg.returnType = ""
+}
+
+// goTypes maps Pokemon types to Go types
+var goTypes = map[string]string{
+	"Pikachu": "int",
+	"Psyduck": "float64",
+	"Eevee":   "string",
+	"Voltorb": "bool",
+}
+
+// generateStatement generates code for a single statement
+func (gg *GoGen) generateStatement(stmt Statement) {
+	switch s := stmt.(type) {
+	case *DeclarationStmt:
+		value := gg.convert(s.Value, s.PokemonType).code
+		if _, ok := s.Value.(*NumberLiteral); ok && s.PokemonType == "Psyduck" {
+			value += ".0" // := would make a whole number an int
+		}
+		gg.emit("%s := %s", gg.declare(s.Name, s.PokemonType).name, value)
+	case *AssignmentStmt:
+		v := gg.lookup(s.Name)
+		gg.emit("%s = %s", v.name, gg.convert(s.Value, v.pokemonType).code)
+	case *ReleaseStmt:
+		gg.imports["fmt"] = true
+		if format, args, ok := gg.concatenation(s.Value); ok {
+			gg.emit("fmt.Printf(%s)", strings.Join(append([]string{strconv.Quote(format + "\n")}, args...), ", "))
+		} else {
+			gg.emit("fmt.Println(%s)", gg.generateExpression(s.Value).code)
+		}
+	case *CatchStmt:
+		gg.generateCatch(s)
+	case *IfStmt:
+		gg.generateIf(s, "if")
+		gg.emit("}")
+	case *WhileStmt:
+		if literal, ok := s.Condition.(*BooleanLiteral); ok && literal.Value {
+			gg.emit("for {")
+		} else {
+			gg.emit("for %s {", gg.generateExpression(s.Condition).code)
+		}
+		gg.generateBlock(s.Body)
+		gg.emit("}")
+	case *RepeatStmt:
+		gg.generateRepeat(s)
+	case *ReturnStmt:
+		if s.Value == nil {
+			gg.emit("return")
+		} else {
+			gg.emit("return %s", gg.convert(s.Value, gg.returnType).code)
+		}
+	case *ExpressionStmt:
+		gg.emit("%s", gg.generateExpression(s.Expr).code)
+	}
+}
+
+// generateIf generates an if statement, writing an "else if" chain the way
+// it was written in OZUL. The caller closes the last block.
+func (gg *GoGen) generateIf(stmt *IfStmt, keyword string) {
+	gg.emit("%s %s {", keyword, gg.generateExpression(stmt.Condition).code)
+	gg.generateBlock(stmt.Then)
+	if len(stmt.Else) == 1 {
+		if elseIf, ok := stmt.Else[0].(*IfStmt); ok {
+			gg.generateIf(elseIf, "} else if")
+			return
+		}
+	}
+	if len(stmt.Else) > 0 {
+		gg.emit("} else {")
+		gg.generateBlock(stmt.Else)
+	}
+}
+
+// generateRepeat generates a counting loop. OZUL evaluates the count once,
+// so a count other than a constant or a variable the body leaves alone is
+// copied first.
+func (gg *GoGen) generateRepeat(stmt *RepeatStmt) {
+	index := gg.fresh("i", "i", "j", "k")
+	count := gg.generateExpression(stmt.Count)
+	_, variable := stmt.Count.(*Identifier)
+	if count.untyped || variable && !assignsAny(stmt.Body) {
+		gg.emit("for %s := 0; %s < %s; %s++ {", index, index, count.code, index)
+	} else {
+		n := gg.fresh("n", "n")
+		gg.emit("for %s, %s := 0, %s; %s < %s; %s++ {", index, n, count.code, index, n, index)
+		defer delete(gg.taken, n)
+	}
+	gg.generateBlock(stmt.Body)
+	gg.emit("}")
+	delete(gg.taken, index)
+}
+
+// assignsAny reports whether statements may change a variable that is
+// already declared, by assignment or by catch
+func assignsAny(stmts []Statement) bool {
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case *AssignmentStmt, *CatchStmt:
+			return true
+		case *IfStmt:
+			if assignsAny(s.Then) || assignsAny(s.Else) {
+				return true
+			}
+		case *WhileStmt:
+			if assignsAny(s.Body) {
+				return true
+			}
+		case *RepeatStmt:
+			if assignsAny(s.Body) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// generateCatch reads a line from the trainer. A typed catch declares a new
+// variable; an untyped one reads into a visible variable, or else declares a
+// new Pikachu.
+func (gg *GoGen) generateCatch(stmt *CatchStmt) {
+	v, exists := gg.variables.Lookup(stmt.Variable)
+	declare := stmt.PokemonType != "" || !exists
+	pokemonType := ""
+	if exists {
+		pokemonType = v.pokemonType
+	}
+	if declare {
+		pokemonType = stmt.PokemonType
+		if pokemonType == "" {
+			pokemonType = "Pikachu"
+		}
+	}
+
+	var value string
+	switch pokemonType {
+	case "Pikachu":
+		value = fmt.Sprintf("catchInt(%s, %d)", strconv.Quote(stmt.Variable), stmt.Pos().Start.Line)
+		gg.useHelper("catchInt")
+	case "Psyduck":
+		value = fmt.Sprintf("catchFloat(%s, %d)", strconv.Quote(stmt.Variable), stmt.Pos().Start.Line)
+		gg.useHelper("catchFloat")
+	case "Eevee":
+		value = fmt.Sprintf("catchString(%s)", strconv.Quote(stmt.Variable))
+		gg.useHelper("catchString")
+	default:
+		panic(fmt.Sprintf("[OZUL Go Error] Cannot catch into %s variable %s", pokemonType, stmt.Variable))
+	}
+	if declare {
+		gg.emit("%s := %s", gg.declare(stmt.Variable, pokemonType).name, value)
+	} else {
+		gg.emit("%s = %s", v.name, value)
+	}
+}
+
+// generateBlock generates the statements of a nested block one level deeper,
+// in a new scope that ends with the Go block
+func (gg *GoGen) generateBlock(stmts []Statement) {
+	outer := gg.variables
+	gg.variables = NewScope(outer)
+	gg.indent++
+	for _, stmt := range stmts {
+		gg.generateStatement(stmt)
+	}
+	gg.indent--
+	gg.variables = outer
+}
+
+// generateExpression generates code for expressions
+func (gg *GoGen) generateExpression(expr Expression) goExpr {
+	switch e := expr.(type) {
+	case *NumberLiteral:
+		return goExpr{fmt.Sprintf("%d", e.Value), goPrecOperand, true}
+	case *FloatLiteral:
+		return goExpr{goFloat(e.Value), goPrecOperand, true}
+	case *InterpolatedString:
+		return gg.generateExpression(e.Value)
+	case *StringLiteral:
+		return goExpr{strconv.Quote(e.Value), goPrecOperand, true}
+	case *BooleanLiteral:
+		return goExpr{fmt.Sprintf("%t", e.Value), goPrecOperand, true}
+	case *Identifier:
+		v := gg.lookup(e.Name)
+		v.used = true
+		return goExpr{v.name, goPrecOperand, false}
+	case *CallExpr:
+		fn := gg.checker.functions[e.Name]
+		args := make([]string, len(e.Args))
+		for i, arg := range e.Args {
+			args[i] = gg.convert(arg, fn.Params[i].PokemonType).code
+		}
+		return goExpr{fmt.Sprintf("%s(%s)", gg.moves[fn.Name], strings.Join(args, ", ")), goPrecOperand, false}
+	case *UnaryExpr:
+		if e.Operator == "not" {
+			operand := gg.generateExpression(e.Operand)
+			return goExpr{"!" + operand.wrap(goPrecUnary), goPrecUnary, operand.untyped}
+		}
+		if e.Operator == "-" {
+			operand := gg.generateExpression(e.Operand)
+			code := operand.wrap(goPrecUnary)
+			if strings.HasPrefix(code, "-") {
+				code = "(" + code + ")" // "--" is Go's decrement
+			}
+			return goExpr{"-" + code, goPrecUnary, operand.untyped}
+		}
+		panic(fmt.Sprintf("[OZUL Go Error] Unknown operator: %s", e.Operator))
+	case *BinaryExpr:
+		return gg.generateBinary(e)
+	default:
+		panic("[OZUL Go Error] Unknown expression type.")
+	}
+}
+
+// wrap returns the expression's code, in parentheses unless its operator
+// binds at least as tightly as prec
+func (x goExpr) wrap(prec int) string {
+	if x.prec < prec {
+		return "(" + x.code + ")"
+	}
+	return x.code
+}
+
+// generateBinary generates operators, following the interpreter's rules:
+// any Eevee makes + a concatenation, any Psyduck makes arithmetic and
+// comparisons floating point, and two Pikachus stay integers
+func (gg *GoGen) generateBinary(e *BinaryExpr) goExpr {
+	leftType, rightType := gg.checker.TypeOf(e.Left), gg.checker.TypeOf(e.Right)
+	prec := goPrecedence[e.Operator]
+	op := map[string]string{"and": "&&", "or": "||"}[e.Operator]
+	if op == "" {
+		op = e.Operator
+	}
+
+	if e.Operator == "+" && (leftType == "Eevee" || rightType == "Eevee") {
+		if format, args, ok := gg.concatenation(e); ok {
+			gg.imports["fmt"] = true
+			return goExpr{fmt.Sprintf("fmt.Sprintf(%s)", strings.Join(append([]string{strconv.Quote(format)}, args...), ", ")), goPrecOperand, false}
+		}
+	}
+
+	numberType := leftType
+	if leftType == "Psyduck" || rightType == "Psyduck" {
+		numberType = "Psyduck"
+	}
+	left, right := gg.convert(e.Left, numberType), gg.convert(e.Right, numberType)
+	if numberType == "Psyduck" && left.untyped && right.untyped {
+		// Go computes constant expressions exactly; typing them rounds
+		// each step like the interpreter does
+		left = goExpr{fmt.Sprintf("float64(%s)", left.code), goPrecOperand, false}
+	}
+	line := e.Pos().Start.Line
+	switch {
+	case e.Operator == "/" && !nonZeroLiteral(e.Right):
+		gg.useHelper("divide")
+		return goExpr{fmt.Sprintf("divide(%s, %s, %d)", left.code, right.code, line), goPrecOperand, false}
+	case e.Operator == "%" && numberType == "Psyduck":
+		if nonZeroLiteral(e.Right) {
+			gg.imports["math"] = true
+			return goExpr{fmt.Sprintf("math.Mod(%s, %s)", left.code, right.code), goPrecOperand, false}
+		}
+		gg.useHelper("floatRemainder")
+		return goExpr{fmt.Sprintf("floatRemainder(%s, %s, %d)", left.code, right.code, line), goPrecOperand, false}
+	case e.Operator == "%" && !nonZeroLiteral(e.Right):
+		gg.useHelper("remainder")
+		return goExpr{fmt.Sprintf("remainder(%s, %s, %d)", left.code, right.code, line), goPrecOperand, false}
+	case e.Operator == "**" && numberType == "Psyduck":
+		gg.imports["math"] = true
+		return goExpr{fmt.Sprintf("math.Pow(%s, %s)", left.code, right.code), goPrecOperand, false}
+	case e.Operator == "**":
+		gg.useHelper("power")
+		return goExpr{fmt.Sprintf("power(%s, %s, %d)", left.code, right.code, line), goPrecOperand, false}
+	}
+	return goExpr{
+		code:    fmt.Sprintf("%s %s %s", left.wrap(prec), op, right.wrap(prec+1)),
+		prec:    prec,
+		untyped: left.untyped && right.untyped,
+	}
+}
+
+// nonZeroLiteral reports whether a divisor is a literal that is not zero,
+// so dividing by it needs no check
+func nonZeroLiteral(expr Expression) bool {
+	switch e := expr.(type) {
+	case *NumberLiteral:
+		return e.Value != 0
+	case *FloatLiteral:
+		return e.Value != 0
+	}
+	return false
+}
+
+// concatenation flattens a chain of string concatenations into a format
+// string and its arguments, formatting numbers the way the interpreter
+// does. It reports false for anything else, including a chain of strings
+// only, which Go can concatenate with + as it is.
+func (gg *GoGen) concatenation(expr Expression) (string, []string, bool) {
+	if e, ok := expr.(*BinaryExpr); !ok || e.Operator != "+" || gg.checker.TypeOf(e) != "Eevee" {
+		return "", nil, false
+	}
+	var parts []Expression
+	var flatten func(expr Expression)
+	flatten = func(expr Expression) {
+		if e, ok := expr.(*BinaryExpr); ok && e.Operator == "+" && gg.checker.TypeOf(e) == "Eevee" {
+			flatten(e.Left)
+			flatten(e.Right)
+			return
+		}
+		parts = append(parts, expr)
+	}
+	flatten(expr)
+
+	onlyStrings := true
+	for _, part := range parts {
+		if gg.checker.TypeOf(part) != "Eevee" {
+			onlyStrings = false
+		}
+	}
+	if onlyStrings {
+		return "", nil, false
+	}
+
+	var format strings.Builder
+	var args []string
+	for _, part := range parts {
+		if literal, ok := part.(*StringLiteral); ok {
+			format.WriteString(strings.ReplaceAll(literal.Value, "%", "%%"))
+			continue
+		}
+		format.WriteString(map[string]string{"Pikachu": "%d", "Psyduck": "%f", "Eevee": "%s", "Voltorb": "%t"}[gg.checker.TypeOf(part)])
+		args = append(args, gg.generateExpression(part).code)
+	}
+	return format.String(), args, true
+}
+
+// convert generates an expression where a value of pokemonType is expected,
+// turning a Pikachu into a float64 where a Psyduck is expected. A whole
+// number literal already works as either.
+func (gg *GoGen) convert(expr Expression, pokemonType string) goExpr {
+	value := gg.generateExpression(expr)
+	if pokemonType == "Psyduck" && gg.checker.TypeOf(expr) == "Pikachu" {
+		if _, ok := expr.(*NumberLiteral); ok {
+			return value
+		}
+		return goExpr{fmt.Sprintf("float64(%s)", value.code), goPrecOperand, false}
+	}
+	return value
+}
+
+// goFloat writes a float literal that Go reads as a floating-point constant
+func goFloat(f float64) string {
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
+
+// useHelper records that the generated code calls a runtime helper, along
+// with the helpers and packages it needs in turn
+func (gg *GoGen) useHelper(name string) {
+	gg.helpers[name] = true
+	gg.imports["fmt"] = true
+	gg.imports["os"] = true
+	switch name {
+	case "catchInt", "catchFloat":
+		gg.imports["strconv"] = true
+		gg.useHelper("catchString")
+		gg.useHelper("fail")
+	case "catchString":
+		gg.imports["bufio"] = true
+		gg.imports["strings"] = true
+	case "floatRemainder":
+		gg.imports["math"] = true
+		gg.useHelper("fail")
+	case "divide", "remainder", "power":
+		gg.useHelper("fail")
+	}
+}
+
+// goHelpers are the runtime helpers, in the order they are written
+var goHelpers = []struct{ name, code string }{
+	{"catchString", `
+// trainer reads the answers to catch from standard input
+var trainer = bufio.NewReader(os.Stdin)
+
+// catchString asks the trainer for a variable's value and reads one line
+func catchString(name string) string {
+	fmt.Printf("Enter value for %s: ", name)
+	input, _ := trainer.ReadString('\n')
+	return strings.TrimRight(input, "\r\n")
+}`},
+	{"catchInt", `
+// catchInt reads a Pikachu (whole number) from the trainer
+func catchInt(name string, line int) int {
+	input := catchString(name)
+	n, err := strconv.Atoi(strings.TrimSpace(input))
+	if err != nil {
+		fail(line, "Expected a Pikachu (whole number) from the trainer, got %q", input)
+	}
+	return n
+}`},
+	{"catchFloat", `
+// catchFloat reads a Psyduck (number) from the trainer
+func catchFloat(name string, line int) float64 {
+	input := catchString(name)
+	f, err := strconv.ParseFloat(strings.TrimSpace(input), 64)
+	if err != nil {
+		fail(line, "Expected a Psyduck (number) from the trainer, got %q", input)
+	}
+	return f
+}`},
+	{"divide", `
+// divide returns a / b, stopping the program if b is zero
+func divide[T int | float64](a, b T, line int) T {
+	if b == 0 {
+		fail(line, "Division by zero.")
+	}
+	return a / b
+}`},
+	{"remainder", `
+// remainder returns a % b, stopping the program if b is zero
+func remainder(a, b int, line int) int {
+	if b == 0 {
+		fail(line, "Division by zero.")
+	}
+	return a % b
+}`},
+	{"floatRemainder", `
+// floatRemainder returns what is left of a after taking out b as many
+// whole times as fit, stopping the program if b is zero
+func floatRemainder(a, b float64, line int) float64 {
+	if b == 0 {
+		fail(line, "Division by zero.")
+	}
+	return math.Mod(a, b)
+}`},
+	{"power", `
+// power raises a to the power b by squaring, wrapping around on overflow,
+// and stops the program if b is negative
+func power(a, b int, line int) int {
+	if b < 0 {
+		fail(line, "` + negativeExponent + `")
+	}
+	result := 1
+	for ; b > 0; b >>= 1 {
+		if b&1 == 1 {
+			result *= a
+		}
+		a *= a
+	}
+	return result
+}`},
+	{"fail", `
+// fail reports a runtime error the way OZUL does and stops the program
+func fail(line int, format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "[OZUL Error] %s (line %d)\n", fmt.Sprintf(format, args...), line)
+	os.Exit(1)
+}`},
+}
+
+// GetCode returns the generated Go source as a string, formatted by gofmt
+func (gg *GoGen) GetCode() string {
+	var sb strings.Builder
+	sb.WriteString("// Generated by the OZUL compiler\n\npackage main\n")
+
+	imports := make([]string, 0, len(gg.imports))
+	for name := range gg.imports {
+		imports = append(imports, strconv.Quote(name))
+	}
+	sort.Strings(imports)
+	if len(imports) > 0 {
+		sb.WriteString("\nimport (\n\t" + strings.Join(imports, "\n\t") + "\n)\n")
+	}
+
+	sb.WriteString("\n" + strings.Join(gg.code, "\n") + "\n")
+	for _, helper := range goHelpers {
+		if gg.helpers[helper.name] {
+			sb.WriteString(helper.code + "\n")
+		}
+	}
+
+	code, err := format.Source([]byte(sb.String()))
+	if err != nil {
+		panic(fmt.Sprintf("[OZUL Go Error] Generated code does not parse: %v", err))
+	}
+	return string(code)
+}
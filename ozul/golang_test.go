@@ -0,0 +1,29 @@
+package main
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"testing"
+)
+
+// TestGoGen_TypeChecks checks with go/types that every generated program
+// is valid Go, without needing a Go toolchain
+func TestGoGen_TypeChecks(t *testing.T) {
+	fset := token.NewFileSet()
+	config := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	for _, tc := range backendPrograms {
+		t.Run(tc.name, func(t *testing.T) {
+			code := generateGo(parseChecked(t, tc.source))
+			file, err := parser.ParseFile(fset, tc.name+".go", code, parser.ParseComments)
+			if err != nil {
+				t.Fatalf("Generated code does not parse: %v\n%s", err, code)
+			}
+			if _, err := config.Check("main", fset, []*ast.File{file}, nil); err != nil {
+				t.Errorf("Generated code does not type check: %v\n%s", err, code)
+			}
+		})
+	}
+}
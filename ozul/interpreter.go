@@ -3,89 +3,342 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"io"
+	"math"
 	"os"
 	"strconv"
+	"strings"
 )
 
 type Value struct {
-	Type  string // "int", "float", "string"
+	Type  string // "int", "float", "string", "bool"
 	Int   int
 	Float float64
 	Str   string
+	Bool  bool
+}
+
+// String formats a value the way release prints it
+func (v Value) String() string {
+	switch v.Type {
+	case "int":
+		return fmt.Sprint(v.Int)
+	case "float":
+		return fmt.Sprint(v.Float)
+	case "string":
+		return v.Str
+	case "bool":
+		return fmt.Sprint(v.Bool)
+	}
+	return ""
+}
+
+// DefaultMaxSteps is the step budget a new Interpreter starts with
+const DefaultMaxSteps = 10000
+
+// MaxCallDepth limits how deeply moves may call each other, so runaway
+// recursion becomes an OZUL error instead of a Go stack overflow
+const MaxCallDepth = 1000
+
+// callFrame holds the variables of one active move call (or the top level).
+// scope is the innermost block scope currently executing in the frame.
+type callFrame struct {
+	name     string
+	scope    *Scope[Value]
+	returned bool
+	result   Value
 }
 
 type Interpreter struct {
-	vars map[string]Value
+	frames   []*callFrame
+	funcs    map[string]*FunctionDecl
+	maxSteps int // 0 or less means unlimited
+	steps    int
+	input    *bufio.Reader // shared by every catch so buffered input is not lost
+	output   io.Writer     // where release writes; nil means standard output
+	at       Span          // source of the statement or expression being run
 }
 
 func NewInterpreter() *Interpreter {
-	return &Interpreter{vars: make(map[string]Value)}
+	return &Interpreter{
+		frames:   []*callFrame{{name: "<main>", scope: NewScope[Value](nil)}},
+		funcs:    make(map[string]*FunctionDecl),
+		maxSteps: DefaultMaxSteps,
+	}
+}
+
+// SetMaxSteps sets how many statements (and loop iterations) a single Run
+// may execute before it is stopped. A limit of 0 or less disables the check.
+func (it *Interpreter) SetMaxSteps(limit int) {
+	it.maxSteps = limit
+}
+
+// SetInput makes catch read from r instead of standard input. Code that reads
+// the same stream itself should pass its own reader so no input is lost.
+func (it *Interpreter) SetInput(r *bufio.Reader) {
+	it.input = r
 }
 
-func (it *Interpreter) Run(program *Program) {
-	const maxSteps = 10000
-	steps := 0
+// SetOutput sends release output and catch prompts to w instead of
+// standard output
+func (it *Interpreter) SetOutput(w io.Writer) {
+	it.output = w
+}
+
+// Variables returns the top-level variables and their current values
+func (it *Interpreter) Variables() map[string]Value {
+	scope := it.frames[0].scope
+	vars := make(map[string]Value)
+	for _, name := range scope.Names() {
+		vars[name], _ = scope.Lookup(name)
+	}
+	return vars
+}
+
+// Run executes a program and returns a *RuntimeError if it fails. The
+// interpreter stays usable afterwards: the failed call frames are dropped and
+// top-level variables declared before the failure are kept.
+func (it *Interpreter) Run(program *Program) (err error) {
+	it.steps = 0
+	main := it.frames[0]
+	root := main.scope
+	defer func() {
+		if r := recover(); r != nil {
+			runtimeErr, ok := r.(*RuntimeError)
+			if !ok {
+				panic(r) // a bug in the interpreter, not in the program
+			}
+			it.frames = it.frames[:1]
+			main.scope = root
+			err = runtimeErr
+		}
+	}()
+
+	// Register every move first so calls may appear before the declaration
 	for _, stmt := range program.Statements {
-		steps++
-		if steps > maxSteps {
-			panic("[OZUL Error] Execution step limit exceeded (possible infinite loop)")
+		if fn, ok := stmt.(*FunctionDecl); ok {
+			if _, exists := it.funcs[fn.Name]; exists {
+				it.at = fn.Pos()
+				panic(it.errorf(ErrRedeclaredMove, "Move already declared: %s", fn.Name))
+			}
+			it.funcs[fn.Name] = fn
 		}
+	}
+	it.execStatements(program.Statements)
+	return nil
+}
+
+// errorf builds a runtime error at the current position. It is raised with
+// panic to unwind nested statements and calls, and Run turns it into an error.
+func (it *Interpreter) errorf(kind RuntimeErrorKind, format string, args ...interface{}) *RuntimeError {
+	return &RuntimeError{Kind: kind, Message: fmt.Sprintf(format, args...), Span: it.at}
+}
+
+// stdout is where program output goes
+func (it *Interpreter) stdout() io.Writer {
+	if it.output != nil {
+		return it.output
+	}
+	return os.Stdout
+}
+
+// frame returns the innermost active call frame
+func (it *Interpreter) frame() *callFrame {
+	return it.frames[len(it.frames)-1]
+}
+
+// step counts one unit of work against the step budget
+func (it *Interpreter) step() {
+	it.steps++
+	if it.maxSteps > 0 && it.steps > it.maxSteps {
+		panic(it.errorf(ErrStepLimit, "Execution step limit of %d exceeded (possible infinite loop)", it.maxSteps))
+	}
+}
+
+// execBlock runs a nested block in its own scope, so its declarations end
+// with the block
+func (it *Interpreter) execBlock(stmts []Statement) {
+	frame := it.frame()
+	outer := frame.scope
+	frame.scope = NewScope(outer)
+	it.execStatements(stmts)
+	frame.scope = outer
+}
+
+// execStatements runs statements in order in the current scope, stopping
+// early once a return has happened in the current frame
+func (it *Interpreter) execStatements(stmts []Statement) {
+	for _, stmt := range stmts {
 		it.execStatement(stmt)
+		if it.frame().returned {
+			return
+		}
 	}
 }
 
 func (it *Interpreter) execStatement(stmt Statement) {
+	it.at = stmt.Pos()
+	it.step()
 	switch s := stmt.(type) {
 	case *DeclarationStmt:
-		val := it.evalExpression(s.Value)
-		it.vars[s.Name] = val
+		val := it.convertTo(it.evalExpression(s.Value), s.PokemonType, "variable "+s.Name)
+		if err := it.frame().scope.Declare(s.Name, val); err != nil {
+			panic(it.errorf(ErrRedeclaredVariable, "Variable already declared in this scope: %s", s.Name))
+		}
 	case *AssignmentStmt:
 		val := it.evalExpression(s.Value)
-		if _, ok := it.vars[s.Name]; ok {
-			it.vars[s.Name] = val
-		} else {
-			panic(fmt.Sprintf("[OZUL Error] Variable not declared: %s", s.Name))
+		scope := it.frame().scope
+		old, ok := scope.Lookup(s.Name)
+		if !ok {
+			panic(it.errorf(ErrUndefinedVariable, "Variable not declared: %s", s.Name))
 		}
+		// A variable keeps the type it was declared with
+		scope.Assign(s.Name, it.convertTo(val, pokemonTypes[old.Type], "variable "+s.Name))
 	case *ReleaseStmt:
 		val := it.evalExpression(s.Value)
 		it.printValue(val)
-	case *CatchStmt:
-		reader := bufio.NewReader(os.Stdin)
-		fmt.Printf("Enter value for %s: ", s.Variable)
-		os.Stdout.Sync() // Flush output buffer so prompt is visible
-		input, _ := reader.ReadString('\n')
-		input = input[:len(input)-1] // remove newline
-		// Default to int, could be improved
-		if intVal, err := strconv.Atoi(input); err == nil {
-			it.vars[s.Variable] = Value{Type: "int", Int: intVal}
-		} else if floatVal, err := strconv.ParseFloat(input, 64); err == nil {
-			it.vars[s.Variable] = Value{Type: "float", Float: floatVal}
+	case *IfStmt:
+		branch := s.Else
+		if it.toBool(it.evalExpression(s.Condition), "if") {
+			branch = s.Then
+		}
+		it.execBlock(branch)
+	case *WhileStmt:
+		for it.toBool(it.evalExpression(s.Condition), "train while") {
+			it.execBlock(s.Body)
+			if it.frame().returned {
+				break
+			}
+			it.step() // count iterations so empty bodies still hit the limit
+		}
+	case *RepeatStmt:
+		count := it.evalExpression(s.Count)
+		if count.Type != "int" {
+			panic(it.errorf(ErrTypeMismatch, "'repeat' needs a Pikachu (int) count, got %s", count.Type))
+		}
+		for i := 0; i < count.Int; i++ {
+			it.execBlock(s.Body)
+			if it.frame().returned {
+				break
+			}
+			it.step()
+		}
+	case *FunctionDecl:
+		// Registered up front by Run
+	case *ReturnStmt:
+		frame := it.frame()
+		if s.Value != nil {
+			frame.result = it.evalExpression(s.Value)
+		}
+		frame.returned = true
+	case *ExpressionStmt:
+		if call, ok := s.Expr.(*CallExpr); ok {
+			it.callFunction(call)
 		} else {
-			it.vars[s.Variable] = Value{Type: "string", Str: input}
+			it.evalExpression(s.Expr)
+		}
+	case *CatchStmt:
+		if it.input == nil {
+			it.input = bufio.NewReader(os.Stdin)
+		}
+		fmt.Fprintf(it.stdout(), "Enter value for %s: ", s.Variable)
+		if it.output == nil {
+			os.Stdout.Sync() // Flush output buffer so prompt is visible
+		}
+		input, _ := it.input.ReadString('\n')
+		input = strings.TrimRight(input, "\r\n") // remove newline
+
+		// A typed catch declares a new variable; an untyped one reads into a
+		// visible variable, or else declares a new Pikachu
+		scope := it.frame().scope
+		old, exists := scope.Lookup(s.Variable)
+		pokemonType := s.PokemonType
+		if pokemonType == "" {
+			pokemonType = "Pikachu"
+			if exists {
+				pokemonType = pokemonTypes[old.Type]
+			}
+		}
+		val, err := parseInput(input, pokemonType)
+		if err != nil {
+			err.Span = it.at
+			panic(err)
+		}
+		if s.PokemonType == "" && exists {
+			scope.Assign(s.Variable, val)
+		} else if err := scope.Declare(s.Variable, val); err != nil {
+			panic(it.errorf(ErrRedeclaredVariable, "Variable already declared in this scope: %s", s.Variable))
 		}
 	}
 }
 
+// evalExpression evaluates expr, pointing the interpreter's position at it
+// while it runs. A failure leaves the position on the innermost expression.
 func (it *Interpreter) evalExpression(expr Expression) Value {
+	outer := it.at
+	if expr != nil {
+		it.at = expr.Pos()
+	}
+	val := it.eval(expr)
+	it.at = outer
+	return val
+}
+
+func (it *Interpreter) eval(expr Expression) Value {
 	switch e := expr.(type) {
 	case *NumberLiteral:
 		return Value{Type: "int", Int: e.Value}
 	case *FloatLiteral:
 		return Value{Type: "float", Float: e.Value}
+	case *InterpolatedString:
+		return it.eval(e.Value)
 	case *StringLiteral:
 		return Value{Type: "string", Str: e.Value}
+	case *BooleanLiteral:
+		return Value{Type: "bool", Bool: e.Value}
 	case *Identifier:
-		v, ok := it.vars[e.Name]
+		v, ok := it.frame().scope.Lookup(e.Name)
 		if !ok {
-			panic(fmt.Sprintf("[OZUL Error] Undefined variable: %s", e.Name))
+			panic(it.errorf(ErrUndefinedVariable, "Undefined variable: %s", e.Name))
 		}
 		return v
+	case *CallExpr:
+		result := it.callFunction(e)
+		if result.Type == "" {
+			panic(it.errorf(ErrMissingReturn, "Move %s does not give a value", e.Name))
+		}
+		return result
+	case *UnaryExpr:
+		operand := it.evalExpression(e.Operand)
+		if e.Operator == "not" {
+			return Value{Type: "bool", Bool: !it.toBool(operand, "not")}
+		}
+		if e.Operator == "-" {
+			switch operand.Type {
+			case "int":
+				return Value{Type: "int", Int: -operand.Int}
+			case "float":
+				return Value{Type: "float", Float: -operand.Float}
+			}
+			panic(it.errorf(ErrTypeMismatch, "Cannot use %s (%s) values with operator -", pokemonTypes[operand.Type], operand.Type))
+		}
+		panic(it.errorf(ErrUnknownOperator, "Unknown operator: %s", e.Operator))
 	case *BinaryExpr:
+		if e.Operator == "and" || e.Operator == "or" {
+			return it.evalLogical(e)
+		}
 		left := it.evalExpression(e.Left)
 		right := it.evalExpression(e.Right)
+		switch e.Operator {
+		case "==", "!=", "<", "<=", ">", ">=":
+			return it.evalComparison(e.Operator, left, right)
+		}
 		if e.Operator == "+" && (left.Type == "string" || right.Type == "string") {
 			return Value{Type: "string", Str: it.toString(left) + it.toString(right)}
 		}
+		if left.Type == "bool" || right.Type == "bool" {
+			panic(it.errorf(ErrTypeMismatch, "Cannot use Voltorb (bool) values with operator %s", e.Operator))
+		}
 		if left.Type == "float" || right.Type == "float" {
 			lf := it.toFloat(left)
 			rf := it.toFloat(right)
@@ -98,9 +351,16 @@ func (it *Interpreter) evalExpression(expr Expression) Value {
 				return Value{Type: "float", Float: lf * rf}
 			case "/":
 				if rf == 0 {
-					panic("[OZUL Error] Division by zero.")
+					panic(it.errorf(ErrDivisionByZero, "Division by zero."))
 				}
 				return Value{Type: "float", Float: lf / rf}
+			case "%":
+				if rf == 0 {
+					panic(it.errorf(ErrDivisionByZero, "Division by zero."))
+				}
+				return Value{Type: "float", Float: math.Mod(lf, rf)}
+			case "**":
+				return Value{Type: "float", Float: math.Pow(lf, rf)}
 			}
 		}
 		li := it.toInt(left)
@@ -114,28 +374,212 @@ func (it *Interpreter) evalExpression(expr Expression) Value {
 			return Value{Type: "int", Int: li * ri}
 		case "/":
 			if ri == 0 {
-				panic("[OZUL Error] Division by zero.")
+				panic(it.errorf(ErrDivisionByZero, "Division by zero."))
 			}
 			return Value{Type: "int", Int: li / ri}
+		case "%":
+			if ri == 0 {
+				panic(it.errorf(ErrDivisionByZero, "Division by zero."))
+			}
+			return Value{Type: "int", Int: li % ri}
+		case "**":
+			if ri < 0 {
+				panic(it.errorf(ErrNegativeExponent, negativeExponent))
+			}
+			return Value{Type: "int", Int: intPower(li, ri)}
 		}
-		fmt.Fprintf(os.Stderr, "[OZUL Error] Unknown operator: %s\n", e.Operator)
-		panic(fmt.Sprintf("[OZUL Error] Unknown operator: %s", e.Operator))
+		panic(it.errorf(ErrUnknownOperator, "Unknown operator: %s", e.Operator))
 	default:
-		fmt.Fprintln(os.Stderr, "[OZUL Error] Unknown expression type.")
-		panic("[OZUL Error] Unknown expression type.")
+		panic(it.errorf(ErrUnknownExpression, "Unknown expression type."))
 	}
-	return Value{}
+}
+
+// callFunction runs a move in a fresh call frame and returns what it gives
+// back (an empty Value for moves without a return type)
+func (it *Interpreter) callFunction(call *CallExpr) Value {
+	fn, ok := it.funcs[call.Name]
+	if !ok {
+		panic(it.errorf(ErrUndefinedMove, "Undefined move: %s", call.Name))
+	}
+	if len(call.Args) != len(fn.Params) {
+		panic(it.errorf(ErrArgumentCount, "Move %s expects %d arguments, got %d", fn.Name, len(fn.Params), len(call.Args)))
+	}
+	if len(it.frames) >= MaxCallDepth {
+		panic(it.errorf(ErrCallDepth, "Maximum call depth of %d exceeded in move %s (runaway recursion?)", MaxCallDepth, fn.Name))
+	}
+
+	// Parameters and the body's top-level declarations share one scope with
+	// no parent: moves cannot see the caller's variables
+	frame := &callFrame{name: fn.Name, scope: NewScope[Value](nil)}
+	for i, param := range fn.Params {
+		arg := it.evalExpression(call.Args[i])
+		if err := frame.scope.Declare(param.Name, it.convertTo(arg, param.PokemonType, "argument "+param.Name+" of move "+fn.Name)); err != nil {
+			panic(it.errorf(ErrRedeclaredVariable, "Duplicate parameter %s in move %s", param.Name, fn.Name))
+		}
+	}
+
+	it.frames = append(it.frames, frame)
+	it.execStatements(fn.Body)
+	it.frames = it.frames[:len(it.frames)-1]
+
+	if fn.ReturnType == "" {
+		if frame.result.Type != "" {
+			panic(it.errorf(ErrTypeMismatch, "Move %s does not declare a return type but returned a value", fn.Name))
+		}
+		return Value{}
+	}
+	if frame.result.Type == "" {
+		panic(it.errorf(ErrMissingReturn, "Move %s ended without giving back a %s", fn.Name, fn.ReturnType))
+	}
+	return it.convertTo(frame.result, fn.ReturnType, "return value of move "+fn.Name)
+}
+
+// valueTypes maps each Pokemon type to the Value type that holds it
+var valueTypes = map[string]string{
+	"Pikachu": "int",
+	"Psyduck": "float",
+	"Eevee":   "string",
+	"Voltorb": "bool",
+}
+
+// pokemonTypes maps each Value type back to its Pokemon type
+var pokemonTypes = map[string]string{
+	"int":    "Pikachu",
+	"float":  "Psyduck",
+	"string": "Eevee",
+	"bool":   "Voltorb",
+}
+
+// parseInput turns a line typed by the trainer into a value of the given
+// Pokemon type. A returned error has no position yet.
+func parseInput(input string, pokemonType string) (Value, *RuntimeError) {
+	switch pokemonType {
+	case "Pikachu":
+		i, err := strconv.Atoi(strings.TrimSpace(input))
+		if err != nil {
+			return Value{}, &RuntimeError{Kind: ErrInvalidInput, Message: fmt.Sprintf("Expected a Pikachu (whole number) from the trainer, got %q", input)}
+		}
+		return Value{Type: "int", Int: i}, nil
+	case "Psyduck":
+		f, err := strconv.ParseFloat(strings.TrimSpace(input), 64)
+		if err != nil {
+			return Value{}, &RuntimeError{Kind: ErrInvalidInput, Message: fmt.Sprintf("Expected a Psyduck (number) from the trainer, got %q", input)}
+		}
+		return Value{Type: "float", Float: f}, nil
+	case "Eevee":
+		return Value{Type: "string", Str: input}, nil
+	}
+	return Value{}, &RuntimeError{Kind: ErrTypeMismatch, Message: fmt.Sprintf("Cannot catch a %s from the trainer", pokemonType)}
+}
+
+// convertTo checks a value against a declared Pokemon type, promoting ints
+// to floats where a Psyduck is expected
+func (it *Interpreter) convertTo(val Value, pokemonType string, context string) Value {
+	want := valueTypes[pokemonType]
+	if val.Type == want {
+		return val
+	}
+	if want == "float" && val.Type == "int" {
+		return Value{Type: "float", Float: float64(val.Int)}
+	}
+	panic(it.errorf(ErrTypeMismatch, "%s must be a %s, got %s", context, pokemonType, val.Type))
+}
+
+// evalLogical evaluates "and"/"or", skipping the right side when the left
+// side already decides the result.
+func (it *Interpreter) evalLogical(e *BinaryExpr) Value {
+	left := it.toBool(it.evalExpression(e.Left), e.Operator)
+	if e.Operator == "and" && !left {
+		return Value{Type: "bool", Bool: false}
+	}
+	if e.Operator == "or" && left {
+		return Value{Type: "bool", Bool: true}
+	}
+	right := it.toBool(it.evalExpression(e.Right), e.Operator)
+	return Value{Type: "bool", Bool: right}
+}
+
+func (it *Interpreter) evalComparison(op string, left, right Value) Value {
+	var cmp int
+	switch {
+	case left.Type == "string" && right.Type == "string":
+		cmp = strings.Compare(left.Str, right.Str)
+	case left.Type == "bool" && right.Type == "bool":
+		if op != "==" && op != "!=" {
+			panic(it.errorf(ErrTypeMismatch, "Cannot order Voltorb (bool) values with operator %s", op))
+		}
+		if left.Bool != right.Bool {
+			cmp = 1
+		}
+	case left.Type == "int" && right.Type == "int":
+		if left.Int < right.Int {
+			cmp = -1
+		} else if left.Int > right.Int {
+			cmp = 1
+		}
+	case it.isNumber(left) && it.isNumber(right):
+		lf := it.toFloat(left)
+		rf := it.toFloat(right)
+		if lf < rf {
+			cmp = -1
+		} else if lf > rf {
+			cmp = 1
+		}
+	default:
+		panic(it.errorf(ErrTypeMismatch, "Cannot compare %s with %s", left.Type, right.Type))
+	}
+
+	var result bool
+	switch op {
+	case "==":
+		result = cmp == 0
+	case "!=":
+		result = cmp != 0
+	case "<":
+		result = cmp < 0
+	case "<=":
+		result = cmp <= 0
+	case ">":
+		result = cmp > 0
+	case ">=":
+		result = cmp >= 0
+	}
+	return Value{Type: "bool", Bool: result}
 }
 
 func (it *Interpreter) printValue(val Value) {
-	switch val.Type {
-	case "int":
-		fmt.Println(val.Int)
-	case "float":
-		fmt.Println(val.Float)
-	case "string":
-		fmt.Println(val.Str)
+	fmt.Fprintln(it.stdout(), val.String())
+}
+
+func (it *Interpreter) isNumber(val Value) bool {
+	return val.Type == "int" || val.Type == "float"
+}
+
+// negativeExponent is the error for a Pikachu raised to a negative power,
+// which would not be a whole number
+const negativeExponent = "Negative exponent: a Pikachu can only be raised to a power of 0 or more."
+
+// intPower raises base to a power of 0 or more by squaring, wrapping around
+// on overflow like the other Pikachu arithmetic
+func intPower(base, exp int) int {
+	result := 1
+	for exp > 0 {
+		if exp&1 == 1 {
+			result *= base
+		}
+		base *= base
+		exp >>= 1
+	}
+	return result
+}
+
+// toBool unwraps a bool value; anything else is an error, since conditions
+// and logical operators need real truth values
+func (it *Interpreter) toBool(val Value, context string) bool {
+	if val.Type != "bool" {
+		panic(it.errorf(ErrTypeMismatch, "'%s' needs a Voltorb (bool) value, got %s", context, val.Type))
 	}
+	return val.Bool
 }
 
 func (it *Interpreter) toInt(val Value) int {
@@ -170,6 +614,8 @@ func (it *Interpreter) toString(val Value) string {
 		return strconv.Itoa(val.Int)
 	case "float":
 		return fmt.Sprintf("%f", val.Float)
+	case "bool":
+		return strconv.FormatBool(val.Bool)
 	}
 	return ""
 }
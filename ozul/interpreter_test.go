@@ -35,21 +35,38 @@ func captureOutput(f func()) string {
 	return bufOut.String() + bufErr.String()
 }
 
-func runInterpreterWithOutput(program *Program, input string) (string, int) {
+// newRunner creates what the interpreter tests run programs with.
+// TestVM_InterpreterSuite swaps in the bytecode VM to run them again.
+var newRunner = func() Runner { return NewInterpreter() }
+
+func runInterpreterWithOutput(program *Program, input string) (string, error) {
 	origStdin := os.Stdin
 	inR, inW, _ := os.Pipe()
 	inW.WriteString(input)
 	inW.Close()
 	os.Stdin = inR
 
-	var output string
-	output = captureOutput(func() {
-		it := NewInterpreter()
-		it.Run(program)
+	var err error
+	output := captureOutput(func() {
+		err = newRunner().Run(program)
 	})
 
 	os.Stdin = origStdin
-	return output, 0
+	return output, err
+}
+
+// expectRuntimeError checks that a run failed with the given kind of error
+// and a message containing the given text
+func expectRuntimeError(t *testing.T, source string, err error, kind RuntimeErrorKind, message string) {
+	t.Helper()
+	runtimeErr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Errorf("%q: expected a %s runtime error, got: %v", source, kind, err)
+		return
+	}
+	if runtimeErr.Kind != kind || !strings.Contains(runtimeErr.Message, message) {
+		t.Errorf("%q: expected %s error containing %q, got %s error %q", source, kind, message, runtimeErr.Kind, runtimeErr.Message)
+	}
 }
 
 func TestInterpreter_DeclarationAndRelease(t *testing.T) {
@@ -80,6 +97,66 @@ release sum`
 	}
 }
 
+func TestInterpreter_Operators(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{"release 10 - 3 - 2", "5"},
+		{"release 100 / 10 / 5", "2"},
+		{"release 2 ** 3 ** 2", "512"},
+		{"release (2 ** 3) ** 2", "64"},
+		{"release -2 ** 2", "-4"},
+		{"release (-2) ** 3", "-8"},
+		{"release 2 ** 0", "1"},
+		{"release 2 ** 64", "0"},
+		{"release 3 ** 41", "-420491770248316829"},
+		{"release 2 ** 0.5 * 2 ** 0.5", "2.0000000000000004"},
+		{"release 2.0 ** -1", "0.5"},
+		{"release 7 % 3", "1"},
+		{"release -7 % 3", "-1"},
+		{"release 7 % -3", "1"},
+		{"release 7.5 % 2", "1.5"},
+		{"release -7.5 % 2", "-1.5"},
+		{"release (1 + 2) * 3", "9"},
+		{"release -(1 + 2) * 3", "-9"},
+		{"release - -4", "4"},
+		{"release -2.5 * 2", "-5"},
+		{"Pikachu hp is 7\nrelease -hp + 10 % 4 * 2", "-3"},
+		{"release not -1 < 0", "false"},
+	}
+
+	for _, tt := range tests {
+		program := NewParser(NewLexer(tt.source).Tokenize()).Parse()
+		output, err := runInterpreterWithOutput(program, "")
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.source, err)
+			continue
+		}
+		if got := strings.TrimSpace(output); got != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.source, tt.expected, got)
+		}
+	}
+}
+
+func TestInterpreter_OperatorErrors(t *testing.T) {
+	tests := []struct {
+		source  string
+		kind    RuntimeErrorKind
+		message string
+	}{
+		{"Pikachu zero is 0\nrelease 5 % zero", ErrDivisionByZero, "Division by zero."},
+		{"Psyduck zero is 0.0\nrelease 5.5 % zero", ErrDivisionByZero, "Division by zero."},
+		{"Pikachu exp is 0 - 1\nrelease 2 ** exp", ErrNegativeExponent, "Negative exponent"},
+	}
+
+	for _, tt := range tests {
+		program := NewParser(NewLexer(tt.source).Tokenize()).Parse()
+		_, err := runInterpreterWithOutput(program, "")
+		expectRuntimeError(t, tt.source, err, tt.kind, tt.message)
+	}
+}
+
 func TestInterpreter_StringConcat(t *testing.T) {
 	source := `Eevee greeting is "Hello " + "Ash"
 release greeting`
@@ -115,20 +192,8 @@ func TestInterpreter_UndefinedVariableError(t *testing.T) {
 	parser := NewParser(tokens)
 	program := parser.Parse()
 
-	var output string
-	var code int
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				output = output + r.(string)
-				code = 1
-			}
-		}()
-		output, _ = runInterpreterWithOutput(program, "")
-	}()
-	if code == 0 || !strings.Contains(output, "Undefined variable") {
-		t.Errorf("Expected undefined variable error, got: %q", output)
-	}
+	_, err := runInterpreterWithOutput(program, "")
+	expectRuntimeError(t, source, err, ErrUndefinedVariable, "Undefined variable")
 }
 
 func TestInterpreter_DivisionByZeroError(t *testing.T) {
@@ -139,18 +204,324 @@ release x`
 	parser := NewParser(tokens)
 	program := parser.Parse()
 
-	var output string
-	var code int
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				output = output + r.(string)
-				code = 1
-			}
-		}()
-		output, _ = runInterpreterWithOutput(program, "")
-	}()
-	if code == 0 || !strings.Contains(output, "Division by zero") {
-		t.Errorf("Expected division by zero error, got: %q", output)
+	_, err := runInterpreterWithOutput(program, "")
+	expectRuntimeError(t, source, err, ErrDivisionByZero, "Division by zero")
+}
+
+func TestInterpreter_ErrorPosition(t *testing.T) {
+	source := `Pikachu zero is 0
+if true then
+  release 10 / zero
+end`
+	program := NewParser(NewLexer(source).Tokenize()).Parse()
+
+	_, err := runInterpreterWithOutput(program, "")
+	expectRuntimeError(t, source, err, ErrDivisionByZero, "Division by zero")
+	expected := Span{Position{3, 11}, Position{3, 20}}
+	if runtimeErr, ok := err.(*RuntimeError); ok && runtimeErr.Span != expected {
+		t.Errorf("Expected error at %v, got %v", expected, runtimeErr.Span)
+	}
+}
+
+func TestInterpreter_RecoversAfterError(t *testing.T) {
+	interpreter := newRunner()
+	run := func(source string) error {
+		return interpreter.Run(NewParser(NewLexer(source).Tokenize()).Parse())
+	}
+
+	failing := "Pikachu hp is 10\nmove faint(Pikachu n) gives Pikachu\nif true then\nreturn n / 0\nend\nreturn n\nend\nrelease faint(1)"
+	var err error
+	captureOutput(func() { err = run(failing) })
+	expectRuntimeError(t, failing, err, ErrDivisionByZero, "Division by zero")
+
+	// The failed move's frame and block scope must be gone: the next run is
+	// back at the top level, where hp is still visible
+	output := captureOutput(func() { err = run("Pikachu more is hp + 1\nrelease more") })
+	if err != nil || output != "11\n" {
+		t.Errorf("Expected the interpreter to keep working after an error, got %q, %v", output, err)
+	}
+}
+
+func TestInterpreter_IfElse(t *testing.T) {
+	source := `Pikachu health is 0
+if health > 0 then
+release "alive"
+else
+release "fainted"
+end`
+	lexer := NewLexer(source)
+	tokens := lexer.Tokenize()
+	parser := NewParser(tokens)
+	program := parser.Parse()
+
+	output, _ := runInterpreterWithOutput(program, "")
+	if !strings.Contains(output, "fainted") || strings.Contains(output, "alive") {
+		t.Errorf("Expected output to contain only 'fainted', got: %q", output)
+	}
+}
+
+func TestInterpreter_ElseIfChain(t *testing.T) {
+	source := `Pikachu a is 0
+Pikachu b is 1
+if a == 1 then
+release "first"
+else if b == 1 then
+release "second"
+else
+release "third"
+end`
+	lexer := NewLexer(source)
+	tokens := lexer.Tokenize()
+	parser := NewParser(tokens)
+	program := parser.Parse()
+
+	output, _ := runInterpreterWithOutput(program, "")
+	if strings.TrimSpace(output) != "second" {
+		t.Errorf("Expected output 'second', got: %q", output)
+	}
+}
+
+func TestInterpreter_ComparisonsAndLogic(t *testing.T) {
+	source := `Pikachu hp is 30
+Psyduck speed is 2.5
+Eevee name is "Ash"
+Voltorb ready is hp > 10 and speed <= 2.5
+release ready
+release hp + 5 < 35
+release name == "Ash" or hp / 0 > 1
+release not name != "Ash"
+release hp != 30 and hp / 0 > 1
+release "Ready: " + ready`
+	lexer := NewLexer(source)
+	tokens := lexer.Tokenize()
+	parser := NewParser(tokens)
+	program := parser.Parse()
+
+	output, _ := runInterpreterWithOutput(program, "")
+	expected := "true\nfalse\ntrue\ntrue\nfalse\nReady: true\n"
+	if output != expected {
+		t.Errorf("Expected output %q, got: %q", expected, output)
+	}
+}
+
+func TestInterpreter_ConditionMustBeBool(t *testing.T) {
+	source := `Pikachu x is 1
+if x then
+release x
+end`
+	lexer := NewLexer(source)
+	tokens := lexer.Tokenize()
+	parser := NewParser(tokens)
+	program := parser.Parse()
+
+	_, err := runInterpreterWithOutput(program, "")
+	expectRuntimeError(t, source, err, ErrTypeMismatch, "Voltorb")
+}
+
+func TestInterpreter_WhileLoop(t *testing.T) {
+	source := `Pikachu hp is 30
+train while hp > 0
+release hp
+hp evolves to hp - 10
+end`
+	lexer := NewLexer(source)
+	tokens := lexer.Tokenize()
+	parser := NewParser(tokens)
+	program := parser.Parse()
+
+	output, _ := runInterpreterWithOutput(program, "")
+	if output != "30\n20\n10\n" {
+		t.Errorf("Expected countdown 30, 20, 10, got: %q", output)
+	}
+}
+
+func TestInterpreter_RepeatLoop(t *testing.T) {
+	source := `Pikachu total is 0
+repeat 2 + 2 times
+total evolves to total + 5
+end
+release total`
+	lexer := NewLexer(source)
+	tokens := lexer.Tokenize()
+	parser := NewParser(tokens)
+	program := parser.Parse()
+
+	output, _ := runInterpreterWithOutput(program, "")
+	if strings.TrimSpace(output) != "20" {
+		t.Errorf("Expected output '20', got: %q", output)
+	}
+}
+
+func TestInterpreter_StepLimit(t *testing.T) {
+	source := `train while true
+end`
+	program := NewParser(NewLexer(source).Tokenize()).Parse()
+
+	it := newRunner()
+	it.SetMaxSteps(50)
+	expectRuntimeError(t, source, it.Run(program), ErrStepLimit, "step limit of 50 exceeded")
+}
+
+func TestInterpreter_StepLimitCountsLoopBodies(t *testing.T) {
+	source := `Pikachu n is 0
+repeat 100 times
+n evolves to n + 1
+end`
+	program := NewParser(NewLexer(source).Tokenize()).Parse()
+
+	// 1 declaration + 1 repeat + 100 * (1 body statement + 1 iteration)
+	it := newRunner()
+	it.SetMaxSteps(202)
+	if err := it.Run(program); err != nil {
+		t.Errorf("Expected a budget of 202 to be enough, got: %v", err)
+	}
+
+	it = newRunner()
+	it.SetMaxSteps(201)
+	expectRuntimeError(t, source, it.Run(program), ErrStepLimit, "step limit")
+}
+
+func TestInterpreter_Functions(t *testing.T) {
+	source := `release fact(5)
+move fact(Pikachu n) gives Pikachu
+if n <= 1 then
+return 1
+end
+return n * fact(n - 1)
+end
+move cheer(Eevee name)
+release "Go " + name + "!"
+end
+move half(Psyduck x) gives Psyduck
+return x / 2
+end
+cheer("Pikachu")
+release half(5)`
+	lexer := NewLexer(source)
+	tokens := lexer.Tokenize()
+	parser := NewParser(tokens)
+	program := parser.Parse()
+
+	output, _ := runInterpreterWithOutput(program, "")
+	expected := "120\nGo Pikachu!\n2.5\n"
+	if output != expected {
+		t.Errorf("Expected output %q, got: %q", expected, output)
+	}
+}
+
+func TestInterpreter_FunctionFramesAreIsolated(t *testing.T) {
+	source := `Pikachu hp is 10
+move poke(Pikachu hp) gives Pikachu
+hp evolves to hp + 1
+return hp
+end
+release poke(100)
+release hp`
+	lexer := NewLexer(source)
+	tokens := lexer.Tokenize()
+	parser := NewParser(tokens)
+	program := parser.Parse()
+
+	output, _ := runInterpreterWithOutput(program, "")
+	if output != "101\n10\n" {
+		t.Errorf("Expected output %q, got: %q", "101\n10\n", output)
+	}
+}
+
+func TestInterpreter_FunctionErrors(t *testing.T) {
+	tests := []struct {
+		source   string
+		kind     RuntimeErrorKind
+		expected string
+	}{
+		{"move loop(Pikachu n) gives Pikachu\nreturn loop(n + 1)\nend\nrelease loop(0)", ErrCallDepth, "Maximum call depth"},
+		{"Pikachu hp is 5\nmove peek() gives Pikachu\nreturn hp\nend\nrelease peek()", ErrUndefinedVariable, "Undefined variable: hp"},
+		{"release missing(1)", ErrUndefinedMove, "Undefined move: missing"},
+		{"move one(Pikachu a)\nend\none(1, 2)", ErrArgumentCount, "expects 1 arguments, got 2"},
+		{"move nothing()\nend\nrelease nothing()", ErrMissingReturn, "does not give a value"},
+		{"move lazy() gives Pikachu\nend\nrelease lazy()", ErrMissingReturn, "without giving back a Pikachu"},
+		{"move name(Eevee s)\nend\nname(5)", ErrTypeMismatch, "must be a Eevee"},
+	}
+
+	for _, tt := range tests {
+		program := NewParser(NewLexer(tt.source).Tokenize()).Parse()
+		_, err := runInterpreterWithOutput(program, "")
+		expectRuntimeError(t, tt.source, err, tt.kind, tt.expected)
+	}
+}
+
+func TestInterpreter_BlockScoping(t *testing.T) {
+	source := `Pikachu hp is 10
+if true then
+Pikachu hp is 99
+release hp
+hp evolves to 100
+release hp
+end
+release hp
+repeat 2 times
+Pikachu fresh is 1
+release fresh
+end`
+	lexer := NewLexer(source)
+	tokens := lexer.Tokenize()
+	parser := NewParser(tokens)
+	program := parser.Parse()
+
+	output, _ := runInterpreterWithOutput(program, "")
+	expected := "99\n100\n10\n1\n1\n"
+	if output != expected {
+		t.Errorf("Expected output %q, got: %q", expected, output)
+	}
+}
+
+func TestInterpreter_ScopeErrors(t *testing.T) {
+	tests := []struct {
+		source   string
+		kind     RuntimeErrorKind
+		expected string
+	}{
+		{"Pikachu hp is 1\nPikachu hp is 2", ErrRedeclaredVariable, "already declared in this scope: hp"},
+		{"if true then\nPikachu inner is 1\nend\nrelease inner", ErrUndefinedVariable, "Undefined variable: inner"},
+		{"move heal(Pikachu hp)\nPikachu hp is 5\nend\nheal(1)", ErrRedeclaredVariable, "already declared in this scope: hp"},
+	}
+
+	for _, tt := range tests {
+		program := NewParser(NewLexer(tt.source).Tokenize()).Parse()
+		_, err := runInterpreterWithOutput(program, "")
+		expectRuntimeError(t, tt.source, err, tt.kind, tt.expected)
+	}
+}
+
+func TestInterpreter_DeclaredTypesAreKept(t *testing.T) {
+	source := `Psyduck speed is 5
+release speed / 2
+speed evolves to 3
+release speed / 2
+Pikachu hp is 7
+release hp / 2`
+	lexer := NewLexer(source)
+	tokens := lexer.Tokenize()
+	parser := NewParser(tokens)
+	program := parser.Parse()
+
+	output, _ := runInterpreterWithOutput(program, "")
+	if output != "2.5\n1.5\n3\n" {
+		t.Errorf("Expected output %q, got: %q", "2.5\n1.5\n3\n", output)
+	}
+}
+
+func TestInterpreter_TypedCatch(t *testing.T) {
+	source := `catch Eevee name from trainer
+catch Psyduck speed from trainer
+release name + " " + speed`
+	lexer := NewLexer(source)
+	tokens := lexer.Tokenize()
+	parser := NewParser(tokens)
+	program := parser.Parse()
+
+	output, _ := runInterpreterWithOutput(program, "Ash Ketchum\n2.5\n")
+	if !strings.Contains(output, "Ash Ketchum 2.500000") {
+		t.Errorf("Expected output to contain 'Ash Ketchum 2.500000', got: %q", output)
 	}
 }
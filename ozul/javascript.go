@@ -0,0 +1,606 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// JSGen generates standalone JavaScript from a program, to run OZUL
+// client-side without the Go binary. The script runs itself under Node.js
+// (`node prog.js`), reading catch input from stdin; loaded any other way it
+// defines ozulProgram.run(io), where io.write receives output and io.read
+// returns the trainer's next line (or null).
+//
+// Values keep the interpreter's semantics: a Pikachu is a BigInt wrapped to
+// 64 bits, so integer division truncates and overflow wraps as in Go, and it
+// becomes a Number wherever it meets a Psyduck.
+type JSGen struct {
+	// Symbol table for variables, one scope per JavaScript block
+	variables *Scope[jsVar]
+
+	// Generated code
+	code []string
+
+	// Current indentation depth
+	indent int
+
+	// How often each name was declared in the current function, so shadowed
+	// variables get names of their own
+	declared map[string]int
+
+	// Counter used to give each repeat loop unique variables
+	loops int
+
+	// Pokemon type the current move gives, "" for none
+	returnType string
+
+	// Type checker whose inferred types drive conversions and formatting
+	checker *Checker
+}
+
+// jsVar is a variable's name in the generated code and its Pokemon type
+type jsVar struct {
+	name        string
+	pokemonType string
+}
+
+// jsReserved are names JavaScript does not allow for variables, or that
+// would change meaning as one
+var jsReserved = map[string]bool{
+	"await": true, "break": true, "case": true, "catch": true, "class": true, "const": true, "continue": true,
+	"debugger": true, "default": true, "delete": true, "do": true, "else": true, "enum": true, "export": true,
+	"extends": true, "false": true, "finally": true, "for": true, "function": true, "if": true, "implements": true,
+	"import": true, "in": true, "instanceof": true, "interface": true, "let": true, "new": true, "null": true,
+	"package": true, "private": true, "protected": true, "public": true, "return": true, "static": true,
+	"super": true, "switch": true, "this": true, "throw": true, "true": true, "try": true, "typeof": true,
+	"var": true, "void": true, "while": true, "with": true, "yield": true,
+	"arguments": true, "eval": true, "undefined": true, "NaN": true, "Infinity": true,
+}
+
+// NewJSGen creates a new JavaScript generator
+func NewJSGen() *JSGen {
+	return &JSGen{
+		variables: NewScope[jsVar](nil),
+		declared:  make(map[string]int),
+		checker:   NewChecker(),
+	}
+}
+
+// GenerateProgram generates the script for the entire program. The program
+// is type checked first; type errors are reported as a panic.
+func (jg *JSGen) GenerateProgram(program *Program) {
+	if errs := jg.checker.Check(program); len(errs) > 0 {
+		messages := make([]string, len(errs))
+		for i, err := range errs {
+			messages[i] = err.Error()
+		}
+		panic("[OZUL JS Error] " + strings.Join(messages, "; "))
+	}
+
+	jg.code = append(jg.code, strings.Split(strings.Trim(jsPrologue, "\n"), "\n")...)
+	jg.code = append(jg.code, "")
+	jg.indent = 1
+	jg.emit("// run executes the program, writing output to io.write and reading")
+	jg.emit("// catch input from io.read")
+	jg.emit("function run($io = $stdio()) {")
+	jg.indent++
+
+	// Moves become nested functions named move$<name>, so they are hoisted
+	// and never clash with variables
+	for _, stmt := range program.Statements {
+		if fn, ok := stmt.(*FunctionDecl); ok {
+			jg.generateFunction(fn)
+		}
+	}
+	for _, stmt := range program.Statements {
+		if _, ok := stmt.(*FunctionDecl); ok {
+			continue
+		}
+		jg.generateStatement(stmt)
+	}
+
+	jg.indent--
+	jg.emit("}")
+	jg.code = append(jg.code, "")
+	jg.code = append(jg.code, strings.Split(strings.Trim(jsEpilogue, "\n"), "\n")...)
+}
+
+// emit appends a line of code at the current indentation depth
+func (jg *JSGen) emit(format string, args ...interface{}) {
+	jg.code = append(jg.code, strings.Repeat("  ", jg.indent)+fmt.Sprintf(format, args...))
+}
+
+// declare gives a variable a JavaScript name in the current scope
+func (jg *JSGen) declare(name, pokemonType string) jsVar {
+	v := jsVar{name: name, pokemonType: pokemonType}
+	if jsReserved[name] {
+		v.name += "$"
+	}
+	jg.declared[name]++
+	if n := jg.declared[name]; n > 1 {
+		v.name = fmt.Sprintf("%s$%d", v.name, n)
+	}
+	if err := jg.variables.Declare(name, v); err != nil {
+		panic(fmt.Sprintf("[OZUL JS Error] Variable %s already declared in this scope!", name))
+	}
+	return v
+}
+
+func (jg *JSGen) lookup(name string) jsVar {
+	v, ok := jg.variables.Lookup(name)
+	if !ok {
+		panic(fmt.Sprintf("[OZUL JS Error] Variable %s not declared!", name))
+	}
+	return v
+}
+
+// generateFunction generates a nested function for a move, with its own
+// variables so it cannot see the top level's
+func (jg *JSGen) generateFunction(fn *FunctionDecl) {
+	outer, outerDeclared := jg.variables, jg.declared
+	jg.variables, jg.declared = NewScope[jsVar](nil), make(map[string]int)
+	jg.returnType = fn.ReturnType
+
+	params := make([]string, len(fn.Params))
+	for i, param := range fn.Params {
+		params[i] = jg.declare(param.Name, param.PokemonType).name
+	}
+	jg.emit("function move$%s(%s) {", fn.Name, strings.Join(params, ", "))
+	jg.generateBlock(fn.Body)
+	jg.emit("}")
+	jg.code = append(jg.code, "")
+
+	jg.variables, jg.declared = outer, outerDeclared
+	jg.returnType = ""
+}
+
+// generateStatement generates code for a single statement
+func (jg *JSGen) generateStatement(stmt Statement) {
+	switch s := stmt.(type) {
+	case *DeclarationStmt:
+		value := jg.convert(s.Value, s.PokemonType)
+		jg.emit("let %s = %s;", jg.declare(s.Name, s.PokemonType).name, value)
+	case *AssignmentStmt:
+		v := jg.lookup(s.Name)
+		jg.emit("%s = %s;", v.name, jg.convert(s.Value, v.pokemonType))
+	case *ReleaseStmt:
+		jg.emit("$io.write(%s + \"\\n\");", jg.format(s.Value))
+	case *CatchStmt:
+		jg.generateCatch(s)
+	case *IfStmt:
+		jg.generateIf(s, "if")
+		jg.emit("}")
+	case *WhileStmt:
+		jg.emit("while (%s) {", jg.condition(s.Condition))
+		jg.generateBlock(s.Body)
+		jg.emit("}")
+	case *RepeatStmt:
+		// The count is evaluated once, before the first iteration
+		jg.loops++
+		index, count := fmt.Sprintf("$i%d", jg.loops), fmt.Sprintf("$n%d", jg.loops)
+		jg.emit("for (let %s = 0n, %s = %s; %s < %s; %s++) {", index, count, jg.generateExpression(s.Count), index, count, index)
+		jg.generateBlock(s.Body)
+		jg.emit("}")
+	case *ReturnStmt:
+		if s.Value == nil {
+			jg.emit("return;")
+		} else {
+			jg.emit("return %s;", jg.convert(s.Value, jg.returnType))
+		}
+	case *ExpressionStmt:
+		jg.emit("%s;", jg.generateExpression(s.Expr))
+	}
+}
+
+// generateIf generates an if statement, writing an "else if" chain the way
+// it was written in OZUL. The caller closes the last block.
+func (jg *JSGen) generateIf(stmt *IfStmt, keyword string) {
+	jg.emit("%s (%s) {", keyword, jg.condition(stmt.Condition))
+	jg.generateBlock(stmt.Then)
+	if len(stmt.Else) == 1 {
+		if elseIf, ok := stmt.Else[0].(*IfStmt); ok {
+			jg.generateIf(elseIf, "} else if")
+			return
+		}
+	}
+	if len(stmt.Else) > 0 {
+		jg.emit("} else {")
+		jg.generateBlock(stmt.Else)
+	}
+}
+
+// generateCatch reads a line from the trainer. A typed catch declares a new
+// variable; an untyped one reads into a visible variable, or else declares a
+// new Pikachu.
+func (jg *JSGen) generateCatch(stmt *CatchStmt) {
+	v, exists := jg.variables.Lookup(stmt.Variable)
+	declare := stmt.PokemonType != "" || !exists
+	pokemonType := v.pokemonType
+	if declare {
+		pokemonType = stmt.PokemonType
+		if pokemonType == "" {
+			pokemonType = "Pikachu"
+		}
+	}
+
+	var value string
+	switch pokemonType {
+	case "Pikachu":
+		value = fmt.Sprintf("$catchInt($io, %s, %d)", jsQuote(stmt.Variable), stmt.Pos().Start.Line)
+	case "Psyduck":
+		value = fmt.Sprintf("$catchFloat($io, %s, %d)", jsQuote(stmt.Variable), stmt.Pos().Start.Line)
+	case "Eevee":
+		value = fmt.Sprintf("$catchString($io, %s)", jsQuote(stmt.Variable))
+	default:
+		panic(fmt.Sprintf("[OZUL JS Error] Cannot catch into %s variable %s", pokemonType, stmt.Variable))
+	}
+	if declare {
+		jg.emit("let %s = %s;", jg.declare(stmt.Variable, pokemonType).name, value)
+	} else {
+		jg.emit("%s = %s;", v.name, value)
+	}
+}
+
+// generateBlock generates the statements of a nested block one level deeper,
+// in a new scope that ends with the JavaScript block
+func (jg *JSGen) generateBlock(stmts []Statement) {
+	outer := jg.variables
+	jg.variables = NewScope(outer)
+	jg.indent++
+	for _, stmt := range stmts {
+		jg.generateStatement(stmt)
+	}
+	jg.indent--
+	jg.variables = outer
+}
+
+// condition generates the condition of an if or a loop, without the
+// parentheses an operator would add around it
+func (jg *JSGen) condition(expr Expression) string {
+	code := jg.generateExpression(expr)
+	if _, ok := expr.(*BinaryExpr); ok && strings.HasPrefix(code, "(") {
+		return code[1 : len(code)-1]
+	}
+	return code
+}
+
+// generateExpression generates code for expressions
+func (jg *JSGen) generateExpression(expr Expression) string {
+	switch e := expr.(type) {
+	case *NumberLiteral:
+		return fmt.Sprintf("%dn", e.Value)
+	case *FloatLiteral:
+		return strconv.FormatFloat(e.Value, 'g', -1, 64)
+	case *InterpolatedString:
+		return jg.generateExpression(e.Value)
+	case *StringLiteral:
+		return jsQuote(e.Value)
+	case *BooleanLiteral:
+		return fmt.Sprintf("%t", e.Value)
+	case *Identifier:
+		return jg.lookup(e.Name).name
+	case *CallExpr:
+		fn := jg.checker.functions[e.Name]
+		args := make([]string, len(e.Args))
+		for i, arg := range e.Args {
+			args[i] = jg.convert(arg, fn.Params[i].PokemonType)
+		}
+		return fmt.Sprintf("move$%s(%s)", fn.Name, strings.Join(args, ", "))
+	case *UnaryExpr:
+		if e.Operator == "not" {
+			return fmt.Sprintf("!%s", jg.generateExpression(e.Operand))
+		}
+		if e.Operator == "-" {
+			if jg.checker.TypeOf(e.Operand) == "Psyduck" {
+				return fmt.Sprintf("(-%s)", jg.generateExpression(e.Operand))
+			}
+			return fmt.Sprintf("$int(-%s)", jg.generateExpression(e.Operand))
+		}
+		panic(fmt.Sprintf("[OZUL JS Error] Unknown operator: %s", e.Operator))
+	case *BinaryExpr:
+		return jg.generateBinary(e)
+	default:
+		panic("[OZUL JS Error] Unknown expression type.")
+	}
+}
+
+// generateBinary generates operators, following the interpreter's rules:
+// any Eevee makes + a concatenation, any Psyduck makes arithmetic and
+// comparisons floating point, and two Pikachus stay integers
+func (jg *JSGen) generateBinary(e *BinaryExpr) string {
+	leftType, rightType := jg.checker.TypeOf(e.Left), jg.checker.TypeOf(e.Right)
+	switch e.Operator {
+	case "and":
+		return fmt.Sprintf("(%s && %s)", jg.generateExpression(e.Left), jg.generateExpression(e.Right))
+	case "or":
+		return fmt.Sprintf("(%s || %s)", jg.generateExpression(e.Left), jg.generateExpression(e.Right))
+	case "==", "!=", "<", "<=", ">", ">=":
+		op := map[string]string{"==": "===", "!=": "!=="}[e.Operator]
+		if op == "" {
+			op = e.Operator
+		}
+		if leftType == "Eevee" && op != "===" && op != "!==" {
+			// Order strings by bytes, like Go, rather than by UTF-16
+			return fmt.Sprintf("($compare(%s, %s) %s 0)", jg.generateExpression(e.Left), jg.generateExpression(e.Right), op)
+		}
+		numberType := leftType
+		if leftType == "Psyduck" || rightType == "Psyduck" {
+			numberType = "Psyduck"
+		}
+		return fmt.Sprintf("(%s %s %s)", jg.convert(e.Left, numberType), op, jg.convert(e.Right, numberType))
+	}
+
+	if e.Operator == "+" && (leftType == "Eevee" || rightType == "Eevee") {
+		return fmt.Sprintf("(%s + %s)", jg.toString(e.Left), jg.toString(e.Right))
+	}
+	if leftType == "Psyduck" || rightType == "Psyduck" {
+		left, right := jg.convert(e.Left, "Psyduck"), jg.convert(e.Right, "Psyduck")
+		switch e.Operator {
+		case "/":
+			return fmt.Sprintf("$fdiv(%s, %s, %d)", left, right, e.Pos().Start.Line)
+		case "%":
+			return fmt.Sprintf("$fmod(%s, %s, %d)", left, right, e.Pos().Start.Line)
+		case "**":
+			return fmt.Sprintf("Math.pow(%s, %s)", left, right)
+		}
+		return fmt.Sprintf("(%s %s %s)", left, e.Operator, right)
+	}
+	left, right := jg.generateExpression(e.Left), jg.generateExpression(e.Right)
+	switch e.Operator {
+	case "/":
+		return fmt.Sprintf("$div(%s, %s, %d)", left, right, e.Pos().Start.Line)
+	case "%":
+		return fmt.Sprintf("$mod(%s, %s, %d)", left, right, e.Pos().Start.Line)
+	case "**":
+		return fmt.Sprintf("$pow(%s, %s, %d)", left, right, e.Pos().Start.Line)
+	}
+	return fmt.Sprintf("$int(%s %s %s)", left, e.Operator, right)
+}
+
+// convert generates an expression where a value of pokemonType is expected,
+// turning a Pikachu into a Number where a Psyduck is expected
+func (jg *JSGen) convert(expr Expression, pokemonType string) string {
+	if pokemonType == "Psyduck" && jg.checker.TypeOf(expr) == "Pikachu" {
+		if literal, ok := expr.(*NumberLiteral); ok {
+			return fmt.Sprintf("%d", literal.Value)
+		}
+		return fmt.Sprintf("Number(%s)", jg.generateExpression(expr))
+	}
+	return jg.generateExpression(expr)
+}
+
+// toString generates an expression for string concatenation, formatting
+// numbers the way the interpreter does
+func (jg *JSGen) toString(expr Expression) string {
+	code := jg.generateExpression(expr)
+	switch jg.checker.TypeOf(expr) {
+	case "Pikachu", "Voltorb":
+		return fmt.Sprintf("String(%s)", code)
+	case "Psyduck":
+		return fmt.Sprintf("$fixed(%s)", code)
+	}
+	return code
+}
+
+// format generates the text release writes for an expression
+func (jg *JSGen) format(expr Expression) string {
+	code := jg.generateExpression(expr)
+	switch pokemonType := jg.checker.TypeOf(expr); pokemonType {
+	case "Pikachu", "Voltorb":
+		return fmt.Sprintf("String(%s)", code)
+	case "Psyduck":
+		return fmt.Sprintf("$format(%s)", code)
+	case "Eevee":
+		return code
+	default:
+		panic(fmt.Sprintf("[OZUL JS Error] Unknown type for release: %s", pokemonType))
+	}
+}
+
+// jsQuote writes a JavaScript string literal
+func jsQuote(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			sb.WriteByte('\\')
+			sb.WriteRune(r)
+		case r == '\n':
+			sb.WriteString(`\n`)
+		case r == '\t':
+			sb.WriteString(`\t`)
+		case r < ' ' || r == 0x7f || r == 0x2028 || r == 0x2029:
+			sb.WriteString(fmt.Sprintf(`\u%04x`, r))
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// GetCode returns the generated JavaScript as a string
+func (jg *JSGen) GetCode() string {
+	return strings.Join(jg.code, "\n") + "\n"
+}
+
+// jsPrologue is the runtime every script starts with. Its names start with
+// $ so OZUL variables can never hide them.
+const jsPrologue = `
+// Generated by the OZUL compiler
+(function (root) {
+  "use strict";
+
+  class OzulError extends Error {}
+
+  function $fail(message, line) {
+    throw new OzulError(` + "`[OZUL Error] ${message} (line ${line})`" + `);
+  }
+
+  // Pikachu arithmetic wraps around at 64 bits, like Go's int
+  const $int = (v) => BigInt.asIntN(64, v);
+
+  function $div(a, b, line) {
+    if (b === 0n) $fail("Division by zero.", line);
+    return $int(a / b);
+  }
+
+  function $fdiv(a, b, line) {
+    if (b === 0) $fail("Division by zero.", line);
+    return a / b;
+  }
+
+  function $mod(a, b, line) {
+    if (b === 0n) $fail("Division by zero.", line);
+    return a % b;
+  }
+
+  function $fmod(a, b, line) {
+    if (b === 0) $fail("Division by zero.", line);
+    return a % b;
+  }
+
+  // $pow squares and multiplies, wrapping at every step, so a large power
+  // never builds a huge BigInt
+  function $pow(a, b, line) {
+    if (b < 0n) $fail("` + negativeExponent + `", line);
+    let result = 1n;
+    for (; b > 0n; b >>= 1n) {
+      if (b & 1n) result = $int(result * a);
+      a = $int(a * a);
+    }
+    return result;
+  }
+
+  // $compare orders strings by code point, which is how Go orders their bytes
+  function $compare(a, b) {
+    if (a === b) return 0;
+    const x = Array.from(a, (c) => c.codePointAt(0));
+    const y = Array.from(b, (c) => c.codePointAt(0));
+    for (let i = 0; i < x.length && i < y.length; i++) {
+      if (x[i] !== y[i]) return x[i] < y[i] ? -1 : 1;
+    }
+    return x.length < y.length ? -1 : x.length > y.length ? 1 : 0;
+  }
+
+  // $format writes a Psyduck for release, like the interpreter (Go %v)
+  function $format(x) {
+    if (Number.isNaN(x)) return "NaN";
+    if (!Number.isFinite(x)) return x > 0 ? "+Inf" : "-Inf";
+    if (x === 0) return Object.is(x, -0) ? "-0" : "0";
+    const [mantissa, exponent] = x.toExponential().split("e");
+    const exp = Number(exponent);
+    if (exp < -4 || exp >= 6) {
+      return mantissa + "e" + (exp < 0 ? "-" : "+") + String(Math.abs(exp)).padStart(2, "0");
+    }
+    return String(x);
+  }
+
+  // $fixed writes a Psyduck joined to an Eevee, like Go's %f: six decimals,
+  // with exact halves rounded to even
+  function $fixed(x) {
+    if (Number.isNaN(x)) return "NaN";
+    if (!Number.isFinite(x)) return x > 0 ? "+Inf" : "-Inf";
+    const sign = x < 0 || Object.is(x, -0) ? "-" : "";
+    x = Math.abs(x);
+    if (x >= 1e21) return sign + BigInt(x).toString() + ".000000";
+    let fixed = x.toFixed(6);
+    const exact = x.toFixed(100);
+    const dot = exact.indexOf(".");
+    if (/^50*$/.test(exact.slice(dot + 7)) && Number(exact[dot + 6]) % 2 === 0) {
+      fixed = exact.slice(0, dot + 7);
+    }
+    return sign + fixed;
+  }
+
+  function $read(io, name) {
+    io.write(` + "`Enter value for ${name}: `" + `);
+    const line = io.read(name);
+    return line == null ? "" : line.replace(/\r?\n$/, "");
+  }
+
+  function $catchInt(io, name, line) {
+    const input = $read(io, name);
+    const text = input.trim();
+    if (/^[+-]?\d+$/.test(text)) {
+      const v = BigInt(text);
+      if ($int(v) === v) return v;
+    }
+    $fail(` + "`Expected a Pikachu (whole number) from the trainer, got ${JSON.stringify(input)}`" + `, line);
+  }
+
+  function $catchFloat(io, name, line) {
+    const input = $read(io, name);
+    const text = input.trim();
+    if (/^[+-]?(\d+\.?\d*|\.\d+)([eE][+-]?\d+)?$/.test(text)) return Number(text);
+    const special = { inf: Infinity, "+inf": Infinity, "-inf": -Infinity, infinity: Infinity, "+infinity": Infinity, "-infinity": -Infinity, nan: NaN };
+    if (text.toLowerCase() in special) return special[text.toLowerCase()];
+    $fail(` + "`Expected a Psyduck (number) from the trainer, got ${JSON.stringify(input)}`" + `, line);
+  }
+
+  function $catchString(io, name) {
+    return $read(io, name);
+  }
+
+  // $stdio is the default io: standard input and output under Node.js, the
+  // console and prompt() in a browser
+  function $stdio() {
+    if (typeof process !== "undefined" && process.stdout) {
+      const fs = require("fs");
+      return {
+        write: (text) => process.stdout.write(text),
+        read: () => {
+          const bytes = [];
+          const buf = Buffer.alloc(1);
+          for (;;) {
+            let n;
+            try {
+              n = fs.readSync(0, buf, 0, 1, null);
+            } catch (e) {
+              if (e.code === "EAGAIN") continue;
+              if (e.code === "EOF") n = 0;
+              else throw e;
+            }
+            if (n === 0) return bytes.length ? Buffer.from(bytes).toString() : null;
+            if (buf[0] === 10) return Buffer.from(bytes).toString();
+            bytes.push(buf[0]);
+          }
+        },
+      };
+    }
+    let pending = "";
+    return {
+      write: (text) => {
+        const lines = (pending + text).split("\n");
+        pending = lines.pop();
+        lines.forEach((line) => console.log(line));
+      },
+      read: (name) => {
+        const question = pending || ` + "`Enter value for ${name}: `" + `;
+        pending = "";
+        return prompt(question);
+      },
+    };
+  }
+`
+
+// jsEpilogue runs or exports the program
+const jsEpilogue = `
+  if (typeof module !== "undefined" && module.exports) {
+    module.exports = { run, OzulError };
+    if (require.main === module) {
+      try {
+        run();
+      } catch (e) {
+        if (!(e instanceof OzulError)) throw e;
+        process.stderr.write(e.message + "\n");
+        process.exitCode = 1;
+      }
+    }
+  } else {
+    root.ozulProgram = { run, OzulError };
+  }
+})(typeof globalThis !== "undefined" ? globalThis : this);
+`
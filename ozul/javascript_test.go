@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// TestJSGen_InjectedInput runs a script the way a web page would, with its
+// own input and output instead of the console
+func TestJSGen_InjectedInput(t *testing.T) {
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node not found")
+	}
+	code := generateJS(parseChecked(t, `catch Pikachu age from trainer
+catch Eevee name from trainer
+release name + " is " + age
+catch Psyduck height from trainer
+release height`))
+	script := code + `
+const output = [];
+const input = ["41", "Misty", "1.5"];
+const io = { write: (text) => output.push(text), read: (name) => input.shift() ?? null };
+ozulProgram.run(io);
+console.log(JSON.stringify(output));
+try {
+  ozulProgram.run({ write: () => {}, read: () => "tall" });
+} catch (e) {
+  console.log(e instanceof ozulProgram.OzulError, e.message);
+}
+`
+	// Run as a plain script, without Node's module or process, like a
+	// browser would
+	cmd := exec.Command(node, "-e", "require('vm').runInNewContext(require('fs').readFileSync(0, 'utf8'), { console })")
+	cmd.Stdin = strings.NewReader(script)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("node failed: %v\n%s", err, out)
+	}
+	expected := `["Enter value for age: ","Enter value for name: ","Misty is 41\n","Enter value for height: ","1.5\n"]
+true [OZUL Error] Expected a Pikachu (whole number) from the trainer, got "tall" (line 1)
+`
+	if string(out) != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, out)
+	}
+}
@@ -1,36 +1,138 @@
 package main
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
 	"unicode"
+	"unicode/utf8"
+)
+
+// ColumnUnit is what the lexer counts columns in
+type ColumnUnit int
+
+const (
+	// ColumnRunes counts one column per Unicode character (code point)
+	ColumnRunes ColumnUnit = iota
+	// ColumnUTF16 counts UTF-16 code units, as editors speaking the Language
+	// Server Protocol do: characters beyond U+FFFF, like most emoji, take two
+	ColumnUTF16
 )
 
 type Lexer struct {
-	source string
-	pos    int
-	line   int
-	column int
-	ch     rune
+	source  string
+	offset  int // byte offset of ch
+	pos     int // byte offset of the character after ch
+	line    int
+	column  int
+	ch      rune
+	invalid bool // ch stands for a byte that is not valid UTF-8
+	onLine  bool // a token other than a newline has been read on this line
+	unit    ColumnUnit
+	errors  []PokemonError
+
+	// interpolations counts the strings whose {...} the lexer is inside, so a
+	// '}' knows to carry on with the string
+	interpolations int
 }
 
 func NewLexer(input string) *Lexer {
 	l := &Lexer{source: input, line: 1, column: 0}
+	// Editors on Windows often start UTF-8 files with a byte order mark
+	l.pos = len(input) - len(strings.TrimPrefix(input, "\uFEFF"))
 	l.readChar()
 	return l
 }
 
+// SetColumnUnit chooses what columns are counted in; the default is
+// ColumnRunes. Call it before Tokenize.
+func (l *Lexer) SetColumnUnit(unit ColumnUnit) {
+	l.unit = unit
+}
+
+// Errors returns the problems found in the source text itself, like bytes
+// that are not UTF-8
+func (l *Lexer) Errors() []PokemonError {
+	return l.errors
+}
+
 func (l *Lexer) readChar() {
-	if l.pos >= len(l.source) {
-		l.ch = 0
-	} else {
-		l.ch = rune(l.source[l.pos])
-	}
+	// Moving past a newline starts the next line
 	if l.ch == '\n' {
 		l.line++
-		l.column = 0
+		l.column = 1
 	} else {
-		l.column++
+		l.column += l.width(l.ch)
+	}
+	l.offset = l.pos
+	if l.pos >= len(l.source) {
+		l.ch, l.invalid = 0, false
+		return
+	}
+	r, size := utf8.DecodeRuneInString(l.source[l.pos:])
+	l.ch, l.invalid = r, r == utf8.RuneError && size == 1
+	l.pos += size
+	if l.invalid {
+		span := Span{Position{l.line, l.column}, Position{l.line, l.column + 1}}
+		l.errors = append(l.errors, NewPokemonError("syntax", span,
+			fmt.Sprintf("invalid UTF-8 byte 0x%02x (save the file as UTF-8)", l.source[l.offset])))
 	}
-	l.pos++
+}
+
+// illegalHints explain characters that people reach for from other languages
+var illegalHints = map[string]string{
+	"=": " (declare with 'is', assign with 'evolves to', compare with '==')",
+	"!": " (write 'not', or '!=' to compare)",
+	"&": " (write 'and')",
+	"|": " (write 'or')",
+	"'": " (strings use double quotes)",
+	";": " (put each statement on a line of its own)",
+	"{": " (blocks end with 'end')",
+	"}": " (blocks end with 'end')",
+	"^": " (write '**' for a power)",
+}
+
+// quoteText shows characters in an error message, spelling out the ones
+// that would not show up
+func quoteText(text string) string {
+	r, _ := utf8.DecodeRuneInString(text)
+	switch {
+	case r == '\'':
+		return `"` + text + `"`
+	case unicode.IsPrint(r):
+		return "'" + text + "'"
+	}
+	return fmt.Sprintf("U+%04X", r)
+}
+
+// errorFrom records a syntax error covering the source from start to the
+// current character
+func (l *Lexer) errorFrom(start Position, format string, args ...interface{}) {
+	span := Span{start, Position{l.line, l.column}}
+	l.errors = append(l.errors, NewPokemonError("syntax", span, fmt.Sprintf(format, args...)))
+}
+
+// width is how many columns a character takes up
+func (l *Lexer) width(ch rune) int {
+	if l.unit == ColumnUTF16 && ch > 0xFFFF {
+		return 2
+	}
+	return 1
+}
+
+// eof reports whether the whole source has been read. A zero byte in the
+// middle of it is just an unexpected character.
+func (l *Lexer) eof() bool {
+	return l.offset >= len(l.source)
+}
+
+// peekChar returns the character after the current one without consuming it
+func (l *Lexer) peekChar() rune {
+	if l.pos >= len(l.source) {
+		return 0
+	}
+	r, _ := utf8.DecodeRuneInString(l.source[l.pos:])
+	return r
 }
 
 func (l *Lexer) Tokenize() []Token {
@@ -46,12 +148,22 @@ func (l *Lexer) Tokenize() []Token {
 }
 
 func (l *Lexer) nextToken() Token {
+	var comments []Comment
 	l.skipWhitespace()
+	for l.ch == '#' {
+		comments = append(comments, l.readComment())
+		// A line holding only comments is not a line of code, so its newline
+		// goes along with the comments to the next token
+		if l.ch == '\n' && !l.onLine {
+			l.readChar()
+		}
+		l.skipWhitespace()
+	}
 
-	tok := Token{Line: l.line, Column: l.column}
+	tok := Token{Line: l.line, Column: l.column, Leading: comments}
 
 	switch {
-	case l.ch == 0:
+	case l.eof():
 		tok.Type = EOF
 		tok.Value = ""
 	case l.ch == '+':
@@ -62,6 +174,11 @@ func (l *Lexer) nextToken() Token {
 		tok.Type = MINUS
 		tok.Value = "-"
 		l.readChar()
+	case l.ch == '*' && l.peekChar() == '*':
+		tok.Type = POWER
+		tok.Value = "**"
+		l.readChar()
+		l.readChar()
 	case l.ch == '*':
 		tok.Type = MULTIPLY
 		tok.Value = "*"
@@ -70,20 +187,71 @@ func (l *Lexer) nextToken() Token {
 		tok.Type = DIVIDE
 		tok.Value = "/"
 		l.readChar()
+	case l.ch == '%':
+		tok.Type = MODULO
+		tok.Value = "%"
+		l.readChar()
+	case l.ch == '(':
+		tok.Type = LPAREN
+		tok.Value = "("
+		l.readChar()
+	case l.ch == ')':
+		tok.Type = RPAREN
+		tok.Value = ")"
+		l.readChar()
+	case l.ch == ',':
+		tok.Type = COMMA
+		tok.Value = ","
+		l.readChar()
+	case l.ch == '=' && l.peekChar() == '=':
+		tok.Type = EQ
+		tok.Value = "=="
+		l.readChar()
+		l.readChar()
+	case l.ch == '!' && l.peekChar() == '=':
+		tok.Type = NOT_EQ
+		tok.Value = "!="
+		l.readChar()
+		l.readChar()
+	case l.ch == '<':
+		tok.Type = LT
+		tok.Value = "<"
+		l.readChar()
+		if l.ch == '=' {
+			tok.Type = LTE
+			tok.Value = "<="
+			l.readChar()
+		}
+	case l.ch == '>':
+		tok.Type = GT
+		tok.Value = ">"
+		l.readChar()
+		if l.ch == '=' {
+			tok.Type = GTE
+			tok.Value = ">="
+			l.readChar()
+		}
 	case l.ch == '\n':
 		tok.Type = NEWLINE
 		tok.Value = "\n"
+		// A {...} left open ends with its line; the parser reports it
+		l.interpolations = 0
 		l.readChar()
+	case strings.HasPrefix(l.source[l.offset:], `"""`):
+		tok.Type = RAW_STRING
+		tok.Value = l.readRawString()
 	case l.ch == '"':
-		tok.Type = STRING
-		tok.Value = l.readString()
-	case unicode.IsLetter(l.ch):
+		tok.Type, tok.Value = l.readString(STRING_START, STRING)
+	case l.ch == '}' && l.interpolations > 0:
+		l.interpolations--
+		tok.Type, tok.Value = l.readString(STRING_MIDDLE, STRING_END)
+	case isIdentStart(l.ch):
 		startCol := l.column
 		ident := l.readIdentifier()
 		tok.Value = ident
 		tok.Column = startCol
 		tok.Type = lookupIdentOrKeyword(ident)
-	case unicode.IsDigit(l.ch):
+	case isDigit(l.ch):
 		num, isFloat := l.readNumber()
 		if isFloat {
 			tok.Type = FLOAT
@@ -92,51 +260,240 @@ func (l *Lexer) nextToken() Token {
 		}
 		tok.Value = num
 	default:
-		tok.Type = EOF
-		l.readChar()
+		// A run of the same character, like "&&", is one mistake
+		ch, start := l.ch, l.offset
+		for l.ch == ch && !l.eof() {
+			l.readChar()
+		}
+		tok.Type = ILLEGAL
+		tok.Value = l.source[start:l.offset]
+		what := "character"
+		if utf8.RuneCountInString(tok.Value) > 1 {
+			what = "characters"
+		}
+		l.errorFrom(Position{tok.Line, tok.Column}, "unexpected %s %s%s", what, quoteText(tok.Value), illegalHints[string(ch)])
 	}
+	tok.EndLine, tok.EndColumn = l.line, l.column
+	l.onLine = tok.Type != NEWLINE
 	return tok
 }
 
+// readComment reads a comment starting at '#'. A line comment runs to the
+// end of the line; a block comment runs to its "]#" and may span lines, or
+// to the end of the source when it is never closed.
+func (l *Lexer) readComment() Comment {
+	start := l.offset
+	comment := Comment{Span: Span{Start: Position{l.line, l.column}}}
+	if l.peekChar() == '[' {
+		l.readChar() // '#'
+		l.readChar() // '['
+		for !l.eof() && !(l.ch == ']' && l.peekChar() == '#') {
+			l.readChar()
+		}
+		if !l.eof() {
+			l.readChar() // ']'
+			l.readChar() // '#'
+		}
+	} else {
+		comment.Doc = l.peekChar() == '#'
+		for l.ch != '\n' && !l.eof() {
+			l.readChar()
+		}
+	}
+	comment.Text = l.source[start:l.offset]
+	comment.End = Position{l.line, l.column}
+	if !strings.HasPrefix(comment.Text, "#[") {
+		// Trailing spaces (and the \r of a \r\n) are not part of a line comment
+		comment.Text = strings.TrimRight(comment.Text, " \t\r")
+		comment.End.Column = comment.Start.Column + l.columns(comment.Text)
+	}
+	return comment
+}
+
+// columns is how many columns text takes up
+func (l *Lexer) columns(text string) int {
+	n := 0
+	for _, r := range text {
+		n += l.width(r)
+	}
+	return n
+}
+
+// skipWhitespace skips spaces, and bytes that are not UTF-8, which have
+// already been reported
 func (l *Lexer) skipWhitespace() {
-	for l.ch == ' ' || l.ch == '\t' || l.ch == '\r' {
+	for l.ch == ' ' || l.ch == '\t' || l.ch == '\r' || l.invalid {
 		l.readChar()
 	}
 }
 
+// isIdentStart reports whether a name can start with r: any Unicode letter
+// (categories Lu, Ll, Lt, Lm and Lo), so "Pokémon" and "名前" are names
+func isIdentStart(r rune) bool {
+	return unicode.IsLetter(r)
+}
+
+// isIdentPart reports whether r can appear in a name after its first
+// character: letters, decimal digits (Nd), the combining marks that accents
+// and many scripts are written with (Mn and Mc), and _
+func isIdentPart(r rune) bool {
+	return unicode.IsLetter(r) || unicode.Is(unicode.Nd, r) || unicode.In(r, unicode.Mn, unicode.Mc) || r == '_'
+}
+
+// isDigit reports whether r is an ASCII digit; numbers are only written with
+// 0 to 9
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+func isHexDigit(r rune) bool {
+	return isDigit(r) || r >= 'a' && r <= 'f' || r >= 'A' && r <= 'F'
+}
+
 func (l *Lexer) readIdentifier() string {
-	start := l.pos - 1
-	for unicode.IsLetter(l.ch) || unicode.IsDigit(l.ch) || l.ch == '_' {
+	start := l.offset
+	for isIdentPart(l.ch) {
 		l.readChar()
 	}
-	return l.source[start : l.pos-1]
+	return l.source[start:l.offset]
 }
 
 func (l *Lexer) readNumber() (string, bool) {
-	start := l.pos - 1
+	start := l.offset
 	isFloat := false
-	for unicode.IsDigit(l.ch) {
+	for isDigit(l.ch) {
 		l.readChar()
 	}
 	if l.ch == '.' {
 		isFloat = true
 		l.readChar()
-		for unicode.IsDigit(l.ch) {
+		for isDigit(l.ch) {
+			l.readChar()
+		}
+	}
+	return l.source[start:l.offset], isFloat
+}
+
+// readString reads the text of a string, from its opening quote or from the
+// '}' that ends one of its interpolations, decoding escape sequences. The
+// text ends at the closing quote, giving the done token type, or at the '{'
+// of an interpolation, giving open; the tokens of the expression inside the
+// braces come next. A string must end on the line it started on.
+func (l *Lexer) readString(open, done TokenType) (TokenType, string) {
+	start := Position{l.line, l.column}
+	l.readChar() // '"' or '}'
+	var sb strings.Builder
+	for {
+		switch {
+		case l.ch == '"':
 			l.readChar()
+			return done, sb.String()
+		case l.ch == '{':
+			l.readChar()
+			l.interpolations++
+			return open, sb.String()
+		case l.ch == '\n' || l.eof():
+			l.errorFrom(start, `unterminated string (close it with " on the same line, or use """ for text over several lines)`)
+			return done, sb.String()
+		case l.ch == '\\':
+			l.readEscape(&sb)
+		case l.invalid:
+			l.readChar() // already reported
+		case l.ch == 0:
+			// Generated C and LLVM code ends strings at a zero byte
+			at := Position{l.line, l.column}
+			l.readChar()
+			l.errorFrom(at, "unexpected character U+0000 in a string")
+		default:
+			sb.WriteRune(l.ch)
+			l.readChar()
+		}
+	}
+}
+
+// readEscape decodes the escape sequence at a backslash: \n, \t, \r, \\, \",
+// \{ and \} for themselves, or \u{...} for any character by its hex code point
+func (l *Lexer) readEscape(sb *strings.Builder) {
+	start, from := Position{l.line, l.column}, l.offset
+	l.readChar() // '\\'
+	switch l.ch {
+	case 'n':
+		sb.WriteByte('\n')
+	case 't':
+		sb.WriteByte('\t')
+	case 'r':
+		sb.WriteByte('\r')
+	case '\\', '"', '{', '}':
+		sb.WriteRune(l.ch)
+	case 'u':
+		l.readChar()
+		l.readCodePoint(sb, start)
+		return
+	case '\n':
+		return // the string is unterminated, which readString reports
+	default:
+		if l.eof() {
+			return
 		}
+		l.readChar()
+		l.errorFrom(start, `unknown escape sequence %s (write \\ for a backslash)`, l.source[from:l.offset])
+		return
 	}
-	return l.source[start : l.pos-1], isFloat
+	l.readChar()
 }
 
-func (l *Lexer) readString() string {
-	l.readChar() // skip opening quote
-	start := l.pos - 1
-	for l.ch != '"' && l.ch != 0 {
+// readCodePoint reads the {...} of a \u{...} escape
+func (l *Lexer) readCodePoint(sb *strings.Builder, start Position) {
+	if l.ch != '{' {
+		l.errorFrom(start, `\u needs a hex code point in braces, like \u{1F525}`)
+		return
+	}
+	l.readChar()
+	digits := l.offset
+	for isHexDigit(l.ch) {
 		l.readChar()
 	}
-	str := l.source[start : l.pos-1]
-	l.readChar() // skip closing quote
-	return str
+	hex := l.source[digits:l.offset]
+	if l.ch != '}' || hex == "" || len(hex) > 6 {
+		l.errorFrom(start, `\u needs a hex code point in braces, like \u{1F525}`)
+		return
+	}
+	l.readChar()
+	code, _ := strconv.ParseUint(hex, 16, 32)
+	// Generated C and LLVM code ends strings at a zero byte, so U+0000 is out
+	if r := rune(code); r != 0 && utf8.ValidRune(r) {
+		sb.WriteRune(r)
+		return
+	}
+	l.errorFrom(start, `\u{%s} is not a character a string can hold`, hex)
+}
+
+// unterminatedRawString is the error for a """ string that is never closed
+const unterminatedRawString = `unterminated string (close it with """)`
+
+// readRawString reads a """ string: everything up to the next """, taken
+// as written, so a string can end with quotes of its own. It may span lines,
+// and neither escapes nor interpolations are decoded in it.
+func (l *Lexer) readRawString() string {
+	start := Position{l.line, l.column}
+	for i := 0; i < 3; i++ {
+		l.readChar()
+	}
+	from := l.offset
+	// In a run of more than three quotes, the last three close the string
+	for !l.eof() && (!strings.HasPrefix(l.source[l.offset:], `"""`) || strings.HasPrefix(l.source[l.offset:], `""""`)) {
+		l.readChar()
+	}
+	text := l.source[from:l.offset]
+	if l.eof() {
+		l.errorFrom(start, unterminatedRawString)
+	} else {
+		for i := 0; i < 3; i++ {
+			l.readChar()
+		}
+	}
+	// Files saved on Windows end their lines with \r\n
+	return strings.ReplaceAll(text, "\r\n", "\n")
 }
 
 func lookupIdentOrKeyword(ident string) TokenType {
@@ -147,6 +504,8 @@ func lookupIdentOrKeyword(ident string) TokenType {
 		return PSYDUCK
 	case "Eevee":
 		return EEVEE
+	case "Voltorb":
+		return VOLTORB
 	case "is":
 		return IS
 	case "evolves":
@@ -157,8 +516,40 @@ func lookupIdentOrKeyword(ident string) TokenType {
 		return RELEASE
 	case "from":
 		return FROM
-	case "wildgrass":
-		return WILDGRASS
+	case "trainer":
+		return TRAINER
+	case "if":
+		return IF
+	case "then":
+		return THEN
+	case "else":
+		return ELSE
+	case "end":
+		return END
+	case "train":
+		return TRAIN
+	case "while":
+		return WHILE
+	case "repeat":
+		return REPEAT
+	case "times":
+		return TIMES
+	case "move":
+		return MOVE
+	case "gives":
+		return GIVES
+	case "return":
+		return RETURN
+	case "and":
+		return AND
+	case "or":
+		return OR
+	case "not":
+		return NOT
+	case "true":
+		return TRUE
+	case "false":
+		return FALSE
 	default:
 		return IDENTIFIER
 	}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -43,6 +44,27 @@ func TestLexer_Arithmetic(t *testing.T) {
 	}
 }
 
+func TestLexer_Operators(t *testing.T) {
+	lexer := NewLexer(`-a % b ** c*d**-2`)
+	tokens := lexer.Tokenize()
+
+	expected := []struct {
+		tokType TokenType
+		value   string
+	}{
+		{MINUS, "-"}, {IDENTIFIER, "a"}, {MODULO, "%"}, {IDENTIFIER, "b"}, {POWER, "**"}, {IDENTIFIER, "c"},
+		{MULTIPLY, "*"}, {IDENTIFIER, "d"}, {POWER, "**"}, {MINUS, "-"}, {NUMBER, "2"}, {EOF, ""},
+	}
+	if len(tokens) != len(expected) {
+		t.Fatalf("Expected %d tokens, got %d: %v", len(expected), len(tokens), tokens)
+	}
+	for i, exp := range expected {
+		if tokens[i].Type != exp.tokType || tokens[i].Value != exp.value {
+			t.Errorf("token %d: expected %v %q, got %v %q", i, exp.tokType, exp.value, tokens[i].Type, tokens[i].Value)
+		}
+	}
+}
+
 func TestLexer_String(t *testing.T) {
 	source := `Eevee greeting is "Hello " + "Ash"`
 	lexer := NewLexer(source)
@@ -69,3 +91,347 @@ release userInput`
 		}
 	}
 }
+
+func TestLexer_IfElse(t *testing.T) {
+	source := `if x then
+release x
+else
+release 0
+end`
+	lexer := NewLexer(source)
+	tokens := lexer.Tokenize()
+
+	expected := []TokenType{IF, IDENTIFIER, THEN, NEWLINE, RELEASE, IDENTIFIER, NEWLINE, ELSE, NEWLINE, RELEASE, NUMBER, NEWLINE, END, EOF}
+	for i, tokType := range expected {
+		if tokens[i].Type != tokType {
+			t.Errorf("token %d: expected %v, got %v", i, tokType, tokens[i].Type)
+		}
+	}
+}
+
+func TestLexer_ComparisonAndLogic(t *testing.T) {
+	source := `Voltorb ok is a == b and c != d or not e < f <= g > h >= i
+Voltorb yes is true`
+	lexer := NewLexer(source)
+	tokens := lexer.Tokenize()
+
+	expected := []TokenType{VOLTORB, IDENTIFIER, IS, IDENTIFIER, EQ, IDENTIFIER, AND, IDENTIFIER, NOT_EQ, IDENTIFIER,
+		OR, NOT, IDENTIFIER, LT, IDENTIFIER, LTE, IDENTIFIER, GT, IDENTIFIER, GTE, IDENTIFIER, NEWLINE,
+		VOLTORB, IDENTIFIER, IS, TRUE, EOF}
+	for i, tokType := range expected {
+		if tokens[i].Type != tokType {
+			t.Errorf("token %d: expected %v, got %v", i, tokType, tokens[i].Type)
+		}
+	}
+}
+
+func TestLexer_Loops(t *testing.T) {
+	source := `train while x > 0
+end
+repeat 3 times
+end`
+	lexer := NewLexer(source)
+	tokens := lexer.Tokenize()
+
+	expected := []TokenType{TRAIN, WHILE, IDENTIFIER, GT, NUMBER, NEWLINE, END, NEWLINE, REPEAT, NUMBER, TIMES, NEWLINE, END, EOF}
+	for i, tokType := range expected {
+		if tokens[i].Type != tokType {
+			t.Errorf("token %d: expected %v, got %v", i, tokType, tokens[i].Type)
+		}
+	}
+}
+
+func TestLexer_Functions(t *testing.T) {
+	source := `move attack(Pikachu a, Psyduck b) gives Pikachu
+return a
+end`
+	lexer := NewLexer(source)
+	tokens := lexer.Tokenize()
+
+	expected := []TokenType{MOVE, IDENTIFIER, LPAREN, PIKACHU, IDENTIFIER, COMMA, PSYDUCK, IDENTIFIER, RPAREN, GIVES, PIKACHU, NEWLINE,
+		RETURN, IDENTIFIER, NEWLINE, END, EOF}
+	for i, tokType := range expected {
+		if tokens[i].Type != tokType {
+			t.Errorf("token %d: expected %v, got %v", i, tokType, tokens[i].Type)
+		}
+	}
+}
+
+func TestLexer_Positions(t *testing.T) {
+	source := "Pikachu hp is 10\nrelease \"hi\""
+	tokens := NewLexer(source).Tokenize()
+
+	expected := []struct {
+		tokType                          TokenType
+		line, column, endLine, endColumn int
+	}{
+		{PIKACHU, 1, 1, 1, 8},
+		{IDENTIFIER, 1, 9, 1, 11},
+		{IS, 1, 12, 1, 14},
+		{NUMBER, 1, 15, 1, 17},
+		{NEWLINE, 1, 17, 2, 1},
+		{RELEASE, 2, 1, 2, 8},
+		{STRING, 2, 9, 2, 13},
+	}
+	for i, tt := range expected {
+		tok := tokens[i]
+		if tok.Type != tt.tokType || tok.Line != tt.line || tok.Column != tt.column ||
+			tok.EndLine != tt.endLine || tok.EndColumn != tt.endColumn {
+			t.Errorf("token %d: expected %v at %d:%d-%d:%d, got %v at %d:%d-%d:%d", i,
+				tt.tokType, tt.line, tt.column, tt.endLine, tt.endColumn,
+				tok.Type, tok.Line, tok.Column, tok.EndLine, tok.EndColumn)
+		}
+	}
+}
+
+func TestLexer_Comments(t *testing.T) {
+	source := "# intro\nrelease 1 # one\n## doc\n#[ a\nblock ]# release 2\n\nrelease 3 #[ never closed"
+	tokens := NewLexer(source).Tokenize()
+
+	// Lines holding only comments give no NEWLINE; the blank line does
+	expected := []TokenType{RELEASE, NUMBER, NEWLINE, RELEASE, NUMBER, NEWLINE, NEWLINE, RELEASE, NUMBER, EOF}
+	if len(tokens) != len(expected) {
+		t.Fatalf("Expected %d tokens, got %d: %v", len(expected), len(tokens), tokens)
+	}
+	for i, tokType := range expected {
+		if tokens[i].Type != tokType {
+			t.Errorf("token %d: expected %v, got %v", i, tokType, tokens[i].Type)
+		}
+	}
+
+	leading := map[int][]Comment{
+		0: {{Span{Position{1, 1}, Position{1, 8}}, "# intro", false}},
+		2: {{Span{Position{2, 11}, Position{2, 16}}, "# one", false}},
+		3: {
+			{Span{Position{3, 1}, Position{3, 7}}, "## doc", true},
+			{Span{Position{4, 1}, Position{5, 9}}, "#[ a\nblock ]#", false},
+		},
+		9: {{Span{Position{7, 11}, Position{7, 26}}, "#[ never closed", false}},
+	}
+	for i, tok := range tokens {
+		if len(tok.Leading) != len(leading[i]) {
+			t.Errorf("token %d: expected comments %v, got %v", i, leading[i], tok.Leading)
+			continue
+		}
+		for j, comment := range leading[i] {
+			if tok.Leading[j] != comment {
+				t.Errorf("token %d comment %d: expected %+v, got %+v", i, j, comment, tok.Leading[j])
+			}
+		}
+	}
+}
+
+func TestLexer_HashNoLongerEndsTheProgram(t *testing.T) {
+	tokens := NewLexer("release 1 # stop?\nrelease 2").Tokenize()
+	releases := 0
+	for _, tok := range tokens {
+		if tok.Type == RELEASE {
+			releases++
+		}
+	}
+	if releases != 2 {
+		t.Errorf("Expected both releases after a comment, got %d", releases)
+	}
+}
+
+func TestLexer_UnicodeIdentifiers(t *testing.T) {
+	// A letter starts a name; digits, combining marks and _ may follow
+	source := "\uFEFFEevee café is \"🔥\"\nPikachu 名前_2 is 1\nrelease naïve + नमस्ते"
+	tokens := NewLexer(source).Tokenize()
+
+	expected := []struct {
+		tokType TokenType
+		value   string
+	}{
+		{EEVEE, "Eevee"}, {IDENTIFIER, "café"}, {IS, "is"}, {STRING, "🔥"}, {NEWLINE, ""},
+		{PIKACHU, "Pikachu"}, {IDENTIFIER, "名前_2"}, {IS, "is"}, {NUMBER, "1"}, {NEWLINE, ""},
+		{RELEASE, "release"}, {IDENTIFIER, "naïve"}, {PLUS, "+"}, {IDENTIFIER, "नमस्ते"}, {EOF, ""},
+	}
+	if len(tokens) != len(expected) {
+		t.Fatalf("Expected %d tokens, got %d: %v", len(expected), len(tokens), tokens)
+	}
+	for i, tt := range expected {
+		if tokens[i].Type != tt.tokType || (tt.value != "" && tokens[i].Value != tt.value) {
+			t.Errorf("token %d: expected %v %q, got %v %q", i, tt.tokType, tt.value, tokens[i].Type, tokens[i].Value)
+		}
+	}
+}
+
+func TestLexer_ColumnUnits(t *testing.T) {
+	// 🔥 is one character but two UTF-16 units; the BOM takes up no column
+	source := "\uFEFFrelease \"🔥\" + x"
+
+	for _, tt := range []struct {
+		unit   ColumnUnit
+		column int
+	}{
+		{ColumnRunes, 15},
+		{ColumnUTF16, 16},
+	} {
+		lexer := NewLexer(source)
+		lexer.SetColumnUnit(tt.unit)
+		tokens := lexer.Tokenize()
+		if tokens[0].Column != 1 {
+			t.Errorf("unit %v: expected release at column 1, got %d", tt.unit, tokens[0].Column)
+		}
+		if tokens[3].Type != IDENTIFIER || tokens[3].Column != tt.column {
+			t.Errorf("unit %v: expected x at column %d, got %v at %d", tt.unit, tt.column, tokens[3].Type, tokens[3].Column)
+		}
+	}
+}
+
+func TestLexer_InvalidUTF8(t *testing.T) {
+	lexer := NewLexer("release \xff1\nrelease 2")
+	tokens := lexer.Tokenize()
+
+	// The bad byte is reported and skipped, and the rest still lexes
+	expected := []TokenType{RELEASE, NUMBER, NEWLINE, RELEASE, NUMBER, EOF}
+	if len(tokens) != len(expected) {
+		t.Fatalf("Expected %d tokens, got %d: %v", len(expected), len(tokens), tokens)
+	}
+	for i, tokType := range expected {
+		if tokens[i].Type != tokType {
+			t.Errorf("token %d: expected %v, got %v", i, tokType, tokens[i].Type)
+		}
+	}
+
+	errs := lexer.Errors()
+	if len(errs) != 1 {
+		t.Fatalf("Expected 1 error, got %v", errs)
+	}
+	if errs[0].Line != 1 || errs[0].Column != 9 || !strings.Contains(errs[0].Message, "0xff") {
+		t.Errorf("Expected the error at 1:9 about 0xff, got %d:%d %s", errs[0].Line, errs[0].Column, errs[0].Message)
+	}
+}
+
+func TestLexer_StringEscapes(t *testing.T) {
+	lexer := NewLexer(`release "a\tb\n\"c\"\\d \{e} \u{1F525}\u{e9}"`)
+	tokens := lexer.Tokenize()
+
+	if len(lexer.Errors()) > 0 {
+		t.Fatalf("Expected no errors, got %v", lexer.Errors())
+	}
+	if tokens[1].Type != STRING {
+		t.Fatalf("Expected STRING, got %v", tokens[1].Type)
+	}
+	if expected := "a\tb\n\"c\"\\d {e} 🔥é"; tokens[1].Value != expected {
+		t.Errorf("Expected %q, got %q", expected, tokens[1].Value)
+	}
+}
+
+func TestLexer_RawString(t *testing.T) {
+	source := "release \"\"\"one \"two\" \\n {three}\r\nfour\"\"\" + x"
+	tokens := NewLexer(source).Tokenize()
+
+	expected := []TokenType{RELEASE, RAW_STRING, PLUS, IDENTIFIER, EOF}
+	if len(tokens) != len(expected) {
+		t.Fatalf("Expected %d tokens, got %d: %v", len(expected), len(tokens), tokens)
+	}
+	if value := "one \"two\" \\n {three}\nfour"; tokens[1].Value != value {
+		t.Errorf("Expected %q, got %q", value, tokens[1].Value)
+	}
+	if tokens[1].EndLine != 2 || tokens[3].Line != 2 || tokens[3].Column != 11 {
+		t.Errorf("Expected the string to end on line 2 and x at 2:11, got %d and %d:%d",
+			tokens[1].EndLine, tokens[3].Line, tokens[3].Column)
+	}
+
+	// Quotes just before the closing ones belong to the string
+	tokens = NewLexer(`release """say "hi""""`).Tokenize()
+	if tokens[1].Type != RAW_STRING || tokens[1].Value != `say "hi"` || tokens[2].Type != EOF {
+		t.Errorf("Expected the raw string %q, got %v", `say "hi"`, tokens)
+	}
+}
+
+func TestLexer_Interpolation(t *testing.T) {
+	tokens := NewLexer(`release "HP: {hp + 1}, {"in {x}"}!" + "{y}"`).Tokenize()
+
+	expected := []struct {
+		tokType TokenType
+		value   string
+	}{
+		{RELEASE, "release"},
+		{STRING_START, "HP: "}, {IDENTIFIER, "hp"}, {PLUS, "+"}, {NUMBER, "1"},
+		{STRING_MIDDLE, ", "},
+		{STRING_START, "in "}, {IDENTIFIER, "x"}, {STRING_END, ""},
+		{STRING_END, "!"},
+		{PLUS, "+"},
+		{STRING_START, ""}, {IDENTIFIER, "y"}, {STRING_END, ""},
+		{EOF, ""},
+	}
+	if len(tokens) != len(expected) {
+		t.Fatalf("Expected %d tokens, got %d: %v", len(expected), len(tokens), tokens)
+	}
+	for i, tt := range expected {
+		if tokens[i].Type != tt.tokType || tokens[i].Value != tt.value {
+			t.Errorf("token %d: expected %v %q, got %v %q", i, tt.tokType, tt.value, tokens[i].Type, tokens[i].Value)
+		}
+	}
+}
+
+func TestLexer_StringErrors(t *testing.T) {
+	tests := []struct {
+		source, message string
+		column          int
+	}{
+		{`release "never closed`, "unterminated string", 9},
+		{`release "a\qb"`, `unknown escape sequence \q`, 11},
+		{`release "\u41"`, `\u needs a hex code point in braces`, 10},
+		{`release "\u{}"`, `\u needs a hex code point in braces`, 10},
+		{`release "\u{1234567}"`, `\u needs a hex code point in braces`, 10},
+		{`release "\u{D800}"`, `\u{D800} is not a character`, 10},
+		{`release "\u{0}"`, `\u{0} is not a character`, 10},
+		{`release """never closed`, "unterminated string", 9},
+	}
+	for _, tt := range tests {
+		lexer := NewLexer(tt.source + "\nrelease 1")
+		tokens := lexer.Tokenize()
+		errs := lexer.Errors()
+		if len(errs) != 1 || !strings.Contains(errs[0].Message, tt.message) || errs[0].Column != tt.column {
+			t.Errorf("%s: expected one error %q at column %d, got %v", tt.source, tt.message, tt.column, errs)
+		}
+		// A string that is not closed ends with its line
+		if tt.message == "unterminated string" && !strings.HasPrefix(tt.source, `release """`) {
+			if last := tokens[len(tokens)-2]; last.Type != NUMBER || last.Line != 2 {
+				t.Errorf("%s: expected the next line to lex, got %v", tt.source, tokens)
+			}
+		}
+	}
+}
+
+func TestLexer_IllegalCharacters(t *testing.T) {
+	// Unknown characters become ILLEGAL tokens, and the rest still lexes
+	lexer := NewLexer("release 10 ^ 3\nif a && b then\nrelease 1\x00\nrelease 2")
+	tokens := lexer.Tokenize()
+
+	expected := []struct {
+		tokType TokenType
+		value   string
+	}{
+		{RELEASE, "release"}, {NUMBER, "10"}, {ILLEGAL, "^"}, {NUMBER, "3"}, {NEWLINE, "\n"},
+		{IF, "if"}, {IDENTIFIER, "a"}, {ILLEGAL, "&&"}, {IDENTIFIER, "b"}, {THEN, "then"}, {NEWLINE, "\n"},
+		{RELEASE, "release"}, {NUMBER, "1"}, {ILLEGAL, "\x00"}, {NEWLINE, "\n"},
+		{RELEASE, "release"}, {NUMBER, "2"}, {EOF, ""},
+	}
+	if len(tokens) != len(expected) {
+		t.Fatalf("Expected %d tokens, got %d: %v", len(expected), len(tokens), tokens)
+	}
+	for i, tt := range expected {
+		if tokens[i].Type != tt.tokType || tokens[i].Value != tt.value {
+			t.Errorf("token %d: expected %v %q, got %v %q", i, tt.tokType, tt.value, tokens[i].Type, tokens[i].Value)
+		}
+	}
+	if tok := tokens[7]; tok.Line != 2 || tok.Column != 6 || tok.EndColumn != 8 {
+		t.Errorf("Expected && at 2:6-2:8, got %d:%d-%d:%d", tok.Line, tok.Column, tok.EndLine, tok.EndColumn)
+	}
+
+	errs := lexer.Errors()
+	messages := []string{"unexpected character '^' (write '**' for a power)", "unexpected characters '&&' (write 'and')", "unexpected character U+0000"}
+	if len(errs) != len(messages) {
+		t.Fatalf("Expected %d errors, got %v", len(messages), errs)
+	}
+	for i, message := range messages {
+		if errs[i].Message != message {
+			t.Errorf("error %d: expected %q, got %q", i, message, errs[i].Message)
+		}
+	}
+}
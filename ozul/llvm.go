@@ -0,0 +1,971 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// LLVMGen lowers a program to textual LLVM IR (a .ll file). The IR uses
+// opaque pointers and calls only the C library, so llc can turn it into an
+// object file and any linker can make a native binary of it.
+//
+// Values follow the interpreter: a Pikachu is an i64, a Psyduck a double,
+// an Eevee a pointer to a NUL-terminated string and a Voltorb an i1.
+// Runtime errors print a message like the interpreter's and exit with
+// status 1.
+type LLVMGen struct {
+	// Type checker whose inferred types drive the IR types
+	checker *Checker
+
+	// String constants, by value, and their definitions in order
+	constants    map[string]string
+	constantDefs []string
+
+	// Finished function definitions
+	functions []string
+
+	// Function being generated: allocas go to the top of its entry block
+	// so loops do not grow the stack
+	allocas    []string
+	body       []string
+	variables  *Scope[llvmVar]
+	returnType string // Pokemon type the current move gives, "" for none
+
+	// Current basic block, and whether it already ends in a terminator
+	block      string
+	terminated bool
+
+	// Counters for unique register and label names in the current function
+	regs   int
+	labels int
+}
+
+// llvmVar is a variable's stack slot and its Pokemon type
+type llvmVar struct {
+	ptr         string
+	pokemonType string
+}
+
+// llvmValue is an SSA value and its Pokemon type
+type llvmValue struct {
+	ref         string
+	pokemonType string
+}
+
+// llvmTypes maps each Pokemon type to the LLVM type that represents it
+var llvmTypes = map[string]string{
+	"Pikachu": "i64",
+	"Psyduck": "double",
+	"Eevee":   "ptr",
+	"Voltorb": "i1",
+}
+
+// NewLLVMGen creates a new LLVM IR generator
+func NewLLVMGen() *LLVMGen {
+	return &LLVMGen{
+		checker:   NewChecker(),
+		constants: make(map[string]string),
+	}
+}
+
+// GenerateProgram generates IR for the entire program. The program is type
+// checked first; type errors are reported as a panic.
+func (lg *LLVMGen) GenerateProgram(program *Program) {
+	if errs := lg.checker.Check(program); len(errs) > 0 {
+		messages := make([]string, len(errs))
+		for i, err := range errs {
+			messages[i] = err.Error()
+		}
+		panic("[OZUL LLVM Error] " + strings.Join(messages, "; "))
+	}
+
+	for _, stmt := range program.Statements {
+		if fn, ok := stmt.(*FunctionDecl); ok {
+			lg.generateFunction(fn)
+		}
+	}
+
+	lg.beginFunction(NewScope[llvmVar](nil), "")
+	for _, stmt := range program.Statements {
+		if _, ok := stmt.(*FunctionDecl); ok {
+			continue
+		}
+		lg.generateStatement(stmt)
+	}
+	lg.terminate("ret i32 0")
+	lg.endFunction("define i32 @main()")
+}
+
+// GetIR returns the generated module as a string
+func (lg *LLVMGen) GetIR() string {
+	var sb strings.Builder
+	sb.WriteString("; Generated by the OZUL compiler\n\n")
+	sb.WriteString(llvmRuntime)
+	for _, def := range lg.constantDefs {
+		sb.WriteString(def + "\n")
+	}
+	for _, fn := range lg.functions {
+		sb.WriteString("\n" + fn)
+	}
+	return sb.String()
+}
+
+// beginFunction resets the per-function state
+func (lg *LLVMGen) beginFunction(variables *Scope[llvmVar], returnType string) {
+	lg.allocas = nil
+	lg.body = nil
+	lg.variables = variables
+	lg.returnType = returnType
+	lg.block = "entry"
+	lg.terminated = false
+	lg.regs = 0
+	lg.labels = 0
+}
+
+// endFunction assembles the current function under the given header
+func (lg *LLVMGen) endFunction(header string) {
+	if !lg.terminated {
+		// Moves that give a value always return first (the checker makes
+		// sure), so only the dead block after the last return gets here
+		if lg.returnType == "" {
+			lg.emit("ret void")
+		} else {
+			lg.emit("unreachable")
+		}
+	}
+	lines := []string{header + " {", "entry:"}
+	lines = append(lines, lg.allocas...)
+	lines = append(lines, lg.body...)
+	lines = append(lines, "}", "")
+	lg.functions = append(lg.functions, strings.Join(lines, "\n"))
+}
+
+// emit appends an instruction to the current block. Code after a return
+// gets a fresh (unreachable) block, since a block must end at its
+// terminator.
+func (lg *LLVMGen) emit(format string, args ...interface{}) {
+	if lg.terminated {
+		lg.startBlock(lg.newLabel("dead"))
+	}
+	lg.body = append(lg.body, "  "+fmt.Sprintf(format, args...))
+}
+
+// terminate emits an instruction that ends the current block
+func (lg *LLVMGen) terminate(format string, args ...interface{}) {
+	lg.emit(format, args...)
+	lg.terminated = true
+}
+
+// startBlock begins a new basic block
+func (lg *LLVMGen) startBlock(label string) {
+	lg.body = append(lg.body, label+":")
+	lg.block = label
+	lg.terminated = false
+}
+
+func (lg *LLVMGen) newReg() string {
+	lg.regs++
+	return fmt.Sprintf("%%t%d", lg.regs)
+}
+
+func (lg *LLVMGen) newLabel(prefix string) string {
+	lg.labels++
+	return fmt.Sprintf("%s.%d", prefix, lg.labels)
+}
+
+// simpleName matches names that need no quoting in LLVM IR
+var simpleName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// alloca reserves a stack slot named after an OZUL variable
+func (lg *LLVMGen) alloca(name, pokemonType string) string {
+	lg.regs++
+	slot := fmt.Sprintf("%s.%d", name, lg.regs)
+	if !simpleName.MatchString(name) {
+		slot = strconv.Quote(slot)
+	}
+	ptr := "%" + slot
+	lg.allocas = append(lg.allocas, fmt.Sprintf("  %s = alloca %s", ptr, llvmTypes[pokemonType]))
+	return ptr
+}
+
+// declare gives a variable a stack slot in the current scope
+func (lg *LLVMGen) declare(name, pokemonType string) llvmVar {
+	v := llvmVar{ptr: lg.alloca(name, pokemonType), pokemonType: pokemonType}
+	if err := lg.variables.Declare(name, v); err != nil {
+		panic(fmt.Sprintf("[OZUL LLVM Error] Variable %s already declared in this scope!", name))
+	}
+	return v
+}
+
+func (lg *LLVMGen) lookup(name string) llvmVar {
+	v, ok := lg.variables.Lookup(name)
+	if !ok {
+		panic(fmt.Sprintf("[OZUL LLVM Error] Variable %s not declared!", name))
+	}
+	return v
+}
+
+// constant returns a global holding a NUL-terminated string
+func (lg *LLVMGen) constant(value string) string {
+	if name, ok := lg.constants[value]; ok {
+		return name
+	}
+	name := fmt.Sprintf("@.str.%d", len(lg.constantDefs))
+	lg.constants[value] = name
+	lg.constantDefs = append(lg.constantDefs, fmt.Sprintf("%s = private unnamed_addr constant [%d x i8] c\"%s\"",
+		name, len(value)+1, llvmEscape(value)))
+	return name
+}
+
+// llvmEscape writes a string as the body of an LLVM c"..." constant
+func llvmEscape(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= ' ' && c <= '~' && c != '"' && c != '\\' {
+			sb.WriteByte(c)
+		} else {
+			sb.WriteString(fmt.Sprintf("\\%02X", c))
+		}
+	}
+	sb.WriteString("\\00")
+	return sb.String()
+}
+
+// generateFunction generates an LLVM function for a move, named @move.<name>
+// so it cannot clash with the C library or the runtime. Parameters are
+// copied into stack slots so the body can assign to them.
+func (lg *LLVMGen) generateFunction(fn *FunctionDecl) {
+	lg.beginFunction(NewScope[llvmVar](nil), fn.ReturnType)
+	params := make([]string, len(fn.Params))
+	for i, param := range fn.Params {
+		llvmType := llvmTypes[param.PokemonType]
+		params[i] = fmt.Sprintf("%s %%arg%d", llvmType, i)
+		v := lg.declare(param.Name, param.PokemonType)
+		lg.emit("store %s %%arg%d, ptr %s", llvmType, i, v.ptr)
+	}
+	for _, stmt := range fn.Body {
+		lg.generateStatement(stmt)
+	}
+	lg.endFunction(fmt.Sprintf("define internal %s %s(%s)", lg.llvmReturnType(fn.ReturnType), llvmMoveName(fn.Name), strings.Join(params, ", ")))
+}
+
+// llvmMoveName is the global name of a move, quoted when the move's name
+// is not plain ASCII
+func llvmMoveName(name string) string {
+	if simpleName.MatchString(name) {
+		return "@move." + name
+	}
+	return "@" + strconv.Quote("move."+name)
+}
+
+func (lg *LLVMGen) llvmReturnType(pokemonType string) string {
+	if pokemonType == "" {
+		return "void"
+	}
+	return llvmTypes[pokemonType]
+}
+
+// generateStatement generates IR for a single statement
+func (lg *LLVMGen) generateStatement(stmt Statement) {
+	switch s := stmt.(type) {
+	case *DeclarationStmt:
+		value := lg.convert(lg.generateExpression(s.Value), s.PokemonType)
+		v := lg.declare(s.Name, s.PokemonType)
+		lg.emit("store %s %s, ptr %s", llvmTypes[s.PokemonType], value.ref, v.ptr)
+	case *AssignmentStmt:
+		v := lg.lookup(s.Name)
+		value := lg.convert(lg.generateExpression(s.Value), v.pokemonType)
+		lg.emit("store %s %s, ptr %s", llvmTypes[v.pokemonType], value.ref, v.ptr)
+	case *ReleaseStmt:
+		lg.generateRelease(s)
+	case *CatchStmt:
+		lg.generateCatch(s)
+	case *IfStmt:
+		lg.generateIf(s)
+	case *WhileStmt:
+		lg.generateWhile(s)
+	case *RepeatStmt:
+		lg.generateRepeat(s)
+	case *ReturnStmt:
+		lg.generateReturn(s)
+	case *ExpressionStmt:
+		lg.generateExpression(s.Expr)
+	}
+}
+
+// generateRelease prints a value the way the interpreter does
+func (lg *LLVMGen) generateRelease(stmt *ReleaseStmt) {
+	value := lg.generateExpression(stmt.Value)
+	reg := lg.newReg()
+	switch value.pokemonType {
+	case "Pikachu":
+		lg.emit("%s = call i32 (ptr, ...) @printf(ptr @.fmt.int, i64 %s)", reg, value.ref)
+	case "Psyduck":
+		text := lg.newReg()
+		lg.emit("%s = call ptr @ozul_format_float(double %s)", text, value.ref)
+		lg.emit("%s = call i32 @puts(ptr %s)", reg, text)
+	case "Eevee":
+		lg.emit("%s = call i32 @puts(ptr %s)", reg, value.ref)
+	case "Voltorb":
+		text := lg.toString(value)
+		lg.emit("%s = call i32 @puts(ptr %s)", reg, text.ref)
+	default:
+		panic(fmt.Sprintf("[OZUL LLVM Error] Unknown type for release: %s", value.pokemonType))
+	}
+}
+
+// generateCatch reads a line from the trainer. A typed catch declares a new
+// variable; an untyped one reads into a visible variable, or else declares
+// a new Pikachu.
+func (lg *LLVMGen) generateCatch(stmt *CatchStmt) {
+	v, exists := lg.variables.Lookup(stmt.Variable)
+	if stmt.PokemonType != "" || !exists {
+		pokemonType := stmt.PokemonType
+		if pokemonType == "" {
+			pokemonType = "Pikachu"
+		}
+		v = lg.declare(stmt.Variable, pokemonType)
+	}
+
+	name := lg.constant(stmt.Variable)
+	line := stmt.Pos().Start.Line
+	value := lg.newReg()
+	switch v.pokemonType {
+	case "Pikachu":
+		lg.emit("%s = call i64 @ozul_read_int(ptr %s, i64 %d)", value, name, line)
+	case "Psyduck":
+		lg.emit("%s = call double @ozul_read_float(ptr %s, i64 %d)", value, name, line)
+	case "Eevee":
+		lg.emit("%s = call ptr @ozul_read_string(ptr %s)", value, name)
+	default:
+		panic(fmt.Sprintf("[OZUL LLVM Error] Cannot catch into %s variable %s", v.pokemonType, stmt.Variable))
+	}
+	lg.emit("store %s %s, ptr %s", llvmTypes[v.pokemonType], value, v.ptr)
+}
+
+// generateIf generates IR for conditional blocks
+func (lg *LLVMGen) generateIf(stmt *IfStmt) {
+	cond := lg.generateExpression(stmt.Condition)
+	thenLabel, endLabel := lg.newLabel("if.then"), lg.newLabel("if.end")
+	elseLabel := endLabel
+	if stmt.Else != nil {
+		elseLabel = lg.newLabel("if.else")
+	}
+	lg.terminate("br i1 %s, label %%%s, label %%%s", cond.ref, thenLabel, elseLabel)
+
+	lg.startBlock(thenLabel)
+	lg.generateBlock(stmt.Then)
+	lg.terminate("br label %%%s", endLabel)
+	if stmt.Else != nil {
+		lg.startBlock(elseLabel)
+		lg.generateBlock(stmt.Else)
+		lg.terminate("br label %%%s", endLabel)
+	}
+	lg.startBlock(endLabel)
+}
+
+// generateWhile generates IR for "train while" loops
+func (lg *LLVMGen) generateWhile(stmt *WhileStmt) {
+	condLabel, bodyLabel, endLabel := lg.newLabel("while.cond"), lg.newLabel("while.body"), lg.newLabel("while.end")
+	lg.terminate("br label %%%s", condLabel)
+
+	lg.startBlock(condLabel)
+	cond := lg.generateExpression(stmt.Condition)
+	lg.terminate("br i1 %s, label %%%s, label %%%s", cond.ref, bodyLabel, endLabel)
+
+	lg.startBlock(bodyLabel)
+	lg.generateBlock(stmt.Body)
+	lg.terminate("br label %%%s", condLabel)
+	lg.startBlock(endLabel)
+}
+
+// generateRepeat generates IR for counted "repeat N times" loops. The count
+// is evaluated once, before the first iteration.
+func (lg *LLVMGen) generateRepeat(stmt *RepeatStmt) {
+	count := lg.generateExpression(stmt.Count)
+	index := lg.alloca("repeat", "Pikachu")
+	lg.emit("store i64 0, ptr %s", index)
+	condLabel, bodyLabel, endLabel := lg.newLabel("repeat.cond"), lg.newLabel("repeat.body"), lg.newLabel("repeat.end")
+	lg.terminate("br label %%%s", condLabel)
+
+	lg.startBlock(condLabel)
+	i, more := lg.newReg(), lg.newReg()
+	lg.emit("%s = load i64, ptr %s", i, index)
+	lg.emit("%s = icmp slt i64 %s, %s", more, i, count.ref)
+	lg.terminate("br i1 %s, label %%%s, label %%%s", more, bodyLabel, endLabel)
+
+	lg.startBlock(bodyLabel)
+	lg.generateBlock(stmt.Body)
+	current, next := lg.newReg(), lg.newReg()
+	lg.emit("%s = load i64, ptr %s", current, index)
+	lg.emit("%s = add i64 %s, 1", next, current)
+	lg.emit("store i64 %s, ptr %s", next, index)
+	lg.terminate("br label %%%s", condLabel)
+	lg.startBlock(endLabel)
+}
+
+// generateReturn generates IR for returning from a move
+func (lg *LLVMGen) generateReturn(stmt *ReturnStmt) {
+	if stmt.Value == nil {
+		lg.terminate("ret void")
+		return
+	}
+	value := lg.convert(lg.generateExpression(stmt.Value), lg.returnType)
+	lg.terminate("ret %s %s", llvmTypes[lg.returnType], value.ref)
+}
+
+// generateBlock generates the statements of a nested block in a new scope
+func (lg *LLVMGen) generateBlock(stmts []Statement) {
+	outer := lg.variables
+	lg.variables = NewScope(outer)
+	for _, stmt := range stmts {
+		lg.generateStatement(stmt)
+	}
+	lg.variables = outer
+}
+
+// generateExpression generates IR for an expression and returns its value
+// (with an empty ref for calls to moves that give nothing back)
+func (lg *LLVMGen) generateExpression(expr Expression) llvmValue {
+	switch e := expr.(type) {
+	case *NumberLiteral:
+		return llvmValue{fmt.Sprintf("%d", e.Value), "Pikachu"}
+	case *FloatLiteral:
+		return llvmValue{llvmFloat(e.Value), "Psyduck"}
+	case *InterpolatedString:
+		return lg.generateExpression(e.Value)
+	case *StringLiteral:
+		return llvmValue{lg.constant(e.Value), "Eevee"}
+	case *BooleanLiteral:
+		return llvmValue{fmt.Sprintf("%t", e.Value), "Voltorb"}
+	case *Identifier:
+		v := lg.lookup(e.Name)
+		reg := lg.newReg()
+		lg.emit("%s = load %s, ptr %s", reg, llvmTypes[v.pokemonType], v.ptr)
+		return llvmValue{reg, v.pokemonType}
+	case *CallExpr:
+		return lg.generateCall(e)
+	case *UnaryExpr:
+		operand := lg.generateExpression(e.Operand)
+		if e.Operator == "not" {
+			reg := lg.newReg()
+			lg.emit("%s = xor i1 %s, true", reg, operand.ref)
+			return llvmValue{reg, "Voltorb"}
+		}
+		if e.Operator == "-" {
+			reg := lg.newReg()
+			if operand.pokemonType == "Psyduck" {
+				lg.emit("%s = fneg double %s", reg, operand.ref)
+			} else {
+				lg.emit("%s = sub i64 0, %s", reg, operand.ref)
+			}
+			return llvmValue{reg, operand.pokemonType}
+		}
+		panic(fmt.Sprintf("[OZUL LLVM Error] Unknown operator: %s", e.Operator))
+	case *BinaryExpr:
+		if e.Operator == "and" || e.Operator == "or" {
+			return lg.generateLogical(e)
+		}
+		left := lg.generateExpression(e.Left)
+		right := lg.generateExpression(e.Right)
+		switch e.Operator {
+		case "==", "!=", "<", "<=", ">", ">=":
+			return lg.generateComparison(e.Operator, left, right)
+		}
+		if e.Operator == "+" && (left.pokemonType == "Eevee" || right.pokemonType == "Eevee") {
+			reg := lg.newReg()
+			lg.emit("%s = call ptr @ozul_concat(ptr %s, ptr %s)", reg, lg.toString(left).ref, lg.toString(right).ref)
+			return llvmValue{reg, "Eevee"}
+		}
+		return lg.generateArithmetic(e, left, right)
+	default:
+		panic("[OZUL LLVM Error] Unknown expression type.")
+	}
+}
+
+// llvmFloat writes a double constant in the exact hexadecimal form LLVM
+// needs for values that have no short decimal representation
+func llvmFloat(f float64) string {
+	return fmt.Sprintf("0x%016X", math.Float64bits(f))
+}
+
+func (lg *LLVMGen) generateCall(call *CallExpr) llvmValue {
+	fn := lg.checker.functions[call.Name]
+	args := make([]string, len(call.Args))
+	for i, arg := range call.Args {
+		pokemonType := fn.Params[i].PokemonType
+		value := lg.convert(lg.generateExpression(arg), pokemonType)
+		args[i] = fmt.Sprintf("%s %s", llvmTypes[pokemonType], value.ref)
+	}
+	if fn.ReturnType == "" {
+		lg.emit("call void %s(%s)", llvmMoveName(fn.Name), strings.Join(args, ", "))
+		return llvmValue{}
+	}
+	reg := lg.newReg()
+	lg.emit("%s = call %s %s(%s)", reg, llvmTypes[fn.ReturnType], llvmMoveName(fn.Name), strings.Join(args, ", "))
+	return llvmValue{reg, fn.ReturnType}
+}
+
+// generateLogical generates short-circuit and/or: the right side only runs
+// when the left side does not decide the result
+func (lg *LLVMGen) generateLogical(e *BinaryExpr) llvmValue {
+	left := lg.generateExpression(e.Left)
+	leftBlock := lg.block
+	rightLabel, endLabel := lg.newLabel(e.Operator+".rhs"), lg.newLabel(e.Operator+".end")
+	shortCircuit := "false"
+	if e.Operator == "and" {
+		lg.terminate("br i1 %s, label %%%s, label %%%s", left.ref, rightLabel, endLabel)
+	} else {
+		shortCircuit = "true"
+		lg.terminate("br i1 %s, label %%%s, label %%%s", left.ref, endLabel, rightLabel)
+	}
+
+	lg.startBlock(rightLabel)
+	right := lg.generateExpression(e.Right)
+	rightBlock := lg.block
+	lg.terminate("br label %%%s", endLabel)
+
+	lg.startBlock(endLabel)
+	reg := lg.newReg()
+	lg.emit("%s = phi i1 [ %s, %%%s ], [ %s, %%%s ]", reg, shortCircuit, leftBlock, right.ref, rightBlock)
+	return llvmValue{reg, "Voltorb"}
+}
+
+var llvmIntPredicates = map[string]string{"==": "eq", "!=": "ne", "<": "slt", "<=": "sle", ">": "sgt", ">=": "sge"}
+var llvmFloatPredicates = map[string]string{"==": "oeq", "!=": "une", "<": "olt", "<=": "ole", ">": "ogt", ">=": "oge"}
+
+func (lg *LLVMGen) generateComparison(op string, left, right llvmValue) llvmValue {
+	reg := lg.newReg()
+	switch {
+	case left.pokemonType == "Eevee":
+		cmp := lg.newReg()
+		lg.emit("%s = call i32 @strcmp(ptr %s, ptr %s)", cmp, left.ref, right.ref)
+		lg.emit("%s = icmp %s i32 %s, 0", reg, llvmIntPredicates[op], cmp)
+	case left.pokemonType == "Voltorb":
+		lg.emit("%s = icmp %s i1 %s, %s", reg, llvmIntPredicates[op], left.ref, right.ref)
+	case left.pokemonType == "Pikachu" && right.pokemonType == "Pikachu":
+		lg.emit("%s = icmp %s i64 %s, %s", reg, llvmIntPredicates[op], left.ref, right.ref)
+	default:
+		left, right = lg.convert(left, "Psyduck"), lg.convert(right, "Psyduck")
+		lg.emit("%s = fcmp %s double %s, %s", reg, llvmFloatPredicates[op], left.ref, right.ref)
+	}
+	return llvmValue{reg, "Voltorb"}
+}
+
+var llvmIntOps = map[string]string{"+": "add", "-": "sub", "*": "mul", "/": "sdiv", "%": "srem"}
+var llvmFloatOps = map[string]string{"+": "fadd", "-": "fsub", "*": "fmul", "/": "fdiv", "%": "frem"}
+
+// generateArithmetic generates + - * / % **, in floating point when either
+// side is a Psyduck. Dividing by zero and raising a Pikachu to a negative
+// power are runtime errors, as in the interpreter.
+func (lg *LLVMGen) generateArithmetic(e *BinaryExpr, left, right llvmValue) llvmValue {
+	resultType, llvmType, op, zero := "Pikachu", "i64", llvmIntOps[e.Operator], "icmp eq i64 %s, 0"
+	if left.pokemonType == "Psyduck" || right.pokemonType == "Psyduck" {
+		resultType, llvmType, op, zero = "Psyduck", "double", llvmFloatOps[e.Operator], "fcmp oeq double %s, 0.0"
+		left, right = lg.convert(left, "Psyduck"), lg.convert(right, "Psyduck")
+	}
+
+	reg := lg.newReg()
+	switch {
+	case e.Operator == "**" && resultType == "Psyduck":
+		lg.emit("%s = call double @llvm.pow.f64(double %s, double %s)", reg, left.ref, right.ref)
+		return llvmValue{reg, resultType}
+	case e.Operator == "**":
+		lg.failIf("icmp slt i64 %s, 0", right.ref, "pow", negativeExponent, e)
+		lg.emit("%s = call i64 @ozul_ipow(i64 %s, i64 %s)", reg, left.ref, right.ref)
+		return llvmValue{reg, resultType}
+	case op == "":
+		panic(fmt.Sprintf("[OZUL LLVM Error] Unknown operator: %s", e.Operator))
+	case e.Operator == "/" || e.Operator == "%":
+		lg.failIf(zero, right.ref, "div", "Division by zero.", e)
+		if resultType == "Pikachu" {
+			return lg.generateIntDivision(op, left, right)
+		}
+	}
+	lg.emit("%s = %s %s %s, %s", reg, op, llvmType, left.ref, right.ref)
+	return llvmValue{reg, resultType}
+}
+
+// generateIntDivision divides Pikachus with sdiv or srem. Dividing the
+// smallest Pikachu by -1 overflows, which traps, so the division is by 1
+// instead and the quotient is negated, wrapping around like the other
+// backends.
+func (lg *LLVMGen) generateIntDivision(op string, left, right llvmValue) llvmValue {
+	minusOne, divisor, result := lg.newReg(), lg.newReg(), lg.newReg()
+	lg.emit("%s = icmp eq i64 %s, -1", minusOne, right.ref)
+	lg.emit("%s = select i1 %s, i64 1, i64 %s", divisor, minusOne, right.ref)
+	lg.emit("%s = %s i64 %s, %s", result, op, left.ref, divisor)
+	if op == "srem" {
+		return llvmValue{result, "Pikachu"}
+	}
+	negated, reg := lg.newReg(), lg.newReg()
+	lg.emit("%s = sub i64 0, %s", negated, result)
+	lg.emit("%s = select i1 %s, i64 %s, i64 %s", reg, minusOne, negated, result)
+	return llvmValue{reg, "Pikachu"}
+}
+
+// failIf stops the program with message when test, filled in with value,
+// is true. The blocks it branches to are named after prefix.
+func (lg *LLVMGen) failIf(test, value, prefix, message string, e Expression) {
+	failed := lg.newReg()
+	failLabel, okLabel := lg.newLabel(prefix+".fail"), lg.newLabel(prefix+".ok")
+	lg.emit("%s = "+test, failed, value)
+	lg.terminate("br i1 %s, label %%%s, label %%%s", failed, failLabel, okLabel)
+	lg.startBlock(failLabel)
+	lg.emit("call void @ozul_fail(ptr %s, i64 %d)", lg.constant(message), e.Pos().Start.Line)
+	lg.terminate("unreachable")
+	lg.startBlock(okLabel)
+}
+
+// convert widens a Pikachu to a Psyduck where a Psyduck is expected
+func (lg *LLVMGen) convert(value llvmValue, pokemonType string) llvmValue {
+	if pokemonType == "Psyduck" && value.pokemonType == "Pikachu" {
+		reg := lg.newReg()
+		lg.emit("%s = sitofp i64 %s to double", reg, value.ref)
+		return llvmValue{reg, "Psyduck"}
+	}
+	return value
+}
+
+// toString converts a value for string concatenation, formatting numbers
+// the way the interpreter does
+func (lg *LLVMGen) toString(value llvmValue) llvmValue {
+	reg := lg.newReg()
+	switch value.pokemonType {
+	case "Eevee":
+		return value
+	case "Pikachu":
+		lg.emit("%s = call ptr @ozul_int_str(i64 %s)", reg, value.ref)
+	case "Psyduck":
+		lg.emit("%s = call ptr @ozul_float_str(double %s)", reg, value.ref)
+	case "Voltorb":
+		lg.emit("%s = select i1 %s, ptr @.str.true, ptr @.str.false", reg, value.ref)
+	}
+	return llvmValue{reg, "Eevee"}
+}
+
+// WriteObjectFile compiles LLVM IR to a native object file with llc, which
+// must be on the PATH. Link the object with the C library to get a program,
+// for example with "cc prog.o -o prog".
+func WriteObjectFile(ir string, path string) error {
+	llc, err := exec.LookPath("llc")
+	if err != nil {
+		return fmt.Errorf("llc was not found on the PATH; install LLVM or use --target=llvm and compile the .ll file yourself")
+	}
+	args := []string{"-filetype=obj", "-relocation-model=pic", "-o", path, "-"}
+	if llvmMajorVersion(llc) < 15 {
+		// Opaque pointers are the default from LLVM 15 on
+		args = append([]string{"-opaque-pointers"}, args...)
+	}
+	cmd := exec.Command(llc, args...)
+	cmd.Stdin = strings.NewReader(ir)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("llc failed: %v\n%s", err, stderr.String())
+	}
+	return nil
+}
+
+// llvmMajorVersion asks an LLVM tool for its major version, or returns 0
+// if it cannot tell
+func llvmMajorVersion(tool string) int {
+	out, err := exec.Command(tool, "--version").Output()
+	if err != nil {
+		return 0
+	}
+	match := regexp.MustCompile(`LLVM version (\d+)`).FindSubmatch(out)
+	if match == nil {
+		return 0
+	}
+	major, _ := strconv.Atoi(string(match[1]))
+	return major
+}
+
+// llvmRuntime declares the C library functions the generated code calls
+// and defines the small runtime it needs: number formatting that matches
+// the interpreter, string joining, reading input and failing with an error.
+const llvmRuntime = `declare i32 @printf(ptr, ...)
+declare i32 @snprintf(ptr, i64, ptr, ...)
+declare i32 @dprintf(i32, ptr, ...)
+declare i32 @puts(ptr)
+declare i32 @getchar()
+declare i32 @fflush(ptr)
+declare ptr @malloc(i64)
+declare ptr @realloc(ptr, i64)
+declare i64 @strlen(ptr)
+declare ptr @memcpy(ptr, ptr, i64)
+declare i32 @strcmp(ptr, ptr)
+declare ptr @strchr(ptr, i32)
+declare i32 @atoi(ptr)
+declare double @strtod(ptr, ptr)
+declare i64 @strtoll(ptr, ptr, i32)
+declare i32 @isspace(i32)
+declare void @exit(i32)
+declare double @llvm.pow.f64(double, double)
+
+@.fmt.int = private unnamed_addr constant [6 x i8] c"%lld\0A\00"
+@.fmt.lld = private unnamed_addr constant [5 x i8] c"%lld\00"
+@.fmt.f = private unnamed_addr constant [3 x i8] c"%f\00"
+@.fmt.e = private unnamed_addr constant [5 x i8] c"%.*e\00"
+@.fmt.fixed = private unnamed_addr constant [5 x i8] c"%.*f\00"
+@.fmt.prompt = private unnamed_addr constant [21 x i8] c"Enter value for %s: \00"
+@.fmt.error = private unnamed_addr constant [29 x i8] c"[OZUL Error] %s (line %lld)\0A\00"
+@.fmt.badint = private unnamed_addr constant [87 x i8] c"[OZUL Error] Expected a Pikachu (whole number) from the trainer, got \22%s\22 (line %lld)\0A\00"
+@.fmt.badfloat = private unnamed_addr constant [81 x i8] c"[OZUL Error] Expected a Psyduck (number) from the trainer, got \22%s\22 (line %lld)\0A\00"
+@.str.true = private unnamed_addr constant [5 x i8] c"true\00"
+@.str.false = private unnamed_addr constant [6 x i8] c"false\00"
+@.str.nan = private unnamed_addr constant [4 x i8] c"NaN\00"
+@.str.pinf = private unnamed_addr constant [5 x i8] c"+Inf\00"
+@.str.ninf = private unnamed_addr constant [5 x i8] c"-Inf\00"
+
+define internal void @ozul_fail(ptr %msg, i64 %line) noreturn {
+entry:
+  %0 = call i32 @fflush(ptr null)
+  %1 = call i32 (i32, ptr, ...) @dprintf(i32 2, ptr @.fmt.error, ptr %msg, i64 %line)
+  call void @exit(i32 1)
+  unreachable
+}
+
+; Raises a Pikachu to a power of 0 or more by squaring, wrapping on overflow
+define internal i64 @ozul_ipow(i64 %base, i64 %exp) {
+entry:
+  br label %loop
+loop:
+  %result = phi i64 [ 1, %entry ], [ %next.result, %body ]
+  %b = phi i64 [ %base, %entry ], [ %next.b, %body ]
+  %e = phi i64 [ %exp, %entry ], [ %next.e, %body ]
+  %done = icmp eq i64 %e, 0
+  br i1 %done, label %exit, label %body
+body:
+  %bit = and i64 %e, 1
+  %odd = icmp ne i64 %bit, 0
+  %times = mul i64 %result, %b
+  %next.result = select i1 %odd, i64 %times, i64 %result
+  %next.b = mul i64 %b, %b
+  %next.e = lshr i64 %e, 1
+  br label %loop
+exit:
+  ret i64 %result
+}
+
+define internal ptr @ozul_concat(ptr %a, ptr %b) {
+entry:
+  %la = call i64 @strlen(ptr %a)
+  %lb = call i64 @strlen(ptr %b)
+  %sum = add i64 %la, %lb
+  %size = add i64 %sum, 1
+  %buf = call ptr @malloc(i64 %size)
+  %0 = call ptr @memcpy(ptr %buf, ptr %a, i64 %la)
+  %tail = getelementptr i8, ptr %buf, i64 %la
+  %lb1 = add i64 %lb, 1
+  %1 = call ptr @memcpy(ptr %tail, ptr %b, i64 %lb1)
+  ret ptr %buf
+}
+
+define internal ptr @ozul_int_str(i64 %v) {
+entry:
+  %buf = call ptr @malloc(i64 24)
+  %0 = call i32 (ptr, i64, ptr, ...) @snprintf(ptr %buf, i64 24, ptr @.fmt.lld, i64 %v)
+  ret ptr %buf
+}
+
+; NaN and the infinities are spelled like Go spells them; null otherwise
+define internal ptr @ozul_float_special(double %v) {
+entry:
+  %nan = fcmp uno double %v, %v
+  br i1 %nan, label %is.nan, label %not.nan
+is.nan:
+  ret ptr @.str.nan
+not.nan:
+  %pinf = fcmp oeq double %v, 0x7FF0000000000000
+  br i1 %pinf, label %is.pinf, label %not.pinf
+is.pinf:
+  ret ptr @.str.pinf
+not.pinf:
+  %ninf = fcmp oeq double %v, 0xFFF0000000000000
+  br i1 %ninf, label %is.ninf, label %finite
+is.ninf:
+  ret ptr @.str.ninf
+finite:
+  ret ptr null
+}
+
+; Six decimals, like Go's %f, for joining a Psyduck onto a string
+define internal ptr @ozul_float_str(double %v) {
+entry:
+  %special = call ptr @ozul_float_special(double %v)
+  %is.special = icmp ne ptr %special, null
+  br i1 %is.special, label %done.special, label %finite
+done.special:
+  ret ptr %special
+finite:
+  %n = call i32 (ptr, i64, ptr, ...) @snprintf(ptr null, i64 0, ptr @.fmt.f, double %v)
+  %n64 = sext i32 %n to i64
+  %size = add i64 %n64, 1
+  %buf = call ptr @malloc(i64 %size)
+  %0 = call i32 (ptr, i64, ptr, ...) @snprintf(ptr %buf, i64 %size, ptr @.fmt.f, double %v)
+  ret ptr %buf
+}
+
+; Like Go's %v: the fewest digits that read back as the same value, in
+; exponent form when the exponent is below -4 or at least 6
+define internal ptr @ozul_format_float(double %v) {
+entry:
+  %special = call ptr @ozul_float_special(double %v)
+  %is.special = icmp ne ptr %special, null
+  br i1 %is.special, label %done.special, label %finite
+done.special:
+  ret ptr %special
+finite:
+  %buf = call ptr @malloc(i64 40)
+  br label %try
+try:
+  %p = phi i32 [ 0, %finite ], [ %next, %retry ]
+  %0 = call i32 (ptr, i64, ptr, ...) @snprintf(ptr %buf, i64 40, ptr @.fmt.e, i32 %p, double %v)
+  %back = call double @strtod(ptr %buf, ptr null)
+  %same = fcmp oeq double %back, %v
+  %last = icmp sge i32 %p, 16
+  %stop = or i1 %same, %last
+  br i1 %stop, label %found, label %retry
+retry:
+  %next = add i32 %p, 1
+  br label %try
+found:
+  %e = call ptr @strchr(ptr %buf, i32 101)
+  %exp.text = getelementptr i8, ptr %e, i64 1
+  %exp = call i32 @atoi(ptr %exp.text)
+  %small = icmp slt i32 %exp, -4
+  %big = icmp sge i32 %exp, 6
+  %scientific = or i1 %small, %big
+  br i1 %scientific, label %done, label %fixed
+done:
+  ret ptr %buf
+fixed:
+  %d = sub i32 %p, %exp
+  %neg = icmp slt i32 %d, 0
+  %digits = select i1 %neg, i32 0, i32 %d
+  %1 = call i32 (ptr, i64, ptr, ...) @snprintf(ptr %buf, i64 40, ptr @.fmt.fixed, i32 %digits, double %v)
+  ret ptr %buf
+}
+
+; Reads one line from standard input without its line ending
+define internal ptr @ozul_read_line() {
+entry:
+  %0 = call i32 @fflush(ptr null)
+  %first = call ptr @malloc(i64 16)
+  br label %loop
+loop:
+  %buf = phi ptr [ %first, %entry ], [ %buf.next, %append ]
+  %len = phi i64 [ 0, %entry ], [ %len.next, %append ]
+  %cap = phi i64 [ 16, %entry ], [ %cap.next, %append ]
+  %c = call i32 @getchar()
+  %eof = icmp eq i32 %c, -1
+  %nl = icmp eq i32 %c, 10
+  %end = or i1 %eof, %nl
+  br i1 %end, label %done, label %check
+check:
+  %len.next = add i64 %len, 1
+  %full = icmp sge i64 %len.next, %cap
+  br i1 %full, label %grow, label %append
+grow:
+  %bigger = mul i64 %cap, 2
+  %grown = call ptr @realloc(ptr %buf, i64 %bigger)
+  br label %append
+append:
+  %buf.next = phi ptr [ %buf, %check ], [ %grown, %grow ]
+  %cap.next = phi i64 [ %cap, %check ], [ %bigger, %grow ]
+  %slot = getelementptr i8, ptr %buf.next, i64 %len
+  %ch = trunc i32 %c to i8
+  store i8 %ch, ptr %slot
+  br label %loop
+done:
+  %has.text = icmp sgt i64 %len, 0
+  br i1 %has.text, label %check.cr, label %terminate
+check.cr:
+  %last = sub i64 %len, 1
+  %last.slot = getelementptr i8, ptr %buf, i64 %last
+  %last.ch = load i8, ptr %last.slot
+  %is.cr = icmp eq i8 %last.ch, 13
+  %trimmed = select i1 %is.cr, i64 %last, i64 %len
+  br label %terminate
+terminate:
+  %final = phi i64 [ %len, %done ], [ %trimmed, %check.cr ]
+  %end.slot = getelementptr i8, ptr %buf, i64 %final
+  store i8 0, ptr %end.slot
+  ret ptr %buf
+}
+
+; True when a number was read and only spaces follow it
+define internal i1 @ozul_parsed(ptr %text, ptr %end) {
+entry:
+  %none = icmp eq ptr %text, %end
+  br i1 %none, label %no, label %loop
+loop:
+  %p = phi ptr [ %end, %entry ], [ %next, %space ]
+  %c = load i8, ptr %p
+  %at.end = icmp eq i8 %c, 0
+  br i1 %at.end, label %yes, label %check
+check:
+  %ci = zext i8 %c to i32
+  %sp = call i32 @isspace(i32 %ci)
+  %is.space = icmp ne i32 %sp, 0
+  br i1 %is.space, label %space, label %no
+space:
+  %next = getelementptr i8, ptr %p, i64 1
+  br label %loop
+yes:
+  ret i1 true
+no:
+  ret i1 false
+}
+
+define internal ptr @ozul_read_string(ptr %name) {
+entry:
+  %0 = call i32 (ptr, ...) @printf(ptr @.fmt.prompt, ptr %name)
+  %text = call ptr @ozul_read_line()
+  ret ptr %text
+}
+
+define internal i64 @ozul_read_int(ptr %name, i64 %line) {
+entry:
+  %end.ptr = alloca ptr
+  %0 = call i32 (ptr, ...) @printf(ptr @.fmt.prompt, ptr %name)
+  %text = call ptr @ozul_read_line()
+  %v = call i64 @strtoll(ptr %text, ptr %end.ptr, i32 10)
+  %end = load ptr, ptr %end.ptr
+  %ok = call i1 @ozul_parsed(ptr %text, ptr %end)
+  br i1 %ok, label %good, label %bad
+good:
+  ret i64 %v
+bad:
+  %1 = call i32 @fflush(ptr null)
+  %2 = call i32 (i32, ptr, ...) @dprintf(i32 2, ptr @.fmt.badint, ptr %text, i64 %line)
+  call void @exit(i32 1)
+  unreachable
+}
+
+define internal double @ozul_read_float(ptr %name, i64 %line) {
+entry:
+  %end.ptr = alloca ptr
+  %0 = call i32 (ptr, ...) @printf(ptr @.fmt.prompt, ptr %name)
+  %text = call ptr @ozul_read_line()
+  %v = call double @strtod(ptr %text, ptr %end.ptr)
+  %end = load ptr, ptr %end.ptr
+  %ok = call i1 @ozul_parsed(ptr %text, ptr %end)
+  br i1 %ok, label %good, label %bad
+good:
+  ret double %v
+bad:
+  %1 = call i32 @fflush(ptr null)
+  %2 = call i32 (i32, ptr, ...) @dprintf(i32 2, ptr @.fmt.badfloat, ptr %text, i64 %line)
+  call void @exit(i32 1)
+  unreachable
+}
+`
@@ -1,84 +1,587 @@
 package main
 
 import (
+	"bufio"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"strings"
 )
 
+// Exit statuses of the ozul command
+const (
+	exitOK    = 0 // success
+	exitError = 1 // the program has errors, fails at runtime, or a file could not be read or written
+	exitUsage = 2 // the command line itself is wrong
+)
+
+// stdinName stands for the program read from stdin, in place of a file name
+const stdinName = "-"
+
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: ozul <source.ozul> [-c -o output.c] [-debug]")
-		fmt.Println("  (no flags): interpret and run the OZUL program directly")
-		fmt.Println("  -c: generate C code instead of running (advanced)")
-		fmt.Println("  -o output.c: write C code to output file (with -c)")
-		fmt.Println("  -debug: show debug info (tokens, AST)")
-		os.Exit(1)
-	}
-
-	sourceFile := os.Args[1]
-	var outputFile string
-	generateC := false
-
-	// Parse command line arguments
-	for i := 2; i < len(os.Args); i++ {
-		arg := os.Args[i]
-		if arg == "-o" && i+1 < len(os.Args) {
-			outputFile = os.Args[i+1]
-			i++ // Skip next argument
-		} else if arg == "-debug" {
-			// debug = true // This line is removed as per the edit hint
-		} else if arg == "-c" {
-			generateC = true
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// cli holds the streams the ozul command works with
+type cli struct {
+	stdin          io.Reader
+	stdout, stderr io.Writer
+	columns        ColumnUnit // what positions are counted in, set by --columns
+}
+
+// subcommand is one of the ozul commands, like "run" or "build"
+type subcommand struct {
+	name    string
+	summary string // one line for the command list
+	help    string // the longer description shown by --help
+	run     func(c *cli, args []string) int
+}
+
+var subcommands []subcommand
+
+func init() {
+	// Set in init, because help refers back to the list
+	subcommands = []subcommand{
+		{"run", "interpret and run a program (the default: ozul <file.ozul>)",
+			"Interpret and run a program, reading catch input from stdin.", (*cli).runCommand},
+		{"build", "translate a program to C, LLVM IR, an object file, WebAssembly, JavaScript or Go",
+			"Translate a program for another platform. Without -o the result is printed,\nexcept for obj and wasm, which are binary and need -o.", (*cli).buildCommand},
+		{"check", "check a program for syntax and type errors without running it",
+			"Check a program for syntax and type errors without running it. Prints\nnothing when the program is fine.", (*cli).checkCommand},
+		{"fmt", "print a program in the standard layout",
+			"Print a program in the standard layout: one statement per line, blocks\nindented by four spaces and single spaces around operators.", (*cli).fmtCommand},
+		{"tokens", "print the tokens of a program",
+			"Print the tokens of a program, one per line with its span.", (*cli).tokensCommand},
+		{"ast", "print the syntax tree of a program",
+			"Print the syntax tree of a program. Syntax errors are reported after the\ntree, with the nodes they left out shown as <missing>.", (*cli).astCommand},
+		{"repl", "start the interactive REPL (the default with no arguments)",
+			"Start the interactive REPL.", (*cli).replCommand},
+		{"help", "show help for ozul or one of its commands",
+			"Show help for ozul, or for the command given.", (*cli).helpCommand},
+	}
+}
+
+// run is the ozul command: it handles the arguments (without the program
+// name) using the given streams, and returns the exit status
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	c := &cli{stdin: stdin, stdout: stdout, stderr: stderr}
+	if len(args) == 0 {
+		return c.replCommand(nil)
+	}
+	switch args[0] {
+	case "-h", "-help", "--help":
+		c.usage(stdout)
+		return exitOK
+	}
+	if cmd := findSubcommand(args[0]); cmd != nil {
+		return cmd.run(c, args[1:])
+	}
+	// "ozul prog.ozul -vm" is short for "ozul run prog.ozul -vm"
+	if strings.HasPrefix(args[0], "-") || strings.HasSuffix(args[0], ".ozul") {
+		return c.runCommand(args)
+	}
+	fmt.Fprintf(stderr, "ozul: unknown command %q\n", args[0])
+	fmt.Fprintln(stderr, "Run 'ozul help' for the list of commands.")
+	return exitUsage
+}
+
+func findSubcommand(name string) *subcommand {
+	for i := range subcommands {
+		if subcommands[i].name == name {
+			return &subcommands[i]
+		}
+	}
+	return nil
+}
+
+// usage prints the overview of every command
+func (c *cli) usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: ozul <command> [flags] <file.ozul | ->")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range subcommands {
+		fmt.Fprintf(w, "  %-7s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Flags may come before or after the file. Use - as the file to read the")
+	fmt.Fprintln(w, "program from stdin.")
+	fmt.Fprintln(w, "Run 'ozul <command> --help' for the flags of a command.")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Exit status: 0 on success, 1 when the program has errors or fails, 2 when")
+	fmt.Fprintln(w, "the command line is wrong.")
+}
+
+// flagSet creates the flags of a subcommand. Errors go to stderr; --help is
+// handled by parse.
+func (c *cli) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("ozul "+name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.Usage = func() {}
+	return fs
+}
+
+// parse reads the flags of a subcommand, which may come before, after or
+// between the other arguments, and checks that exactly one file is given.
+// When it returns ok false, the command should exit with code.
+func (c *cli) parse(fs *flag.FlagSet, args []string) (file string, code int, ok bool) {
+	var files []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				c.commandUsage(c.stdout, fs)
+				return "", exitOK, false
+			}
+			// The flag package has already printed the error
+			fmt.Fprintf(c.stderr, "Run '%s --help' for usage.\n", fs.Name())
+			return "", exitUsage, false
+		}
+		rest := fs.Args()
+		if len(rest) == 0 {
+			break
 		}
+		// After "--" every argument is a file, even one starting with "-"
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			files = append(files, rest...)
+			break
+		}
+		files = append(files, rest[0])
+		args = rest[1:]
 	}
 
-	source, err := ioutil.ReadFile(sourceFile)
+	if len(files) != 1 {
+		if len(files) == 0 {
+			fmt.Fprintf(c.stderr, "%s: expected a file (or - for stdin)\n", fs.Name())
+		} else {
+			fmt.Fprintf(c.stderr, "%s: expected one file, got %d: %s\n", fs.Name(), len(files), strings.Join(files, " "))
+		}
+		fmt.Fprintf(c.stderr, "Run '%s --help' for usage.\n", fs.Name())
+		return "", exitUsage, false
+	}
+	return files[0], exitOK, true
+}
+
+// commandUsage prints the help of a subcommand and its flags
+func (c *cli) commandUsage(w io.Writer, fs *flag.FlagSet) {
+	cmd := findSubcommand(strings.TrimPrefix(fs.Name(), "ozul "))
+	hasFlags := false
+	fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+	if hasFlags {
+		fmt.Fprintf(w, "Usage: %s [flags] <file.ozul | ->\n\n", fs.Name())
+	} else {
+		fmt.Fprintf(w, "Usage: %s <file.ozul | ->\n\n", fs.Name())
+	}
+	fmt.Fprintln(w, cmd.help)
+	if hasFlags {
+		fmt.Fprintln(w, "\nFlags:")
+		fs.SetOutput(w)
+		fs.PrintDefaults()
+		fs.SetOutput(c.stderr)
+	}
+}
+
+// readSource reads the program from a file, or from stdin for "-"
+func (c *cli) readSource(file string) (string, bool) {
+	var source []byte
+	var err error
+	if file == stdinName {
+		source, err = ioutil.ReadAll(c.stdin)
+	} else {
+		source, err = ioutil.ReadFile(file)
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "[ERROR] Error reading source file: %v\n", err)
-		os.Exit(1)
+		fmt.Fprintf(c.stderr, "[ERROR] Error reading source file: %v\n", err)
+		return "", false
 	}
+	return string(source), true
+}
 
-	// Lexing
-	lexer := NewLexer(string(source))
+// lex splits a program into tokens, returning any errors in its text
+func (c *cli) lex(source string) ([]Token, []PokemonError) {
+	lexer := NewLexer(source)
+	lexer.SetColumnUnit(c.columns)
 	tokens := lexer.Tokenize()
+	return tokens, lexer.Errors()
+}
+
+// columnsFlag adds --columns to the commands whose positions editors read
+func (c *cli) columnsFlag(fs *flag.FlagSet) {
+	fs.Var((*columnsValue)(&c.columns), "columns", "count columns in `unit`s: runes (characters) or utf16 (as editors using LSP do)")
+}
+
+// columnsValue reads and prints a ColumnUnit as a flag
+type columnsValue ColumnUnit
+
+func (v *columnsValue) String() string {
+	if v != nil && ColumnUnit(*v) == ColumnUTF16 {
+		return "utf16"
+	}
+	return "runes"
+}
+
+func (v *columnsValue) Set(s string) error {
+	switch s {
+	case "runes":
+		*v = columnsValue(ColumnRunes)
+	case "utf16":
+		*v = columnsValue(ColumnUTF16)
+	default:
+		return errors.New("expected runes or utf16")
+	}
+	return nil
+}
 
-	// Parsing
+// debugValue reads -debug, which dumps the tokens and AST as text, or the
+// older -debug=json, which dumps them as JSON
+type debugValue string
+
+func (v *debugValue) String() string {
+	if v == nil {
+		return ""
+	}
+	return string(*v)
+}
+
+func (v *debugValue) Set(s string) error {
+	switch s {
+	case "true":
+		*v = "text"
+	case "false":
+		*v = ""
+	case "json":
+		*v = "json"
+	default:
+		return errors.New("expected no value or json")
+	}
+	return nil
+}
+
+// IsBoolFlag lets -debug be given without a value
+func (v *debugValue) IsBoolFlag() bool { return true }
+
+// sourceName is how diagnostics refer to the program
+func sourceName(file string) string {
+	if file == stdinName {
+		return "<stdin>"
+	}
+	return file
+}
+
+// load reads, parses and optionally type checks a program, reporting any
+// errors. It returns a nil program when there were errors.
+func (c *cli) load(file string, typeCheck bool) (program *Program, tokens []Token, source string) {
+	source, ok := c.readSource(file)
+	if !ok {
+		return nil, nil, ""
+	}
+	tokens, errs := c.lex(source)
+	parser := NewParser(tokens)
+	program = parser.Parse()
+	if errs = append(errs, parser.Errors()...); len(errs) > 0 {
+		c.report(sourceName(file), source, errs)
+		return nil, tokens, source
+	}
+	if typeCheck {
+		if errs := NewChecker().Check(program); len(errs) > 0 {
+			c.report(sourceName(file), source, errs)
+			return nil, tokens, source
+		}
+	}
+	return program, tokens, source
+}
+
+func (c *cli) runCommand(args []string) int {
+	fs := c.flagSet("run")
+	useVM := fs.Bool("vm", false, "run on the bytecode virtual machine (faster for long programs)")
+	maxSteps := fs.Int("steps", DefaultMaxSteps, "stop after `N` executed statements (0 = unlimited)")
+	var debug debugValue
+	fs.Var(&debug, "debug", "print the tokens and AST to stderr before running (-debug=json is deprecated)")
+	file, code, ok := c.parse(fs, args)
+	if !ok {
+		return code
+	}
+
+	source, ok := c.readSource(file)
+	if !ok {
+		return exitError
+	}
+	tokens, errs := c.lex(source)
 	parser := NewParser(tokens)
 	program := parser.Parse()
+	switch debug {
+	case "text":
+		DumpDebugText(c.stderr, tokens, program)
+	case "json":
+		fmt.Fprintln(c.stderr, "ozul: -debug=json is deprecated; use 'ozul tokens --json' and 'ozul ast --json' instead")
+		if err := DumpDebugJSON(c.stdout, tokens, program); err != nil {
+			fmt.Fprintf(c.stderr, "[ERROR] Error writing debug output: %v\n", err)
+			return exitError
+		}
+	}
+	if errs = append(errs, parser.Errors()...); len(errs) > 0 {
+		c.report(sourceName(file), source, errs)
+		return exitError
+	}
+	if debug == "json" {
+		// -debug=json only ever printed the program, without running it
+		return exitOK
+	}
+	if errs := NewChecker().Check(program); len(errs) > 0 {
+		c.report(sourceName(file), source, errs)
+		return exitError
+	}
 
-	if len(parser.Errors()) > 0 {
-		fmt.Println("Parser errors:")
-		for _, err := range parser.Errors() {
-			fmt.Println("  ", err)
+	var runner Runner = NewInterpreter()
+	if *useVM {
+		runner = NewVM()
+	}
+	runner.SetMaxSteps(*maxSteps)
+	runner.SetInput(bufio.NewReader(c.stdin))
+	runner.SetOutput(c.stdout)
+	if err := runner.Run(program); err != nil {
+		if runtimeErr, ok := err.(*RuntimeError); ok {
+			fmt.Fprint(c.stderr, runtimeErr.Diagnostic().Render(sourceName(file), source))
+		} else {
+			fmt.Fprintf(c.stderr, "[ERROR] %v\n", err)
 		}
-		os.Exit(1)
+		return exitError
 	}
+	return exitOK
+}
+
+// buildTargets describes each -target of ozul build, for messages
+var buildTargets = []struct{ name, written string }{
+	{"c", "C code"},
+	{"llvm", "LLVM IR"},
+	{"obj", "Object file"},
+	{"wat", "WebAssembly text"},
+	{"wasm", "WebAssembly module"},
+	{"js", "JavaScript"},
+	{"go", "Go source"},
+}
 
-	if generateC {
-		// Code generation (C)
+func (c *cli) buildCommand(args []string) int {
+	fs := c.flagSet("build")
+	target := fs.String("target", "c", "what to build: c, llvm, obj (a native object file, needs llc), wat, wasm, js or go")
+	output := fs.String("o", "", "write the result to `file` instead of printing it")
+	file, code, ok := c.parse(fs, args)
+	if !ok {
+		return code
+	}
+
+	written := ""
+	names := make([]string, len(buildTargets))
+	for i, t := range buildTargets {
+		names[i] = t.name
+		if t.name == *target {
+			written = t.written
+		}
+	}
+	if written == "" {
+		fmt.Fprintf(c.stderr, "ozul build: unknown target %q (expected %s)\n", *target, strings.Join(names, ", "))
+		return exitUsage
+	}
+	if (*target == "obj" || *target == "wasm") && *output == "" {
+		fmt.Fprintf(c.stderr, "ozul build: --target=%s needs an output file (-o file)\n", *target)
+		return exitUsage
+	}
+
+	program, _, _ := c.load(file, true)
+	if program == nil {
+		return exitError
+	}
+
+	var result []byte
+	switch *target {
+	case "c":
 		codegen := NewCodeGen()
 		codegen.GenerateProgram(program)
-		cCode := codegen.GetCode()
-		if outputFile != "" {
-			err := ioutil.WriteFile(outputFile, []byte(cCode), 0644)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "[ERROR] Error writing output file: %v\n", err)
-				os.Exit(1)
+		result = []byte(codegen.GetCode())
+	case "llvm", "obj":
+		llvmgen := NewLLVMGen()
+		llvmgen.GenerateProgram(program)
+		if *target == "obj" {
+			// llc writes the object file itself
+			if err := WriteObjectFile(llvmgen.GetIR(), *output); err != nil {
+				fmt.Fprintf(c.stderr, "[ERROR] %v\n", err)
+				return exitError
 			}
-			fmt.Printf("C code written to %s\n", outputFile)
+			fmt.Fprintf(c.stdout, "%s written to %s\n", written, *output)
+			return exitOK
+		}
+		result = []byte(llvmgen.GetIR())
+	case "wat", "wasm":
+		wasmgen := NewWasmGen()
+		wasmgen.GenerateProgram(program)
+		if *target == "wasm" {
+			result = wasmgen.GetWasm()
 		} else {
-			fmt.Println("\n=== GENERATED C CODE ===")
-			fmt.Println(cCode)
+			result = []byte(wasmgen.GetWAT())
+		}
+	case "js":
+		jsgen := NewJSGen()
+		jsgen.GenerateProgram(program)
+		result = []byte(jsgen.GetCode())
+	case "go":
+		gogen := NewGoGen()
+		gogen.GenerateProgram(program)
+		result = []byte(gogen.GetCode())
+	}
+
+	if *output == "" {
+		c.stdout.Write(result)
+		return exitOK
+	}
+	if err := ioutil.WriteFile(*output, result, 0644); err != nil {
+		fmt.Fprintf(c.stderr, "[ERROR] Error writing output file: %v\n", err)
+		return exitError
+	}
+	fmt.Fprintf(c.stdout, "%s written to %s\n", written, *output)
+	return exitOK
+}
+
+func (c *cli) checkCommand(args []string) int {
+	fs := c.flagSet("check")
+	c.columnsFlag(fs)
+	file, code, ok := c.parse(fs, args)
+	if !ok {
+		return code
+	}
+	if program, _, _ := c.load(file, true); program == nil {
+		return exitError
+	}
+	return exitOK
+}
+
+func (c *cli) fmtCommand(args []string) int {
+	fs := c.flagSet("fmt")
+	write := fs.Bool("w", false, "write the result back to the file instead of printing it")
+	file, code, ok := c.parse(fs, args)
+	if !ok {
+		return code
+	}
+	if *write && file == stdinName {
+		fmt.Fprintln(c.stderr, "ozul fmt: -w needs a file, not stdin")
+		return exitUsage
+	}
+
+	program, tokens, source := c.load(file, false)
+	if program == nil {
+		return exitError
+	}
+	formatted := Format(program, tokens)
+	if !*write {
+		fmt.Fprint(c.stdout, formatted)
+		return exitOK
+	}
+	if formatted == source {
+		return exitOK
+	}
+	if err := ioutil.WriteFile(file, []byte(formatted), 0644); err != nil {
+		fmt.Fprintf(c.stderr, "[ERROR] Error writing output file: %v\n", err)
+		return exitError
+	}
+	return exitOK
+}
+
+func (c *cli) tokensCommand(args []string) int {
+	fs := c.flagSet("tokens")
+	asJSON := fs.Bool("json", false, "print the tokens as JSON (see DumpDebugJSON in debug.go)")
+	c.columnsFlag(fs)
+	file, code, ok := c.parse(fs, args)
+	if !ok {
+		return code
+	}
+	source, ok := c.readSource(file)
+	if !ok {
+		return exitError
+	}
+	tokens, errs := c.lex(source)
+	if *asJSON {
+		if err := DumpDebugJSON(c.stdout, tokens, nil); err != nil {
+			fmt.Fprintf(c.stderr, "[ERROR] Error writing debug output: %v\n", err)
+			return exitError
 		}
 	} else {
-		// Interpret and run the program directly
-		interpreter := NewInterpreter()
-		defer func() {
-			if r := recover(); r != nil {
-				fmt.Fprintf(os.Stderr, "[ERROR] Interpreter panic: %v\n", r)
-			}
-		}()
-		interpreter.Run(program)
+		DumpTokensText(c.stdout, tokens)
+	}
+	if len(errs) > 0 {
+		c.report(sourceName(file), source, errs)
+		return exitError
+	}
+	return exitOK
+}
+
+func (c *cli) astCommand(args []string) int {
+	fs := c.flagSet("ast")
+	asJSON := fs.Bool("json", false, "print the tree as JSON (see DumpDebugJSON in debug.go)")
+	c.columnsFlag(fs)
+	file, code, ok := c.parse(fs, args)
+	if !ok {
+		return code
+	}
+	source, ok := c.readSource(file)
+	if !ok {
+		return exitError
+	}
+	tokens, errs := c.lex(source)
+	parser := NewParser(tokens)
+	program := parser.Parse()
+	if *asJSON {
+		if err := DumpDebugJSON(c.stdout, nil, program); err != nil {
+			fmt.Fprintf(c.stderr, "[ERROR] Error writing debug output: %v\n", err)
+			return exitError
+		}
+	} else {
+		DumpASTText(c.stdout, program)
+	}
+	if errs = append(errs, parser.Errors()...); len(errs) > 0 {
+		c.report(sourceName(file), source, errs)
+		return exitError
+	}
+	return exitOK
+}
+
+func (c *cli) replCommand(args []string) int {
+	fs := c.flagSet("repl")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(c.stdout, "Usage: ozul repl")
+			fmt.Fprintln(c.stdout)
+			fmt.Fprintln(c.stdout, findSubcommand("repl").help)
+			return exitOK
+		}
+		return exitUsage
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(c.stderr, "ozul repl: unexpected argument %q\n", fs.Arg(0))
+		return exitUsage
+	}
+	repl := NewREPL(c.stdin, c.stdout)
+	repl.SetHistoryFile(defaultHistoryFile())
+	repl.Run()
+	return exitOK
+}
+
+func (c *cli) helpCommand(args []string) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		c.usage(c.stdout)
+		return exitOK
+	}
+	cmd := findSubcommand(args[0])
+	if cmd == nil {
+		fmt.Fprintf(c.stderr, "ozul help: unknown command %q\n", args[0])
+		return exitUsage
+	}
+	return cmd.run(c, []string{"--help"})
+}
+
+// report prints diagnostics to stderr with the source they point at
+func (c *cli) report(file, source string, errs []PokemonError) {
+	sortErrors(errs)
+	for _, err := range errs {
+		fmt.Fprint(c.stderr, err.RenderIn(file, source, c.columns))
 	}
 }
@@ -0,0 +1,121 @@
+// ozul_host.js runs OZUL programs compiled with `ozul build --target=wasm`.
+// It provides the host functions the module imports from "ozul" and works
+// in browser pages (<script src="ozul_host.js">) and in Node.js
+// (require("./ozul_host.js")).
+//
+//   await runOzul(bytes, {
+//     write: text => ...,  // program output, including catch prompts
+//     read: name => ...,   // a line from the trainer for catch, or null
+//   })
+//
+// A runtime error rejects the promise with an OzulError whose message reads
+// like the compiled programs' "[OZUL Error] ... (line N)".
+(function (root) {
+  "use strict";
+
+  class OzulError extends Error {}
+
+  // formatFloat writes a Psyduck for release, like the interpreter (Go %v)
+  function formatFloat(x) {
+    if (Number.isNaN(x)) return "NaN";
+    if (!Number.isFinite(x)) return x > 0 ? "+Inf" : "-Inf";
+    if (x === 0) return Object.is(x, -0) ? "-0" : "0";
+    const [mantissa, exponent] = x.toExponential().split("e");
+    const exp = Number(exponent);
+    if (exp < -4 || exp >= 6) {
+      const digits = String(Math.abs(exp)).padStart(2, "0");
+      return mantissa + "e" + (exp < 0 ? "-" : "+") + digits;
+    }
+    return String(x); // plain notation in this range, with the same digits
+  }
+
+  // fixedFloat writes a Psyduck joined to an Eevee, like Go's %f: six
+  // decimals, with exact halves rounded to even
+  function fixedFloat(x) {
+    if (Number.isNaN(x)) return "NaN";
+    if (!Number.isFinite(x)) return x > 0 ? "+Inf" : "-Inf";
+    const sign = x < 0 || Object.is(x, -0) ? "-" : "";
+    x = Math.abs(x);
+    if (x >= 1e21) return sign + BigInt(x).toString() + ".000000";
+    let fixed = x.toFixed(6); // rounds halves away from zero
+    const exact = x.toFixed(100);
+    const dot = exact.indexOf(".");
+    if (/^50*$/.test(exact.slice(dot + 7)) && Number(exact[dot + 6]) % 2 === 0) {
+      fixed = exact.slice(0, dot + 7);
+    }
+    return sign + fixed;
+  }
+
+  function parseInt64(text) {
+    if (!/^[+-]?\d+$/.test(text)) return null;
+    const v = BigInt(text);
+    return BigInt.asIntN(64, v) === v ? v : null;
+  }
+
+  function parseFloat64(text) {
+    if (/^[+-]?(\d+\.?\d*|\.\d+)([eE][+-]?\d+)?$/.test(text)) return Number(text);
+    const special = { inf: Infinity, "+inf": Infinity, "-inf": -Infinity, infinity: Infinity, "+infinity": Infinity, "-infinity": -Infinity, nan: NaN };
+    const v = special[text.toLowerCase()];
+    return v === undefined ? null : v;
+  }
+
+  async function runOzul(bytes, io) {
+    let exports;
+    const encoder = new TextEncoder();
+    const decoder = new TextDecoder();
+    const text = (ptr, len) => decoder.decode(new Uint8Array(exports.memory.buffer, ptr, len));
+    const newString = (s) => {
+      const b = encoder.encode(s);
+      const ptr = exports.alloc(4 + b.length); // may grow memory, so views come after
+      new DataView(exports.memory.buffer).setUint32(ptr, b.length, true);
+      new Uint8Array(exports.memory.buffer, ptr + 4, b.length).set(b);
+      return ptr;
+    };
+    const fail = (message, line) => {
+      throw new OzulError(`[OZUL Error] ${message} (line ${line})`);
+    };
+    const read = (ptr, len) => {
+      const name = text(ptr, len);
+      io.write(`Enter value for ${name}: `);
+      const line = io.read(name);
+      return line == null ? "" : line.replace(/\r?\n$/, "");
+    };
+
+    const imports = {
+      ozul: {
+        release_int: (v) => io.write(v.toString() + "\n"),
+        release_float: (v) => io.write(formatFloat(v) + "\n"),
+        release_bool: (v) => io.write((v ? "true" : "false") + "\n"),
+        release_string: (ptr, len) => io.write(text(ptr, len) + "\n"),
+        catch_int: (ptr, len, line) => {
+          const input = read(ptr, len);
+          const v = parseInt64(input.trim());
+          if (v === null) fail(`Expected a Pikachu (whole number) from the trainer, got ${JSON.stringify(input)}`, line);
+          return v;
+        },
+        catch_float: (ptr, len, line) => {
+          const input = read(ptr, len);
+          const v = parseFloat64(input.trim());
+          if (v === null) fail(`Expected a Psyduck (number) from the trainer, got ${JSON.stringify(input)}`, line);
+          return v;
+        },
+        catch_string: (ptr, len) => newString(read(ptr, len)),
+        float_to_string: (v) => newString(fixedFloat(v)),
+        float_rem: (a, b) => a % b,
+        float_pow: (a, b) => Math.pow(a, b),
+        fail: (ptr, len, line) => fail(text(ptr, len), line),
+      },
+    };
+
+    const { instance } = await WebAssembly.instantiate(bytes, imports);
+    exports = instance.exports;
+    exports.run();
+  }
+
+  const api = { runOzul, OzulError, formatFloat, fixedFloat };
+  if (typeof module !== "undefined" && module.exports) {
+    module.exports = api;
+  } else {
+    Object.assign(root, api);
+  }
+})(typeof globalThis !== "undefined" ? globalThis : this);
@@ -2,13 +2,17 @@ package main
 
 import (
 	"fmt"
+	"strings"
 )
 
 type Parser struct {
-	tokens []Token
-	pos    int
-	cur    Token
-	errors []string
+	tokens     []Token
+	pos        int
+	cur        Token
+	prev       Token // last consumed token, where the node being built ends
+	errors     []PokemonError
+	depth      int  // how many blocks deep the current statement is
+	inFunction bool // whether we are inside a move body
 }
 
 func NewParser(tokens []Token) *Parser {
@@ -47,7 +51,7 @@ func (p *Parser) Parse() *Program {
 
 func (p *Parser) parseStatement() Statement {
 	switch p.cur.Type {
-	case PIKACHU, PSYDUCK, EEVEE:
+	case PIKACHU, PSYDUCK, EEVEE, VOLTORB:
 		return p.parseDeclaration()
 	case IDENTIFIER:
 		if p.peek().Type == EVOLVES_TO {
@@ -58,16 +62,30 @@ func (p *Parser) parseStatement() Statement {
 		return p.parseRelease()
 	case CATCH:
 		return p.parseCatch()
+	case IF:
+		return p.parseIf()
+	case TRAIN:
+		return p.parseWhile()
+	case REPEAT:
+		return p.parseRepeat()
+	case MOVE:
+		return p.parseFunction()
+	case RETURN:
+		return p.parseReturn()
 	case NEWLINE:
 		return nil
 	case EOF:
 		return nil
+	case ILLEGAL:
+		p.skipIllegalLine()
+		return nil
 	default:
 		return p.parseExpressionStatement()
 	}
 }
 
 func (p *Parser) parseDeclaration() Statement {
+	start := p.cur
 	pokemonType := p.cur.Value
 	p.nextToken() // consume Pokemon type
 
@@ -86,16 +104,19 @@ func (p *Parser) parseDeclaration() Statement {
 	}
 	p.nextToken() // consume 'is'
 
-	value := p.parseExpression(0)
+	value := p.parseExpression(precLowest)
 
 	return &DeclarationStmt{
 		PokemonType: pokemonType,
 		Name:        name,
 		Value:       value,
+		Doc:         docComment(start),
+		Span:        p.spanFrom(start),
 	}
 }
 
 func (p *Parser) parseAssignment() Statement {
+	start := p.cur
 	name := p.cur.Value
 	p.nextToken() // consume identifier
 
@@ -113,24 +134,33 @@ func (p *Parser) parseAssignment() Statement {
 	}
 	p.nextToken() // consume 'to'
 
-	value := p.parseExpression(0)
+	value := p.parseExpression(precLowest)
 
 	return &AssignmentStmt{
 		Name:  name,
 		Value: value,
+		Span:  p.spanFrom(start),
 	}
 }
 
 func (p *Parser) parseRelease() Statement {
+	start := p.cur
 	p.nextToken() // consume 'release'
-	value := p.parseExpression(0)
+	value := p.parseExpression(precLowest)
 
-	return &ReleaseStmt{Value: value}
+	return &ReleaseStmt{Value: value, Span: p.spanFrom(start)}
 }
 
 func (p *Parser) parseCatch() Statement {
+	start := p.cur
 	p.nextToken() // consume 'catch'
 
+	pokemonType := ""
+	if p.isTypeToken(p.cur.Type) {
+		pokemonType = p.cur.Value
+		p.nextToken() // consume Pokemon type
+	}
+
 	if p.cur.Type != IDENTIFIER {
 		p.addError("expected identifier after 'catch'")
 		p.nextToken()
@@ -153,65 +183,433 @@ func (p *Parser) parseCatch() Statement {
 	}
 	p.nextToken() // consume 'trainer'
 
-	return &CatchStmt{Variable: variable}
+	return &CatchStmt{PokemonType: pokemonType, Variable: variable, Span: p.spanFrom(start)}
 }
 
-func (p *Parser) parseExpressionStatement() Statement {
-	expr := p.parseExpression(0)
-	return &ReleaseStmt{Value: expr} // Treat bare expressions as release statements
-}
+func (p *Parser) parseIf() Statement {
+	start := p.cur
+	p.nextToken() // consume 'if'
+	condition := p.parseExpression(precLowest)
 
-func (p *Parser) parseExpression(precedence int) Expression {
-	left := p.parsePrimary()
+	broken := p.skipIllegalLine()
+	if !broken {
+		if p.cur.Type != THEN {
+			p.addError("expected 'then' after condition")
+			p.nextToken()
+			return nil
+		}
+		p.nextToken() // consume 'then'
+	}
 
-	for precedence < p.getPrecedence(p.cur.Type) {
-		if !p.isOperator(p.cur.Type) {
-			break
+	stmt := &IfStmt{Condition: condition}
+	stmt.Then = p.parseBlock(ELSE, END)
+
+	if p.cur.Type == ELSE {
+		stmt.ElseLine = p.cur.Line
+		p.nextToken() // consume 'else'
+		if p.cur.Type == IF {
+			// "else if" chains share the closing 'end' of the innermost if
+			nested := p.parseIf()
+			if nested == nil || broken {
+				return nil
+			}
+			stmt.Else = []Statement{nested}
+			stmt.Span = p.spanFrom(start)
+			return stmt
 		}
+		stmt.Else = p.parseBlock(END)
+	}
 
-		operator := p.cur.Value
+	if p.cur.Type != END {
+		p.addError("expected 'end' to close 'if'")
+		return nil
+	}
+	p.nextToken() // consume 'end'
+	if broken {
+		return nil
+	}
+
+	stmt.Span = p.spanFrom(start)
+	return stmt
+}
+
+func (p *Parser) parseWhile() Statement {
+	start := p.cur
+	p.nextToken() // consume 'train'
+
+	if p.cur.Type != WHILE {
+		p.addError("expected 'while' after 'train'")
 		p.nextToken()
+		return nil
+	}
+	p.nextToken() // consume 'while'
 
-		right := p.parseExpression(p.getPrecedence(TokenType(0))) // Use current operator precedence
+	condition := p.parseExpression(precLowest)
+	body := p.parseBlock(END)
 
-		left = &BinaryExpr{
-			Left:     left,
-			Operator: operator,
-			Right:    right,
+	if p.cur.Type != END {
+		p.addError("expected 'end' to close 'train while'")
+		return nil
+	}
+	p.nextToken() // consume 'end'
+
+	return &WhileStmt{Condition: condition, Body: body, Span: p.spanFrom(start)}
+}
+
+func (p *Parser) parseRepeat() Statement {
+	start := p.cur
+	p.nextToken() // consume 'repeat'
+	count := p.parseExpression(precLowest)
+
+	broken := p.skipIllegalLine()
+	if !broken {
+		if p.cur.Type != TIMES {
+			p.addError("expected 'times' after repeat count")
+			p.nextToken()
+			return nil
 		}
+		p.nextToken() // consume 'times'
+	}
+
+	body := p.parseBlock(END)
+
+	if p.cur.Type != END {
+		p.addError("expected 'end' to close 'repeat'")
+		return nil
+	}
+	p.nextToken() // consume 'end'
+	if broken {
+		return nil
 	}
 
-	return left
+	return &RepeatStmt{Count: count, Body: body, Span: p.spanFrom(start)}
 }
 
-func (p *Parser) parsePrimary() Expression {
-	switch p.cur.Type {
-	case NUMBER:
-		value := 0
-		fmt.Sscanf(p.cur.Value, "%d", &value)
+func (p *Parser) parseFunction() Statement {
+	if p.depth > 0 {
+		p.addError("moves can only be declared at the top level")
 		p.nextToken()
-		return &NumberLiteral{Value: value}
-	case FLOAT:
-		value := 0.0
-		fmt.Sscanf(p.cur.Value, "%f", &value)
+		return nil
+	}
+	start := p.cur
+	p.nextToken() // consume 'move'
+
+	if p.cur.Type != IDENTIFIER {
+		p.addError("expected move name after 'move'")
 		p.nextToken()
-		return &FloatLiteral{Value: value}
-	case STRING:
-		value := p.cur.Value
+		return nil
+	}
+	fn := &FunctionDecl{Name: p.cur.Value, Params: []Param{}, Doc: docComment(start)}
+	p.nextToken() // consume name
+
+	if p.cur.Type != LPAREN {
+		p.addError("expected '(' after move name")
 		p.nextToken()
-		return &StringLiteral{Value: value}
-	case IDENTIFIER:
-		name := p.cur.Value
+		return nil
+	}
+	p.nextToken() // consume '('
+
+	for p.cur.Type != RPAREN {
+		if !p.isTypeToken(p.cur.Type) {
+			p.addError("expected Pokemon type for parameter")
+			p.nextToken()
+			return nil
+		}
+		paramStart := p.cur
+		param := Param{PokemonType: p.cur.Value}
+		p.nextToken() // consume type
+
+		if p.cur.Type != IDENTIFIER {
+			p.addError("expected parameter name after Pokemon type")
+			p.nextToken()
+			return nil
+		}
+		param.Name = p.cur.Value
+		p.nextToken() // consume name
+		param.Span = p.spanFrom(paramStart)
+		fn.Params = append(fn.Params, param)
+
+		if p.cur.Type == COMMA {
+			p.nextToken() // consume ','
+		} else if p.cur.Type != RPAREN {
+			p.addError("expected ',' or ')' in parameter list")
+			p.nextToken()
+			return nil
+		}
+	}
+	p.nextToken() // consume ')'
+
+	if p.cur.Type == GIVES {
+		p.nextToken() // consume 'gives'
+		if !p.isTypeToken(p.cur.Type) {
+			p.addError("expected Pokemon type after 'gives'")
+			p.nextToken()
+			return nil
+		}
+		fn.ReturnType = p.cur.Value
+		p.nextToken() // consume type
+	}
+
+	p.inFunction = true
+	fn.Body = p.parseBlock(END)
+	p.inFunction = false
+
+	if p.cur.Type != END {
+		p.addError("expected 'end' to close 'move'")
+		return nil
+	}
+	p.nextToken() // consume 'end'
+
+	fn.Span = p.spanFrom(start)
+	return fn
+}
+
+func (p *Parser) parseReturn() Statement {
+	if !p.inFunction {
+		p.addError("'return' can only be used inside a move")
 		p.nextToken()
-		return &Identifier{Name: name}
-	default:
-		p.addError("unexpected token: " + p.cur.Value)
+		return nil
+	}
+	start := p.cur
+	p.nextToken() // consume 'return'
+
+	if p.cur.Type == NEWLINE || p.cur.Type == END || p.cur.Type == EOF {
+		return &ReturnStmt{Span: p.spanFrom(start)}
+	}
+	value := p.parseExpression(precLowest)
+	return &ReturnStmt{Value: value, Span: p.spanFrom(start)}
+}
+
+func (p *Parser) parseCallArgs() []Expression {
+	p.nextToken() // consume '('
+	args := []Expression{}
+	for p.cur.Type != RPAREN {
+		arg := p.parseExpression(precLowest)
+		if arg == nil {
+			return nil
+		}
+		args = append(args, arg)
+
+		if p.cur.Type == COMMA {
+			p.nextToken() // consume ','
+		} else if p.cur.Type != RPAREN {
+			p.addError("expected ',' or ')' in argument list")
+			return nil
+		}
+	}
+	p.nextToken() // consume ')'
+	return args
+}
+
+// parseBlock parses statements until one of the terminator tokens (or EOF)
+// is reached. The terminator itself is left for the caller to consume.
+func (p *Parser) parseBlock(terminators ...TokenType) []Statement {
+	p.depth++
+	defer func() { p.depth-- }()
+
+	stmts := []Statement{}
+	for p.cur.Type != EOF {
+		if p.cur.Type == NEWLINE {
+			p.nextToken()
+			continue
+		}
+		for _, t := range terminators {
+			if p.cur.Type == t {
+				return stmts
+			}
+		}
+		stmt := p.parseStatement()
+		if stmt != nil {
+			stmts = append(stmts, stmt)
+		} else {
+			p.nextToken() // Prevent infinite loop on error
+		}
+	}
+	return stmts
+}
+
+func (p *Parser) parseExpressionStatement() Statement {
+	start := p.cur
+	expr := p.parseExpression(precLowest)
+	if call, ok := expr.(*CallExpr); ok {
+		return &ExpressionStmt{Expr: call, Span: p.spanFrom(start)} // Calls run for their side effects
+	}
+	return &ReleaseStmt{Value: expr, Span: p.spanFrom(start)} // Treat bare expressions as release statements
+}
+
+// parseExpression parses an expression whose operators all bind tighter
+// than precedence. A prefix parser reads the first operand, then each infix
+// operator that binds tighter takes the expression so far as its left side.
+// Its right side may only hold operators that bind tighter still, which
+// makes "10 - 3 - 2" group as "(10 - 3) - 2"; a right-associative operator
+// lets its own level through on the right, so "2 ** 3 ** 2" is
+// "2 ** (3 ** 2)".
+func (p *Parser) parseExpression(precedence int) Expression {
+	prefix, ok := prefixParsers[p.cur.Type]
+	if !ok {
+		p.addError("unexpected " + describeToken(p.cur))
 		p.nextToken()
 		return nil
 	}
+	left := prefix(p)
+
+	for {
+		op, ok := infixOperators[p.cur.Type]
+		if !ok || op.precedence <= precedence {
+			return left
+		}
+		p.nextToken()
+
+		rightPrecedence := op.precedence
+		if op.right {
+			rightPrecedence--
+		}
+		right := p.parseExpression(rightPrecedence)
+		if left == nil || right == nil {
+			return nil // already reported
+		}
+
+		left = &BinaryExpr{
+			Left:     left,
+			Operator: op.text,
+			Right:    right,
+			Span:     Span{Start: left.Pos().Start, End: right.Pos().End},
+		}
+	}
+}
+
+func (p *Parser) parseNumber() Expression {
+	start := p.cur
+	value := 0
+	fmt.Sscanf(p.cur.Value, "%d", &value)
+	p.nextToken()
+	return &NumberLiteral{Value: value, Span: p.spanFrom(start)}
+}
+
+func (p *Parser) parseFloat() Expression {
+	start := p.cur
+	value := 0.0
+	fmt.Sscanf(p.cur.Value, "%f", &value)
+	p.nextToken()
+	return &FloatLiteral{Value: value, Span: p.spanFrom(start)}
+}
+
+func (p *Parser) parseString() Expression {
+	start := p.cur
+	p.nextToken()
+	return &StringLiteral{Value: start.Value, Raw: start.Type == RAW_STRING, Span: p.spanFrom(start)}
+}
+
+func (p *Parser) parseBoolean() Expression {
+	start := p.cur
+	p.nextToken()
+	return &BooleanLiteral{Value: start.Type == TRUE, Span: p.spanFrom(start)}
+}
+
+func (p *Parser) parseIdentifier() Expression {
+	start := p.cur
+	p.nextToken()
+	if p.cur.Type == LPAREN {
+		args := p.parseCallArgs()
+		if args == nil {
+			return nil
+		}
+		return &CallExpr{Name: start.Value, Args: args, Span: p.spanFrom(start)}
+	}
+	return &Identifier{Name: start.Value, Span: p.spanFrom(start)}
+}
+
+// parsePrefix parses "not" or a unary minus and the operand it applies to
+func (p *Parser) parsePrefix() Expression {
+	start := p.cur
+	precedence := precPrefix
+	if start.Type == NOT {
+		precedence = precNot
+	}
+	p.nextToken()
+	operand := p.parseExpression(precedence)
+	if operand == nil {
+		return nil
+	}
+	return &UnaryExpr{Operator: start.Value, Operand: operand, Span: p.spanFrom(start)}
+}
+
+// parseGroup parses an expression in parentheses. The parentheses only
+// group, so the tree holds the expression itself.
+func (p *Parser) parseGroup() Expression {
+	p.nextToken()
+	expr := p.parseExpression(precLowest)
+	if expr == nil {
+		return nil
+	}
+	if p.cur.Type != RPAREN {
+		p.addError("expected ')' to close '(', got " + describeToken(p.cur))
+		return nil
+	}
+	p.nextToken()
+	return expr
+}
+
+// parseInterpolation parses a string with expressions in it, which the
+// lexer splits at its braces: "HP: {hp}!" is STRING_START "HP: ", the tokens
+// of hp, then STRING_END "!", with a STRING_MIDDLE between two expressions
+func (p *Parser) parseInterpolation() Expression {
+	start := p.cur
+	var parts []Expression
+	failed := false
+	for {
+		text := p.cur
+		p.nextToken()
+		if text.Value != "" {
+			parts = append(parts, &StringLiteral{Value: text.Value, Span: p.spanFrom(text)})
+		}
+		if p.cur.Type == STRING_MIDDLE || p.cur.Type == STRING_END {
+			// Carry on with the rest of the string, so only this is reported
+			p.addError("expected an expression between { and }")
+			failed = true
+		} else if expr := p.parseExpression(precLowest); expr != nil {
+			parts = append(parts, expr)
+		} else {
+			return nil
+		}
+
+		switch p.cur.Type {
+		case STRING_MIDDLE:
+			continue
+		case STRING_END:
+			text := p.cur
+			p.nextToken()
+			if text.Value != "" {
+				parts = append(parts, &StringLiteral{Value: text.Value, Span: p.spanFrom(text)})
+			}
+			if failed {
+				return nil
+			}
+			return interpolate(parts, p.spanFrom(start))
+		default:
+			p.addError("expected } to end the expression in the string, got " + describeToken(p.cur))
+			return nil
+		}
+	}
+}
+
+// interpolate builds the InterpolatedString of parts, lowering it to
+// concatenation. The result starts from a string, even an empty one, so
+// "{a}{b}" joins a and b as text rather than adding them.
+func interpolate(parts []Expression, span Span) *InterpolatedString {
+	value := parts[0]
+	if _, ok := value.(*StringLiteral); !ok {
+		empty := &StringLiteral{Span: Span{span.Start, span.Start}}
+		value = &BinaryExpr{Left: empty, Operator: "+", Right: value, Span: Span{span.Start, value.Pos().End}}
+	}
+	for _, part := range parts[1:] {
+		value = &BinaryExpr{Left: value, Operator: "+", Right: part, Span: Span{span.Start, part.Pos().End}}
+	}
+	return &InterpolatedString{Parts: parts, Value: value, Span: span}
 }
 
 func (p *Parser) nextToken() {
+	p.prev = p.cur
 	p.pos++
 	if p.pos < len(p.tokens) {
 		p.cur = p.tokens[p.pos]
@@ -227,25 +625,139 @@ func (p *Parser) peek() Token {
 	return Token{Type: EOF}
 }
 
+// skipIllegalLine moves past the rest of the line when the current token is
+// ILLEGAL, reporting whether it was. The lexer has reported the token, and
+// what follows it on the line would only make more errors. A block whose
+// first line is cut short this way still has its body and 'end' parsed, so
+// those do not make errors either.
+func (p *Parser) skipIllegalLine() bool {
+	if p.cur.Type != ILLEGAL {
+		return false
+	}
+	for p.cur.Type != NEWLINE && p.cur.Type != EOF {
+		p.nextToken()
+	}
+	return true
+}
+
+// addError records a syntax error at the current token. The lexer has
+// already reported an ILLEGAL token, so nothing more is said about one.
 func (p *Parser) addError(msg string) {
-	p.errors = append(p.errors, msg)
+	if p.cur.Type == ILLEGAL {
+		return
+	}
+	span := Span{
+		Start: Position{Line: p.cur.Line, Column: p.cur.Column},
+		End:   Position{Line: p.cur.EndLine, Column: p.cur.EndColumn},
+	}
+	p.errors = append(p.errors, NewPokemonError("syntax", span, msg))
 }
 
-func (p *Parser) getPrecedence(tokType TokenType) int {
-	switch tokType {
-	case MULTIPLY, DIVIDE:
-		return 2
-	case PLUS, MINUS:
-		return 1
-	default:
-		return 0
+// docComment returns the text of the "##" lines directly above a token,
+// without their markers and one space after them. A blank line, an ordinary
+// comment or code in between means they are not its documentation.
+func docComment(tok Token) string {
+	var lines []string
+	line := tok.Line
+	for i := len(tok.Leading) - 1; i >= 0; i-- {
+		comment := tok.Leading[i]
+		if !comment.Doc || comment.Start.Line != line-1 {
+			break
+		}
+		text := strings.TrimPrefix(comment.Text, "##")
+		lines = append([]string{strings.TrimPrefix(text, " ")}, lines...)
+		line = comment.Start.Line
+	}
+	return strings.Join(lines, "\n")
+}
+
+// describeToken names a token for error messages
+func describeToken(tok Token) string {
+	switch tok.Type {
+	case EOF:
+		return "end of file"
+	case NEWLINE:
+		return "end of line"
+	case STRING, RAW_STRING, STRING_START:
+		return "string"
+	case STRING_MIDDLE, STRING_END:
+		return "token: }"
+	}
+	return "token: " + tok.Value
+}
+
+// spanFrom returns the span from the start token to the last consumed token
+func (p *Parser) spanFrom(start Token) Span {
+	return Span{
+		Start: Position{Line: start.Line, Column: start.Column},
+		End:   Position{Line: p.prev.EndLine, Column: p.prev.EndColumn},
+	}
+}
+
+// Operator precedence, from the loosest binding to the tightest. "not" sits
+// between 'and' and the comparisons, so "not a == b" negates the comparison
+// while "not a and b" only negates a. A unary minus binds tighter than the
+// arithmetic but looser than "**", so "-x ** 2" is "-(x ** 2)".
+const (
+	precLowest = iota
+	precOr
+	precAnd
+	precNot
+	precCompare
+	precSum
+	precProduct
+	precPrefix
+	precPower
+)
+
+// infixOperator is how a binary operator token parses
+type infixOperator struct {
+	text       string
+	precedence int
+	right      bool // right-associative: a op b op c is a op (b op c)
+}
+
+var infixOperators = map[TokenType]infixOperator{
+	OR:       {"or", precOr, false},
+	AND:      {"and", precAnd, false},
+	EQ:       {"==", precCompare, false},
+	NOT_EQ:   {"!=", precCompare, false},
+	LT:       {"<", precCompare, false},
+	LTE:      {"<=", precCompare, false},
+	GT:       {">", precCompare, false},
+	GTE:      {">=", precCompare, false},
+	PLUS:     {"+", precSum, false},
+	MINUS:    {"-", precSum, false},
+	MULTIPLY: {"*", precProduct, false},
+	DIVIDE:   {"/", precProduct, false},
+	MODULO:   {"%", precProduct, false},
+	POWER:    {"**", precPower, true},
+}
+
+// prefixParsers parse the tokens an expression can start with. It is filled
+// in init, since the parsers refer back to parseExpression.
+var prefixParsers map[TokenType]func(*Parser) Expression
+
+func init() {
+	prefixParsers = map[TokenType]func(*Parser) Expression{
+		NUMBER:       (*Parser).parseNumber,
+		FLOAT:        (*Parser).parseFloat,
+		STRING:       (*Parser).parseString,
+		RAW_STRING:   (*Parser).parseString,
+		STRING_START: (*Parser).parseInterpolation,
+		TRUE:         (*Parser).parseBoolean,
+		FALSE:        (*Parser).parseBoolean,
+		IDENTIFIER:   (*Parser).parseIdentifier,
+		NOT:          (*Parser).parsePrefix,
+		MINUS:        (*Parser).parsePrefix,
+		LPAREN:       (*Parser).parseGroup,
 	}
 }
 
-func (p *Parser) isOperator(tokType TokenType) bool {
-	return tokType == PLUS || tokType == MINUS || tokType == MULTIPLY || tokType == DIVIDE
+func (p *Parser) isTypeToken(tokType TokenType) bool {
+	return tokType == PIKACHU || tokType == PSYDUCK || tokType == EEVEE || tokType == VOLTORB
 }
 
-func (p *Parser) Errors() []string {
+func (p *Parser) Errors() []PokemonError {
 	return p.errors
 }
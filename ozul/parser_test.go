@@ -1,6 +1,7 @@
 package main
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -159,6 +160,75 @@ func TestParser_String(t *testing.T) {
 	}
 }
 
+func TestParser_Interpolation(t *testing.T) {
+	program := NewParser(NewLexer(`release "HP: {hp * 2}!"` + "\n" + `release "{a}{b}"`).Tokenize()).Parse()
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("Expected 2 statements, got %d", len(program.Statements))
+	}
+	interp, ok := program.Statements[0].(*ReleaseStmt).Value.(*InterpolatedString)
+	if !ok {
+		t.Fatalf("Expected InterpolatedString, got %T", program.Statements[0].(*ReleaseStmt).Value)
+	}
+	if len(interp.Parts) != 3 {
+		t.Fatalf("Expected 3 parts, got %d", len(interp.Parts))
+	}
+	if _, ok := interp.Parts[1].(*BinaryExpr); !ok {
+		t.Errorf("Expected the middle part to be a BinaryExpr, got %T", interp.Parts[1])
+	}
+	if expected := `(("HP: " + (hp * 2)) + "!")`; interp.Value.String() != expected {
+		t.Errorf("Expected %s, got %s", expected, interp.Value)
+	}
+	if interp.Pos() != (Span{Position{1, 9}, Position{1, 24}}) {
+		t.Errorf("Expected the whole string's span, got %v", interp.Pos())
+	}
+
+	// Concatenation starts from a string, so numbers join as text
+	joined := program.Statements[1].(*ReleaseStmt).Value.(*InterpolatedString)
+	if expected := `(("" + a) + b)`; joined.Value.String() != expected {
+		t.Errorf("Expected %s, got %s", expected, joined.Value)
+	}
+}
+
+func TestParser_InterpolationErrors(t *testing.T) {
+	tests := []struct {
+		source, message string
+	}{
+		{`release "{}"`, "expected an expression between { and }"},
+		{`release "{hp"`, "expected } to end the expression in the string"},
+		{`release "{1 +}"`, "unexpected token: }"},
+	}
+	for _, tt := range tests {
+		parser := NewParser(NewLexer(tt.source).Tokenize())
+		parser.Parse()
+		errs := parser.Errors()
+		if len(errs) != 1 || !strings.Contains(errs[0].Message, tt.message) {
+			t.Errorf("%s: expected one error %q, got %v", tt.source, tt.message, errs)
+		}
+	}
+}
+
+func TestParser_IllegalTokens(t *testing.T) {
+	// The lexer reports ILLEGAL tokens; the parser skips their lines without
+	// errors of its own, and blocks around them still close
+	source := "Pikachu hp is 10 ^ 3\nif hp > 1 && true then\nrelease hp\nend\nrepeat 2 ; times\nrelease 1\nend\nrelease hp"
+	parser := NewParser(NewLexer(source).Tokenize())
+	program := parser.Parse()
+
+	if errs := parser.Errors(); len(errs) > 0 {
+		t.Errorf("Expected no parser errors, got %v", errs)
+	}
+	if len(program.Statements) != 2 {
+		t.Fatalf("Expected 2 statements, got %d: %v", len(program.Statements), program.Statements)
+	}
+	if _, ok := program.Statements[0].(*DeclarationStmt); !ok {
+		t.Errorf("Expected DeclarationStmt, got %T", program.Statements[0])
+	}
+	if release, ok := program.Statements[1].(*ReleaseStmt); !ok || release.Pos().Start.Line != 8 {
+		t.Errorf("Expected the release on line 8, got %v", program.Statements[1])
+	}
+}
+
 func TestParser_IO(t *testing.T) {
 	source := `catch userInput from trainer
 release userInput`
@@ -195,3 +265,438 @@ release userInput`
 		t.Errorf("Expected identifier 'userInput', got '%s'", ident.Name)
 	}
 }
+
+func TestParser_IfElse(t *testing.T) {
+	source := `if health then
+release "alive"
+else
+release "fainted"
+end`
+	lexer := NewLexer(source)
+	tokens := lexer.Tokenize()
+
+	parser := NewParser(tokens)
+	program := parser.Parse()
+
+	if len(parser.Errors()) > 0 {
+		t.Fatalf("Unexpected parser errors: %v", parser.Errors())
+	}
+	if len(program.Statements) != 1 {
+		t.Fatalf("Expected 1 statement, got %d", len(program.Statements))
+	}
+
+	ifStmt, ok := program.Statements[0].(*IfStmt)
+	if !ok {
+		t.Fatalf("Expected IfStmt, got %T", program.Statements[0])
+	}
+
+	cond, ok := ifStmt.Condition.(*Identifier)
+	if !ok || cond.Name != "health" {
+		t.Errorf("Expected condition identifier 'health', got %v", ifStmt.Condition)
+	}
+	if len(ifStmt.Then) != 1 {
+		t.Errorf("Expected 1 statement in then branch, got %d", len(ifStmt.Then))
+	}
+	if len(ifStmt.Else) != 1 {
+		t.Errorf("Expected 1 statement in else branch, got %d", len(ifStmt.Else))
+	}
+}
+
+func TestParser_ElseIfChain(t *testing.T) {
+	source := `if a then
+release 1
+else if b then
+release 2
+else
+release 3
+end
+release 4`
+	lexer := NewLexer(source)
+	tokens := lexer.Tokenize()
+
+	parser := NewParser(tokens)
+	program := parser.Parse()
+
+	if len(parser.Errors()) > 0 {
+		t.Fatalf("Unexpected parser errors: %v", parser.Errors())
+	}
+	if len(program.Statements) != 2 {
+		t.Fatalf("Expected 2 statements, got %d", len(program.Statements))
+	}
+
+	outer, ok := program.Statements[0].(*IfStmt)
+	if !ok {
+		t.Fatalf("Expected IfStmt, got %T", program.Statements[0])
+	}
+	if len(outer.Else) != 1 {
+		t.Fatalf("Expected nested if in else branch, got %d statements", len(outer.Else))
+	}
+	inner, ok := outer.Else[0].(*IfStmt)
+	if !ok {
+		t.Fatalf("Expected nested IfStmt, got %T", outer.Else[0])
+	}
+	if len(inner.Else) != 1 {
+		t.Errorf("Expected 1 statement in inner else branch, got %d", len(inner.Else))
+	}
+}
+
+func TestParser_IfMissingEnd(t *testing.T) {
+	source := `if x then
+release x`
+	lexer := NewLexer(source)
+	tokens := lexer.Tokenize()
+
+	parser := NewParser(tokens)
+	parser.Parse()
+
+	if len(parser.Errors()) == 0 {
+		t.Errorf("Expected an error for missing 'end', got none")
+	}
+}
+
+func TestParser_ComparisonPrecedence(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{"release a + 1 < b", "release ((a + 1) < b)"},
+		{"release a < b and c > d or e", "release (((a < b) and (c > d)) or e)"},
+		{"release not a == b", "release (not (a == b))"},
+		{"release not a and b", "release ((not a) and b)"},
+		{"release 10 - 3 - 2", "release ((10 - 3) - 2)"},
+		{"release 10 * 2 + 1", "release ((10 * 2) + 1)"},
+	}
+
+	for _, tt := range tests {
+		parser := NewParser(NewLexer(tt.source).Tokenize())
+		program := parser.Parse()
+		if len(parser.Errors()) > 0 {
+			t.Errorf("%q: unexpected parser errors: %v", tt.source, parser.Errors())
+			continue
+		}
+		if len(program.Statements) != 1 {
+			t.Errorf("%q: expected 1 statement, got %d", tt.source, len(program.Statements))
+			continue
+		}
+		if got := program.Statements[0].String(); got != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.source, tt.expected, got)
+		}
+	}
+}
+
+// TestParser_PrecedenceMatrix pairs every level of operator with the levels
+// around it, in both orders, along with associativity, prefix operators and
+// parentheses
+func TestParser_PrecedenceMatrix(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		// Associativity: everything groups to the left except **
+		{"a - b - c", "((a - b) - c)"},
+		{"a / b / c", "((a / b) / c)"},
+		{"a % b % c", "((a % b) % c)"},
+		{"a - b + c", "((a - b) + c)"},
+		{"a / b * c", "((a / b) * c)"},
+		{"a % b * c", "((a % b) * c)"},
+		{"a == b == c", "((a == b) == c)"},
+		{"a or b or c", "((a or b) or c)"},
+		{"a and b and c", "((a and b) and c)"},
+		{"a ** b ** c", "(a ** (b ** c))"},
+
+		// Each level against the next tighter one, in both orders
+		{"a or b and c", "(a or (b and c))"},
+		{"a and b or c", "((a and b) or c)"},
+		{"not a and b", "((not a) and b)"},
+		{"a and not b", "(a and (not b))"},
+		{"not a == b", "(not (a == b))"},
+		{"a == b + c", "(a == (b + c))"},
+		{"a + b < c", "((a + b) < c)"},
+		{"a + b * c", "(a + (b * c))"},
+		{"a * b + c", "((a * b) + c)"},
+		{"a - b % c", "(a - (b % c))"},
+		{"a * -b", "(a * (- b))"},
+		{"-a * b", "((- a) * b)"},
+		{"-a ** b", "(- (a ** b))"},
+		{"a ** -b", "(a ** (- b))"},
+		{"a * b ** c", "(a * (b ** c))"},
+		{"a ** b * c", "((a ** b) * c)"},
+
+		// Prefix operators
+		{"-5", "(- 5)"},
+		{"- -a", "(- (- a))"},
+		{"a - -b", "(a - (- b))"},
+		{"not not a", "(not (not a))"},
+		{"-f(a) + 1", "((- f(a)) + 1)"},
+		{"not -a < b", "(not ((- a) < b))"},
+
+		// Parentheses
+		{"(a + b) * c", "((a + b) * c)"},
+		{"a - (b - c)", "(a - (b - c))"},
+		{"(a ** b) ** c", "((a ** b) ** c)"},
+		{"-(a + b)", "(- (a + b))"},
+		{"(-a) ** b", "((- a) ** b)"},
+		{"not (a and b)", "(not (a and b))"},
+		{"((a))", "a"},
+		{"f((a + b) * 2, -c)", "f(((a + b) * 2), (- c))"},
+	}
+
+	for _, tt := range tests {
+		parser := NewParser(NewLexer("release " + tt.source).Tokenize())
+		program := parser.Parse()
+		if len(parser.Errors()) > 0 {
+			t.Errorf("%q: unexpected parser errors: %v", tt.source, parser.Errors())
+			continue
+		}
+		if len(program.Statements) != 1 {
+			t.Errorf("%q: expected 1 statement, got %d", tt.source, len(program.Statements))
+			continue
+		}
+		if got := program.Statements[0].String(); got != "release "+tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.source, tt.expected, strings.TrimPrefix(got, "release "))
+		}
+	}
+}
+
+func TestParser_GroupingErrors(t *testing.T) {
+	tests := []struct {
+		source  string
+		message string
+	}{
+		{"release (1 + 2", "expected ')' to close '(', got end of file"},
+		{"release (1 + 2\nrelease 3", "expected ')' to close '(', got end of line"},
+		{"release ()", "unexpected token: )"},
+		{"release 1 + ", "unexpected end of file"},
+		{"release -", "unexpected end of file"},
+		{"release 2 * * 3", "unexpected token: *"},
+	}
+
+	for _, tt := range tests {
+		parser := NewParser(NewLexer(tt.source).Tokenize())
+		parser.Parse()
+		errs := parser.Errors()
+		if len(errs) != 1 || errs[0].Message != tt.message {
+			t.Errorf("%q: expected one error %q, got %v", tt.source, tt.message, errs)
+		}
+	}
+}
+
+func TestParser_Loops(t *testing.T) {
+	source := `train while hp > 0
+hp evolves to hp - 10
+end
+repeat 3 times
+release "Pika!"
+end`
+	lexer := NewLexer(source)
+	tokens := lexer.Tokenize()
+
+	parser := NewParser(tokens)
+	program := parser.Parse()
+
+	if len(parser.Errors()) > 0 {
+		t.Fatalf("Unexpected parser errors: %v", parser.Errors())
+	}
+	if len(program.Statements) != 2 {
+		t.Fatalf("Expected 2 statements, got %d", len(program.Statements))
+	}
+
+	loop, ok := program.Statements[0].(*WhileStmt)
+	if !ok {
+		t.Fatalf("Expected WhileStmt, got %T", program.Statements[0])
+	}
+	if len(loop.Body) != 1 {
+		t.Errorf("Expected 1 statement in while body, got %d", len(loop.Body))
+	}
+
+	repeat, ok := program.Statements[1].(*RepeatStmt)
+	if !ok {
+		t.Fatalf("Expected RepeatStmt, got %T", program.Statements[1])
+	}
+	if count, ok := repeat.Count.(*NumberLiteral); !ok || count.Value != 3 {
+		t.Errorf("Expected repeat count 3, got %v", repeat.Count)
+	}
+}
+
+func TestParser_LoopMissingKeyword(t *testing.T) {
+	sources := []string{
+		"train hp > 0\nend",
+		"repeat 3\nend",
+		"repeat 3 times\nrelease 1",
+	}
+
+	for _, source := range sources {
+		parser := NewParser(NewLexer(source).Tokenize())
+		parser.Parse()
+		if len(parser.Errors()) == 0 {
+			t.Errorf("%q: expected a parser error, got none", source)
+		}
+	}
+}
+
+func TestParser_Function(t *testing.T) {
+	source := `move attack(Pikachu power, Psyduck bonus) gives Psyduck
+return power * bonus
+end
+release attack(10, 1.5)
+attack(1, 2.0)`
+	lexer := NewLexer(source)
+	tokens := lexer.Tokenize()
+
+	parser := NewParser(tokens)
+	program := parser.Parse()
+
+	if len(parser.Errors()) > 0 {
+		t.Fatalf("Unexpected parser errors: %v", parser.Errors())
+	}
+	if len(program.Statements) != 3 {
+		t.Fatalf("Expected 3 statements, got %d", len(program.Statements))
+	}
+
+	fn, ok := program.Statements[0].(*FunctionDecl)
+	if !ok {
+		t.Fatalf("Expected FunctionDecl, got %T", program.Statements[0])
+	}
+	if fn.Name != "attack" || fn.ReturnType != "Psyduck" {
+		t.Errorf("Expected move attack giving Psyduck, got %s giving %s", fn.Name, fn.ReturnType)
+	}
+	expectedParams := []Param{{PokemonType: "Pikachu", Name: "power"}, {PokemonType: "Psyduck", Name: "bonus"}}
+	if len(fn.Params) != len(expectedParams) {
+		t.Fatalf("Expected %d params, got %d", len(expectedParams), len(fn.Params))
+	}
+	for i, param := range expectedParams {
+		if fn.Params[i].PokemonType != param.PokemonType || fn.Params[i].Name != param.Name {
+			t.Errorf("param %d: expected %v, got %v", i, param, fn.Params[i])
+		}
+	}
+	if _, ok := fn.Body[0].(*ReturnStmt); !ok {
+		t.Errorf("Expected ReturnStmt in body, got %T", fn.Body[0])
+	}
+
+	release, ok := program.Statements[1].(*ReleaseStmt)
+	if !ok {
+		t.Fatalf("Expected ReleaseStmt, got %T", program.Statements[1])
+	}
+	call, ok := release.Value.(*CallExpr)
+	if !ok {
+		t.Fatalf("Expected CallExpr, got %T", release.Value)
+	}
+	if call.Name != "attack" || len(call.Args) != 2 {
+		t.Errorf("Expected call attack with 2 args, got %s", call.String())
+	}
+
+	if _, ok := program.Statements[2].(*ExpressionStmt); !ok {
+		t.Errorf("Expected bare call to be an ExpressionStmt, got %T", program.Statements[2])
+	}
+}
+
+func TestParser_FunctionErrors(t *testing.T) {
+	sources := []string{
+		"return 5",
+		"move attack(a, b)\nend",
+		"move attack(Pikachu a\nend",
+		"if true then\nmove inner()\nend\nend",
+		"release attack(1, 2",
+	}
+
+	for _, source := range sources {
+		parser := NewParser(NewLexer(source).Tokenize())
+		parser.Parse()
+		if len(parser.Errors()) == 0 {
+			t.Errorf("%q: expected a parser error, got none", source)
+		}
+	}
+}
+
+func TestParser_TypedCatch(t *testing.T) {
+	source := `catch Pikachu age from trainer`
+	parser := NewParser(NewLexer(source).Tokenize())
+	program := parser.Parse()
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("Expected 1 statement, got %d", len(program.Statements))
+	}
+	catch, ok := program.Statements[0].(*CatchStmt)
+	if !ok {
+		t.Fatalf("Expected CatchStmt, got %T", program.Statements[0])
+	}
+	if catch.PokemonType != "Pikachu" || catch.Variable != "age" {
+		t.Errorf("Expected 'catch Pikachu age', got %s", catch.String())
+	}
+}
+
+func TestParser_Spans(t *testing.T) {
+	source := "Pikachu x is 1 + 2\nif x > 2 then\n  release x\nend"
+	parser := NewParser(NewLexer(source).Tokenize())
+	program := parser.Parse()
+	if len(parser.Errors()) != 0 {
+		t.Fatalf("Unexpected parser errors: %v", parser.Errors())
+	}
+
+	decl := program.Statements[0].(*DeclarationStmt)
+	ifStmt := program.Statements[1].(*IfStmt)
+	tests := []struct {
+		name     string
+		node     ASTNode
+		expected Span
+	}{
+		{"declaration", decl, Span{Position{1, 1}, Position{1, 19}}},
+		{"sum", decl.Value, Span{Position{1, 14}, Position{1, 19}}},
+		{"right operand", decl.Value.(*BinaryExpr).Right, Span{Position{1, 18}, Position{1, 19}}},
+		{"if", ifStmt, Span{Position{2, 1}, Position{4, 4}}},
+		{"condition", ifStmt.Condition, Span{Position{2, 4}, Position{2, 9}}},
+		{"release", ifStmt.Then[0], Span{Position{3, 3}, Position{3, 12}}},
+	}
+
+	for _, tt := range tests {
+		if got := tt.node.Pos(); got != tt.expected {
+			t.Errorf("%s: expected span %v, got %v", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestParser_ErrorPositions(t *testing.T) {
+	parser := NewParser(NewLexer("Pikachu x is 1\nPikachu is 5").Tokenize())
+	parser.Parse()
+
+	errs := parser.Errors()
+	if len(errs) == 0 {
+		t.Fatal("Expected a parser error, got none")
+	}
+	if errs[0].Kind != "syntax" || errs[0].Line != 2 || errs[0].Column != 9 {
+		t.Errorf("Expected syntax error at 2:9, got %s error at %d:%d", errs[0].Kind, errs[0].Line, errs[0].Column)
+	}
+}
+
+func TestParser_DocComments(t *testing.T) {
+	source := `## The trainer's starting HP.
+##   Never below zero.
+Pikachu hp is 10
+## Separated by a blank line, so not hp2's
+
+Pikachu hp2 is 1
+## Interrupted
+# by an ordinary comment
+Pikachu hp3 is 1
+Pikachu hp4 is 1 ## trailing, belongs to no one
+##Heals a little.
+move heal(Pikachu amount) gives Pikachu
+return amount
+end`
+	parser := NewParser(NewLexer(source).Tokenize())
+	program := parser.Parse()
+	if len(parser.Errors()) > 0 {
+		t.Fatalf("Unexpected parser errors: %v", parser.Errors())
+	}
+
+	expected := []string{"The trainer's starting HP.\n  Never below zero.", "", "", ""}
+	for i, doc := range expected {
+		decl := program.Statements[i].(*DeclarationStmt)
+		if decl.Doc != doc {
+			t.Errorf("%s: expected doc %q, got %q", decl.Name, doc, decl.Doc)
+		}
+	}
+	if fn := program.Statements[4].(*FunctionDecl); fn.Doc != "Heals a little." {
+		t.Errorf("Expected the move's doc, got %q", fn.Doc)
+	}
+}
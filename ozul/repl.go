@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	replPrompt         = "ozul> "
+	replContinuePrompt = "....> "
+	replFile           = "<repl>"
+)
+
+// REPL is the interactive read-eval-print loop. One interpreter lives for
+// the whole session, so variables and moves from earlier entries stay
+// visible. An entry that opens a block keeps reading lines until the block
+// is closed with end.
+type REPL struct {
+	in          *bufio.Reader
+	out         io.Writer
+	interpreter *Interpreter
+	checker     *Checker
+
+	// Statements of every entry that ran without errors, used to rebuild
+	// the checker when an entry is rejected
+	accepted []Statement
+
+	// Statements of the last entry that parsed, for :ast
+	last []Statement
+
+	// File every entered line is appended to; empty disables history
+	historyFile string
+}
+
+// NewREPL creates a REPL that reads entries from in and writes to out
+func NewREPL(in io.Reader, out io.Writer) *REPL {
+	r := &REPL{in: bufio.NewReader(in), out: out}
+	r.reset()
+	return r
+}
+
+// defaultHistoryFile is ~/.ozul_history, or empty if there is no home
+func defaultHistoryFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".ozul_history")
+}
+
+// SetHistoryFile sets the file entered lines are appended to
+func (r *REPL) SetHistoryFile(path string) {
+	r.historyFile = path
+}
+
+// reset forgets every variable and move
+func (r *REPL) reset() {
+	r.interpreter = NewInterpreter()
+	r.interpreter.SetInput(r.in) // catch shares the REPL's buffered input
+	r.interpreter.SetOutput(r.out)
+	r.checker = NewChecker()
+	r.accepted = nil
+	r.last = nil
+}
+
+// Run reads and evaluates entries until the input ends or :quit is entered
+func (r *REPL) Run() {
+	fmt.Fprintln(r.out, "OZUL REPL - type :help for commands, :quit to leave")
+	for {
+		entry, ok := r.readEntry()
+		if !ok {
+			fmt.Fprintln(r.out)
+			return
+		}
+		r.saveHistory(entry)
+
+		trimmed := strings.TrimSpace(entry)
+		if strings.HasPrefix(trimmed, ":") {
+			if !r.command(trimmed) {
+				return
+			}
+			continue
+		}
+		if trimmed != "" {
+			r.eval(entry)
+		}
+	}
+}
+
+// readEntry reads one line, plus more lines while a block is left open
+func (r *REPL) readEntry() (string, bool) {
+	var lines []string
+	prompt := replPrompt
+	for {
+		fmt.Fprint(r.out, prompt)
+		line, err := r.in.ReadString('\n')
+		if err != nil && line == "" {
+			if len(lines) > 0 {
+				return strings.Join(lines, "\n"), true
+			}
+			return "", false
+		}
+		lines = append(lines, strings.TrimRight(line, "\r\n"))
+
+		entry := strings.Join(lines, "\n")
+		if strings.HasPrefix(strings.TrimSpace(entry), ":") || openBlocks(entry) <= 0 {
+			return entry, true
+		}
+		prompt = replContinuePrompt
+	}
+}
+
+// openBlocks counts the blocks an entry opens but does not close yet. An
+// "else if" continues its if and shares the same end, and a """ string left
+// open counts as a block too.
+func openBlocks(source string) int {
+	depth := 0
+	lexer := NewLexer(source)
+	tokens := lexer.Tokenize()
+	for _, err := range lexer.Errors() {
+		if err.Message == unterminatedRawString {
+			depth++
+		}
+	}
+	for i, tok := range tokens {
+		switch tok.Type {
+		case IF:
+			if i == 0 || tokens[i-1].Type != ELSE {
+				depth++
+			}
+		case TRAIN, REPEAT, MOVE:
+			depth++
+		case END:
+			depth--
+		}
+	}
+	return depth
+}
+
+// eval parses, checks and runs one entry, printing any errors
+func (r *REPL) eval(entry string) {
+	lexer := NewLexer(entry)
+	parser := NewParser(lexer.Tokenize())
+	program := parser.Parse()
+	if errs := append(lexer.Errors(), parser.Errors()...); len(errs) > 0 {
+		r.report(entry, errs)
+		return
+	}
+	r.last = program.Statements
+
+	if errs := r.checker.Check(program); len(errs) > 0 {
+		r.report(entry, errs)
+		r.rebuildChecker()
+		return
+	}
+
+	// A bare call to a move that gives a value prints it, like any other
+	// bare expression
+	for i, stmt := range program.Statements {
+		if s, ok := stmt.(*ExpressionStmt); ok && r.checker.TypeOf(s.Expr) != "" {
+			program.Statements[i] = &ReleaseStmt{Span: s.Span, Value: s.Expr}
+		}
+	}
+
+	if err := r.interpreter.Run(program); err != nil {
+		if runtimeErr, ok := err.(*RuntimeError); ok {
+			fmt.Fprint(r.out, runtimeErr.Diagnostic().Render(replFile, entry))
+		} else {
+			fmt.Fprintln(r.out, err)
+		}
+		r.rebuildChecker()
+		return
+	}
+	r.accepted = append(r.accepted, program.Statements...)
+}
+
+// rebuildChecker forgets the declarations of a rejected entry by checking
+// the accepted entries again from scratch
+func (r *REPL) rebuildChecker() {
+	r.checker = NewChecker()
+	r.checker.Check(&Program{Statements: r.accepted})
+}
+
+func (r *REPL) report(entry string, errs []PokemonError) {
+	sortErrors(errs)
+	for _, err := range errs {
+		fmt.Fprint(r.out, err.Render(replFile, entry))
+	}
+}
+
+// command runs a meta-command and reports whether the REPL should go on
+func (r *REPL) command(line string) bool {
+	name, arg, _ := strings.Cut(line, " ")
+	switch name {
+	case ":quit", ":q":
+		return false
+	case ":help":
+		fmt.Fprintln(r.out, "  :vars         list variables and their values")
+		fmt.Fprintln(r.out, "  :ast [code]   show the syntax tree of code, or of the last entry")
+		fmt.Fprintln(r.out, "  :reset        forget every variable and move")
+		fmt.Fprintln(r.out, "  :quit         leave the REPL")
+	case ":vars":
+		r.printVars()
+	case ":ast":
+		r.printAST(strings.TrimSpace(arg))
+	case ":reset":
+		r.reset()
+		fmt.Fprintln(r.out, "All Pokemon released. Starting fresh!")
+	default:
+		fmt.Fprintf(r.out, "Unknown command %s (try :help)\n", name)
+	}
+	return true
+}
+
+func (r *REPL) printVars() {
+	vars := r.interpreter.Variables()
+	if len(vars) == 0 {
+		fmt.Fprintln(r.out, "(no variables)")
+		return
+	}
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		val := vars[name]
+		if val.Type == "string" {
+			fmt.Fprintf(r.out, "%s: %s = %q\n", name, pokemonTypes[val.Type], val.Str)
+		} else {
+			fmt.Fprintf(r.out, "%s: %s = %s\n", name, pokemonTypes[val.Type], val)
+		}
+	}
+}
+
+// printAST shows the syntax tree of code without running it, or of the
+// last entry when no code is given
+func (r *REPL) printAST(code string) {
+	stmts := r.last
+	if code != "" {
+		lexer := NewLexer(code)
+		parser := NewParser(lexer.Tokenize())
+		program := parser.Parse()
+		if errs := append(lexer.Errors(), parser.Errors()...); len(errs) > 0 {
+			r.report(code, errs)
+			return
+		}
+		stmts = program.Statements
+	}
+	if len(stmts) == 0 {
+		fmt.Fprintln(r.out, "(nothing entered yet)")
+		return
+	}
+	for _, stmt := range stmts {
+		kind := strings.TrimPrefix(fmt.Sprintf("%T", stmt), "*main.")
+		fmt.Fprintf(r.out, "%s: %s\n", kind, stmt.String())
+	}
+}
+
+// saveHistory appends an entry to the history file. History is a
+// convenience, so a file that cannot be written is ignored.
+func (r *REPL) saveHistory(entry string) {
+	if r.historyFile == "" || strings.TrimSpace(entry) == "" {
+		return
+	}
+	f, err := os.OpenFile(r.historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, entry)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runREPL feeds input to a fresh REPL and returns everything it printed
+func runREPL(input string) string {
+	var out bytes.Buffer
+	NewREPL(strings.NewReader(input), &out).Run()
+	return out.String()
+}
+
+func TestREPL_KeepsStateBetweenEntries(t *testing.T) {
+	output := runREPL("Pikachu hp is 10\nhp evolves to hp + 5\nhp * 2\n")
+	if !strings.Contains(output, "ozul> 30\n") {
+		t.Errorf("Expected the bare expression to print 30, got: %q", output)
+	}
+}
+
+func TestREPL_MultiLineBlocks(t *testing.T) {
+	input := `move double(Pikachu n) gives Pikachu
+if n > 100 then
+return n
+else if n > 10 then
+return n + n
+end
+return n * 2
+end
+double(21)
+`
+	output := runREPL(input)
+	if strings.Count(output, replContinuePrompt) != 7 {
+		t.Errorf("Expected 7 continuation prompts, got: %q", output)
+	}
+	if !strings.Contains(output, "42\n") {
+		t.Errorf("Expected the bare call to print 42, got: %q", output)
+	}
+}
+
+func TestREPL_MultiLineStrings(t *testing.T) {
+	output := runREPL("release \"\"\"first\nsecond\"\"\"\nPikachu hp is 3\n\"HP: {hp}\"\n")
+	if strings.Count(output, replContinuePrompt) != 1 {
+		t.Errorf("Expected 1 continuation prompt, got: %q", output)
+	}
+	if !strings.Contains(output, "first\nsecond\n") || !strings.Contains(output, "HP: 3\n") {
+		t.Errorf("Expected both strings printed, got: %q", output)
+	}
+}
+
+func TestREPL_ErrorsDoNotEndTheSession(t *testing.T) {
+	input := `Pikachu x is "nope"
+release 1 / 0
+Pikachu x is 3
+release x
+`
+	output := runREPL(input)
+	for _, expected := range []string{
+		"<repl>:1:14: type error: variable x must be a Pikachu, got Eevee",
+		"<repl>:1:9: runtime error: Division by zero.",
+		"ozul> 3\n",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected %q in output, got: %q", expected, output)
+		}
+	}
+}
+
+func TestREPL_Catch(t *testing.T) {
+	output := runREPL("catch Eevee name from trainer\nMisty\nrelease \"Hi \" + name\n")
+	if !strings.Contains(output, "Hi Misty\n") {
+		t.Errorf("Expected catch to read the next line, got: %q", output)
+	}
+}
+
+func TestREPL_Commands(t *testing.T) {
+	input := `Eevee name is "Ash"
+Pikachu hp is 7
+:vars
+:ast
+:ast release 1 + 2 * 3
+:reset
+:vars
+release hp
+:bogus
+:quit
+release "unreachable"
+`
+	output := runREPL(input)
+	for _, expected := range []string{
+		"hp: Pikachu = 7\nname: Eevee = \"Ash\"\n",
+		"DeclarationStmt: Pikachu hp is 7\n",
+		"ReleaseStmt: release (1 + (2 * 3))\n",
+		"(no variables)\n",
+		"type error: undefined variable hp",
+		"Unknown command :bogus",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected %q in output, got: %q", expected, output)
+		}
+	}
+	if strings.Contains(output, "unreachable") {
+		t.Errorf("Expected :quit to end the session, got: %q", output)
+	}
+}
+
+func TestREPL_History(t *testing.T) {
+	history := filepath.Join(t.TempDir(), "history")
+	repl := NewREPL(strings.NewReader("Pikachu hp is 1\n\nrepeat 2 times\nrelease hp\nend\n:vars\n"), &bytes.Buffer{})
+	repl.SetHistoryFile(history)
+	repl.Run()
+
+	data, err := os.ReadFile(history)
+	if err != nil {
+		t.Fatalf("Expected a history file, got: %v", err)
+	}
+	expected := "Pikachu hp is 1\nrepeat 2 times\nrelease hp\nend\n:vars\n"
+	if string(data) != expected {
+		t.Errorf("Expected history %q, got %q", expected, string(data))
+	}
+}
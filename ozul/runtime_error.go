@@ -0,0 +1,42 @@
+package main
+
+import "fmt"
+
+// RuntimeErrorKind says what went wrong while a program was running, so
+// callers can react to a failure without matching on its message
+type RuntimeErrorKind string
+
+const (
+	ErrUndefinedVariable  RuntimeErrorKind = "undefined variable"
+	ErrRedeclaredVariable RuntimeErrorKind = "redeclared variable"
+	ErrUndefinedMove      RuntimeErrorKind = "undefined move"
+	ErrRedeclaredMove     RuntimeErrorKind = "redeclared move"
+	ErrArgumentCount      RuntimeErrorKind = "argument count"
+	ErrMissingReturn      RuntimeErrorKind = "missing return"
+	ErrTypeMismatch       RuntimeErrorKind = "type mismatch"
+	ErrUnknownOperator    RuntimeErrorKind = "unknown operator"
+	ErrUnknownExpression  RuntimeErrorKind = "unknown expression"
+	ErrDivisionByZero     RuntimeErrorKind = "division by zero"
+	ErrNegativeExponent   RuntimeErrorKind = "negative exponent"
+	ErrInvalidInput       RuntimeErrorKind = "invalid input"
+	ErrStepLimit          RuntimeErrorKind = "step limit"
+	ErrCallDepth          RuntimeErrorKind = "call depth"
+)
+
+// RuntimeError is returned by Interpreter.Run when a program fails. Span is
+// the statement or expression that was running when it failed.
+type RuntimeError struct {
+	Kind    RuntimeErrorKind
+	Message string
+	Span    Span
+}
+
+func (e *RuntimeError) Error() string {
+	return fmt.Sprintf("[OZUL Error] %s (line %d)", e.Message, e.Span.Start.Line)
+}
+
+// Diagnostic converts the error into a PokemonError that can be rendered
+// against the program's source like syntax and type errors
+func (e *RuntimeError) Diagnostic() PokemonError {
+	return NewPokemonError("runtime", e.Span, e.Message)
+}
@@ -0,0 +1,66 @@
+package main
+
+import "fmt"
+
+// Scope is one level of lexical scope, chained to the scope that encloses it.
+// The interpreter stores runtime values in it and the code generator stores
+// C types, so both backends follow the same rules:
+//   - a name may be declared only once per scope
+//   - an inner scope may shadow a name from an outer scope
+//   - names declared in a scope disappear when the block that owns it ends
+type Scope[T any] struct {
+	parent *Scope[T]
+	names  map[string]T
+}
+
+// NewScope creates a scope nested inside parent (nil for an outermost scope)
+func NewScope[T any](parent *Scope[T]) *Scope[T] {
+	return &Scope[T]{parent: parent, names: make(map[string]T)}
+}
+
+// Parent returns the enclosing scope, or nil for an outermost scope
+func (s *Scope[T]) Parent() *Scope[T] {
+	return s.parent
+}
+
+// Declare adds a name to this scope. Redeclaring a name that already lives
+// in this same scope is an error; shadowing an outer one is not.
+func (s *Scope[T]) Declare(name string, value T) error {
+	if _, exists := s.names[name]; exists {
+		return fmt.Errorf("variable %s is already declared in this scope", name)
+	}
+	s.names[name] = value
+	return nil
+}
+
+// Lookup finds a name in this scope or the nearest enclosing one
+func (s *Scope[T]) Lookup(name string) (T, bool) {
+	for scope := s; scope != nil; scope = scope.parent {
+		if value, ok := scope.names[name]; ok {
+			return value, true
+		}
+	}
+	var zero T
+	return zero, false
+}
+
+// Assign updates a name in the nearest scope that declares it. It reports
+// false when no enclosing scope declares the name.
+func (s *Scope[T]) Assign(name string, value T) bool {
+	for scope := s; scope != nil; scope = scope.parent {
+		if _, ok := scope.names[name]; ok {
+			scope.names[name] = value
+			return true
+		}
+	}
+	return false
+}
+
+// Names returns the names declared directly in this scope
+func (s *Scope[T]) Names() []string {
+	names := make([]string, 0, len(s.names))
+	for name := range s.names {
+		names = append(names, name)
+	}
+	return names
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestScope_DeclareAndLookup(t *testing.T) {
+	global := NewScope[int](nil)
+	if err := global.Declare("hp", 100); err != nil {
+		t.Fatalf("Unexpected error declaring hp: %v", err)
+	}
+
+	inner := NewScope(global)
+	if v, ok := inner.Lookup("hp"); !ok || v != 100 {
+		t.Errorf("Expected inner scope to see hp = 100, got %d (found %v)", v, ok)
+	}
+	if _, ok := inner.Lookup("missingno"); ok {
+		t.Errorf("Expected missingno to be undefined")
+	}
+	if inner.Parent() != global {
+		t.Errorf("Expected inner scope's parent to be the global scope")
+	}
+}
+
+func TestScope_RedeclareInSameScope(t *testing.T) {
+	scope := NewScope[int](nil)
+	scope.Declare("hp", 1)
+	if err := scope.Declare("hp", 2); err == nil {
+		t.Errorf("Expected an error redeclaring hp in the same scope")
+	}
+	if v, _ := scope.Lookup("hp"); v != 1 {
+		t.Errorf("Expected failed redeclaration to keep hp = 1, got %d", v)
+	}
+}
+
+func TestScope_Shadowing(t *testing.T) {
+	global := NewScope[int](nil)
+	global.Declare("hp", 1)
+
+	inner := NewScope(global)
+	if err := inner.Declare("hp", 2); err != nil {
+		t.Fatalf("Expected shadowing to be allowed, got: %v", err)
+	}
+	if v, _ := inner.Lookup("hp"); v != 2 {
+		t.Errorf("Expected inner hp = 2, got %d", v)
+	}
+	if v, _ := global.Lookup("hp"); v != 1 {
+		t.Errorf("Expected outer hp to stay 1, got %d", v)
+	}
+}
+
+func TestScope_AssignUpdatesNearestDeclaration(t *testing.T) {
+	global := NewScope[int](nil)
+	global.Declare("hp", 1)
+	inner := NewScope(global)
+
+	if !inner.Assign("hp", 5) {
+		t.Fatalf("Expected assignment through the chain to succeed")
+	}
+	if v, _ := global.Lookup("hp"); v != 5 {
+		t.Errorf("Expected outer hp = 5 after assignment, got %d", v)
+	}
+	if len(inner.Names()) != 0 {
+		t.Errorf("Expected assignment not to declare in the inner scope, got %v", inner.Names())
+	}
+	if inner.Assign("missingno", 1) {
+		t.Errorf("Expected assignment to an undeclared name to fail")
+	}
+}
+
+func TestScope_Names(t *testing.T) {
+	scope := NewScope[string](nil)
+	scope.Declare("b", "int")
+	scope.Declare("a", "double")
+
+	names := scope.Names()
+	sort.Strings(names)
+	if len(names) != 2 || names[0] != "a" || names[1] != "b" {
+		t.Errorf("Expected names [a b], got %v", names)
+	}
+}
@@ -1,6 +1,9 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 // Token types for OZUL
 //go:generate stringer -type=TokenType
@@ -12,43 +15,107 @@ const (
 	PIKACHU TokenType = iota // int
 	PSYDUCK                  // float64
 	EEVEE                    // string
+	VOLTORB                  // bool
 
 	// Keywords
 	IS         // =
-	HAS_LEVEL // = (reassignment)
+	EVOLVES_TO // = (reassignment)
 	CATCH      // input
 	RELEASE    // print
 	FROM       // from
-	WILDGRASS    // wildgrass
+	TRAINER    // trainer
+	IF         // if
+	THEN       // then
+	ELSE       // else
+	END        // end (closes a block)
+	TRAIN      // train (while loop)
+	WHILE      // while
+	REPEAT     // repeat (counted loop)
+	TIMES      // times
+	MOVE       // move (function declaration)
+	GIVES      // gives (function return type)
+	RETURN     // return
+	AND        // and
+	OR         // or
+	NOT        // not
+	TRUE       // true
+	FALSE      // false
 
 	// Literals
-	NUMBER     // 123
-	FLOAT      // 3.14
-	STRING     // "hello"
-	IDENTIFIER // variable names
+	NUMBER        // 123
+	FLOAT         // 3.14
+	STRING        // "hello"
+	RAW_STRING    // """hello"""
+	STRING_START  // "HP: { (the text before an interpolation)
+	STRING_MIDDLE // } and { (text between interpolations)
+	STRING_END    // }!" (the text after the last interpolation)
+	IDENTIFIER    // variable names
 
 	// Operators
 	PLUS     // +
 	MINUS    // -
 	MULTIPLY // *
 	DIVIDE   // /
+	MODULO   // %
+	POWER    // **
+	EQ       // ==
+	NOT_EQ   // !=
+	LT       // <
+	LTE      // <=
+	GT       // >
+	GTE      // >=
 
 	// Delimiters
+	LPAREN // (
+	RPAREN // )
+	COMMA  // ,
 	NEWLINE
 	EOF
+	ILLEGAL // a character no token can start with; Value holds it
 )
 
 type Token struct {
-	Type   TokenType
-	Value  string
-	Line   int
-	Column int
+	Type      TokenType
+	Value     string
+	Line      int
+	Column    int
+	EndLine   int // position just past the token's last character
+	EndColumn int
+	Leading   []Comment // comments between the previous token and this one
+}
+
+// Comment is a "# line comment", a "#[ block comment ]#" or a "## doc
+// comment". Comments are not tokens: each is kept as trivia on the token
+// that follows it, so a formatter can put it back.
+type Comment struct {
+	Span
+	Text string // as written, with its markers
+	Doc  bool   // a "##" doc comment
+}
+
+// Position is a 1-based line and column in the source
+type Position struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// Span is the source range covered by a node; End is just past its last
+// character
+type Span struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+// Pos returns the source range of the node embedding this span
+func (s Span) Pos() Span {
+	return s
 }
 
 // AST Node interfaces and structs
 
 type ASTNode interface {
 	String() string
+	Pos() Span
 }
 
 type Program struct {
@@ -65,9 +132,11 @@ type Expression interface {
 
 // Variable declaration: "Pikachu health is 100"
 type DeclarationStmt struct {
-	PokemonType string     // "Pikachu", "Psyduck", or "Eevee"
+	Span
+	PokemonType string     // "Pikachu", "Psyduck", "Eevee", or "Voltorb"
 	Name        string     // variable name
 	Value       Expression // initial value
+	Doc         string     // the "##" comment right above it, if any
 }
 
 func (d *DeclarationStmt) String() string {
@@ -76,16 +145,18 @@ func (d *DeclarationStmt) String() string {
 
 // Assignment: "health evolves to 150"
 type AssignmentStmt struct {
+	Span
 	Name  string
 	Value Expression
 }
 
 func (a *AssignmentStmt) String() string {
-	return fmt.Sprintf("%s has level %s", a.Name, a.Value.String())
+	return fmt.Sprintf("%s evolves to %s", a.Name, a.Value.String())
 }
 
 // Output: "release health"
 type ReleaseStmt struct {
+	Span
 	Value Expression
 }
 
@@ -93,17 +164,129 @@ func (r *ReleaseStmt) String() string {
 	return fmt.Sprintf("release %s", r.Value.String())
 }
 
-// Input: "catch userInput from wildgrass"
+// Input: "catch userInput from trainer" or "catch Eevee name from trainer"
 type CatchStmt struct {
-	Variable string
+	Span
+	PokemonType string // optional; empty when no type is given
+	Variable    string
 }
 
 func (c *CatchStmt) String() string {
-	return fmt.Sprintf("catch %s from wildgrass", c.Variable)
+	if c.PokemonType != "" {
+		return fmt.Sprintf("catch %s %s from trainer", c.PokemonType, c.Variable)
+	}
+	return fmt.Sprintf("catch %s from trainer", c.Variable)
+}
+
+// Conditional: "if health then ... else ... end"
+type IfStmt struct {
+	Span
+	Condition Expression
+	Then      []Statement
+	Else      []Statement // nil when there is no else branch
+	ElseLine  int         // the line of the 'else', 0 when there is none
+}
+
+func (i *IfStmt) String() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("if %s then\n", i.Condition.String()))
+	sb.WriteString(blockString(i.Then))
+	if i.Else != nil {
+		sb.WriteString("else\n")
+		sb.WriteString(blockString(i.Else))
+	}
+	sb.WriteString("end")
+	return sb.String()
+}
+
+// While loop: "train while health > 0 ... end"
+type WhileStmt struct {
+	Span
+	Condition Expression
+	Body      []Statement
+}
+
+func (w *WhileStmt) String() string {
+	return fmt.Sprintf("train while %s\n%send", w.Condition.String(), blockString(w.Body))
+}
+
+// Counted loop: "repeat 3 times ... end"
+type RepeatStmt struct {
+	Span
+	Count Expression
+	Body  []Statement
+}
+
+func (r *RepeatStmt) String() string {
+	return fmt.Sprintf("repeat %s times\n%send", r.Count.String(), blockString(r.Body))
+}
+
+// Function parameter: "Pikachu power"
+type Param struct {
+	Span
+	PokemonType string
+	Name        string
+}
+
+// Function declaration: "move attack(Pikachu a, Pikachu b) gives Pikachu ... end"
+type FunctionDecl struct {
+	Span
+	Name       string
+	Params     []Param
+	ReturnType string // empty when the move gives nothing back
+	Body       []Statement
+	Doc        string // the "##" comment right above it, if any
+}
+
+func (f *FunctionDecl) String() string {
+	params := make([]string, len(f.Params))
+	for i, param := range f.Params {
+		params[i] = param.PokemonType + " " + param.Name
+	}
+	header := fmt.Sprintf("move %s(%s)", f.Name, strings.Join(params, ", "))
+	if f.ReturnType != "" {
+		header += " gives " + f.ReturnType
+	}
+	return fmt.Sprintf("%s\n%send", header, blockString(f.Body))
+}
+
+// Return from a move: "return damage * 2"
+type ReturnStmt struct {
+	Span
+	Value Expression // nil for a bare "return"
+}
+
+func (r *ReturnStmt) String() string {
+	if r.Value == nil {
+		return "return"
+	}
+	return fmt.Sprintf("return %s", r.Value.String())
+}
+
+// A call used as a statement, evaluated only for its side effects: "heal(10)"
+type ExpressionStmt struct {
+	Span
+	Expr Expression
+}
+
+func (e *ExpressionStmt) String() string {
+	return e.Expr.String()
+}
+
+// blockString renders the statements of a block, one per line, indented
+func blockString(stmts []Statement) string {
+	var sb strings.Builder
+	for _, stmt := range stmts {
+		for _, line := range strings.Split(stmt.String(), "\n") {
+			sb.WriteString("    " + line + "\n")
+		}
+	}
+	return sb.String()
 }
 
 // Binary operations: "10 + 5"
 type BinaryExpr struct {
+	Span
 	Left     Expression
 	Operator string
 	Right    Expression
@@ -113,8 +296,20 @@ func (b *BinaryExpr) String() string {
 	return fmt.Sprintf("(%s %s %s)", b.Left.String(), b.Operator, b.Right.String())
 }
 
+// Unary operations: "not fainted", "-hp"
+type UnaryExpr struct {
+	Span
+	Operator string
+	Operand  Expression
+}
+
+func (u *UnaryExpr) String() string {
+	return fmt.Sprintf("(%s %s)", u.Operator, u.Operand.String())
+}
+
 // Literals
 type NumberLiteral struct {
+	Span
 	Value int
 }
 
@@ -123,6 +318,7 @@ func (n *NumberLiteral) String() string {
 }
 
 type FloatLiteral struct {
+	Span
 	Value float64
 }
 
@@ -131,14 +327,54 @@ func (f *FloatLiteral) String() string {
 }
 
 type StringLiteral struct {
+	Span
 	Value string
+	Raw   bool // written between """ quotes
 }
 
 func (s *StringLiteral) String() string {
 	return fmt.Sprintf("\"%s\"", s.Value)
 }
 
+// String with expressions in it: "HP: {health}". Parts holds the text, as
+// StringLiterals, and the expressions in order. Value is the same string as
+// a concatenation, ("HP: " + health), which is what is checked and run.
+type InterpolatedString struct {
+	Span
+	Parts []Expression
+	Value Expression
+}
+
+func (s *InterpolatedString) String() string {
+	return s.Value.String()
+}
+
+type BooleanLiteral struct {
+	Span
+	Value bool
+}
+
+func (b *BooleanLiteral) String() string {
+	return fmt.Sprintf("%t", b.Value)
+}
+
+// Function call: "attack(10, 5)"
+type CallExpr struct {
+	Span
+	Name string
+	Args []Expression
+}
+
+func (c *CallExpr) String() string {
+	args := make([]string, len(c.Args))
+	for i, arg := range c.Args {
+		args[i] = arg.String()
+	}
+	return fmt.Sprintf("%s(%s)", c.Name, strings.Join(args, ", "))
+}
+
 type Identifier struct {
+	Span
 	Name string
 }
 
@@ -146,11 +382,26 @@ func (i *Identifier) String() string {
 	return i.Name
 }
 
-// Pokemon-themed error type
+// Pokemon-themed error type, used for every OZUL diagnostic
 type PokemonError struct {
-	Message string
-	Line    int
-	Column  int
+	Kind      string // "syntax", "type" or "runtime"
+	Message   string
+	Line      int
+	Column    int
+	EndLine   int // position just past the offending code
+	EndColumn int
+}
+
+// NewPokemonError creates a diagnostic covering the given source range
+func NewPokemonError(kind string, span Span, message string) PokemonError {
+	return PokemonError{
+		Kind:      kind,
+		Message:   message,
+		Line:      span.Start.Line,
+		Column:    span.Start.Column,
+		EndLine:   span.End.Line,
+		EndColumn: span.End.Column,
+	}
 }
 
 func (e PokemonError) Error() string {
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Runner runs programs: the tree-walking Interpreter and the bytecode VM
+// both implement it, and behave the same
+type Runner interface {
+	Run(program *Program) error
+	SetMaxSteps(limit int)
+	SetInput(r *bufio.Reader)
+	SetOutput(w io.Writer)
+}
+
+// VM is a stack-based virtual machine that runs programs compiled to
+// bytecode. Values live on one operand stack; each call frame's slots are a
+// window of that stack, starting with the move's arguments.
+type VM struct {
+	compiler *Compiler
+	globals  []Value
+	stack    []Value
+	frames   []vmFrame // callers of the function running now
+	maxSteps int       // 0 or less means unlimited
+	steps    int
+	input    *bufio.Reader // shared by every catch so buffered input is not lost
+	output   io.Writer     // where release writes; nil means standard output
+}
+
+// vmFrame is where a caller resumes once the move it called returns
+type vmFrame struct {
+	fn   *Function
+	pc   int
+	base int // stack index of the frame's first slot
+}
+
+// NewVM creates a VM with no variables or moves
+func NewVM() *VM {
+	return &VM{
+		compiler: NewCompiler(),
+		maxSteps: DefaultMaxSteps,
+	}
+}
+
+// SetMaxSteps sets how many statements (and loop iterations) a single Run
+// may execute, counted like the Interpreter counts them. A limit of 0 or
+// less disables the check.
+func (vm *VM) SetMaxSteps(limit int) {
+	vm.maxSteps = limit
+}
+
+// SetInput makes catch read from r instead of standard input
+func (vm *VM) SetInput(r *bufio.Reader) {
+	vm.input = r
+}
+
+// SetOutput sends release output and catch prompts to w instead of
+// standard output
+func (vm *VM) SetOutput(w io.Writer) {
+	vm.output = w
+}
+
+// Run compiles and executes a program and returns a *RuntimeError if it
+// fails. Like the Interpreter, the VM stays usable afterwards and keeps the
+// top-level variables declared before the failure.
+func (vm *VM) Run(program *Program) (err error) {
+	vm.steps = 0
+	main := vm.compiler.Compile(program)
+	defer func() {
+		vm.compiler.forgetGlobals(len(vm.globals))
+		vm.stack = vm.stack[:0]
+		vm.frames = vm.frames[:0]
+		if r := recover(); r != nil {
+			runtimeErr, ok := r.(*RuntimeError)
+			if !ok {
+				panic(r) // a bug in the VM, not in the program
+			}
+			err = runtimeErr
+		}
+	}()
+	vm.execute(main)
+	return nil
+}
+
+// stdout is where program output goes
+func (vm *VM) stdout() io.Writer {
+	if vm.output != nil {
+		return vm.output
+	}
+	return os.Stdout
+}
+
+// execute runs a program's top level until it halts. Runtime errors are
+// raised with panic, like in the interpreter.
+func (vm *VM) execute(main *Function) {
+	fn, pc, base := main, 0, len(vm.stack)
+	vm.stack = append(vm.stack, make([]Value, fn.Slots)...)
+
+	errorf := func(kind RuntimeErrorKind, format string, args ...interface{}) *RuntimeError {
+		return &RuntimeError{Kind: kind, Message: fmt.Sprintf(format, args...), Span: fn.Spans[pc-1]}
+	}
+
+	for {
+		ins := fn.Code[pc]
+		pc++
+		top := len(vm.stack) - 1
+
+		switch ins.Op {
+		case OpConst:
+			vm.stack = append(vm.stack, fn.Constants[ins.Arg])
+		case OpPop:
+			vm.stack = vm.stack[:top]
+		case OpStep:
+			vm.steps++
+			if vm.maxSteps > 0 && vm.steps > vm.maxSteps {
+				panic(errorf(ErrStepLimit, "Execution step limit of %d exceeded (possible infinite loop)", vm.maxSteps))
+			}
+		case OpHalt:
+			return
+		case OpFail:
+			failure := fn.Failures[ins.Arg]
+			panic(errorf(failure.Kind, "%s", failure.Message))
+		case OpCatch:
+			name := vm.stack[top].Str
+			if vm.input == nil {
+				vm.input = bufio.NewReader(os.Stdin)
+			}
+			fmt.Fprintf(vm.stdout(), "Enter value for %s: ", name)
+			if vm.output == nil {
+				os.Stdout.Sync() // Flush output buffer so prompt is visible
+			}
+			input, _ := vm.input.ReadString('\n')
+			val, err := parseInput(strings.TrimRight(input, "\r\n"), catchTypes[ins.Arg])
+			if err != nil {
+				err.Span = fn.Spans[pc-1]
+				panic(err)
+			}
+			vm.stack[top] = val
+		case OpPrint:
+			fmt.Fprintln(vm.stdout(), vm.stack[top].String())
+			vm.stack = vm.stack[:top]
+
+		case OpLoadLocal:
+			vm.stack = append(vm.stack, vm.stack[base+int(ins.Arg)])
+		case OpStoreLocal:
+			vm.stack[base+int(ins.Arg)] = vm.stack[top]
+			vm.stack = vm.stack[:top]
+		case OpLoadGlobal:
+			vm.stack = append(vm.stack, vm.globals[ins.Arg])
+		case OpStoreGlobal:
+			vm.globals[ins.Arg] = vm.stack[top]
+			vm.stack = vm.stack[:top]
+		case OpDefineGlobal:
+			vm.globals = append(vm.globals, vm.stack[top])
+			vm.stack = vm.stack[:top]
+
+		case OpJump:
+			pc = int(ins.Arg)
+		case OpJumpIfFalse:
+			if !vm.stack[top].Bool {
+				pc = int(ins.Arg)
+			}
+			vm.stack = vm.stack[:top]
+		case OpJumpIfTrue:
+			if vm.stack[top].Bool {
+				pc = int(ins.Arg)
+			}
+			vm.stack = vm.stack[:top]
+
+		case OpCall:
+			callee := vm.compiler.functions[ins.Arg]
+			if len(vm.frames)+1 >= MaxCallDepth {
+				panic(errorf(ErrCallDepth, "Maximum call depth of %d exceeded in move %s (runaway recursion?)", MaxCallDepth, callee.Name))
+			}
+			vm.frames = append(vm.frames, vmFrame{fn, pc, base})
+			fn, pc, base = callee, 0, len(vm.stack)-callee.Params
+			for i := callee.Params; i < callee.Slots; i++ {
+				vm.stack = append(vm.stack, Value{})
+			}
+		case OpReturn, OpReturnVoid:
+			if ins.Op == OpReturn {
+				vm.stack[base] = vm.stack[top]
+				vm.stack = vm.stack[:base+1]
+			} else {
+				vm.stack = vm.stack[:base]
+			}
+			caller := vm.frames[len(vm.frames)-1]
+			vm.frames = vm.frames[:len(vm.frames)-1]
+			fn, pc, base = caller.fn, caller.pc, caller.base
+
+		case OpAddInt:
+			vm.stack[top-1].Int += vm.stack[top].Int
+			vm.stack = vm.stack[:top]
+		case OpSubInt:
+			vm.stack[top-1].Int -= vm.stack[top].Int
+			vm.stack = vm.stack[:top]
+		case OpMulInt:
+			vm.stack[top-1].Int *= vm.stack[top].Int
+			vm.stack = vm.stack[:top]
+		case OpDivInt:
+			if vm.stack[top].Int == 0 {
+				panic(errorf(ErrDivisionByZero, "Division by zero."))
+			}
+			vm.stack[top-1].Int /= vm.stack[top].Int
+			vm.stack = vm.stack[:top]
+		case OpModInt:
+			if vm.stack[top].Int == 0 {
+				panic(errorf(ErrDivisionByZero, "Division by zero."))
+			}
+			vm.stack[top-1].Int %= vm.stack[top].Int
+			vm.stack = vm.stack[:top]
+		case OpPowInt:
+			if vm.stack[top].Int < 0 {
+				panic(errorf(ErrNegativeExponent, negativeExponent))
+			}
+			vm.stack[top-1].Int = intPower(vm.stack[top-1].Int, vm.stack[top].Int)
+			vm.stack = vm.stack[:top]
+		case OpNegInt:
+			vm.stack[top].Int = -vm.stack[top].Int
+		case OpAddFloat:
+			vm.stack[top-1].Float += vm.stack[top].Float
+			vm.stack = vm.stack[:top]
+		case OpSubFloat:
+			vm.stack[top-1].Float -= vm.stack[top].Float
+			vm.stack = vm.stack[:top]
+		case OpMulFloat:
+			vm.stack[top-1].Float *= vm.stack[top].Float
+			vm.stack = vm.stack[:top]
+		case OpDivFloat:
+			if vm.stack[top].Float == 0 {
+				panic(errorf(ErrDivisionByZero, "Division by zero."))
+			}
+			vm.stack[top-1].Float /= vm.stack[top].Float
+			vm.stack = vm.stack[:top]
+		case OpModFloat:
+			if vm.stack[top].Float == 0 {
+				panic(errorf(ErrDivisionByZero, "Division by zero."))
+			}
+			vm.stack[top-1].Float = math.Mod(vm.stack[top-1].Float, vm.stack[top].Float)
+			vm.stack = vm.stack[:top]
+		case OpPowFloat:
+			vm.stack[top-1].Float = math.Pow(vm.stack[top-1].Float, vm.stack[top].Float)
+			vm.stack = vm.stack[:top]
+		case OpNegFloat:
+			vm.stack[top].Float = -vm.stack[top].Float
+		case OpConcat:
+			vm.stack[top-1].Str += vm.stack[top].Str
+			vm.stack = vm.stack[:top]
+
+		case OpCompareInt:
+			left, right := vm.stack[top-1].Int, vm.stack[top].Int
+			vm.stack[top-1] = Value{Type: "bool", Bool: compareResult(ins.Arg, sign(left < right, left > right))}
+			vm.stack = vm.stack[:top]
+		case OpCompareFloat:
+			left, right := vm.stack[top-1].Float, vm.stack[top].Float
+			vm.stack[top-1] = Value{Type: "bool", Bool: compareResult(ins.Arg, sign(left < right, left > right))}
+			vm.stack = vm.stack[:top]
+		case OpCompareString:
+			cmp := strings.Compare(vm.stack[top-1].Str, vm.stack[top].Str)
+			vm.stack[top-1] = Value{Type: "bool", Bool: compareResult(ins.Arg, cmp)}
+			vm.stack = vm.stack[:top]
+		case OpCompareBool:
+			cmp := sign(false, vm.stack[top-1].Bool != vm.stack[top].Bool)
+			vm.stack[top-1] = Value{Type: "bool", Bool: compareResult(ins.Arg, cmp)}
+			vm.stack = vm.stack[:top]
+		case OpNot:
+			vm.stack[top].Bool = !vm.stack[top].Bool
+
+		case OpIntToFloat:
+			v := &vm.stack[top-int(ins.Arg)]
+			*v = Value{Type: "float", Float: float64(v.Int)}
+		case OpStringToInt:
+			v := &vm.stack[top-int(ins.Arg)]
+			i, _ := strconv.Atoi(v.Str)
+			*v = Value{Type: "int", Int: i}
+		case OpStringToFloat:
+			v := &vm.stack[top-int(ins.Arg)]
+			f, _ := strconv.ParseFloat(v.Str, 64)
+			*v = Value{Type: "float", Float: f}
+		case OpIntToString:
+			v := &vm.stack[top-int(ins.Arg)]
+			*v = Value{Type: "string", Str: strconv.Itoa(v.Int)}
+		case OpFloatToString:
+			v := &vm.stack[top-int(ins.Arg)]
+			*v = Value{Type: "string", Str: fmt.Sprintf("%f", v.Float)}
+		case OpBoolToString:
+			v := &vm.stack[top-int(ins.Arg)]
+			*v = Value{Type: "string", Str: strconv.FormatBool(v.Bool)}
+
+		default:
+			panic(fmt.Sprintf("[OZUL VM Error] Unknown opcode %s", ins.Op))
+		}
+	}
+}
+
+// sign turns the outcome of an ordering into -1, 0 or 1
+func sign(less, greater bool) int {
+	if less {
+		return -1
+	} else if greater {
+		return 1
+	}
+	return 0
+}
+
+// compareResult applies a comparison operator to the sign of a comparison
+func compareResult(op int32, cmp int) bool {
+	switch op {
+	case CmpEq:
+		return cmp == 0
+	case CmpNe:
+		return cmp != 0
+	case CmpLt:
+		return cmp < 0
+	case CmpLe:
+		return cmp <= 0
+	case CmpGt:
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
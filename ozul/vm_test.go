@@ -0,0 +1,165 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"strings"
+	"testing"
+)
+
+// interpreterSuite is every test in interpreter_test.go, run again on the VM
+var interpreterSuite = map[string]func(*testing.T){
+	"DeclarationAndRelease":     TestInterpreter_DeclarationAndRelease,
+	"Arithmetic":                TestInterpreter_Arithmetic,
+	"Operators":                 TestInterpreter_Operators,
+	"OperatorErrors":            TestInterpreter_OperatorErrors,
+	"StringConcat":              TestInterpreter_StringConcat,
+	"CatchInput":                TestInterpreter_CatchInput,
+	"UndefinedVariableError":    TestInterpreter_UndefinedVariableError,
+	"DivisionByZeroError":       TestInterpreter_DivisionByZeroError,
+	"ErrorPosition":             TestInterpreter_ErrorPosition,
+	"RecoversAfterError":        TestInterpreter_RecoversAfterError,
+	"IfElse":                    TestInterpreter_IfElse,
+	"ElseIfChain":               TestInterpreter_ElseIfChain,
+	"ComparisonsAndLogic":       TestInterpreter_ComparisonsAndLogic,
+	"ConditionMustBeBool":       TestInterpreter_ConditionMustBeBool,
+	"WhileLoop":                 TestInterpreter_WhileLoop,
+	"RepeatLoop":                TestInterpreter_RepeatLoop,
+	"StepLimit":                 TestInterpreter_StepLimit,
+	"StepLimitCountsLoopBodies": TestInterpreter_StepLimitCountsLoopBodies,
+	"Functions":                 TestInterpreter_Functions,
+	"FunctionFramesAreIsolated": TestInterpreter_FunctionFramesAreIsolated,
+	"FunctionErrors":            TestInterpreter_FunctionErrors,
+	"BlockScoping":              TestInterpreter_BlockScoping,
+	"ScopeErrors":               TestInterpreter_ScopeErrors,
+	"DeclaredTypesAreKept":      TestInterpreter_DeclaredTypesAreKept,
+	"TypedCatch":                TestInterpreter_TypedCatch,
+}
+
+func TestVM_InterpreterSuite(t *testing.T) {
+	defer func(original func() Runner) { newRunner = original }(newRunner)
+	newRunner = func() Runner { return NewVM() }
+
+	for name, test := range interpreterSuite {
+		t.Run(name, test)
+	}
+}
+
+// TestVM_InterpreterSuiteIsComplete keeps interpreterSuite in step with
+// interpreter_test.go
+func TestVM_InterpreterSuiteIsComplete(t *testing.T) {
+	file, err := parser.ParseFile(token.NewFileSet(), "interpreter_test.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || !strings.HasPrefix(fn.Name.Name, "TestInterpreter_") {
+			continue
+		}
+		if _, ok := interpreterSuite[strings.TrimPrefix(fn.Name.Name, "TestInterpreter_")]; !ok {
+			t.Errorf("%s is missing from interpreterSuite", fn.Name.Name)
+		}
+	}
+}
+
+func TestVM_ForgetsVariablesAFailedRunNeverDeclared(t *testing.T) {
+	vm := NewVM()
+	run := func(source string) (string, error) {
+		var out strings.Builder
+		vm.SetOutput(&out)
+		err := vm.Run(NewParser(NewLexer(source).Tokenize()).Parse())
+		return out.String(), err
+	}
+
+	_, err := run("Pikachu hp is 10\nrelease hp / 0\nPikachu later is 1")
+	expectRuntimeError(t, "first run", err, ErrDivisionByZero, "Division by zero")
+
+	output, err := run("Eevee later is \"declared now\"\nrelease later + \" \" + hp")
+	if err != nil || output != "declared now 10\n" {
+		t.Errorf("Expected hp to survive and later to be free, got %q, %v", output, err)
+	}
+}
+
+func TestVM_DeepRecursion(t *testing.T) {
+	source := `move count(Pikachu n) gives Pikachu
+if n == 0 then
+return 0
+end
+return 1 + count(n - 1)
+end
+release count(998)`
+	vm := NewVM()
+	var out strings.Builder
+	vm.SetOutput(&out)
+	if err := vm.Run(NewParser(NewLexer(source).Tokenize()).Parse()); err != nil || out.String() != "998\n" {
+		t.Errorf("Expected 998, got %q, %v", out.String(), err)
+	}
+}
+
+// benchmarkSource is the kind of simulation students write: loops, moves
+// and arithmetic on all number types
+const benchmarkSource = `move step(Psyduck position, Psyduck speed) gives Psyduck
+return position + speed * 0.5
+end
+move collatz(Pikachu n) gives Pikachu
+Pikachu steps is 0
+train while n != 1
+if n / 2 * 2 == n then
+n evolves to n / 2
+else
+n evolves to 3 * n + 1
+end
+steps evolves to steps + 1
+end
+return steps
+end
+Psyduck position is 0
+Pikachu total is 0
+Pikachu i is 1
+repeat 2000 times
+position evolves to step(position, i)
+total evolves to total + collatz(i)
+i evolves to i + 1
+end
+release total
+release position`
+
+func TestVM_MatchesInterpreterOnBenchmark(t *testing.T) {
+	program := NewParser(NewLexer(benchmarkSource).Tokenize()).Parse()
+	outputs := make([]string, 2)
+	for i, runner := range []Runner{NewInterpreter(), NewVM()} {
+		var out strings.Builder
+		runner.SetMaxSteps(0)
+		runner.SetOutput(&out)
+		if err := runner.Run(program); err != nil {
+			t.Fatal(err)
+		}
+		outputs[i] = out.String()
+	}
+	if outputs[0] != outputs[1] {
+		t.Errorf("Expected the VM to print %q like the interpreter, got %q", outputs[0], outputs[1])
+	}
+}
+
+func benchmarkRunner(b *testing.B, newRunner func() Runner) {
+	program := NewParser(NewLexer(benchmarkSource).Tokenize()).Parse()
+	for i := 0; i < b.N; i++ {
+		runner := newRunner()
+		runner.SetMaxSteps(0)
+		runner.SetOutput(io.Discard)
+		if err := runner.Run(program); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkInterpreter(b *testing.B) {
+	benchmarkRunner(b, func() Runner { return NewInterpreter() })
+}
+
+func BenchmarkVM(b *testing.B) {
+	benchmarkRunner(b, func() Runner { return NewVM() })
+}
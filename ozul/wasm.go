@@ -0,0 +1,985 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// WasmGen lowers a program to a WebAssembly module, as WAT text or as a
+// .wasm binary. Output and input go through functions the host imports
+// from the "ozul" module (see wasmImports), so the same module runs in a
+// browser page or any other embedder; ozul_host.js is a host for both
+// browsers and Node.js.
+//
+// Values follow the interpreter: a Pikachu is an i64, a Psyduck an f64 and
+// a Voltorb an i32. An Eevee is the i32 address of a string in linear
+// memory, stored as its byte length (an i32) followed by its bytes. Strings
+// are allocated from a heap that is never freed, which suits short-lived
+// exercise programs.
+type WasmGen struct {
+	// Type checker whose inferred types drive the wasm types
+	checker *Checker
+
+	// String constants, by value, and the data segment holding them
+	constants map[string]int32
+	data      []byte
+
+	// Runtime functions and moves, in index order after the imports
+	funcs []*wasmFunc
+
+	// Function being generated
+	fn         *wasmFunc
+	variables  *Scope[wasmVar]
+	returnType string // Pokemon type the current move gives, "" for none
+	labels     int
+}
+
+// wasmFunc is one function of the module. The body is flat WAT, one
+// instruction per line, which is both printed as text and encoded to binary.
+type wasmFunc struct {
+	name   string // without the leading $
+	export string // exported name, "" for none
+	params []wasmLocal
+	result string // value type, "" for none
+	locals []wasmLocal
+	body   []string
+}
+
+type wasmLocal struct {
+	name string // without the leading $
+	typ  string
+}
+
+// wasmVar is a variable's local and its Pokemon type
+type wasmVar struct {
+	local       string
+	pokemonType string
+}
+
+// wasmTypes maps each Pokemon type to the wasm type that represents it
+var wasmTypes = map[string]string{
+	"Pikachu": "i64",
+	"Psyduck": "f64",
+	"Eevee":   "i32",
+	"Voltorb": "i32",
+}
+
+// wasmImports are the host functions a module imports from "ozul". Names
+// are passed as an address and a byte length; catch_string and
+// float_to_string return a string the host allocated with the exported
+// alloc function. float_rem and float_pow are a % b and a ** b on
+// Psyducks, which wasm has no instructions for. fail reports a runtime
+// error and must not return.
+var wasmImports = []wasmFunc{
+	{name: "release_int", params: []wasmLocal{{"value", "i64"}}},
+	{name: "release_float", params: []wasmLocal{{"value", "f64"}}},
+	{name: "release_bool", params: []wasmLocal{{"value", "i32"}}},
+	{name: "release_string", params: []wasmLocal{{"ptr", "i32"}, {"len", "i32"}}},
+	{name: "catch_int", params: []wasmLocal{{"name", "i32"}, {"len", "i32"}, {"line", "i32"}}, result: "i64"},
+	{name: "catch_float", params: []wasmLocal{{"name", "i32"}, {"len", "i32"}, {"line", "i32"}}, result: "f64"},
+	{name: "catch_string", params: []wasmLocal{{"name", "i32"}, {"len", "i32"}}, result: "i32"},
+	{name: "float_to_string", params: []wasmLocal{{"value", "f64"}}, result: "i32"},
+	{name: "float_rem", params: []wasmLocal{{"a", "f64"}, {"b", "f64"}}, result: "f64"},
+	{name: "float_pow", params: []wasmLocal{{"a", "f64"}, {"b", "f64"}}, result: "f64"},
+	{name: "fail", params: []wasmLocal{{"message", "i32"}, {"len", "i32"}, {"line", "i32"}}},
+}
+
+// wasmDataStart is where string constants start; address 0 stays unused
+const wasmDataStart = 8
+
+// NewWasmGen creates a new WebAssembly generator
+func NewWasmGen() *WasmGen {
+	wg := &WasmGen{
+		checker:   NewChecker(),
+		constants: make(map[string]int32),
+	}
+	wg.funcs = wg.runtime()
+	return wg
+}
+
+// GenerateProgram generates the module for the entire program. The program
+// is type checked first; type errors are reported as a panic.
+func (wg *WasmGen) GenerateProgram(program *Program) {
+	if errs := wg.checker.Check(program); len(errs) > 0 {
+		messages := make([]string, len(errs))
+		for i, err := range errs {
+			messages[i] = err.Error()
+		}
+		panic("[OZUL Wasm Error] " + strings.Join(messages, "; "))
+	}
+
+	for _, stmt := range program.Statements {
+		if fn, ok := stmt.(*FunctionDecl); ok {
+			wg.generateFunction(fn)
+		}
+	}
+
+	wg.beginFunction(&wasmFunc{name: "main", export: "run"}, "")
+	for _, stmt := range program.Statements {
+		if _, ok := stmt.(*FunctionDecl); ok {
+			continue
+		}
+		wg.generateStatement(stmt)
+	}
+	wg.funcs = append(wg.funcs, wg.fn)
+}
+
+// heapStart is the first address after the string constants
+func (wg *WasmGen) heapStart() int32 {
+	return int32(align4(wasmDataStart + len(wg.data)))
+}
+
+func align4(n int) int {
+	return (n + 3) &^ 3
+}
+
+// GetWAT returns the module as WebAssembly text
+func (wg *WasmGen) GetWAT() string {
+	var sb strings.Builder
+	sb.WriteString(";; Generated by the OZUL compiler\n(module\n")
+	for _, imp := range wasmImports {
+		sb.WriteString(fmt.Sprintf("  (import \"ozul\" %q (func $%s%s))\n", imp.name, imp.name, wasmSignature(&imp, false)))
+	}
+	sb.WriteString("  (memory (export \"memory\") 1)\n")
+	sb.WriteString(fmt.Sprintf("  (global $heap (mut i32) (i32.const %d))\n", wg.heapStart()))
+	sb.WriteString(fmt.Sprintf("  (data (i32.const %d) \"%s\")\n", wasmDataStart, watEscape(wg.data)))
+	for _, fn := range wg.funcs {
+		sb.WriteString("\n  (func $" + fn.name)
+		if fn.export != "" {
+			sb.WriteString(fmt.Sprintf(" (export %q)", fn.export))
+		}
+		sb.WriteString(wasmSignature(fn, true) + "\n")
+		for _, local := range fn.locals {
+			sb.WriteString(fmt.Sprintf("    (local $%s %s)\n", local.name, local.typ))
+		}
+		indent := "    "
+		for _, line := range fn.body {
+			op := strings.Fields(line)[0]
+			if op == "end" || op == "else" {
+				indent = indent[2:]
+			}
+			sb.WriteString(indent + line + "\n")
+			if op == "block" || op == "loop" || op == "if" || op == "else" {
+				indent += "  "
+			}
+		}
+		sb.WriteString("  )\n")
+	}
+	sb.WriteString(")\n")
+	return sb.String()
+}
+
+// wasmSignature writes the params and result of a function, with the
+// parameter names when named is set
+func wasmSignature(fn *wasmFunc, named bool) string {
+	var sb strings.Builder
+	for _, param := range fn.params {
+		if named {
+			sb.WriteString(fmt.Sprintf(" (param $%s %s)", param.name, param.typ))
+		} else {
+			sb.WriteString(" (param " + param.typ + ")")
+		}
+	}
+	if fn.result != "" {
+		sb.WriteString(" (result " + fn.result + ")")
+	}
+	return sb.String()
+}
+
+// watEscape writes bytes as the body of a WAT string
+func watEscape(data []byte) string {
+	var sb strings.Builder
+	for _, c := range data {
+		if c >= ' ' && c <= '~' && c != '"' && c != '\\' {
+			sb.WriteByte(c)
+		} else {
+			sb.WriteString(fmt.Sprintf("\\%02x", c))
+		}
+	}
+	return sb.String()
+}
+
+// beginFunction starts generating fn
+func (wg *WasmGen) beginFunction(fn *wasmFunc, returnType string) {
+	wg.fn = fn
+	wg.variables = NewScope[wasmVar](nil)
+	wg.returnType = returnType
+	wg.labels = 0
+}
+
+// emit appends an instruction to the current function
+func (wg *WasmGen) emit(format string, args ...interface{}) {
+	wg.fn.body = append(wg.fn.body, fmt.Sprintf(format, args...))
+}
+
+func (wg *WasmGen) newLabel(prefix string) string {
+	wg.labels++
+	return fmt.Sprintf("$%s.%d", prefix, wg.labels)
+}
+
+// local adds a local to the current function, numbered so that shadowed
+// variables get locals of their own
+func (wg *WasmGen) local(name, typ string) string {
+	wg.labels++
+	local := fmt.Sprintf("%s.%d", name, wg.labels)
+	wg.fn.locals = append(wg.fn.locals, wasmLocal{local, typ})
+	return "$" + local
+}
+
+// declare gives a variable a local in the current scope
+func (wg *WasmGen) declare(name, pokemonType string) wasmVar {
+	local := name
+	if !simpleName.MatchString(name) {
+		local = "v"
+	}
+	v := wasmVar{local: wg.local(local, wasmTypes[pokemonType]), pokemonType: pokemonType}
+	if err := wg.variables.Declare(name, v); err != nil {
+		panic(fmt.Sprintf("[OZUL Wasm Error] Variable %s already declared in this scope!", name))
+	}
+	return v
+}
+
+func (wg *WasmGen) lookup(name string) wasmVar {
+	v, ok := wg.variables.Lookup(name)
+	if !ok {
+		panic(fmt.Sprintf("[OZUL Wasm Error] Variable %s not declared!", name))
+	}
+	return v
+}
+
+// constant returns the address of a string constant in the data segment
+func (wg *WasmGen) constant(value string) int32 {
+	if addr, ok := wg.constants[value]; ok {
+		return addr
+	}
+	for len(wg.data)%4 != 0 {
+		wg.data = append(wg.data, 0)
+	}
+	addr := int32(wasmDataStart + len(wg.data))
+	n := len(value)
+	wg.data = append(wg.data, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	wg.data = append(wg.data, value...)
+	wg.constants[value] = addr
+	return addr
+}
+
+// emitConstantBytes pushes the address and length of a constant's bytes,
+// the way imports take names and messages
+func (wg *WasmGen) emitConstantBytes(value string) {
+	wg.emit("i32.const %d", wg.constant(value)+4)
+	wg.emit("i32.const %d", len(value))
+}
+
+// wasmMoveName is the name of a move's function. Text format names are
+// ASCII, so any other character is written as % and its code point in hex,
+// which no OZUL name can contain.
+func wasmMoveName(name string) string {
+	var sb strings.Builder
+	sb.WriteString("move.")
+	for _, r := range name {
+		if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			sb.WriteRune(r)
+		} else {
+			fmt.Fprintf(&sb, "%%%x", r)
+		}
+	}
+	return sb.String()
+}
+
+// generateFunction generates a wasm function for a move, named $move.<name>
+// so it cannot clash with the runtime
+func (wg *WasmGen) generateFunction(fn *FunctionDecl) {
+	wg.beginFunction(&wasmFunc{name: wasmMoveName(fn.Name), result: wasmTypes[fn.ReturnType]}, fn.ReturnType)
+	for _, param := range fn.Params {
+		v := wasmVar{local: "$" + param.Name, pokemonType: param.PokemonType}
+		if !simpleName.MatchString(param.Name) {
+			v.local = fmt.Sprintf("$p.%d", len(wg.fn.params))
+		}
+		wg.fn.params = append(wg.fn.params, wasmLocal{v.local[1:], wasmTypes[param.PokemonType]})
+		if err := wg.variables.Declare(param.Name, v); err != nil {
+			panic(fmt.Sprintf("[OZUL Wasm Error] Duplicate parameter %s in move %s", param.Name, fn.Name))
+		}
+	}
+	for _, stmt := range fn.Body {
+		wg.generateStatement(stmt)
+	}
+	if fn.ReturnType != "" {
+		// The checker makes sure every path returns first
+		wg.emit("unreachable")
+	}
+	wg.funcs = append(wg.funcs, wg.fn)
+}
+
+// generateStatement generates code for a single statement
+func (wg *WasmGen) generateStatement(stmt Statement) {
+	switch s := stmt.(type) {
+	case *DeclarationStmt:
+		wg.convert(wg.generateExpression(s.Value), s.PokemonType)
+		v := wg.declare(s.Name, s.PokemonType)
+		wg.emit("local.set %s", v.local)
+	case *AssignmentStmt:
+		v := wg.lookup(s.Name)
+		wg.convert(wg.generateExpression(s.Value), v.pokemonType)
+		wg.emit("local.set %s", v.local)
+	case *ReleaseStmt:
+		wg.generateRelease(s)
+	case *CatchStmt:
+		wg.generateCatch(s)
+	case *IfStmt:
+		wg.generateExpression(s.Condition)
+		wg.emit("if")
+		wg.generateBlock(s.Then)
+		if len(s.Else) > 0 {
+			wg.emit("else")
+			wg.generateBlock(s.Else)
+		}
+		wg.emit("end")
+	case *WhileStmt:
+		wg.generateWhile(s)
+	case *RepeatStmt:
+		wg.generateRepeat(s)
+	case *ReturnStmt:
+		if s.Value != nil {
+			wg.convert(wg.generateExpression(s.Value), wg.returnType)
+		}
+		wg.emit("return")
+	case *ExpressionStmt:
+		if wg.generateExpression(s.Expr) != "" {
+			wg.emit("drop")
+		}
+	}
+}
+
+// generateRelease hands a value to the host to print
+func (wg *WasmGen) generateRelease(stmt *ReleaseStmt) {
+	switch pokemonType := wg.generateExpression(stmt.Value); pokemonType {
+	case "Pikachu":
+		wg.emit("call $release_int")
+	case "Psyduck":
+		wg.emit("call $release_float")
+	case "Eevee":
+		wg.emit("call $ozul.release")
+	case "Voltorb":
+		wg.emit("call $release_bool")
+	default:
+		panic(fmt.Sprintf("[OZUL Wasm Error] Unknown type for release: %s", pokemonType))
+	}
+}
+
+// generateCatch asks the host for a line from the trainer. A typed catch
+// declares a new variable; an untyped one reads into a visible variable, or
+// else declares a new Pikachu.
+func (wg *WasmGen) generateCatch(stmt *CatchStmt) {
+	v, exists := wg.variables.Lookup(stmt.Variable)
+	if stmt.PokemonType != "" || !exists {
+		pokemonType := stmt.PokemonType
+		if pokemonType == "" {
+			pokemonType = "Pikachu"
+		}
+		v = wg.declare(stmt.Variable, pokemonType)
+	}
+
+	wg.emitConstantBytes(stmt.Variable)
+	switch v.pokemonType {
+	case "Pikachu":
+		wg.emit("i32.const %d", stmt.Pos().Start.Line)
+		wg.emit("call $catch_int")
+	case "Psyduck":
+		wg.emit("i32.const %d", stmt.Pos().Start.Line)
+		wg.emit("call $catch_float")
+	case "Eevee":
+		wg.emit("call $catch_string")
+	default:
+		panic(fmt.Sprintf("[OZUL Wasm Error] Cannot catch into %s variable %s", v.pokemonType, stmt.Variable))
+	}
+	wg.emit("local.set %s", v.local)
+}
+
+// generateWhile generates a "train while" loop: a loop to repeat the body
+// inside a block to break out of
+func (wg *WasmGen) generateWhile(stmt *WhileStmt) {
+	exit, next := wg.newLabel("while.end"), wg.newLabel("while.cond")
+	wg.emit("block %s", exit)
+	wg.emit("loop %s", next)
+	wg.generateExpression(stmt.Condition)
+	wg.emit("i32.eqz")
+	wg.emit("br_if %s", exit)
+	wg.generateBlock(stmt.Body)
+	wg.emit("br %s", next)
+	wg.emit("end")
+	wg.emit("end")
+}
+
+// generateRepeat generates a counted "repeat N times" loop. The count is
+// evaluated once, before the first iteration.
+func (wg *WasmGen) generateRepeat(stmt *RepeatStmt) {
+	count, index := wg.local("repeat.count", "i64"), wg.local("repeat", "i64")
+	wg.generateExpression(stmt.Count)
+	wg.emit("local.set %s", count)
+	wg.emit("i64.const 0")
+	wg.emit("local.set %s", index)
+
+	exit, next := wg.newLabel("repeat.end"), wg.newLabel("repeat.cond")
+	wg.emit("block %s", exit)
+	wg.emit("loop %s", next)
+	wg.emit("local.get %s", index)
+	wg.emit("local.get %s", count)
+	wg.emit("i64.ge_s")
+	wg.emit("br_if %s", exit)
+	wg.generateBlock(stmt.Body)
+	wg.emit("local.get %s", index)
+	wg.emit("i64.const 1")
+	wg.emit("i64.add")
+	wg.emit("local.set %s", index)
+	wg.emit("br %s", next)
+	wg.emit("end")
+	wg.emit("end")
+}
+
+// generateBlock generates the statements of a nested block in a new scope
+func (wg *WasmGen) generateBlock(stmts []Statement) {
+	outer := wg.variables
+	wg.variables = NewScope(outer)
+	for _, stmt := range stmts {
+		wg.generateStatement(stmt)
+	}
+	wg.variables = outer
+}
+
+// generateExpression generates code leaving an expression's value on the
+// stack, and returns its Pokemon type ("" for calls to moves that give
+// nothing back)
+func (wg *WasmGen) generateExpression(expr Expression) string {
+	switch e := expr.(type) {
+	case *NumberLiteral:
+		wg.emit("i64.const %d", e.Value)
+		return "Pikachu"
+	case *FloatLiteral:
+		wg.emit("f64.const %s", wasmFloat(e.Value))
+		return "Psyduck"
+	case *InterpolatedString:
+		return wg.generateExpression(e.Value)
+	case *StringLiteral:
+		wg.emit("i32.const %d", wg.constant(e.Value))
+		return "Eevee"
+	case *BooleanLiteral:
+		if e.Value {
+			wg.emit("i32.const 1")
+		} else {
+			wg.emit("i32.const 0")
+		}
+		return "Voltorb"
+	case *Identifier:
+		v := wg.lookup(e.Name)
+		wg.emit("local.get %s", v.local)
+		return v.pokemonType
+	case *CallExpr:
+		fn := wg.checker.functions[e.Name]
+		for i, arg := range e.Args {
+			wg.convert(wg.generateExpression(arg), fn.Params[i].PokemonType)
+		}
+		wg.emit("call $%s", wasmMoveName(fn.Name))
+		return fn.ReturnType
+	case *UnaryExpr:
+		if e.Operator == "-" && wg.checker.TypeOf(e.Operand) == "Pikachu" {
+			wg.emit("i64.const 0") // wasm has no i64.neg, so 0 - operand
+			wg.generateExpression(e.Operand)
+			wg.emit("i64.sub")
+			return "Pikachu"
+		}
+		operandType := wg.generateExpression(e.Operand)
+		switch e.Operator {
+		case "not":
+			wg.emit("i32.eqz")
+			return "Voltorb"
+		case "-":
+			wg.emit("f64.neg")
+			return operandType
+		}
+		panic(fmt.Sprintf("[OZUL Wasm Error] Unknown operator: %s", e.Operator))
+	case *BinaryExpr:
+		return wg.generateBinary(e)
+	default:
+		panic("[OZUL Wasm Error] Unknown expression type.")
+	}
+}
+
+// wasmFloat writes an f64 constant so that it reads back exactly
+func wasmFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+var wasmIntOps = map[string]string{
+	"+": "i64.add", "-": "i64.sub", "*": "i64.mul",
+	"==": "i64.eq", "!=": "i64.ne", "<": "i64.lt_s", "<=": "i64.le_s", ">": "i64.gt_s", ">=": "i64.ge_s",
+}
+var wasmFloatOps = map[string]string{
+	"+": "f64.add", "-": "f64.sub", "*": "f64.mul",
+	"==": "f64.eq", "!=": "f64.ne", "<": "f64.lt", "<=": "f64.le", ">": "f64.gt", ">=": "f64.ge",
+}
+
+// wasmRuntimeOps name the runtime functions for operators that can fail
+var wasmRuntimeOps = map[string]string{"/": "div", "%": "rem", "**": "pow"}
+
+// wasmCompareOps compare the result of $ozul.compare, or two Voltorbs,
+// with each other
+var wasmCompareOps = map[string]string{"==": "i32.eq", "!=": "i32.ne", "<": "i32.lt_s", "<=": "i32.le_s", ">": "i32.gt_s", ">=": "i32.ge_s"}
+
+// generateBinary generates operators. Since wasm is a stack machine, a
+// Pikachu on the left that must become a Psyduck is converted before the
+// right side is pushed, so the types of both sides are looked up first.
+func (wg *WasmGen) generateBinary(e *BinaryExpr) string {
+	if e.Operator == "and" || e.Operator == "or" {
+		// Short circuit: the right side only runs when the left side does
+		// not decide the result
+		wg.generateExpression(e.Left)
+		wg.emit("if (result i32)")
+		if e.Operator == "and" {
+			wg.generateExpression(e.Right)
+			wg.emit("else")
+			wg.emit("i32.const 0")
+		} else {
+			wg.emit("i32.const 1")
+			wg.emit("else")
+			wg.generateExpression(e.Right)
+		}
+		wg.emit("end")
+		return "Voltorb"
+	}
+
+	leftType, rightType := wg.checker.TypeOf(e.Left), wg.checker.TypeOf(e.Right)
+	if e.Operator == "+" && (leftType == "Eevee" || rightType == "Eevee") {
+		wg.toString(wg.generateExpression(e.Left))
+		wg.toString(wg.generateExpression(e.Right))
+		wg.emit("call $ozul.concat")
+		return "Eevee"
+	}
+
+	comparison := wasmCompareOps[e.Operator] != ""
+	switch {
+	case leftType == "Eevee" && comparison:
+		wg.generateExpression(e.Left)
+		wg.generateExpression(e.Right)
+		wg.emit("call $ozul.compare")
+		wg.emit("i32.const 0")
+		wg.emit(wasmCompareOps[e.Operator])
+		return "Voltorb"
+	case leftType == "Voltorb" && comparison:
+		wg.generateExpression(e.Left)
+		wg.generateExpression(e.Right)
+		wg.emit(wasmCompareOps[e.Operator])
+		return "Voltorb"
+	}
+
+	resultType, ops := "Pikachu", wasmIntOps
+	if leftType == "Psyduck" || rightType == "Psyduck" {
+		resultType, ops = "Psyduck", wasmFloatOps
+	}
+	wg.convert(wg.generateExpression(e.Left), resultType)
+	wg.convert(wg.generateExpression(e.Right), resultType)
+	if comparison {
+		wg.emit(ops[e.Operator])
+		return "Voltorb"
+	}
+	switch {
+	case e.Operator == "**" && resultType == "Psyduck":
+		wg.emit("call $float_pow")
+		return resultType
+	case e.Operator == "/" || e.Operator == "%" || e.Operator == "**":
+		// Dividing by zero and negative Pikachu powers are runtime errors,
+		// as in the interpreter
+		wg.emit("i32.const %d", e.Pos().Start.Line)
+		wg.emit("call $ozul.%s_%s", wasmRuntimeOps[e.Operator], wasmTypes[resultType])
+		return resultType
+	}
+	if ops[e.Operator] == "" {
+		panic(fmt.Sprintf("[OZUL Wasm Error] Unknown operator: %s", e.Operator))
+	}
+	wg.emit(ops[e.Operator])
+	return resultType
+}
+
+// convert widens a Pikachu to a Psyduck where a Psyduck is expected
+func (wg *WasmGen) convert(valueType, pokemonType string) {
+	if pokemonType == "Psyduck" && valueType == "Pikachu" {
+		wg.emit("f64.convert_i64_s")
+	}
+}
+
+// toString converts a value for string concatenation, formatting numbers
+// the way the interpreter does
+func (wg *WasmGen) toString(valueType string) {
+	switch valueType {
+	case "Pikachu":
+		wg.emit("call $ozul.int_to_string")
+	case "Psyduck":
+		wg.emit("call $float_to_string")
+	case "Voltorb":
+		wg.emit("call $ozul.bool_to_string")
+	}
+}
+
+// runtime returns the functions every module carries. They are written in
+// flat WAT like generated code, so the encoder treats both alike.
+func (wg *WasmGen) runtime() []*wasmFunc {
+	trueAddr, falseAddr := wg.constant("true"), wg.constant("false")
+	divMessage := "Division by zero."
+	divAddr := wg.constant(divMessage) + 4
+	powAddr := wg.constant(negativeExponent) + 4
+	i32 := func(names ...string) []wasmLocal {
+		locals := make([]wasmLocal, len(names))
+		for i, name := range names {
+			locals[i] = wasmLocal{name, "i32"}
+		}
+		return locals
+	}
+
+	return []*wasmFunc{
+		// alloc reserves size bytes on the heap, growing memory as needed.
+		// Hosts call it to hand strings to the program.
+		{name: "ozul.alloc", export: "alloc", params: i32("size"), result: "i32", locals: i32("ptr", "end"), body: wasmLines(`
+			global.get $heap
+			local.set $ptr
+			global.get $heap
+			local.get $size
+			i32.add
+			i32.const 3
+			i32.add
+			i32.const -4
+			i32.and
+			local.tee $end
+			global.set $heap
+			block $enough
+			local.get $end
+			memory.size
+			i32.const 16
+			i32.shl
+			i32.le_u
+			br_if $enough
+			local.get $end
+			memory.size
+			i32.const 16
+			i32.shl
+			i32.sub
+			i32.const 65535
+			i32.add
+			i32.const 16
+			i32.shr_u
+			memory.grow
+			i32.const -1
+			i32.ne
+			br_if $enough
+			unreachable
+			end
+			local.get $ptr`)},
+
+		// new_string allocates a string of len bytes and sets its length
+		{name: "ozul.new_string", params: i32("len"), result: "i32", locals: i32("ptr"), body: wasmLines(`
+			local.get $len
+			i32.const 4
+			i32.add
+			call $ozul.alloc
+			local.tee $ptr
+			local.get $len
+			i32.store
+			local.get $ptr`)},
+
+		{name: "ozul.release", params: i32("s"), body: wasmLines(`
+			local.get $s
+			i32.const 4
+			i32.add
+			local.get $s
+			i32.load
+			call $release_string`)},
+
+		{name: "ozul.concat", params: i32("a", "b"), result: "i32", locals: i32("alen", "blen", "ptr"), body: wasmLines(`
+			local.get $a
+			i32.load
+			local.tee $alen
+			local.get $b
+			i32.load
+			local.tee $blen
+			i32.add
+			call $ozul.new_string
+			local.set $ptr
+			local.get $ptr
+			i32.const 4
+			i32.add
+			local.get $a
+			i32.const 4
+			i32.add
+			local.get $alen
+			memory.copy
+			local.get $ptr
+			i32.const 4
+			i32.add
+			local.get $alen
+			i32.add
+			local.get $b
+			i32.const 4
+			i32.add
+			local.get $blen
+			memory.copy
+			local.get $ptr`)},
+
+		// compare orders two strings byte by byte, like strings.Compare,
+		// returning -1, 0 or 1
+		{name: "ozul.compare", params: i32("a", "b"), result: "i32", locals: i32("i", "n", "x", "y"), body: wasmLines(`
+			local.get $a
+			i32.load
+			local.get $b
+			i32.load
+			local.get $a
+			i32.load
+			local.get $b
+			i32.load
+			i32.lt_u
+			select
+			local.set $n
+			block $done
+			loop $next
+			local.get $i
+			local.get $n
+			i32.ge_u
+			br_if $done
+			local.get $a
+			local.get $i
+			i32.add
+			i32.load8_u offset=4
+			local.set $x
+			local.get $b
+			local.get $i
+			i32.add
+			i32.load8_u offset=4
+			local.set $y
+			local.get $x
+			local.get $y
+			i32.ne
+			if
+			i32.const -1
+			i32.const 1
+			local.get $x
+			local.get $y
+			i32.lt_u
+			select
+			return
+			end
+			local.get $i
+			i32.const 1
+			i32.add
+			local.set $i
+			br $next
+			end
+			end
+			local.get $a
+			i32.load
+			local.get $b
+			i32.load
+			i32.gt_u
+			local.get $a
+			i32.load
+			local.get $b
+			i32.load
+			i32.lt_u
+			i32.sub`)},
+
+		// int_to_string writes the digits from the end; the magnitude is
+		// taken as unsigned so the smallest Pikachu works too
+		{name: "ozul.int_to_string", params: []wasmLocal{{"v", "i64"}}, result: "i32",
+			locals: append(i32("neg", "len", "ptr", "at"), wasmLocal{"u", "i64"}, wasmLocal{"t", "i64"}), body: wasmLines(`
+			local.get $v
+			i64.const 0
+			i64.lt_s
+			local.tee $neg
+			local.set $len
+			i64.const 0
+			local.get $v
+			i64.sub
+			local.get $v
+			local.get $neg
+			select
+			local.tee $u
+			local.set $t
+			loop $count
+			local.get $len
+			i32.const 1
+			i32.add
+			local.set $len
+			local.get $t
+			i64.const 10
+			i64.div_u
+			local.tee $t
+			i64.const 0
+			i64.ne
+			br_if $count
+			end
+			local.get $len
+			call $ozul.new_string
+			local.tee $ptr
+			local.get $len
+			i32.add
+			local.set $at
+			loop $digit
+			local.get $at
+			local.get $u
+			i64.const 10
+			i64.rem_u
+			i32.wrap_i64
+			i32.const 48
+			i32.add
+			i32.store8 offset=3
+			local.get $at
+			i32.const 1
+			i32.sub
+			local.set $at
+			local.get $u
+			i64.const 10
+			i64.div_u
+			local.tee $u
+			i64.const 0
+			i64.ne
+			br_if $digit
+			end
+			local.get $neg
+			if
+			local.get $ptr
+			i32.const 45
+			i32.store8 offset=4
+			end
+			local.get $ptr`)},
+
+		{name: "ozul.bool_to_string", params: i32("b"), result: "i32", body: wasmLines(fmt.Sprintf(`
+			i32.const %d
+			i32.const %d
+			local.get $b
+			select`, trueAddr, falseAddr))},
+
+		// div_i64 and div_f64 divide, failing on a zero divisor. Dividing
+		// the smallest Pikachu by -1 wraps around like in Go instead of
+		// trapping.
+		{name: "ozul.div_i64", params: []wasmLocal{{"a", "i64"}, {"b", "i64"}, {"line", "i32"}}, result: "i64", body: wasmLines(fmt.Sprintf(`
+			local.get $b
+			i64.eqz
+			if
+			i32.const %d
+			i32.const %d
+			local.get $line
+			call $fail
+			unreachable
+			end
+			local.get $b
+			i64.const -1
+			i64.eq
+			if
+			i64.const 0
+			local.get $a
+			i64.sub
+			return
+			end
+			local.get $a
+			local.get $b
+			i64.div_s`, divAddr, len(divMessage)))},
+
+		{name: "ozul.div_f64", params: []wasmLocal{{"a", "f64"}, {"b", "f64"}, {"line", "i32"}}, result: "f64", body: wasmLines(fmt.Sprintf(`
+			local.get $b
+			f64.const 0
+			f64.eq
+			if
+			i32.const %d
+			i32.const %d
+			local.get $line
+			call $fail
+			unreachable
+			end
+			local.get $a
+			local.get $b
+			f64.div`, divAddr, len(divMessage)))},
+
+		// rem_i64 and rem_f64 take the remainder, failing on a zero divisor
+		// like division does. The remainder of the smallest Pikachu by -1
+		// is 0 in wasm too.
+		{name: "ozul.rem_i64", params: []wasmLocal{{"a", "i64"}, {"b", "i64"}, {"line", "i32"}}, result: "i64", body: wasmLines(fmt.Sprintf(`
+			local.get $b
+			i64.eqz
+			if
+			i32.const %d
+			i32.const %d
+			local.get $line
+			call $fail
+			unreachable
+			end
+			local.get $a
+			local.get $b
+			i64.rem_s`, divAddr, len(divMessage)))},
+
+		{name: "ozul.rem_f64", params: []wasmLocal{{"a", "f64"}, {"b", "f64"}, {"line", "i32"}}, result: "f64", body: wasmLines(fmt.Sprintf(`
+			local.get $b
+			f64.const 0
+			f64.eq
+			if
+			i32.const %d
+			i32.const %d
+			local.get $line
+			call $fail
+			unreachable
+			end
+			local.get $a
+			local.get $b
+			call $float_rem`, divAddr, len(divMessage)))},
+
+		// pow_i64 raises a to the power b by squaring, wrapping around on
+		// overflow, and fails on a negative b
+		{name: "ozul.pow_i64", params: []wasmLocal{{"a", "i64"}, {"b", "i64"}, {"line", "i32"}}, result: "i64", locals: []wasmLocal{{"result", "i64"}}, body: wasmLines(fmt.Sprintf(`
+			local.get $b
+			i64.const 0
+			i64.lt_s
+			if
+			i32.const %d
+			i32.const %d
+			local.get $line
+			call $fail
+			unreachable
+			end
+			i64.const 1
+			local.set $result
+			block $done
+			loop $next
+			local.get $b
+			i64.eqz
+			br_if $done
+			local.get $b
+			i64.const 1
+			i64.and
+			i32.wrap_i64
+			if
+			local.get $result
+			local.get $a
+			i64.mul
+			local.set $result
+			end
+			local.get $a
+			local.get $a
+			i64.mul
+			local.set $a
+			local.get $b
+			i64.const 1
+			i64.shr_u
+			local.set $b
+			br $next
+			end
+			end
+			local.get $result`, powAddr, len(negativeExponent)))},
+	}
+}
+
+// wasmLines splits flat WAT into instructions, one per line
+func wasmLines(code string) []string {
+	var lines []string
+	for _, line := range strings.Split(code, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// wasmImm is the kind of immediate an instruction takes
+type wasmImm int
+
+const (
+	immNone   wasmImm = iota
+	immBlock          // optional label and "(result T)"; opens a label
+	immLabel          // label to branch to, encoded as its depth
+	immLocal          // local name, encoded as its index
+	immGlobal         // global name; $heap is the only global
+	immFunc           // function name, encoded as its index
+	immI32
+	immI64
+	immF64
+	immMemory // alignment, plus an optional offset=N
+)
+
+// wasmOp is the encoding of an instruction
+type wasmOp struct {
+	code  []byte
+	imm   wasmImm
+	align uint32 // log2 of the natural alignment of memory accesses
+}
+
+// wasmOps are the instructions the generator and the runtime use
+var wasmOps = map[string]wasmOp{
+	"unreachable": {code: []byte{0x00}},
+	"block":       {code: []byte{0x02}, imm: immBlock},
+	"loop":        {code: []byte{0x03}, imm: immBlock},
+	"if":          {code: []byte{0x04}, imm: immBlock},
+	"else":        {code: []byte{0x05}},
+	"end":         {code: []byte{0x0b}},
+	"br":          {code: []byte{0x0c}, imm: immLabel},
+	"br_if":       {code: []byte{0x0d}, imm: immLabel},
+	"return":      {code: []byte{0x0f}},
+	"call":        {code: []byte{0x10}, imm: immFunc},
+	"drop":        {code: []byte{0x1a}},
+	"select":      {code: []byte{0x1b}},
+
+	"local.get":  {code: []byte{0x20}, imm: immLocal},
+	"local.set":  {code: []byte{0x21}, imm: immLocal},
+	"local.tee":  {code: []byte{0x22}, imm: immLocal},
+	"global.get": {code: []byte{0x23}, imm: immGlobal},
+	"global.set": {code: []byte{0x24}, imm: immGlobal},
+
+	"i32.load":    {code: []byte{0x28}, imm: immMemory, align: 2},
+	"i32.load8_u": {code: []byte{0x2d}, imm: immMemory},
+	"i32.store":   {code: []byte{0x36}, imm: immMemory, align: 2},
+	"i32.store8":  {code: []byte{0x3a}, imm: immMemory},
+	"memory.size": {code: []byte{0x3f, 0x00}},
+	"memory.grow": {code: []byte{0x40, 0x00}},
+	"memory.copy": {code: []byte{0xfc, 0x0a, 0x00, 0x00}},
+
+	"i32.const": {code: []byte{0x41}, imm: immI32},
+	"i64.const": {code: []byte{0x42}, imm: immI64},
+	"f64.const": {code: []byte{0x44}, imm: immF64},
+
+	"i32.eqz":  {code: []byte{0x45}},
+	"i32.eq":   {code: []byte{0x46}},
+	"i32.ne":   {code: []byte{0x47}},
+	"i32.lt_s": {code: []byte{0x48}},
+	"i32.lt_u": {code: []byte{0x49}},
+	"i32.gt_s": {code: []byte{0x4a}},
+	"i32.gt_u": {code: []byte{0x4b}},
+	"i32.le_s": {code: []byte{0x4c}},
+	"i32.le_u": {code: []byte{0x4d}},
+	"i32.ge_s": {code: []byte{0x4e}},
+	"i32.ge_u": {code: []byte{0x4f}},
+	"i64.eqz":  {code: []byte{0x50}},
+	"i64.eq":   {code: []byte{0x51}},
+	"i64.ne":   {code: []byte{0x52}},
+	"i64.lt_s": {code: []byte{0x53}},
+	"i64.gt_s": {code: []byte{0x55}},
+	"i64.le_s": {code: []byte{0x57}},
+	"i64.ge_s": {code: []byte{0x59}},
+	"f64.eq":   {code: []byte{0x61}},
+	"f64.ne":   {code: []byte{0x62}},
+	"f64.lt":   {code: []byte{0x63}},
+	"f64.gt":   {code: []byte{0x64}},
+	"f64.le":   {code: []byte{0x65}},
+	"f64.ge":   {code: []byte{0x66}},
+
+	"i32.add":   {code: []byte{0x6a}},
+	"i32.sub":   {code: []byte{0x6b}},
+	"i32.and":   {code: []byte{0x71}},
+	"i32.shl":   {code: []byte{0x74}},
+	"i32.shr_u": {code: []byte{0x76}},
+	"i64.add":   {code: []byte{0x7c}},
+	"i64.sub":   {code: []byte{0x7d}},
+	"i64.mul":   {code: []byte{0x7e}},
+	"i64.div_s": {code: []byte{0x7f}},
+	"i64.div_u": {code: []byte{0x80}},
+	"i64.rem_s": {code: []byte{0x81}},
+	"i64.rem_u": {code: []byte{0x82}},
+	"i64.and":   {code: []byte{0x83}},
+	"i64.shr_u": {code: []byte{0x88}},
+	"f64.neg":   {code: []byte{0x9a}},
+	"f64.add":   {code: []byte{0xa0}},
+	"f64.sub":   {code: []byte{0xa1}},
+	"f64.mul":   {code: []byte{0xa2}},
+	"f64.div":   {code: []byte{0xa3}},
+
+	"i32.wrap_i64":      {code: []byte{0xa7}},
+	"f64.convert_i64_s": {code: []byte{0xb9}},
+}
+
+var wasmValueTypes = map[string]byte{"i32": 0x7f, "i64": 0x7e, "f64": 0x7c}
+
+// Section ids, in the order sections must appear
+const (
+	wasmTypeSection     = 1
+	wasmImportSection   = 2
+	wasmFunctionSection = 3
+	wasmMemorySection   = 5
+	wasmGlobalSection   = 6
+	wasmExportSection   = 7
+	wasmCodeSection     = 10
+	wasmDataSection     = 11
+)
+
+// GetWasm returns the module in the binary format, ready to instantiate
+func (wg *WasmGen) GetWasm() []byte {
+	funcIndex := make(map[string]int) // by $name, like calls refer to them
+	var types []*wasmFunc
+	typeIndex := make(map[string]int)
+	signature := func(fn *wasmFunc) int {
+		key := wasmSignature(fn, false)
+		if i, ok := typeIndex[key]; ok {
+			return i
+		}
+		typeIndex[key] = len(types)
+		types = append(types, fn)
+		return len(types) - 1
+	}
+
+	var imports, functions, exports, code [][]byte
+	for i := range wasmImports {
+		imp := &wasmImports[i]
+		funcIndex["$"+imp.name] = i
+		entry := append(wasmName("ozul"), wasmName(imp.name)...)
+		entry = append(entry, 0x00)
+		imports = append(imports, appendULEB(entry, uint64(signature(imp))))
+	}
+	for i, fn := range wg.funcs {
+		funcIndex["$"+fn.name] = len(wasmImports) + i
+	}
+	exports = append(exports, append(wasmName("memory"), 0x02, 0x00))
+	for i, fn := range wg.funcs {
+		functions = append(functions, appendULEB(nil, uint64(signature(fn))))
+		if fn.export != "" {
+			exports = append(exports, appendULEB(append(wasmName(fn.export), 0x00), uint64(len(wasmImports)+i)))
+		}
+		body := wg.encodeBody(fn, funcIndex)
+		code = append(code, append(appendULEB(nil, uint64(len(body))), body...))
+	}
+
+	var typeEntries [][]byte
+	for _, fn := range types {
+		entry := []byte{0x60}
+		var params [][]byte
+		for _, param := range fn.params {
+			params = append(params, []byte{wasmValueTypes[param.typ]})
+		}
+		entry = append(entry, wasmVector(params)...)
+		if fn.result != "" {
+			entry = append(entry, 1, wasmValueTypes[fn.result])
+		} else {
+			entry = append(entry, 0)
+		}
+		typeEntries = append(typeEntries, entry)
+	}
+
+	// One page of memory to start with, and the heap right after the data
+	memory := []byte{0x00, 0x01}
+	global := append([]byte{0x7f, 0x01, 0x41}, appendSLEB(nil, int64(wg.heapStart()))...)
+	global = append(global, 0x0b)
+	data := append([]byte{0x00, 0x41}, appendSLEB(nil, wasmDataStart)...)
+	data = append(data, 0x0b)
+	data = append(appendULEB(data, uint64(len(wg.data))), wg.data...)
+
+	module := []byte{0x00, 'a', 's', 'm', 0x01, 0x00, 0x00, 0x00}
+	module = wasmSection(module, wasmTypeSection, wasmVector(typeEntries))
+	module = wasmSection(module, wasmImportSection, wasmVector(imports))
+	module = wasmSection(module, wasmFunctionSection, wasmVector(functions))
+	module = wasmSection(module, wasmMemorySection, wasmVector([][]byte{memory}))
+	module = wasmSection(module, wasmGlobalSection, wasmVector([][]byte{global}))
+	module = wasmSection(module, wasmExportSection, wasmVector(exports))
+	module = wasmSection(module, wasmCodeSection, wasmVector(code))
+	module = wasmSection(module, wasmDataSection, wasmVector([][]byte{data}))
+	return module
+}
+
+// encodeBody encodes a function's locals and instructions. Names are
+// resolved to indices here; labels become the depth of the block they name.
+func (wg *WasmGen) encodeBody(fn *wasmFunc, funcIndex map[string]int) []byte {
+	locals := make(map[string]int)
+	for i, param := range fn.params {
+		locals["$"+param.name] = i
+	}
+	var declared [][]byte
+	for i, local := range fn.locals {
+		locals["$"+local.name] = len(fn.params) + i
+		declared = append(declared, []byte{1, wasmValueTypes[local.typ]})
+	}
+	code := wasmVector(declared)
+
+	var labels []string // innermost last
+	for _, line := range fn.body {
+		fields := strings.Fields(line)
+		op, ok := wasmOps[fields[0]]
+		if !ok {
+			panic(fmt.Sprintf("[OZUL Wasm Error] Unknown instruction in %s: %s", fn.name, line))
+		}
+		code = append(code, op.code...)
+		args := fields[1:]
+		lookup := func(names map[string]int) int {
+			if len(args) == 0 {
+				panic(fmt.Sprintf("[OZUL Wasm Error] Missing operand in %s: %s", fn.name, line))
+			}
+			i, ok := names[args[0]]
+			if !ok {
+				panic(fmt.Sprintf("[OZUL Wasm Error] Unknown name in %s: %s", fn.name, line))
+			}
+			return i
+		}
+
+		switch op.imm {
+		case immBlock:
+			label, blockType := "", byte(0x40)
+			for i := 0; i < len(args); i++ {
+				if strings.HasPrefix(args[i], "$") {
+					label = args[i]
+				} else if args[i] == "(result" && i+1 < len(args) {
+					blockType = wasmValueTypes[strings.TrimSuffix(args[i+1], ")")]
+					i++
+				}
+			}
+			labels = append(labels, label)
+			code = append(code, blockType)
+		case immLabel:
+			depth := -1
+			for i := len(labels) - 1; i >= 0; i-- {
+				if len(args) > 0 && labels[i] == args[0] {
+					depth = len(labels) - 1 - i
+					break
+				}
+			}
+			if depth < 0 {
+				panic(fmt.Sprintf("[OZUL Wasm Error] Unknown label in %s: %s", fn.name, line))
+			}
+			code = appendULEB(code, uint64(depth))
+		case immLocal:
+			code = appendULEB(code, uint64(lookup(locals)))
+		case immGlobal:
+			code = appendULEB(code, uint64(lookup(map[string]int{"$heap": 0})))
+		case immFunc:
+			code = appendULEB(code, uint64(lookup(funcIndex)))
+		case immI32, immI64:
+			n, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				panic(fmt.Sprintf("[OZUL Wasm Error] Bad constant in %s: %s", fn.name, line))
+			}
+			code = appendSLEB(code, n)
+		case immF64:
+			f, err := strconv.ParseFloat(args[0], 64)
+			if err != nil {
+				panic(fmt.Sprintf("[OZUL Wasm Error] Bad constant in %s: %s", fn.name, line))
+			}
+			bits := math.Float64bits(f)
+			for i := 0; i < 8; i++ {
+				code = append(code, byte(bits>>(8*i)))
+			}
+		case immMemory:
+			offset := uint64(0)
+			if len(args) > 0 {
+				offset, _ = strconv.ParseUint(strings.TrimPrefix(args[0], "offset="), 10, 32)
+			}
+			code = appendULEB(appendULEB(code, uint64(op.align)), offset)
+		}
+
+		if fields[0] == "end" {
+			labels = labels[:len(labels)-1]
+		}
+	}
+	return append(code, 0x0b)
+}
+
+func wasmSection(module []byte, id byte, contents []byte) []byte {
+	module = append(module, id)
+	module = appendULEB(module, uint64(len(contents)))
+	return append(module, contents...)
+}
+
+// wasmVector encodes a count followed by the items
+func wasmVector(items [][]byte) []byte {
+	vec := appendULEB(nil, uint64(len(items)))
+	for _, item := range items {
+		vec = append(vec, item...)
+	}
+	return vec
+}
+
+func wasmName(name string) []byte {
+	return append(appendULEB(nil, uint64(len(name))), name...)
+}
+
+func appendULEB(b []byte, v uint64) []byte {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+func appendSLEB(b []byte, v int64) []byte {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && c&0x40 == 0) || (v == -1 && c&0x40 != 0) {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
@@ -0,0 +1,549 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// wasmMachine is a small WebAssembly interpreter, just big enough to decode
+// and run the modules WasmGen emits, so the backend can be tested without a
+// wasm engine. Every value is kept as a uint64: i32s in the low 32 bits and
+// f64s as their bits.
+type wasmMachine struct {
+	types       []wasmFuncType
+	imports     []string // names of the imported functions, in index order
+	importTypes []uint32
+	funcs       []*wasmCode
+	exports     map[string]uint32
+	memory      []byte
+	globals     []uint64
+	host        map[string]func(args []uint64) uint64
+}
+
+type wasmFuncType struct {
+	params, results []byte
+}
+
+type wasmCode struct {
+	typ    uint32
+	locals int // besides the parameters
+	code   []byte
+	ends   map[int]int // position of each block, loop and if to its end
+	elses  map[int]int // position of each if to its else, if it has one
+}
+
+// wasmTrap is raised with panic when the module traps
+type wasmTrap string
+
+// wasmLabel is a block the code is inside of
+type wasmLabel struct {
+	loop   bool
+	start  int // where a branch to a loop continues
+	end    int // position of the end instruction
+	height int // stack height when the block was entered
+	arity  int
+}
+
+type wasmReader struct {
+	b   []byte
+	pos int
+}
+
+func (r *wasmReader) byte() byte {
+	if r.pos >= len(r.b) {
+		panic(wasmTrap("unexpected end of module"))
+	}
+	r.pos++
+	return r.b[r.pos-1]
+}
+
+func (r *wasmReader) bytes(n int) []byte {
+	if r.pos+n > len(r.b) {
+		panic(wasmTrap("unexpected end of module"))
+	}
+	r.pos += n
+	return r.b[r.pos-n : r.pos]
+}
+
+func (r *wasmReader) uleb() uint64 {
+	var v uint64
+	for shift := 0; ; shift += 7 {
+		c := r.byte()
+		v |= uint64(c&0x7f) << shift
+		if c&0x80 == 0 {
+			return v
+		}
+	}
+}
+
+func (r *wasmReader) sleb() int64 {
+	var v int64
+	for shift := 0; ; {
+		c := r.byte()
+		v |= int64(c&0x7f) << shift
+		shift += 7
+		if c&0x80 == 0 {
+			if shift < 64 && c&0x40 != 0 {
+				v |= -1 << shift
+			}
+			return v
+		}
+	}
+}
+
+func (r *wasmReader) name() string {
+	return string(r.bytes(int(r.uleb())))
+}
+
+// constExpr reads an i32.const initializer
+func (r *wasmReader) constExpr() int64 {
+	if r.byte() != 0x41 {
+		panic(wasmTrap("unsupported initializer"))
+	}
+	v := r.sleb()
+	if r.byte() != 0x0b {
+		panic(wasmTrap("unterminated initializer"))
+	}
+	return v
+}
+
+// decodeWasm decodes a module and instantiates it with the given host
+// functions, by import name
+func decodeWasm(module []byte, host map[string]func(args []uint64) uint64) (m *wasmMachine, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			trap, ok := r.(wasmTrap)
+			if !ok {
+				panic(r)
+			}
+			err = fmt.Errorf("decoding module: %s", trap)
+		}
+	}()
+
+	r := &wasmReader{b: module}
+	if string(r.bytes(8)) != "\x00asm\x01\x00\x00\x00" {
+		return nil, fmt.Errorf("not a version 1 wasm module")
+	}
+	m = &wasmMachine{exports: make(map[string]uint32), host: host}
+	var funcTypes []uint32
+	lastID := byte(0)
+	for r.pos < len(r.b) {
+		id := r.byte()
+		if id <= lastID {
+			return nil, fmt.Errorf("section %d out of order", id)
+		}
+		lastID = id
+		s := &wasmReader{b: r.bytes(int(r.uleb()))}
+		count := int(s.uleb())
+		for i := 0; i < count; i++ {
+			switch id {
+			case 1: // type
+				if s.byte() != 0x60 {
+					return nil, fmt.Errorf("bad function type")
+				}
+				params := s.bytes(int(s.uleb()))
+				results := s.bytes(int(s.uleb()))
+				m.types = append(m.types, wasmFuncType{params, results})
+			case 2: // import
+				if s.name() != "ozul" {
+					return nil, fmt.Errorf("import from an unknown module")
+				}
+				name := s.name()
+				if s.byte() != 0x00 {
+					return nil, fmt.Errorf("import %s is not a function", name)
+				}
+				if host[name] == nil {
+					return nil, fmt.Errorf("no host function %s", name)
+				}
+				m.imports = append(m.imports, name)
+				m.importTypes = append(m.importTypes, uint32(s.uleb()))
+			case 3: // function
+				funcTypes = append(funcTypes, uint32(s.uleb()))
+			case 5: // memory
+				flags := s.byte()
+				m.memory = make([]byte, s.uleb()*65536)
+				if flags&1 != 0 {
+					s.uleb()
+				}
+			case 6: // global
+				s.bytes(2)
+				m.globals = append(m.globals, uint64(uint32(s.constExpr())))
+			case 7: // export
+				name := s.name()
+				kind := s.byte()
+				index := uint32(s.uleb())
+				if kind == 0x00 {
+					m.exports[name] = index
+				}
+			case 10: // code
+				body := &wasmReader{b: s.bytes(int(s.uleb()))}
+				fn := &wasmCode{typ: funcTypes[i]}
+				for groups := int(body.uleb()); groups > 0; groups-- {
+					fn.locals += int(body.uleb())
+					body.byte()
+				}
+				fn.code = body.b[body.pos:]
+				fn.scanBlocks()
+				m.funcs = append(m.funcs, fn)
+			case 11: // data
+				if s.byte() != 0x00 {
+					return nil, fmt.Errorf("unsupported data segment")
+				}
+				offset := s.constExpr()
+				copy(m.memory[offset:], s.bytes(int(s.uleb())))
+			default:
+				return nil, fmt.Errorf("unexpected section %d", id)
+			}
+		}
+		if s.pos != len(s.b) {
+			return nil, fmt.Errorf("section %d has %d bytes left over", id, len(s.b)-s.pos)
+		}
+	}
+	if len(m.funcs) != len(funcTypes) {
+		return nil, fmt.Errorf("%d functions but %d bodies", len(funcTypes), len(m.funcs))
+	}
+	return m, nil
+}
+
+// scanBlocks matches every block, loop and if with its else and end
+func (fn *wasmCode) scanBlocks() {
+	fn.ends, fn.elses = make(map[int]int), make(map[int]int)
+	r := &wasmReader{b: fn.code}
+	var open []int
+	for r.pos < len(r.b) {
+		at := r.pos
+		switch op := r.byte(); op {
+		case 0x02, 0x03, 0x04:
+			r.byte()
+			open = append(open, at)
+		case 0x05:
+			fn.elses[open[len(open)-1]] = at
+		case 0x0b:
+			if len(open) == 0 {
+				if r.pos != len(r.b) {
+					panic(wasmTrap("code after the end of a function"))
+				}
+				return
+			}
+			fn.ends[open[len(open)-1]] = at
+			open = open[:len(open)-1]
+		default:
+			skipImmediates(r, op)
+		}
+	}
+	panic(wasmTrap("function without an end"))
+}
+
+// skipImmediates reads past the immediates of op
+func skipImmediates(r *wasmReader, op byte) {
+	switch {
+	case op == 0x0c || op == 0x0d || op == 0x10 || (op >= 0x20 && op <= 0x24):
+		r.uleb()
+	case op >= 0x28 && op <= 0x3e:
+		r.uleb()
+		r.uleb()
+	case op == 0x3f || op == 0x40:
+		r.byte()
+	case op == 0x41 || op == 0x42:
+		r.sleb()
+	case op == 0x44:
+		r.bytes(8)
+	case op == 0xfc:
+		if r.uleb() != 10 {
+			panic(wasmTrap("unsupported 0xfc instruction"))
+		}
+		r.bytes(2)
+	}
+}
+
+// funcType returns the type of a function, imported or defined
+func (m *wasmMachine) funcType(index uint32) wasmFuncType {
+	if int(index) < len(m.imports) {
+		return m.types[m.importTypes[index]]
+	}
+	return m.types[m.funcs[int(index)-len(m.imports)].typ]
+}
+
+// invoke calls an exported function, turning traps into errors
+func (m *wasmMachine) invoke(name string, args ...uint64) (result uint64, err error) {
+	index, ok := m.exports[name]
+	if !ok {
+		return 0, fmt.Errorf("no export %s", name)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			trap, ok := r.(wasmTrap)
+			if !ok {
+				panic(r)
+			}
+			err = fmt.Errorf("%s", trap)
+		}
+	}()
+	return m.call(index, args, 0), nil
+}
+
+func (m *wasmMachine) call(index uint32, args []uint64, depth int) uint64 {
+	if depth > 5000 {
+		panic(wasmTrap("call stack exhausted"))
+	}
+	if int(index) < len(m.imports) {
+		return m.host[m.imports[index]](args)
+	}
+	fn := m.funcs[int(index)-len(m.imports)]
+	locals := append(append([]uint64(nil), args...), make([]uint64, fn.locals)...)
+	var stack []uint64
+	var labels []wasmLabel
+	push := func(v uint64) { stack = append(stack, v) }
+	pop := func() uint64 {
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return v
+	}
+	pop2 := func() (uint64, uint64) {
+		b := pop()
+		return pop(), b
+	}
+	b2u := func(b bool) uint64 {
+		if b {
+			return 1
+		}
+		return 0
+	}
+	f64 := math.Float64frombits
+	address := func(r *wasmReader, size int) int {
+		r.uleb()
+		addr := int(uint32(pop())) + int(r.uleb())
+		if addr+size > len(m.memory) {
+			panic(wasmTrap("out of bounds memory access"))
+		}
+		return addr
+	}
+	// branch leaves depth blocks, keeping the results of the one it exits
+	branch := func(depth int) int {
+		label := labels[len(labels)-1-depth]
+		if label.loop {
+			stack = stack[:label.height]
+			labels = labels[:len(labels)-depth]
+			return label.start
+		}
+		results := append([]uint64(nil), stack[len(stack)-label.arity:]...)
+		stack = append(stack[:label.height], results...)
+		labels = labels[:len(labels)-1-depth]
+		return label.end + 1
+	}
+
+	r := &wasmReader{b: fn.code}
+	for {
+		at := r.pos
+		op := r.byte()
+		switch op {
+		case 0x00:
+			panic(wasmTrap("unreachable executed"))
+		case 0x02, 0x03, 0x04:
+			arity := 0
+			if r.byte() != 0x40 {
+				arity = 1
+			}
+			label := wasmLabel{loop: op == 0x03, start: r.pos, end: fn.ends[at], height: len(stack), arity: arity}
+			if op == 0x04 {
+				label.height--
+				if pop() == 0 {
+					if elseAt, ok := fn.elses[at]; ok {
+						r.pos = elseAt + 1
+					} else {
+						r.pos = label.end
+					}
+				}
+			}
+			labels = append(labels, label)
+		case 0x05:
+			r.pos = labels[len(labels)-1].end
+		case 0x0b:
+			if len(labels) == 0 {
+				if len(stack) > 0 {
+					return pop()
+				}
+				return 0
+			}
+			labels = labels[:len(labels)-1]
+		case 0x0c:
+			r.pos = branch(int(r.uleb()))
+		case 0x0d:
+			depth := int(r.uleb())
+			if pop() != 0 {
+				r.pos = branch(depth)
+			}
+		case 0x0f:
+			if len(m.types[fn.typ].results) > 0 {
+				return pop()
+			}
+			return 0
+		case 0x10:
+			callee := uint32(r.uleb())
+			t := m.funcType(callee)
+			args := append([]uint64(nil), stack[len(stack)-len(t.params):]...)
+			stack = stack[:len(stack)-len(t.params)]
+			result := m.call(callee, args, depth+1)
+			if len(t.results) > 0 {
+				push(result)
+			}
+		case 0x1a:
+			pop()
+		case 0x1b:
+			c := pop()
+			a, b := pop2()
+			if c != 0 {
+				push(a)
+			} else {
+				push(b)
+			}
+
+		case 0x20:
+			push(locals[r.uleb()])
+		case 0x21:
+			locals[r.uleb()] = pop()
+		case 0x22:
+			locals[r.uleb()] = stack[len(stack)-1]
+		case 0x23:
+			push(m.globals[r.uleb()])
+		case 0x24:
+			m.globals[r.uleb()] = pop()
+
+		case 0x28:
+			addr := address(r, 4)
+			push(uint64(binary.LittleEndian.Uint32(m.memory[addr:])))
+		case 0x2d:
+			push(uint64(m.memory[address(r, 1)]))
+		case 0x36:
+			v := uint32(pop())
+			binary.LittleEndian.PutUint32(m.memory[address(r, 4):], v)
+		case 0x3a:
+			v := byte(pop())
+			m.memory[address(r, 1)] = v
+		case 0x3f:
+			r.byte()
+			push(uint64(len(m.memory) / 65536))
+		case 0x40:
+			r.byte()
+			pages := int(uint32(pop()))
+			old := len(m.memory) / 65536
+			if old+pages > 256 {
+				push(uint64(math.MaxUint32))
+			} else {
+				m.memory = append(m.memory, make([]byte, pages*65536)...)
+				push(uint64(old))
+			}
+		case 0xfc:
+			skipImmediates(r, op) // memory.copy
+			n := int(uint32(pop()))
+			src := int(uint32(pop()))
+			dst := int(uint32(pop()))
+			if src+n > len(m.memory) || dst+n > len(m.memory) {
+				panic(wasmTrap("out of bounds memory access"))
+			}
+			copy(m.memory[dst:dst+n], m.memory[src:src+n])
+
+		case 0x41:
+			push(uint64(uint32(int32(r.sleb()))))
+		case 0x42:
+			push(uint64(r.sleb()))
+		case 0x44:
+			push(binary.LittleEndian.Uint64(r.bytes(8)))
+
+		case 0x45:
+			push(b2u(uint32(pop()) == 0))
+		case 0x50:
+			push(b2u(pop() == 0))
+		case 0x46, 0x47, 0x48, 0x49, 0x4a, 0x4b, 0x4c, 0x4d, 0x4e, 0x4f:
+			a, b := pop2()
+			x, y := uint32(a), uint32(b)
+			push(b2u([]bool{x == y, x != y, int32(x) < int32(y), x < y, int32(x) > int32(y), x > y,
+				int32(x) <= int32(y), x <= y, int32(x) >= int32(y), x >= y}[op-0x46]))
+		case 0x51, 0x52, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5a:
+			a, b := pop2()
+			x, y := int64(a), int64(b)
+			push(b2u([]bool{x == y, x != y, x < y, a < b, x > y, a > b, x <= y, a <= b, x >= y, a >= b}[op-0x51]))
+		case 0x61, 0x62, 0x63, 0x64, 0x65, 0x66:
+			a, b := pop2()
+			x, y := f64(a), f64(b)
+			push(b2u([]bool{x == y, x != y, x < y, x > y, x <= y, x >= y}[op-0x61]))
+
+		case 0x6a, 0x6b, 0x71, 0x74, 0x76:
+			a, b := pop2()
+			x, y := uint32(a), uint32(b)
+			switch op {
+			case 0x6a:
+				x += y
+			case 0x6b:
+				x -= y
+			case 0x71:
+				x &= y
+			case 0x74:
+				x <<= y & 31
+			case 0x76:
+				x >>= y & 31
+			}
+			push(uint64(x))
+		case 0x7c, 0x7d, 0x7e:
+			a, b := pop2()
+			push([]uint64{a + b, a - b, a * b}[op-0x7c])
+		case 0x7f:
+			a, b := pop2()
+			if b == 0 || (int64(a) == math.MinInt64 && int64(b) == -1) {
+				panic(wasmTrap("integer divide by zero or overflow"))
+			}
+			push(uint64(int64(a) / int64(b)))
+		case 0x80, 0x82:
+			a, b := pop2()
+			if b == 0 {
+				panic(wasmTrap("integer divide by zero"))
+			}
+			if op == 0x80 {
+				push(a / b)
+			} else {
+				push(a % b)
+			}
+		case 0x81:
+			a, b := pop2()
+			if b == 0 {
+				panic(wasmTrap("integer divide by zero"))
+			}
+			push(uint64(int64(a) % int64(b))) // Go gives 0 for MinInt64 % -1, as wasm does
+		case 0x83:
+			a, b := pop2()
+			push(a & b)
+		case 0x88:
+			a, b := pop2()
+			push(a >> (b & 63))
+		case 0x9a:
+			push(pop() ^ 1<<63)
+		case 0xa0, 0xa1, 0xa2, 0xa3:
+			a, b := pop2()
+			x, y := f64(a), f64(b)
+			push(math.Float64bits([]float64{x + y, x - y, x * y, x / y}[op-0xa0]))
+		case 0xa7:
+			push(uint64(uint32(pop())))
+		case 0xb9:
+			push(math.Float64bits(float64(int64(pop()))))
+		default:
+			panic(wasmTrap(fmt.Sprintf("unsupported instruction 0x%02x", op)))
+		}
+	}
+}
+
+// i32 reads an i32 in linear memory
+func (m *wasmMachine) i32(addr uint64) uint32 {
+	return binary.LittleEndian.Uint32(m.memory[uint32(addr):])
+}
+
+// str reads a string the module stored at addr
+func (m *wasmMachine) str(addr uint64) string {
+	n := m.i32(addr)
+	return string(m.memory[uint32(addr)+4 : uint32(addr)+4+n])
+}
+
+// bytesAt reads len bytes at addr, like imports get names and messages
+func (m *wasmMachine) bytesAt(addr, len uint64) string {
+	return string(m.memory[uint32(addr) : uint32(addr)+uint32(len)])
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// wasmHost is ozul_host.js written in Go, for running modules on wasmMachine:
+// release writes to out, catch reads lines from in and fail traps with the
+// message compiled programs print
+func wasmHost(m **wasmMachine, out *strings.Builder, in *bufio.Reader) map[string]func([]uint64) uint64 {
+	release := func(v Value) uint64 {
+		fmt.Fprintln(out, v.String())
+		return 0
+	}
+	fail := func(message string, line uint64) {
+		panic(wasmTrap(fmt.Sprintf("[OZUL Error] %s (line %d)", message, line)))
+	}
+	newString := func(s string) uint64 {
+		ptr := (*m).call((*m).exports["alloc"], []uint64{uint64(4 + len(s))}, 0)
+		binary.LittleEndian.PutUint32((*m).memory[ptr:], uint32(len(s)))
+		copy((*m).memory[ptr+4:], s)
+		return ptr
+	}
+	catch := func(args []uint64, pokemonType string) Value {
+		fmt.Fprintf(out, "Enter value for %s: ", (*m).bytesAt(args[0], args[1]))
+		input, _ := in.ReadString('\n')
+		val, err := parseInput(strings.TrimRight(input, "\r\n"), pokemonType)
+		if err != nil {
+			fail(err.Message, args[2])
+		}
+		return val
+	}
+
+	return map[string]func([]uint64) uint64{
+		"release_int":   func(args []uint64) uint64 { return release(Value{Type: "int", Int: int(int64(args[0]))}) },
+		"release_float": func(args []uint64) uint64 { return release(Value{Type: "float", Float: math.Float64frombits(args[0])}) },
+		"release_bool":  func(args []uint64) uint64 { return release(Value{Type: "bool", Bool: uint32(args[0]) != 0}) },
+		"release_string": func(args []uint64) uint64 {
+			return release(Value{Type: "string", Str: (*m).bytesAt(args[0], args[1])})
+		},
+		"catch_int":   func(args []uint64) uint64 { return uint64(catch(args, "Pikachu").Int) },
+		"catch_float": func(args []uint64) uint64 { return math.Float64bits(catch(args, "Psyduck").Float) },
+		"catch_string": func(args []uint64) uint64 {
+			return newString(catch(append(args, 0), "Eevee").Str)
+		},
+		"float_to_string": func(args []uint64) uint64 {
+			return newString(fmt.Sprintf("%f", math.Float64frombits(args[0])))
+		},
+		"float_rem": func(args []uint64) uint64 {
+			return math.Float64bits(math.Mod(math.Float64frombits(args[0]), math.Float64frombits(args[1])))
+		},
+		"float_pow": func(args []uint64) uint64 {
+			return math.Float64bits(math.Pow(math.Float64frombits(args[0]), math.Float64frombits(args[1])))
+		},
+		"fail": func(args []uint64) uint64 {
+			fail((*m).bytesAt(args[0], args[1]), args[2])
+			return 0
+		},
+	}
+}
+
+// TestWasmGen_Binary checks that the binary module decodes, with the
+// imports, functions, exports and data the generator made
+func TestWasmGen_Binary(t *testing.T) {
+	wasmgen := generateWasm(parseChecked(t, `Pikachu hp is 10
+release "hp: " + hp
+catch Eevee name from trainer`))
+
+	module := wasmgen.GetWasm()
+	if !bytes.HasPrefix(module, []byte("\x00asm\x01\x00\x00\x00")) {
+		t.Fatalf("Expected the wasm magic number and version, got % x", module[:8])
+	}
+	m, err := decodeWasm(module, wasmHost(nil, nil, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.imports) != len(wasmImports) || len(m.funcs) != len(wasmgen.funcs) {
+		t.Errorf("Expected %d imports and %d functions, got %d and %d", len(wasmImports), len(wasmgen.funcs), len(m.imports), len(m.funcs))
+	}
+	for _, name := range []string{"run", "alloc"} {
+		if _, ok := m.exports[name]; !ok {
+			t.Errorf("Expected the module to export %s", name)
+		}
+	}
+	if got := m.str(uint64(wasmgen.constant("hp: "))); got != "hp: " {
+		t.Errorf("Expected the data segment to hold \"hp: \", got %q", got)
+	}
+}
+
+func TestWasmGen_HeapGrows(t *testing.T) {
+	output, err := runWasm(t, parseChecked(t, `Eevee s is ""
+repeat 300 times
+s evolves to s + "0123456789012345678901234567890123456789012345678901234567890123456789012345678901234567890123456789"
+end
+release s == s + ""
+release "done " + 300 * 100`), "")
+	if err != nil || output != "true\ndone 30000\n" {
+		t.Errorf("Expected the heap to grow past one page, got %q, %v", output, err)
+	}
+}
+
+// TestWasmHost_FormatsFloatsLikeGo checks the float formatting in
+// ozul_host.js against Go's, which the interpreter uses
+func TestWasmHost_FormatsFloatsLikeGo(t *testing.T) {
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node not found")
+	}
+	floats := []float64{0, math.Copysign(0, -1), 1, -1.5, 0.1, 1.0 / 3, 2.5e-7, 0.0001, 0.00001, 123456.5, 999999, 1e6,
+		1234567, 1e20, 1e21, 1.7976931348623157e308, 5e-324, 0.0078125, 0.0234375, -0.0078125, 2.5, 1e22, 123.4567895,
+		math.Inf(1), math.Inf(-1), math.NaN()}
+
+	var js, expected []string
+	for _, f := range floats {
+		js = append(js, fmt.Sprintf("%q", fmt.Sprint(f))) // parsed back by Number() below
+		expected = append(expected, fmt.Sprint(f), fmt.Sprintf("%f", f))
+	}
+	script := fmt.Sprintf(`const { formatFloat, fixedFloat } = require(%q);
+const values = [%s].map((s) => s === "+Inf" ? Infinity : s === "-Inf" ? -Infinity : s === "-0" ? -0 : Number(s));
+for (const v of values) console.log(formatFloat(v) + "\n" + fixedFloat(v));`, mustAbs(t, "ozul_host.js"), strings.Join(js, ", "))
+	out, err := exec.Command(node, "-e", script).CombinedOutput()
+	if err != nil {
+		t.Fatalf("node failed: %v\n%s", err, out)
+	}
+	got := strings.Split(strings.TrimRight(string(out), "\n"), "\n")
+	for i := range expected {
+		if i >= len(got) || got[i] != expected[i] {
+			t.Errorf("Value %d: expected %q, got %q", i/2, expected[i], got)
+			break
+		}
+	}
+}
+
+func mustAbs(t *testing.T, path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return abs
+}